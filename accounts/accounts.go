@@ -39,6 +39,7 @@ const (
 	MimetypeDataWithValidator = "data/validator"
 	MimetypeTypedData         = "data/typed"
 	MimetypeClique            = "application/x-clique-header"
+	MimetypeQBFT              = "application/x-qbft-message"
 	MimetypeTextPlain         = "text/plain"
 )
 
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// txAccesses are the distinct accounts a tx of a live chain accessed, as
+// recorded by a node running with --stateaccesses. The accesses made past the
+// last tx of a block, e.g. paying the block reward, form a tx of their own.
+type txAccesses struct {
+	blockNumber int
+	addresses   []string
+}
+
+// groupAccesses splits the state accesses of a block by tx. The accesses are
+// recorded in the order they were made, so those of a tx are consecutive.
+func groupAccesses(number int, accesses []types.StateAccess) []txAccesses {
+	var (
+		txs  []txAccesses
+		seen map[common.Address]struct{}
+	)
+	for i, access := range accesses {
+		if i == 0 || access.TxIndex != accesses[i-1].TxIndex {
+			txs = append(txs, txAccesses{blockNumber: number})
+			seen = make(map[common.Address]struct{})
+		}
+		if _, ok := seen[access.Address]; ok {
+			continue
+		}
+		seen[access.Address] = struct{}{}
+		txs[len(txs)-1].addresses = append(txs[len(txs)-1].addresses, access.Address.Hex())
+	}
+	return txs
+}
+
+// processAccessesFromNode replays the state accesses recorded by the node at
+// the given RPC endpoint, from the first block up to its head, calling
+// finishBlock and processTx like processTxFromZip does for the zip files.
+func processAccessesFromNode(finishBlock func(int) error, processTx func(txAccesses) error, endpoint string) error {
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var head hexutil.Uint64
+	if err := client.Call(&head, "eth_blockNumber"); err != nil {
+		return err
+	}
+	lastBlockNumber := -1
+	for number := uint64(1); number <= uint64(head); number++ {
+		var accesses []types.StateAccess
+		if err := client.Call(&accesses, "debug_getStateAccesses", hexutil.Uint64(number)); err != nil {
+			return fmt.Errorf("block %d: %v", number, err)
+		}
+		for _, tx := range groupAccesses(int(number), accesses) {
+			// If the previous block ends, run finishBlock
+			if lastBlockNumber != tx.blockNumber {
+				if lastBlockNumber != -1 {
+					if err := finishBlock(lastBlockNumber); err != nil {
+						return err
+					}
+				}
+				lastBlockNumber = tx.blockNumber
+			}
+			if err := processTx(tx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
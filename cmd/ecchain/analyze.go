@@ -12,21 +12,30 @@ var analyzeCmd = &cli.Command{
 	Action: analyze,
 	Flags: []cli.Flag{
 		zipDirFlag,
+		accessesRPCFlag,
 		recencyFlag,
 		frequencyFlag,
+		ecKFlag,
+		predictHeightFlag,
+		predictRecenciesFlag,
+		predictFrequenciesFlag,
+		predictBudgetFlag,
+		predictAccountSizeFlag,
 	},
 	Description: `
-    ecchain analyze /path/to/my.zip`,
+    ecchain analyze /path/to/my.zip
+
+With --accesses.rpc, the accounts accessed by the txs of a live chain are
+analyzed instead, as recorded by the node at the endpoint (--stateaccesses).
+
+With --predict.height, the hot/cold bookkeeping is replayed for every
+combination of --predict.recencies and --predict.frequencies, and the growth
+of the hot set, the cold read rate and the migration volume of each are
+extrapolated to the height. With --predict.budget, the thresholds with the
+fewest cold reads fitting the budget are recommended.`,
 }
 
 var (
-	coldReadCount    int
-	hotTrieSize      int
-	coldTrieSize     int
-	hotAccounts      map[string]bool
-	coldAccounts     map[string]bool
-	blockToExpire    map[string]int
-	createdHeight    map[string]int
 	accessTime       map[string]int
 	accountsToExpire map[int]map[string]bool
 )
@@ -40,94 +49,126 @@ func (b *block) appendAddr(addr ...string) {
 	b.addresses = append(b.addresses, addr...)
 }
 
-// BEGIN cold read vs. threshold
-func encoldAccounts(height int) error {
-	for addr := range accountsToExpire[height] {
-		coldAccounts[addr] = true
-		delete(hotAccounts, addr)
-	}
-	delete(accountsToExpire, height)
-	if len(coldAccounts) > coldTrieSize {
-		coldTrieSize = len(coldAccounts)
+// tierSets is the hot/cold bookkeeping of the accounts for a pair of recency
+// and frequency thresholds, shared by analyze and its predictions. An account
+// accessed while not hot is a cold read, new accounts included as they're
+// looked up in the cold set first, and becomes hot. It expires to the cold set
+// once it's been neither accessed within the recency nor often enough for its
+// age.
+type tierSets struct {
+	recency   int
+	frequency float64
+
+	hot, cold map[string]struct{}
+	created   map[string]int              // height of the first access of each account
+	accesses  map[string]int              // number of accesses of each account
+	expiry    map[string]int              // height at which each hot account expires
+	expiring  map[int]map[string]struct{} // accounts to move to the cold set after the block
+	expired   int                         // last block whose expiring accounts were moved
+
+	coldReads, migrated int // counters, reset by the caller
+}
+
+func newTierSets(recency int, frequency float64) *tierSets {
+	return &tierSets{
+		recency:   recency,
+		frequency: frequency,
+		hot:       make(map[string]struct{}),
+		cold:      make(map[string]struct{}),
+		created:   make(map[string]int),
+		accesses:  make(map[string]int),
+		expiry:    make(map[string]int),
+		expiring:  make(map[int]map[string]struct{}),
+		expired:   -1,
 	}
-	return nil
 }
 
-func updateWithTx(tx txFromZip, recency int, frequency float64) error {
-	// update hot and cold tries
-	for _, addr := range []string{tx.sender, tx.to} {
-		if _, ok := hotAccounts[addr]; !ok {
-			coldReadCount++
-			if _, okk := coldAccounts[addr]; okk {
-				delete(coldAccounts, addr)
-			} else {
-				createdHeight[addr] = tx.blockNumber
-			}
-		}
-		hotAccounts[addr] = true
-		if _, ok := accessTime[addr]; !ok {
-			accessTime[addr] = 1
+// access records an access to the account by a tx of the given block.
+func (s *tierSets) access(addr string, height int) {
+	if _, ok := s.hot[addr]; !ok {
+		s.coldReads++
+		if _, ok := s.cold[addr]; ok {
+			delete(s.cold, addr)
+			s.migrated++
 		} else {
-			accessTime[addr]++
-			delete(accountsToExpire[blockToExpire[addr]], addr)
-		}
-		newBlockToExpire := func(a, b int) int {
-			if a > b {
-				return a
-			}
-			return b
-		}(tx.blockNumber+recency, createdHeight[addr]+int(math.Ceil(float64(accessTime[addr])/frequency)))
-		if newBlockToExpire < 4000000 {
-			blockToExpire[addr] = newBlockToExpire
-			if _, ok := accountsToExpire[newBlockToExpire]; !ok {
-				accountsToExpire[newBlockToExpire] = make(map[string]bool)
-			}
-			accountsToExpire[newBlockToExpire][addr] = true
+			s.created[addr] = height
 		}
+		s.hot[addr] = struct{}{}
 	}
-	if len(hotAccounts) > hotTrieSize {
-		hotTrieSize = len(hotAccounts)
+	s.accesses[addr]++
+	if expiry, ok := s.expiry[addr]; ok {
+		delete(s.expiring[expiry], addr)
+		delete(s.expiry, addr)
 	}
-
-	return nil
+	expiry := height + s.recency
+	if byFrequency := s.created[addr] + int(math.Ceil(float64(s.accesses[addr])/s.frequency)); byFrequency > expiry {
+		expiry = byFrequency
+	}
+	if expiry >= maxExpiryHeight {
+		return // stays hot for the rest of the history
+	}
+	s.expiry[addr] = expiry
+	if _, ok := s.expiring[expiry]; !ok {
+		s.expiring[expiry] = make(map[string]struct{})
+	}
+	s.expiring[expiry][addr] = struct{}{}
 }
 
-// END cold read vs. threshold
+// expire moves the accounts expiring up to the block to the cold set,
+// catching up on the blocks without transactions since the last call.
+func (s *tierSets) expire(height int) {
+	if s.expired == -1 {
+		s.expired = height - 1
+	}
+	for h := s.expired + 1; h <= height; h++ {
+		for addr := range s.expiring[h] {
+			delete(s.hot, addr)
+			delete(s.expiry, addr)
+			s.cold[addr] = struct{}{}
+			s.migrated++
+		}
+		delete(s.expiring, h)
+	}
+	if height > s.expired {
+		s.expired = height
+	}
+}
 
 func analyze(ctx *cli.Context) error {
-	hotAccounts = make(map[string]bool)
-	coldAccounts = make(map[string]bool)
-	blockToExpire = make(map[string]int)
-	createdHeight = make(map[string]int)
-	accessTime = make(map[string]int)
-	accountsToExpire = make(map[int]map[string]bool)
-
-	coldReadCount = 0
-	hotTrieSize = 0
-	coldTrieSize = 0
+	if ctx.IsSet(predictHeightFlag.Name) {
+		return predict(ctx)
+	}
 	lstBlock := -1
-
-	recency := ctx.Int(recencyFlag.Name)
-	frequency := ctx.Float64(frequencyFlag.Name)
+	sets := newTierSets(ctx.Int(recencyFlag.Name), ctx.Float64(frequencyFlag.Name))
 	gasSum := 0
 	txCount := 0
-	err := processTxFromZip(func(height int) error {
-		if err := encoldAccounts(height); err != nil {
-			return err
-		}
+	finishBlock := func(height int) error {
+		sets.expire(height)
 
 		if height/10000 != lstBlock/10000 {
-			println(height, coldReadCount, txCount, strconv.FormatFloat(float64(coldReadCount)/float64(txCount), 'f', -1, 64))
-			coldReadCount = 0
+			println(height, sets.coldReads, txCount, strconv.FormatFloat(float64(sets.coldReads)/float64(txCount), 'f', -1, 64))
+			sets.coldReads = 0
 			txCount = 0
 		}
 		lstBlock = height
 
 		return nil
-	}, func(tx txFromZip) error {
+	}
+	if ctx.IsSet(accessesRPCFlag.Name) {
+		return processAccessesFromNode(finishBlock, func(tx txAccesses) error {
+			txCount++
+			for _, addr := range tx.addresses {
+				sets.access(addr, tx.blockNumber)
+			}
+			return nil
+		}, ctx.String(accessesRPCFlag.Name))
+	}
+	err := processTxFromZip(finishBlock, func(tx txFromZip) error {
 		gasSum += tx.gasUsed
 		txCount++
-		return updateWithTx(tx, recency, frequency)
+		sets.access(tx.sender, tx.blockNumber)
+		sets.access(tx.to, tx.blockNumber)
+		return nil
 	}, prepareFiles(ctx)...)
 	if err != nil {
 		return err
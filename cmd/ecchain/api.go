@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/urfave/cli/v2"
+	"math/big"
+)
+
+// ECAPI exposes the shard placement and temperature state of an EcGroup
+// under the "ec" RPC namespace.
+type ECAPI struct {
+	g *EcGroup
+}
+
+// NewECAPI creates the ec RPC service for the given group.
+func NewECAPI(g *EcGroup) *ECAPI {
+	return &ECAPI{g}
+}
+
+// ECMember describes one node of the EC group.
+type ECMember struct {
+	Index    hexutil.Uint   `json:"index"`
+	Shards   []hexutil.Uint `json:"shards"`
+	HotRoot  common.Hash    `json:"hotRoot"`
+	ColdRoot common.Hash    `json:"coldRoot"`
+}
+
+// ColdProofResult is the result of an ec_getColdProof call. The proof is
+// taken against the last committed cold trie of the shard owning the account.
+type ColdProofResult struct {
+	Address      common.Address `json:"address"`
+	Shard        hexutil.Uint   `json:"shard"`
+	ColdRoot     common.Hash    `json:"coldRoot"`
+	AccountProof []string       `json:"accountProof"`
+	Balance      *hexutil.Big   `json:"balance"`
+	Nonce        hexutil.Uint64 `json:"nonce"`
+}
+
+// IsHot reports whether the account currently lives in the replicated hot trie.
+func (api *ECAPI) IsHot(address common.Address) bool {
+	api.g.lock.Lock()
+	defer api.g.lock.Unlock()
+
+	return api.g.IsHot(address)
+}
+
+// ShardOf returns the index of the cold shard of the account. The member holding
+// the shard is listed by GroupMembers.
+func (api *ECAPI) ShardOf(address common.Address) hexutil.Uint {
+	return hexutil.Uint(GetIndForAddress(api.g.k, address))
+}
+
+// ExpiryHeight returns the block height at which the account is scheduled to
+// be moved to the cold tier, or zero if it has never been accessed.
+func (api *ECAPI) ExpiryHeight(address common.Address) hexutil.Uint64 {
+	api.g.lock.Lock()
+	defer api.g.lock.Unlock()
+
+	return hexutil.Uint64(api.g.blockToExpireNode.GetBalance(address).Uint64())
+}
+
+// ColdRoot returns the last committed cold trie root of the member holding the
+// given shard.
+func (api *ECAPI) ColdRoot(shard hexutil.Uint) (common.Hash, error) {
+	api.g.lock.Lock()
+	defer api.g.lock.Unlock()
+
+	if int(shard) >= api.g.size {
+		return common.Hash{}, fmt.Errorf("shard %d out of range, there are %d shards", shard, api.g.size)
+	}
+	return api.g.owner[shard].cold.Root(), nil
+}
+
+// GroupMembers returns the members of the EC group with their committed roots.
+func (api *ECAPI) GroupMembers() []ECMember {
+	api.g.lock.Lock()
+	defer api.g.lock.Unlock()
+
+	members := make([]ECMember, 0, len(api.g.nodes))
+	for _, n := range api.g.nodes {
+		shards := []hexutil.Uint{}
+		for shard, owner := range api.g.owner {
+			if owner == n {
+				shards = append(shards, hexutil.Uint(shard))
+			}
+		}
+		members = append(members, ECMember{
+			Index:    hexutil.Uint(n.ind),
+			Shards:   shards,
+			HotRoot:  n.hot.Root(),
+			ColdRoot: n.cold.Root(),
+		})
+	}
+	return members
+}
+
+// GetColdProof returns the Merkle proof of the account against the cold trie
+// of its owning shard. For accounts that are not cold it proves absence.
+func (api *ECAPI) GetColdProof(address common.Address) (*ColdProofResult, error) {
+	api.g.lock.Lock()
+	defer api.g.lock.Unlock()
+
+	n := api.g.GetNodeForAddress(address)
+	proof, balance, nonce, err := n.cold.GetProof(address)
+	if err != nil {
+		return nil, err
+	}
+	if balance == nil {
+		balance = new(big.Int)
+	}
+	return &ColdProofResult{
+		Address:      address,
+		Shard:        hexutil.Uint(GetIndForAddress(api.g.k, address)),
+		ColdRoot:     n.cold.Root(),
+		AccountProof: toHexSlice(proof),
+		Balance:      (*hexutil.Big)(balance),
+		Nonce:        hexutil.Uint64(nonce),
+	}, nil
+}
+
+func toHexSlice(b [][]byte) []string {
+	r := make([]string, len(b))
+	for i := range b {
+		r[i] = hexutil.Encode(b[i])
+	}
+	return r
+}
+
+// APIs returns the RPC services offered by the group.
+func (g *EcGroup) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "ec",
+			Service:   NewECAPI(g),
+		},
+	}
+}
+
+// startAPINode starts an ephemeral node serving the ec namespace of the group
+// over HTTP-RPC.
+func startAPINode(ctx *cli.Context, g *EcGroup) (*node.Node, error) {
+	stack, err := node.New(&node.Config{
+		Name:             clientIdentifier,
+		Version:          params.Version,
+		HTTPHost:         ctx.String(rpcAddrFlag.Name),
+		HTTPPort:         ctx.Int(rpcPortFlag.Name),
+		HTTPModules:      []string{"ec"},
+		HTTPVirtualHosts: []string{"localhost"},
+		P2P: p2p.Config{
+			NoDiscovery: true,
+			NoDial:      true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	stack.RegisterAPIs(g.APIs())
+	if err := stack.Start(); err != nil {
+		return nil, err
+	}
+	fmt.Println("Serving the ec API at", stack.HTTPEndpoint())
+	return stack, nil
+}
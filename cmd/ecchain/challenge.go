@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/urfave/cli/v2"
+	"math/big"
+	"math/bits"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+var challengeCmd = &cli.Command{
+	Name:   "challenge",
+	Usage:  "Simulate proof-of-storage challenges against a cold shard holder dropping part of its shard",
+	Action: challengeSim,
+	Flags: []cli.Flag{
+		challengeCountFlag,
+		challengeAccountsFlag,
+		challengeDropFlag,
+		challengeTrialsFlag,
+		challengeSeedFlag,
+	},
+	Description: `
+    ecchain challenge --challenge.drop 0.01,0.1,0.5
+
+Builds a cold trie of random accounts and, for every fraction of its trie
+nodes dropped by the holder, outputs the probability that an epoch of
+challenges detects the holder and the fraction of the challenges failed. A
+challenge picks the path of an account and a trie node along it, which the
+holder proves with the nodes from its root down to the challenged one.`,
+}
+
+// challengeRecord is the challenge history of a member.
+type challengeRecord struct {
+	issued      int // challenges issued to the member
+	failed      int // challenges the member failed to answer in time
+	lastFailure int // height of the last epoch with a failure, -1 if none
+}
+
+// nodeChallenge is a challenge on the trie node at the given depth of the path
+// of an account, counting the hashed nodes from the root. The holder answers
+// with the nodes from its root down to the challenged one, or to the leaf of
+// the account if its path is shorter.
+type nodeChallenge struct {
+	address common.Address
+	depth   int
+}
+
+// challengeDepth returns the deepest node challenged in a cold trie of the
+// given number of accounts, the expected depth of its leaves.
+func challengeDepth(accounts int) int {
+	return (bits.Len(uint(accounts)) + 3) / 4
+}
+
+// challengeNodes derives the (account path, trie node) pairs a member is
+// challenged on in an epoch from the seed of the block, picking count paths
+// of the given accounts, which are the committed cold accounts of its shards,
+// and a node along each.
+func challengeNodes(seed common.Hash, member int, accounts []common.Address, count int) []nodeChallenge {
+	if len(accounts) == 0 {
+		return nil
+	}
+	var (
+		picked = make([]nodeChallenge, 0, count)
+		depth  = uint64(challengeDepth(len(accounts)) + 1)
+		buf    [common.HashLength + 16]byte
+	)
+	copy(buf[:], seed[:])
+	binary.BigEndian.PutUint64(buf[common.HashLength:], uint64(member))
+	for j := 0; j < count; j++ {
+		binary.BigEndian.PutUint64(buf[common.HashLength+8:], uint64(j))
+		pick := crypto.Keccak256(buf[:])
+		picked = append(picked, nodeChallenge{
+			address: accounts[binary.BigEndian.Uint64(pick)%uint64(len(accounts))],
+			depth:   int(binary.BigEndian.Uint64(pick[8:]) % depth),
+		})
+	}
+	return picked
+}
+
+// answerChallenge returns the answer to a challenge from the proof of the
+// account: its nodes down to the challenged one.
+func answerChallenge(c nodeChallenge, proof [][]byte) [][]byte {
+	if len(proof) > c.depth+1 {
+		return proof[:c.depth+1]
+	}
+	return proof
+}
+
+// verifyChallenge checks the answer of a holder challenged on a node of its
+// committed cold trie against the root the group agreed on for the holder.
+// The nodes must link by hash from the root along the path of the account
+// down to the challenged depth, or end in the leaf of the account. The account
+// must exist, as the group only challenges committed cold accounts.
+func verifyChallenge(root common.Hash, c nodeChallenge, nodes [][]byte) error {
+	var (
+		key  = keyNibbles(crypto.Keccak256(c.address.Bytes()))
+		want = root
+	)
+	for depth, node := range nodes {
+		if crypto.Keccak256Hash(node) != want {
+			return fmt.Errorf("node %d of the path of %x doesn't match", depth, c.address)
+		}
+		child, rest, leaf, err := descend(node, key)
+		if err != nil {
+			return fmt.Errorf("node %d of the path of %x: %v", depth, c.address, err)
+		}
+		if leaf || depth == c.depth {
+			return nil
+		}
+		want, key = common.BytesToHash(child), rest
+	}
+	return fmt.Errorf("missing node %d of the path of %x", len(nodes), c.address)
+}
+
+// keyNibbles splits a trie key into nibbles.
+func keyNibbles(key []byte) []byte {
+	nibbles := make([]byte, 2*len(key))
+	for i, b := range key {
+		nibbles[2*i], nibbles[2*i+1] = b>>4, b&0x0f
+	}
+	return nibbles
+}
+
+// descend follows the key through an encoded trie node, and the nodes embedded
+// in it, to the reference of the next hashed node on the path and the rest of
+// the key. It reports whether the path ends in the leaf of the key instead.
+func descend(node []byte, key []byte) (child []byte, rest []byte, leaf bool, err error) {
+	for {
+		elems, _, err := rlp.SplitList(node)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		switch n, _ := rlp.CountValues(elems); n {
+		case 17:
+			if len(key) == 0 {
+				return nil, nil, false, errors.New("key ends in a branch")
+			}
+			for i := byte(0); i < key[0]; i++ {
+				if _, _, elems, err = rlp.Split(elems); err != nil {
+					return nil, nil, false, err
+				}
+			}
+			child, key = elems, key[1:]
+		case 2:
+			compact, ref, err := rlp.SplitString(elems)
+			if err != nil {
+				return nil, nil, false, err
+			}
+			prefix, terminal := compactNibbles(compact)
+			if len(key) < len(prefix) || !bytes.Equal(key[:len(prefix)], prefix) {
+				return nil, nil, false, errors.New("account missing")
+			}
+			if key = key[len(prefix):]; terminal {
+				if len(key) != 0 {
+					return nil, nil, false, errors.New("account missing")
+				}
+				return nil, nil, true, nil
+			}
+			child = ref
+		default:
+			return nil, nil, false, fmt.Errorf("invalid node of %d items", n)
+		}
+		kind, content, tail, err := rlp.Split(child)
+		switch {
+		case err != nil:
+			return nil, nil, false, err
+		case kind == rlp.List:
+			node = child[:len(child)-len(tail)] // embedded in its parent, follow it
+		case len(content) == common.HashLength:
+			return content, key, false, nil
+		default:
+			return nil, nil, false, errors.New("account missing")
+		}
+	}
+}
+
+// compactNibbles decodes the hex-prefix encoded key of a short node, reporting
+// whether it is a leaf.
+func compactNibbles(compact []byte) (nibbles []byte, terminal bool) {
+	if len(compact) == 0 {
+		return nil, false
+	}
+	nibbles = keyNibbles(compact)
+	terminal = nibbles[0]&2 != 0
+	if nibbles[0]&1 != 0 {
+		return nibbles[1:], terminal
+	}
+	return nibbles[2:], terminal
+}
+
+// challengeSeed derives the randomness of the challenges issued at a block
+// from the state the group committed at it, standing in for the block hash
+// the zip files don't carry. Like a block hash it commits to the state root,
+// here the hot root and the agreed cold roots, so the challenged accounts
+// can't be known before the block is committed, unlike a hash of the
+// transactions of the trace.
+func (g *EcGroup) challengeSeed(height int) common.Hash {
+	buf := binary.BigEndian.AppendUint64(nil, uint64(height))
+	buf = append(buf, g.nodes[0].hot.Root().Bytes()...)
+	for _, n := range g.nodes {
+		root := g.agreedRoots[n.ind]
+		buf = append(buf, root[:]...)
+	}
+	return crypto.Keccak256Hash(buf)
+}
+
+// coldAccountsOf returns the committed cold accounts of the shards held by a
+// member, in slot order.
+func (g *EcGroup) coldAccountsOf(n *EcNode) []common.Address {
+	var accounts []common.Address
+	for _, shard := range g.shardsOf(n) {
+		for _, address := range g.slots[shard].addrs {
+			if address != (common.Address{}) {
+				accounts = append(accounts, address)
+			}
+		}
+	}
+	return accounts
+}
+
+// challenge runs an epoch of proof-of-storage challenges. Every member holding
+// cold shards has to prove the accounts derived from the seed against
+// its agreed cold root, and to deliver the proofs to all members within the
+// deadline. Members failing a challenge are recorded and suspected, so the
+// group scrubs and repairs them. It must run on the committed cold state.
+func (g *EcGroup) challenge(height int, seed common.Hash) {
+	for _, n := range g.nodes {
+		record, ok := g.challenges[n.ind]
+		if !ok {
+			record = &challengeRecord{lastFailure: -1}
+			g.challenges[n.ind] = record
+		}
+		var (
+			challenges = challengeNodes(seed, n.ind, g.coldAccountsOf(n), g.challengeCount)
+			failed     int
+			lastErr    error
+		)
+		for _, c := range challenges {
+			start := time.Now()
+			proof, _, _, err := n.cold.GetProof(c.address)
+			answer := answerChallenge(c, proof)
+			size := 0
+			for _, node := range answer {
+				size += len(node)
+			}
+			if err == nil {
+				err = verifyChallenge(g.agreedRoots[n.ind], c, answer)
+			}
+			elapsed := time.Since(start)
+			if g.network != nil {
+				elapsed = g.network.FetchAll(n.ind, size, g.memberInds())
+			}
+			if err == nil && g.challengeDeadline > 0 && elapsed > g.challengeDeadline {
+				err = fmt.Errorf("answered node %d of %x after %v", c.depth, c.address, elapsed)
+			}
+			if err != nil {
+				failed++
+				lastErr = err
+			}
+		}
+		record.issued += len(challenges)
+		if failed == 0 {
+			continue
+		}
+		record.failed += failed
+		record.lastFailure = height
+		fmt.Fprintln(os.Stderr, "challenge", height, "member", n.ind, "failed", failed, "of", len(challenges), "err", lastErr)
+		g.suspect(n)
+	}
+}
+
+// printChallenges outputs the challenge history of every member challenged.
+func (g *EcGroup) printChallenges() {
+	inds := make([]int, 0, len(g.challenges))
+	for ind := range g.challenges {
+		inds = append(inds, ind)
+	}
+	sort.Ints(inds)
+	for _, ind := range inds {
+		r := g.challenges[ind]
+		fmt.Println("challenges member", ind, "issued", r.issued, "failed", r.failed, "lastfailure", r.lastFailure)
+	}
+}
+
+// challengeSim measures how likely an epoch of challenges detects a holder
+// that dropped a fraction of the trie nodes of its cold shard. The holder
+// serves the nodes it kept, so a challenge fails if any node on the path of
+// the challenged account down to the challenged node was dropped.
+func challengeSim(ctx *cli.Context) error {
+	var (
+		count  = ctx.Int(challengeCountFlag.Name)
+		trials = ctx.Int(challengeTrialsFlag.Name)
+		drops  = ctx.Float64Slice(challengeDropFlag.Name)
+		rng    = rand.New(rand.NewSource(ctx.Int64(challengeSeedFlag.Name)))
+	)
+	if count <= 0 || trials <= 0 {
+		return errors.New("need at least one challenge and one trial")
+	}
+	holder, err := NewDbNode(0)
+	if err != nil {
+		return err
+	}
+	defer holder.Clean()
+
+	accounts := make([]common.Address, ctx.Int(challengeAccountsFlag.Name))
+	for i := range accounts {
+		rng.Read(accounts[i][:])
+		holder.SetBalance(accounts[i], big.NewInt(1+rng.Int63n(1e18)))
+	}
+	if err := holder.Commit(); err != nil {
+		return err
+	}
+	root := holder.Root()
+
+	// The trie nodes stored by the holder, embedded ones are part of their parent
+	tr, err := holder.db.OpenTrie(root)
+	if err != nil {
+		return err
+	}
+	var nodes []common.Hash
+	for it := tr.NodeIterator(nil); it.Next(true); {
+		if hash := it.Hash(); hash != (common.Hash{}) {
+			nodes = append(nodes, hash)
+		}
+	}
+	proofs := make(map[common.Address][][]byte)
+	fmt.Println("drop detection failed")
+	for _, drop := range drops {
+		detected, failed := 0, 0
+		for trial := 0; trial < trials; trial++ {
+			dropped := make(map[common.Hash]struct{})
+			for _, hash := range nodes {
+				if rng.Float64() < drop {
+					dropped[hash] = struct{}{}
+				}
+			}
+			var seed common.Hash
+			rng.Read(seed[:])
+
+			caught := false
+			for _, c := range challengeNodes(seed, holder.ind, accounts, count) {
+				proof, ok := proofs[c.address]
+				if !ok {
+					if proof, _, _, err = holder.GetProof(c.address); err != nil {
+						return err
+					}
+					proofs[c.address] = proof
+				}
+				var served [][]byte
+				for _, node := range answerChallenge(c, proof) {
+					if _, ok := dropped[crypto.Keccak256Hash(node)]; !ok {
+						served = append(served, node)
+					}
+				}
+				if verifyChallenge(root, c, served) != nil {
+					failed++
+					caught = true
+				}
+			}
+			if caught {
+				detected++
+			}
+		}
+		fmt.Println(drop, float64(detected)/float64(trials), float64(failed)/float64(trials*count))
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// Tests that the answers to node challenges verify at every depth, and that
+// they fail once any node down to the challenged one is withheld.
+func TestNodeChallenge(t *testing.T) {
+	holder, err := NewDbNode(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Clean()
+
+	rng := rand.New(rand.NewSource(1))
+	accounts := make([]common.Address, 300)
+	for i := range accounts {
+		rng.Read(accounts[i][:])
+		holder.SetBalance(accounts[i], big.NewInt(int64(i+1)))
+	}
+	if err := holder.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	root := holder.Root()
+
+	for _, address := range accounts[:20] {
+		proof, _, _, err := holder.GetProof(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for depth := 0; depth <= len(proof); depth++ {
+			c := nodeChallenge{address: address, depth: depth}
+			answer := answerChallenge(c, proof)
+			if err := verifyChallenge(root, c, answer); err != nil {
+				t.Fatalf("account %x depth %d: %v", address, depth, err)
+			}
+			if err := verifyChallenge(common.Hash{1}, c, answer); err == nil {
+				t.Fatalf("account %x depth %d: verified against another root", address, depth)
+			}
+			for drop := range answer {
+				withheld := append(append([][]byte{}, answer[:drop]...), answer[drop+1:]...)
+				if err := verifyChallenge(root, c, withheld); err == nil {
+					t.Fatalf("account %x depth %d: verified without node %d", address, depth, drop)
+				}
+			}
+		}
+	}
+	// The proof of absence of an account doesn't answer a challenge on its path
+	missing := common.Address{0xff}
+	proof, _, _, err := holder.GetProof(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := nodeChallenge{address: missing, depth: len(proof)}
+	if err := verifyChallenge(root, c, answerChallenge(c, proof)); err == nil {
+		t.Fatal("challenge on a missing account answered")
+	}
+}
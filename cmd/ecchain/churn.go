@@ -0,0 +1,411 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// churnEvent is a scheduled change of the group membership.
+type churnEvent struct {
+	join   bool
+	member int  // index of the leaving member, unused for joins
+	abrupt bool // whether the member leaves without handing its shards over
+}
+
+// parseChurnSchedule parses a churn schedule like
+// "1000:join,2500:leave:3,4000:crash:1", mapping block heights to the
+// membership changes applied after them. A member leaving by a crash doesn't
+// hand its shards over, they are rebuilt from the parity.
+func parseChurnSchedule(schedule string) (map[int][]churnEvent, error) {
+	events := make(map[int][]churnEvent)
+	for _, entry := range strings.Split(schedule, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid churn event %q", entry)
+		}
+		height, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid churn height %q", fields[0])
+		}
+		switch {
+		case fields[1] == "join" && len(fields) == 2:
+			events[height] = append(events[height], churnEvent{join: true})
+		case (fields[1] == "leave" || fields[1] == "crash") && len(fields) == 3:
+			member, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid leaving member %q", fields[2])
+			}
+			events[height] = append(events[height], churnEvent{member: member, abrupt: fields[1] == "crash"})
+		default:
+			return nil, fmt.Errorf("invalid churn event %q", entry)
+		}
+	}
+	return events, nil
+}
+
+// churnReport summarises the migration caused by a membership change.
+type churnReport struct {
+	member   int           // index of the member joining or leaving
+	shards   int           // number of cold shards handed over
+	accounts int           // number of cold accounts moved
+	stripes  int           // stripes rebuilt from the parity after a crash
+	bytes    int           // bytes sent, cold accounts plus the hot replica of a joining member
+	transfer time.Duration // time until the migration is complete
+	window   time.Duration // time during which the moved shards had no live holder
+}
+
+func (r *churnReport) String() string {
+	return fmt.Sprintf("member %d shards %d accounts %d stripes %d bytes %d transfer %v window %v",
+		r.member, r.shards, r.accounts, r.stripes, r.bytes, r.transfer, r.window)
+}
+
+// shardsOf returns the cold shards held by the given member.
+func (g *EcGroup) shardsOf(n *EcNode) []int {
+	var shards []int
+	for shard, owner := range g.owner {
+		if owner == n {
+			shards = append(shards, shard)
+		}
+	}
+	return shards
+}
+
+// moveShard hands a cold shard over to another member, moving its accounts
+// between the cold tries. It returns the number of accounts and bytes moved.
+// The parity is computed per shard rather than per member, so it stays valid
+// and the new holder receives the accounts themselves.
+func (g *EcGroup) moveShard(shard int, to *EcNode) (int, int, error) {
+	from := g.owner[shard]
+	size := 0
+	for addr := range g.slots[shard].index {
+		account := &types.StateAccount{
+			Nonce:    from.cold.GetNonce(addr),
+			Balance:  from.cold.GetBalance(addr),
+			Root:     types.EmptyRootHash,
+			CodeHash: types.EmptyCodeHash.Bytes(),
+		}
+		blob, err := rlp.EncodeToBytes(account)
+		if err != nil {
+			return 0, 0, err
+		}
+		size += common.AddressLength + len(blob)
+
+		from.cold.Delete(addr)
+		to.SetBalanceCold(addr, account.Balance)
+	}
+	g.owner[shard] = to
+	return g.slots[shard].len(), size, nil
+}
+
+// Join adds a new member to the group. It receives a copy of the hot trie and
+// takes over cold shards from the members holding the most, until the shards
+// are spread evenly. A group can't have more holding members than shards, so
+// extra members only replicate the hot trie.
+func (g *EcGroup) Join() (*churnReport, error) {
+	n, err := NewEcNode(g.k, g.recency, g.frequency, g.nextInd)
+	if err != nil {
+		return nil, err
+	}
+	g.nextInd++
+	var (
+		report = &churnReport{member: n.ind}
+		start  = time.Now()
+	)
+
+	// sync the hot replica from an existing member
+	src := g.nodes[0]
+	size, err := n.hot.importState(src.hot)
+	if err != nil {
+		return nil, err
+	}
+	report.bytes += size
+	if g.network != nil {
+		report.transfer = g.network.Send(src.ind, n.ind, size)
+	}
+	g.nodes = append(g.nodes, n)
+
+	// take over shards from the most loaded members, in parallel
+	var (
+		target   = g.size / len(g.nodes)
+		received = make(map[int]int) // bytes received from each donor
+		donors   []*EcNode
+	)
+	for len(g.shardsOf(n)) < target {
+		var donor *EcNode
+		for _, m := range g.nodes {
+			if m != n && (donor == nil || len(g.shardsOf(m)) > len(g.shardsOf(donor))) {
+				donor = m
+			}
+		}
+		if len(g.shardsOf(donor)) <= target {
+			break
+		}
+		accounts, size, err := g.moveShard(g.shardsOf(donor)[0], n)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := received[donor.ind]; !ok {
+			donors = append(donors, donor)
+		}
+		received[donor.ind] += size
+		report.shards++
+		report.accounts += accounts
+		report.bytes += size
+	}
+	var migration time.Duration
+	if g.network != nil {
+		for _, donor := range donors {
+			if elapsed := g.network.Send(donor.ind, n.ind, received[donor.ind]); elapsed > migration {
+				migration = elapsed
+			}
+		}
+	} else {
+		migration = time.Since(start)
+	}
+	if migration > report.transfer {
+		report.transfer = migration
+	}
+	for _, m := range append(donors, n) {
+		if err := m.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// removeMember takes a member out of the group and returns it.
+func (g *EcGroup) removeMember(member int) (*EcNode, error) {
+	if len(g.nodes) == 1 {
+		return nil, errors.New("the last member can't leave the group")
+	}
+	for i, n := range g.nodes {
+		if n.ind == member {
+			g.nodes = append(g.nodes[:i:i], g.nodes[i+1:]...)
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("member %d is not in the group", member)
+}
+
+// leastLoaded returns the member holding the fewest cold shards.
+func (g *EcGroup) leastLoaded() *EcNode {
+	var to *EcNode
+	for _, m := range g.nodes {
+		if to == nil || len(g.shardsOf(m)) < len(g.shardsOf(to)) {
+			to = m
+		}
+	}
+	return to
+}
+
+// Leave removes a member from the group. Its cold shards are handed over to
+// the remaining members holding the fewest, and the shards have no live
+// holder until the handover completes.
+func (g *EcGroup) Leave(member int) (*churnReport, error) {
+	n, err := g.removeMember(member)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		report    = &churnReport{member: member}
+		start     = time.Now()
+		sent      = make(map[int]int) // bytes sent to each receiver
+		receivers []*EcNode
+	)
+	for _, shard := range g.shardsOf(n) {
+		to := g.leastLoaded()
+		accounts, size, err := g.moveShard(shard, to)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := sent[to.ind]; !ok {
+			receivers = append(receivers, to)
+		}
+		sent[to.ind] += size
+		report.shards++
+		report.accounts += accounts
+		report.bytes += size
+	}
+	if g.network != nil {
+		for _, to := range receivers {
+			if elapsed := g.network.Send(n.ind, to.ind, sent[to.ind]); elapsed > report.transfer {
+				report.transfer = elapsed
+			}
+		}
+	} else {
+		report.transfer = time.Since(start)
+	}
+	if report.shards > 0 {
+		report.window = report.transfer
+	}
+	for _, m := range receivers {
+		if err := m.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	if err := n.Clean(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Crash removes a member which left the group abruptly, without handing its
+// cold shards over. The remaining members holding the fewest take the shards
+// over and rebuild them stripe by stripe from the other shards and the parity
+// the member didn't hold, and the new holders of the parity it held re-encode
+// it. The shards have no live holder, and the stripes are down to a single
+// parity, until the rebuild completes.
+func (g *EcGroup) Crash(member int) (*churnReport, error) {
+	var (
+		lostP = make(map[int]bool) // stripes whose P parity the member held
+		lostQ = make(map[int]bool) // stripes whose Q parity the member held
+		lost  []int                // shards of the member
+	)
+	stripes := 0
+	for _, shardSlots := range g.slots {
+		if len(shardSlots.addrs) > stripes {
+			stripes = len(shardSlots.addrs)
+		}
+	}
+	for i := 0; i < stripes; i++ {
+		pHolder, qHolder := g.parityHolders(i)
+		lostP[i], lostQ[i] = pHolder.ind == member, qHolder.ind == member
+	}
+	n, err := g.removeMember(member)
+	if err != nil {
+		return nil, err
+	}
+	report := &churnReport{member: member}
+	for _, shard := range g.shardsOf(n) {
+		// the new holder's committed trie lacks the shard, so its slots read
+		// as erased until rebuilt
+		g.owner[shard] = g.leastLoaded()
+		lost = append(lost, shard)
+		report.shards++
+		report.accounts += g.slots[shard].len()
+	}
+	var (
+		start     = time.Now()
+		tries     = make(map[*EcNode]state.Trie)
+		received  = make(map[[2]int]int) // bytes sent by member pair
+		rebuilt   = make(map[*EcNode]struct{})
+		receivers []*EcNode
+	)
+	for i := 0; i < stripes; i++ {
+		slots, erased, sent := g.readStripe(i, tries, -1)
+		if len(erased) == 0 && !lostP[i] && !lostQ[i] {
+			continue
+		}
+		if err := rebuildStripe(g.parity[i], !lostP[i], !lostQ[i], slots, erased); err != nil {
+			return nil, fmt.Errorf("stripe %d: %w", i, err)
+		}
+		// the members rebuilding a slot or re-encoding the parity gather the
+		// stripe, and the parity left for a rebuild
+		var gatherers []*EcNode
+		for _, shard := range erased {
+			address, balance := slots[shard].decode()
+			if address != g.slots[shard].addrs[i] {
+				return nil, fmt.Errorf("stripe %d recovers %x instead of %x", i, address, g.slots[shard].addrs[i])
+			}
+			g.owner[shard].SetBalanceCold(address, balance)
+			gatherers = append(gatherers, g.owner[shard])
+		}
+		pHolder, qHolder := g.parityHolders(i)
+		if lostP[i] {
+			gatherers = append(gatherers, pHolder)
+		}
+		if lostQ[i] {
+			gatherers = append(gatherers, qHolder)
+		}
+		for _, to := range gatherers {
+			for from, bytes := range sent {
+				if from != to {
+					received[[2]int{from.ind, to.ind}] += bytes
+				}
+			}
+			if len(erased) > 0 {
+				for _, holder := range []*EcNode{pHolder, qHolder} {
+					if holder != to {
+						received[[2]int{holder.ind, to.ind}] += slotSize
+					}
+				}
+			}
+			if _, ok := rebuilt[to]; !ok {
+				rebuilt[to] = struct{}{}
+				receivers = append(receivers, to)
+			}
+		}
+		report.stripes++
+	}
+	// charge the transfers in a fixed order, for --net.seed to reproduce them
+	pairs := make([][2]int, 0, len(received))
+	for pair := range received {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0] || (pairs[i][0] == pairs[j][0] && pairs[i][1] < pairs[j][1])
+	})
+	for _, pair := range pairs {
+		report.bytes += received[pair]
+		if g.network != nil {
+			if elapsed := g.network.Send(pair[0], pair[1], received[pair]); elapsed > report.transfer {
+				report.transfer = elapsed
+			}
+		}
+	}
+	if g.network == nil {
+		report.transfer = time.Since(start)
+	}
+	if len(lost) > 0 || report.stripes > 0 {
+		report.window = report.transfer
+	}
+	for _, m := range receivers {
+		if err := m.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	if err := n.Clean(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// applyChurn applies the membership changes scheduled at the given height.
+// The cold shards are scrubbed first, so that no corrupted shard is handed
+// over.
+func (g *EcGroup) applyChurn(events []churnEvent, height int) error {
+	if err := g.scrub(height); err != nil {
+		return err
+	}
+	for _, event := range events {
+		var (
+			report *churnReport
+			err    error
+			kind   = "join"
+		)
+		if event.join {
+			report, err = g.Join()
+		} else if event.abrupt {
+			kind = "crash"
+			report, err = g.Crash(event.member)
+		} else {
+			kind = "leave"
+			report, err = g.Leave(event.member)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "churn", height, kind, report, "members", len(g.nodes))
+	}
+	g.agreeColdRoots()
+	return nil
+}
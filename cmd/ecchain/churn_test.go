@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+)
+
+// Tests that the shards of a crashed member are rebuilt from the other shards
+// and the parity by the members taking them over.
+func TestCrashRebuild(t *testing.T) {
+	g, err := NewEcGroup(2, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Clean()
+
+	balances := make(map[common.Address]*big.Int)
+	for i := 1; i <= 50; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i * 7919)))
+		addr[0] = byte(i * 5) // spread the accounts over the shards
+		balances[addr] = big.NewInt(int64(i))
+		g.addCold(addr, balances[addr])
+	}
+	if err := g.Commit(1, false, false); err != nil {
+		t.Fatal(err)
+	}
+	crashed := g.shardsOf(g.nodes[1])
+	report, err := g.Crash(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.accounts == 0 || report.stripes == 0 || report.bytes == 0 {
+		t.Fatalf("nothing rebuilt: %v", report)
+	}
+	for _, shard := range crashed {
+		if g.owner[shard].ind == 1 {
+			t.Fatalf("shard %d still held by the crashed member", shard)
+		}
+	}
+	for addr, want := range balances {
+		if have := g.GetNodeForAddress(addr).cold.GetBalance(addr); have.Cmp(want) != 0 {
+			t.Fatalf("account %x: balance %v, want %v", addr, have, want)
+		}
+	}
+	// The rebuilt shards are committed and agree with the parity again
+	g.agreeColdRoots()
+	if err := g.scrub(2); err != nil {
+		t.Fatal(err)
+	}
+}
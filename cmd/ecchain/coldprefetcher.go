@@ -0,0 +1,164 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"sync"
+	"time"
+)
+
+// ColdReader retrieves accounts that are not part of the local (hot) state from
+// the remote shard holding them. Implementations must verify the returned data
+// against the committed root of the shard and must be safe for concurrent use.
+type ColdReader interface {
+	// ReadCold returns the account stored in the cold shard, or nil if the
+	// shard proves that the account does not exist.
+	ReadCold(addr common.Address) (*types.StateAccount, error)
+}
+
+// ColdPrefetchStats are the cumulative statistics of a ColdPrefetcher.
+type ColdPrefetchStats struct {
+	Fetched   int           // Number of accounts fetched ahead of execution
+	Failed    int           // Number of fetches that failed or did not verify
+	Hits      int           // Number of cold reads served from the prefetched set
+	Misses    int           // Number of cold reads that had to go to the shard
+	FetchTime time.Duration // Summed latency of the individual fetches
+	WallTime  time.Duration // Wall time spent waiting for the parallel fetches
+}
+
+// HitRate returns the fraction of cold reads served by the prefetcher.
+func (s ColdPrefetchStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Saved returns the latency saved by fetching in parallel instead of one by
+// one on the execution path.
+func (s ColdPrefetchStats) Saved() time.Duration {
+	return s.FetchTime - s.WallTime
+}
+
+// ColdPrefetcher fetches the cold accounts referenced by a block from their
+// owning shards in parallel, before the block is executed, taking the remote
+// round-trips off the critical path. Prefetched accounts are handed out once
+// through Account and dropped by Reset at the end of the block.
+type ColdPrefetcher struct {
+	reader  ColdReader
+	threads int
+
+	lock     sync.Mutex
+	accounts map[common.Address]*types.StateAccount // Prefetched accounts, nil if proven absent
+	stats    ColdPrefetchStats
+}
+
+// NewColdPrefetcher creates a prefetcher reading through the given reader
+// with at most threads concurrent fetches.
+func NewColdPrefetcher(reader ColdReader, threads int) *ColdPrefetcher {
+	if threads < 1 {
+		threads = 1
+	}
+	return &ColdPrefetcher{
+		reader:   reader,
+		threads:  threads,
+		accounts: make(map[common.Address]*types.StateAccount),
+	}
+}
+
+// Prefetch fetches the given accounts in parallel and blocks until all of them
+// are either retrieved or failed. Accounts already prefetched are skipped.
+func (p *ColdPrefetcher) Prefetch(addrs []common.Address) {
+	var (
+		tasks     = make(chan common.Address, len(addrs))
+		wg        sync.WaitGroup
+		start     = time.Now()
+		fetchTime time.Duration // Summed latency of the fetches of this batch
+	)
+	p.lock.Lock()
+	seen := make(map[common.Address]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if _, ok := p.accounts[addr]; ok {
+			continue
+		}
+		if _, ok := seen[addr]; ok {
+			continue
+		}
+		seen[addr] = struct{}{}
+		tasks <- addr
+	}
+	p.lock.Unlock()
+	close(tasks)
+
+	if len(seen) == 0 {
+		return
+	}
+	threads := p.threads
+	if threads > len(seen) {
+		threads = len(seen)
+	}
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range tasks {
+				fetchStart := time.Now()
+				account, err := p.reader.ReadCold(addr)
+				elapsed := time.Since(fetchStart)
+
+				p.lock.Lock()
+				fetchTime += elapsed
+				if err != nil {
+					log.Debug("Failed to prefetch cold account", "addr", addr, "err", err)
+					p.stats.Failed++
+				} else {
+					p.accounts[addr] = account
+					p.stats.Fetched++
+				}
+				p.lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.stats.FetchTime += fetchTime
+	p.stats.WallTime += time.Since(start)
+}
+
+// Account returns the prefetched account and whether the read was served by
+// the prefetcher. A nil account with a hit means the shard proved the account
+// absent.
+func (p *ColdPrefetcher) Account(addr common.Address) (*types.StateAccount, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	account, ok := p.accounts[addr]
+	if !ok {
+		p.stats.Misses++
+		return nil, false
+	}
+	delete(p.accounts, addr)
+	p.stats.Hits++
+	return account, true
+}
+
+// Reset drops the prefetched accounts that were not used. It should be called
+// once the block is executed, since the cold shards change at commit.
+func (p *ColdPrefetcher) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.accounts = make(map[common.Address]*types.StateAccount)
+}
+
+// Stats returns the cumulative statistics of the prefetcher.
+func (p *ColdPrefetcher) Stats() ColdPrefetchStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.stats
+}
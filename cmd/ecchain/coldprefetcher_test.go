@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testColdReader serves accounts from a map with a fixed latency per read.
+type testColdReader struct {
+	accounts map[common.Address]*types.StateAccount
+	broken   map[common.Address]bool
+	delay    time.Duration
+
+	lock  sync.Mutex
+	reads int
+}
+
+func (r *testColdReader) ReadCold(addr common.Address) (*types.StateAccount, error) {
+	time.Sleep(r.delay)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.reads++
+	if r.broken[addr] {
+		return nil, errors.New("proof verification failed")
+	}
+	return r.accounts[addr], nil
+}
+
+func TestColdPrefetcher(t *testing.T) {
+	var (
+		addrs  []common.Address
+		reader = &testColdReader{
+			accounts: make(map[common.Address]*types.StateAccount),
+			broken:   make(map[common.Address]bool),
+			delay:    10 * time.Millisecond,
+		}
+	)
+	for i := 0; i < 16; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		addrs = append(addrs, addr)
+		reader.accounts[addr] = &types.StateAccount{Balance: big.NewInt(int64(i))}
+	}
+	absent := common.HexToAddress("0xdead")
+	broken := common.HexToAddress("0xbeef")
+	reader.broken[broken] = true
+
+	prefetcher := NewColdPrefetcher(reader, 8)
+	prefetcher.Prefetch(append(append(addrs, addrs[0], absent), broken))
+	if reader.reads != len(addrs)+2 {
+		t.Fatalf("duplicate reads: have %d, want %d", reader.reads, len(addrs)+2)
+	}
+	for i, addr := range addrs {
+		account, ok := prefetcher.Account(addr)
+		if !ok {
+			t.Fatalf("account %d: not prefetched", i)
+		}
+		if account.Balance.Int64() != int64(i) {
+			t.Fatalf("account %d: balance mismatch, have %v", i, account.Balance)
+		}
+	}
+	if account, ok := prefetcher.Account(absent); !ok || account != nil {
+		t.Fatalf("absent account: have %v (hit %v), want proven absence", account, ok)
+	}
+	if _, ok := prefetcher.Account(broken); ok {
+		t.Fatal("failed fetch served as a hit")
+	}
+	// Accounts are handed out once, a second read goes to the shard.
+	if _, ok := prefetcher.Account(addrs[0]); ok {
+		t.Fatal("prefetched account served twice")
+	}
+	stats := prefetcher.Stats()
+	if stats.Fetched != len(addrs)+1 || stats.Failed != 1 {
+		t.Fatalf("fetch counters mismatch: fetched %d, failed %d", stats.Fetched, stats.Failed)
+	}
+	if stats.Hits != len(addrs)+1 || stats.Misses != 2 {
+		t.Fatalf("hit counters mismatch: hits %d, misses %d", stats.Hits, stats.Misses)
+	}
+	if stats.HitRate() <= 0.85 {
+		t.Fatalf("hit rate too low: %v", stats.HitRate())
+	}
+	// 18 reads of 10ms on 8 threads should take three rounds, not eighteen.
+	if stats.Saved() <= 0 || stats.WallTime >= stats.FetchTime {
+		t.Fatalf("no latency saved: fetch %v, wall %v", stats.FetchTime, stats.WallTime)
+	}
+}
+
+func TestColdPrefetcherReset(t *testing.T) {
+	addr := common.HexToAddress("0x01")
+	reader := &testColdReader{
+		accounts: map[common.Address]*types.StateAccount{addr: {Balance: big.NewInt(1)}},
+	}
+	prefetcher := NewColdPrefetcher(reader, 1)
+	prefetcher.Prefetch([]common.Address{addr})
+	prefetcher.Prefetch([]common.Address{addr})
+	if reader.reads != 1 {
+		t.Fatalf("prefetched account fetched again: %d reads", reader.reads)
+	}
+	prefetcher.Reset()
+	if _, ok := prefetcher.Account(addr); ok {
+		t.Fatal("account served after reset")
+	}
+}
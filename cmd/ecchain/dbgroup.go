@@ -9,9 +9,10 @@ import (
 )
 
 type DbGroup struct {
-	k     int // size = 2^k
-	size  int
-	nodes []*DbNode
+	k       int // size = 2^k
+	size    int
+	nodes   []*DbNode
+	network *NetworkModel // nil if communication is free
 }
 
 func NewDbGroup(k int) (*DbGroup, error) {
@@ -37,17 +38,19 @@ func (g *DbGroup) executeTx(tx txFromZip) time.Duration {
 		g.GetNodeForAddress(addr).AddBalance(addr, tx.value)
 	}
 	timeSpent := time.Since(timeBegin)
+
+	// a tx spanning two shards is forwarded from the sender's shard
+	if g.network != nil {
+		from := GetIndForAddress(g.k, common.HexToAddress(tx.sender))
+		to := GetIndForAddress(g.k, common.HexToAddress(tx.to))
+		timeSpent += g.network.Send(from, to, crossShardTxSize)
+	}
 	return timeSpent
 }
 
 func (g *DbGroup) Commit(height int, measureStorage, measureTime bool) error {
 	for _, n := range g.nodes {
-		root, err := n.stateDb.Commit(true)
-		if err != nil {
-			return err
-		}
-		err = n.trieDb.Commit(root, false)
-		if err != nil {
+		if err := n.Commit(); err != nil {
 			return err
 		}
 
@@ -79,11 +82,22 @@ var dbGroupCmd = &cli.Command{
 		measureTimeFlag,
 		measureStorageFlag,
 		indFlag,
+		noSnapshotFlag,
+		gcFlag,
+		trieCacheFlag,
+		netTopologyFlag,
+		netLatencyFlag,
+		netJitterFlag,
+		netDistributionFlag,
+		netBandwidthFlag,
+		netLossFlag,
+		netSeedFlag,
 	},
 	Description: "ecchain dbgroup /path/to/my.zip",
 }
 
 func dbGroup(ctx *cli.Context) error {
+	applyNodeFlags(ctx)
 	if ctx.IsSet(indFlag.Name) {
 		return oneNodeFromDBGroup(ctx)
 	}
@@ -95,6 +109,9 @@ func dbGroup(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if g.network, err = newNetworkModel(ctx); err != nil {
+		return err
+	}
 	txCount := 0
 	timeSum := time.Duration(0)
 	err = processTxFromZip(func(height int) error {
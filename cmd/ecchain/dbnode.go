@@ -1,27 +1,82 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/prque"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
+	"github.com/urfave/cli/v2"
 	"math/big"
+	"math/rand"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// trieDirtyLimit is the memory allowance of the dirty trie nodes of a node
+// running with garbage collection, above which the oldest ones are flushed.
+const trieDirtyLimit = 256 * 1024 * 1024
+
+// defaultCache is the memory allowance in MB of the database and snapshot
+// caches of a node, if not sized by --trie.cache.
+const defaultCache = 256
+
+var (
+	// noSnapshot disables the snapshot of newly created nodes (--nosnapshot).
+	noSnapshot bool
+
+	// gcRoots is the number of recent state roots a node keeps referenced in
+	// memory (--gc). Zero disables garbage collection and flushes every root.
+	gcRoots int
+
+	// trieCache is the memory allowance in MB of the trie cache of a node
+	// (--trie.cache), used for clean nodes and as the dirty node limit. It
+	// also sizes the database and snapshot caches. Zero keeps no clean cache
+	// and uses trieDirtyLimit and defaultCache.
+	trieCache int
+)
+
+// cacheSize returns the memory allowance in MB of the database and snapshot
+// caches of a node.
+func cacheSize() int {
+	if trieCache != 0 {
+		return trieCache
+	}
+	return defaultCache
+}
+
+// applyNodeFlags configures the nodes created by the command from its flags.
+func applyNodeFlags(ctx *cli.Context) {
+	noSnapshot = ctx.IsSet(noSnapshotFlag.Name)
+	gcRoots = ctx.Int(gcFlag.Name)
+	trieCache = ctx.Int(trieCacheFlag.Name)
+}
+
 type DbNode struct {
 	ind     int
 	datadir string
 	stateDb *state.StateDB
+	db      state.Database
 	trieDb  *trie.Database
+	snaps   *snapshot.Tree // nil if the node serves reads from the trie only
+	root    common.Hash    // root of the last committed state
+
+	commits int64                            // number of commits, used to order the roots to gc
+	triegc  *prque.Prque[int64, common.Hash] // recent roots kept alive in the trie database
 }
 
 func NewDbNode(ind int) (n *DbNode, err error) {
@@ -42,21 +97,34 @@ func NewDbNode(ind int) (n *DbNode, err error) {
 		UseLightweightKDF: true,
 	}
 	tempNode, err := node.New(nodeConfig)
+	if err != nil {
+		return
+	}
 
-	chainDb, err := tempNode.OpenDatabaseWithFreezer("chaindata", 256, 256, "", "eth/db/chaindata/", false)
-	trieDb := trie.NewDatabase(chainDb)
-
-	// prepare snaps
-	snapconfig := snapshot.Config{
-		CacheSize:  256,
-		Recovery:   false,
-		NoBuild:    false,
-		AsyncBuild: false,
+	chainDb, err := tempNode.OpenDatabaseWithFreezer("chaindata", cacheSize(), 256, "", "eth/db/chaindata/", false)
+	if err != nil {
+		return
 	}
+	trieDb := trie.NewDatabaseWithConfig(chainDb, &trie.Config{Cache: trieCache})
 
-	snaps, _ := snapshot.New(snapconfig, chainDb, trieDb, common.HexToHash("hellomynameisghc")) // TODO I'm not sure about this code
+	// prepare snaps, rooted at the empty genesis state of the node. The tree
+	// is moved forward by StateDB.Commit, which updates and caps it.
+	var snaps *snapshot.Tree
+	if !noSnapshot {
+		snapconfig := snapshot.Config{
+			CacheSize:  cacheSize(),
+			Recovery:   false,
+			NoBuild:    false,
+			AsyncBuild: false,
+		}
+		snaps, err = snapshot.New(snapconfig, chainDb, trieDb, types.EmptyRootHash)
+		if err != nil {
+			return
+		}
+	}
 
-	stateDB, err := state.New(common.Hash{}, state.NewDatabaseWithNodeDB(chainDb, trieDb), snaps)
+	db := state.NewDatabaseWithNodeDB(chainDb, trieDb)
+	stateDB, err := state.New(types.EmptyRootHash, db, snaps)
 
 	if err != nil {
 		return
@@ -66,7 +134,11 @@ func NewDbNode(ind int) (n *DbNode, err error) {
 		ind:     ind,
 		datadir: datadir,
 		stateDb: stateDB,
+		db:      db,
 		trieDb:  trieDb,
+		snaps:   snaps,
+		root:    types.EmptyRootHash,
+		triegc:  prque.New[int64, common.Hash](nil),
 	}, nil
 }
 
@@ -86,9 +158,12 @@ func (dbNode *DbNode) Exist(address common.Address) bool {
 	return dbNode.stateDb.Exist(address)
 }
 
+// Delete removes the account from the state at the next commit. It goes
+// through Suicide rather than deleting from the trie directly, so that the
+// deletion also reaches the snapshot.
 func (dbNode *DbNode) Delete(address common.Address) {
-	obj := dbNode.stateDb.GetOrNewStateObject(address)
-	dbNode.stateDb.DeleteStateObject(obj)
+	dbNode.stateDb.GetOrNewStateObject(address)
+	dbNode.stateDb.Suicide(address)
 }
 
 func (dbNode *DbNode) AddBalance(address common.Address, value *big.Int) {
@@ -107,7 +182,15 @@ func (dbNode *DbNode) GetNonce(address common.Address) uint64 {
 	return dbNode.stateDb.GetNonce(address)
 }
 
+// Clean stops the node and removes its datadir. With garbage collection
+// enabled the last root only lives in memory, so it's committed to disk first
+// like a node shutting down, which fails if the live trie is incomplete.
 func (dbNode *DbNode) Clean() error {
+	if gcRoots != 0 {
+		if err := dbNode.trieDb.Commit(dbNode.root, false); err != nil {
+			return err
+		}
+	}
 	err := os.RemoveAll(dbNode.datadir)
 	if err != nil {
 		return err
@@ -116,17 +199,310 @@ func (dbNode *DbNode) Clean() error {
 }
 
 func (dbNode *DbNode) Commit() error {
+	// StateDB.Commit carries on past trie errors hit while hashing, e.g. on
+	// corrupted nodes, so check for them before committing a wrong root.
+	dbNode.stateDb.IntermediateRoot(true)
+	if err := dbNode.stateDb.Error(); err != nil {
+		return err
+	}
 	root, err := dbNode.stateDb.Commit(true)
 	if err != nil {
 		return err
 	}
-	err = dbNode.trieDb.Commit(root, false)
+	err = dbNode.commitTrie(root)
+	if err != nil {
+		return err
+	}
+	dbNode.root = root
+
+	// A StateDB only consults the snapshot layer it was opened on, so reopen
+	// it at the new root to keep the following reads served by the snapshot.
+	dbNode.stateDb, err = state.New(root, dbNode.db, dbNode.snaps)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// importState replaces the state of the node by the last committed state of
+// src, copying the trie nodes over. It returns the number of bytes copied.
+func (dbNode *DbNode) importState(src *DbNode) (int, error) {
+	tr, err := src.db.OpenTrie(src.root)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		size  int
+		batch = dbNode.db.DiskDB().NewBatch()
+		it    = tr.NodeIterator(nil)
+	)
+	for it.Next(true) {
+		if it.Hash() == (common.Hash{}) {
+			continue // embedded in its parent
+		}
+		blob := it.NodeBlob()
+		rawdb.WriteLegacyTrieNode(batch, it.Hash(), blob)
+		size += len(blob)
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return 0, err
+			}
+			batch.Reset()
+		}
+	}
+	if it.Error() != nil {
+		return 0, it.Error()
+	}
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	if err := dbNode.resetState(src.root); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// resetState discards the uncommitted changes and reopens the state at the
+// given root, whose trie nodes must be on disk. The snapshot is regenerated
+// if the root differs from the last committed one.
+func (dbNode *DbNode) resetState(root common.Hash) (err error) {
+	if dbNode.snaps != nil && root != dbNode.root {
+		dbNode.snaps.Rebuild(root)
+	}
+	dbNode.root = root
+	dbNode.stateDb, err = state.New(root, dbNode.db, dbNode.snaps)
+	return err
+}
+
+// recomputeRoot walks the leaves of the last committed trie as stored by the
+// node and hashes them into a fresh trie, returning the resulting root.
+func (dbNode *DbNode) recomputeRoot() (common.Hash, error) {
+	tr, err := dbNode.db.OpenTrie(dbNode.root)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	var (
+		st = trie.NewStackTrie(nil)
+		it = trie.NewIterator(tr.NodeIterator(nil))
+	)
+	for it.Next() {
+		st.Update(it.Key, it.Value)
+	}
+	if it.Err != nil {
+		return common.Hash{}, it.Err
+	}
+	return st.Hash(), nil
+}
+
+// writeTrie writes the trie of the given leaves, keyed by account hash, to
+// disk and returns its root. Nodes already on disk are overwritten, which
+// repairs any corrupted copy of them.
+func (dbNode *DbNode) writeTrie(leaves map[common.Hash][]byte) (common.Hash, error) {
+	keys := make([]common.Hash, 0, len(leaves))
+	for key := range leaves {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+
+	batch := dbNode.db.DiskDB().NewBatch()
+	st := trie.NewStackTrie(func(owner common.Hash, path []byte, hash common.Hash, blob []byte) {
+		rawdb.WriteLegacyTrieNode(batch, hash, blob)
+	})
+	for _, key := range keys {
+		st.Update(key[:], leaves[key])
+	}
+	root, err := st.Commit()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return root, batch.Write()
+}
+
+// corruptNode flips a byte of a random trie node of the last committed state
+// that is stored on disk, keeping the node and the account of a leaf
+// decodable. It returns the hash of
+// the corrupted node, or false if no node is on disk yet.
+func (dbNode *DbNode) corruptNode(rng *rand.Rand) (common.Hash, bool, error) {
+	tr, err := dbNode.db.OpenTrie(dbNode.root)
+	if err != nil {
+		return common.Hash{}, false, err
+	}
+	var (
+		diskdb = dbNode.db.DiskDB()
+		hashes []common.Hash
+		it     = tr.NodeIterator(nil)
+	)
+	// Nodes below an earlier corruption can't be reached, pick among the rest
+	for it.Next(true) {
+		if hash := it.Hash(); hash != (common.Hash{}) && rawdb.HasLegacyTrieNode(diskdb, hash) {
+			hashes = append(hashes, hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return common.Hash{}, false, nil
+	}
+	hash := hashes[rng.Intn(len(hashes))]
+	blob := common.CopyBytes(rawdb.ReadLegacyTrieNode(diskdb, hash))
+
+	// Only flip bytes inside the string items of the node (child hashes and
+	// values), not the keys or the RLP framing, so the node still decodes and
+	// the damage shows up as wrong data or missing children.
+	elems, _, err := rlp.SplitList(blob)
+	if err != nil {
+		return common.Hash{}, false, err
+	}
+	var (
+		offset  = len(blob) - len(elems)
+		targets [][2]int // offset and length of the string items
+		items   int
+		leaf    bool
+	)
+	for rest := elems; len(rest) > 0; items++ {
+		kind, content, tail, err := rlp.Split(rest)
+		if err != nil {
+			return common.Hash{}, false, err
+		}
+		if items == 0 && kind == rlp.String && len(content) > 0 {
+			leaf = content[0]&0x20 != 0 // the terminator flag, if it's the key of a short node
+		}
+		if kind == rlp.String && len(content) > 0 {
+			targets = append(targets, [2]int{offset + len(rest) - len(tail) - len(content), len(content)})
+		}
+		offset += len(rest) - len(tail)
+		rest = tail
+	}
+	if items == 2 {
+		// a short node, leave its key alone
+		if len(targets) == 2 {
+			targets = targets[1:]
+		} else {
+			targets = nil
+		}
+	} else {
+		leaf = false
+	}
+	if len(targets) == 0 {
+		return common.Hash{}, false, nil
+	}
+	// The account in a leaf must still decode, or the trie database fails
+	// to commit the leaf once it moves, instead of the group detecting it
+	for attempt := 0; attempt < 16; attempt++ {
+		corrupted := common.CopyBytes(blob)
+		target := targets[rng.Intn(len(targets))]
+		corrupted[target[0]+rng.Intn(target[1])] ^= byte(1 + rng.Intn(255))
+		if leaf && rlp.DecodeBytes(corrupted[target[0]:target[0]+target[1]], new(types.StateAccount)) != nil {
+			continue
+		}
+		rawdb.WriteLegacyTrieNode(diskdb, hash, corrupted)
+		return hash, true, nil
+	}
+	return common.Hash{}, false, nil
+}
+
+// ReadLatency measures the time to read the given accounts from the last
+// committed state, once through the snapshot and once through the trie.
+// The snapshot time is zero if the node has no snapshot.
+func (dbNode *DbNode) ReadLatency(addresses []common.Address) (snapTime, trieTime time.Duration, err error) {
+	if dbNode.snaps != nil {
+		snap := dbNode.snaps.Snapshot(dbNode.root)
+		if snap == nil {
+			return 0, 0, fmt.Errorf("missing snapshot for root %x", dbNode.root)
+		}
+		timeBegin := time.Now()
+		for _, addr := range addresses {
+			if _, err = snap.Account(crypto.Keccak256Hash(addr.Bytes())); err != nil {
+				return
+			}
+		}
+		snapTime = time.Since(timeBegin)
+	}
+	tr, err := dbNode.db.OpenTrie(dbNode.root)
+	if err != nil {
+		return
+	}
+	timeBegin := time.Now()
+	for _, addr := range addresses {
+		if _, err = tr.GetAccount(addr); err != nil {
+			return
+		}
+	}
+	trieTime = time.Since(timeBegin)
+	return
+}
+
+// printReadLatency outputs the average snapshot-backed and trie-only read
+// latency in nanoseconds of the given accounts.
+func (dbNode *DbNode) printReadLatency(addrStrings []string) error {
+	if len(addrStrings) == 0 {
+		fmt.Print(" -1 -1")
+		return nil
+	}
+	addresses := make([]common.Address, 0, len(addrStrings))
+	for _, addrString := range addrStrings {
+		addresses = append(addresses, common.HexToAddress(addrString))
+	}
+	snapTime, trieTime, err := dbNode.ReadLatency(addresses)
 	if err != nil {
 		return err
 	}
+	fmt.Print(" ", float64(snapTime.Nanoseconds())/float64(len(addresses)))
+	fmt.Print(" ", float64(trieTime.Nanoseconds())/float64(len(addresses)))
 	return nil
 }
 
+// commitTrie persists the trie nodes of the given root. With garbage collection
+// enabled only the last gcRoots roots are kept referenced, so nodes that are
+// stale by then (e.g. of accounts moved to the cold tier) are dropped from
+// memory before ever reaching the disk. In both modes the dirty nodes left in
+// memory are capped to the trie cache allowance.
+func (dbNode *DbNode) commitTrie(root common.Hash) error {
+	if gcRoots == 0 {
+		if err := dbNode.trieDb.Commit(root, false); err != nil {
+			return err
+		}
+	} else {
+		dbNode.commits++
+		dbNode.trieDb.Reference(root, common.Hash{}) // metadata reference to keep trie alive
+		dbNode.triegc.Push(root, -dbNode.commits)
+
+		for dbNode.triegc.Size() > gcRoots {
+			dbNode.trieDb.Dereference(dbNode.triegc.PopItem())
+		}
+	}
+	limit := common.StorageSize(trieDirtyLimit)
+	if trieCache != 0 {
+		limit = common.StorageSize(trieCache * 1024 * 1024)
+	}
+	if nodes, _ := dbNode.trieDb.Size(); nodes > limit {
+		return dbNode.trieDb.Cap(limit - ethdb.IdealBatchSize)
+	}
+	return nil
+}
+
+// Root returns the root hash of the last committed state.
+func (dbNode *DbNode) Root() common.Hash {
+	return dbNode.root
+}
+
+// GetProof returns the Merkle proof of the given account against the last
+// committed state, together with the account as it is stored there.
+func (dbNode *DbNode) GetProof(address common.Address) ([][]byte, *big.Int, uint64, error) {
+	return dbNode.GetProofAt(dbNode.root, address)
+}
+
+// GetProofAt is like GetProof, against the committed state with the given root.
+func (dbNode *DbNode) GetProofAt(root common.Hash, address common.Address) ([][]byte, *big.Int, uint64, error) {
+	committed, err := state.New(root, dbNode.db, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	proof, err := committed.GetProof(address)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return proof, committed.GetBalance(address), committed.GetNonce(address), nil
+}
+
 func (dbNode *DbNode) executeTx(tx txFromZip) time.Duration {
 	timeBegin := time.Now()
 	for _, addrString := range []string{tx.sender, tx.to} {
@@ -136,10 +512,17 @@ func (dbNode *DbNode) executeTx(tx txFromZip) time.Duration {
 	return time.Since(timeBegin)
 }
 
+// StorageCost returns the storage used by the node in kilobytes. With garbage
+// collection enabled, the live trie nodes not yet flushed to disk are counted
+// too, so the cost reflects the retained state rather than the flush timing.
 func (dbNode *DbNode) StorageCost() int {
 	cmdOutput, _ := exec.Command("du", "-s", dbNode.datadir).Output()
 	storageCost := string(cmdOutput)
 	storageCost = strings.Fields(storageCost)[0]
 	costInt, _ := strconv.Atoi(storageCost)
+	if gcRoots != 0 {
+		nodes, _ := dbNode.trieDb.Size()
+		costInt += int(nodes / 1024)
+	}
 	return costInt
 }
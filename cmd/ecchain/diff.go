@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+)
+
+// hotDiffAccount is the state of an account modified by a block.
+type hotDiffAccount struct {
+	Address common.Address
+	Balance *big.Int
+	Deleted bool
+}
+
+// hotDiff is the change of the hot state made by a block, as shipped by the
+// executor to the other hot replicas, together with the resulting hot root.
+type hotDiff struct {
+	Root     common.Hash
+	Accounts []hotDiffAccount
+}
+
+// replayTx is the part of a tx a replica needs to re-execute it.
+type replayTx struct {
+	Sender common.Address
+	To     common.Address
+	Value  *big.Int
+}
+
+// replicationStats are the cumulative costs of replicating the hot state by
+// diffs. The executor pays the execution of every block, each other replica
+// only the application of its diff. The costs of re-executing instead, where
+// every replica receives the txs and pays the execution, are kept alongside.
+type replicationStats struct {
+	blocks     int
+	applies    int           // diffs applied, one per block and replica
+	bytes      int           // encoded size of the diffs
+	txBytes    int           // encoded size of the txs, shipped to each replica when re-executing
+	execute    time.Duration // hot writes and commits of the executor, paid by each replica when re-executing
+	encode     time.Duration // collection and encoding of the diffs by the executor
+	apply      time.Duration // decoding, application and commits of the replicas
+	mismatches int           // replicas whose hot root didn't match the executor's
+}
+
+// countTx adds the encoded size of a tx to the bytes of re-executing.
+func (s *replicationStats) countTx(tx txFromZip) {
+	blob, err := rlp.EncodeToBytes(&replayTx{
+		Sender: common.HexToAddress(tx.sender),
+		To:     common.HexToAddress(tx.to),
+		Value:  tx.value,
+	})
+	if err != nil {
+		return
+	}
+	s.txBytes += len(blob)
+}
+
+// stateDiff collects the accounts modified since the last commit from the
+// dirty set of the state.
+func (dbNode *DbNode) stateDiff() *hotDiff {
+	addrs := dbNode.stateDb.DirtyAccounts(true)
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+
+	diff := &hotDiff{Accounts: make([]hotDiffAccount, 0, len(addrs))}
+	for _, address := range addrs {
+		if !dbNode.stateDb.Exist(address) {
+			diff.Accounts = append(diff.Accounts, hotDiffAccount{Address: address, Balance: new(big.Int), Deleted: true})
+			continue
+		}
+		diff.Accounts = append(diff.Accounts, hotDiffAccount{Address: address, Balance: dbNode.stateDb.GetBalance(address)})
+	}
+	return diff
+}
+
+// applyDiff writes the accounts of a diff into the state, without committing.
+func (dbNode *DbNode) applyDiff(diff *hotDiff) {
+	for _, account := range diff.Accounts {
+		if account.Deleted {
+			if dbNode.stateDb.Exist(account.Address) {
+				dbNode.Delete(account.Address)
+			}
+			continue
+		}
+		dbNode.SetBalance(account.Address, account.Balance)
+	}
+}
+
+// hotWriters returns the members executing the writes of a block to the hot
+// state: all of them, or only the executor when replicating by diffs.
+func (g *EcGroup) hotWriters() []*EcNode {
+	if g.diffReplication {
+		return g.nodes[:1]
+	}
+	return g.nodes
+}
+
+// writeHot applies a write to the hot state of the members executing it. It
+// returns the network time of replicating the write from the executor to the
+// other members, which is zero when replicating by diffs since the diff is
+// shipped once per block.
+func (g *EcGroup) writeHot(write func(n *EcNode)) time.Duration {
+	start := time.Now()
+	writers := g.hotWriters()
+	for _, n := range writers {
+		write(n)
+	}
+	g.replication.execute += time.Since(start)
+
+	if g.network == nil || len(writers) == 1 {
+		return 0
+	}
+	return g.network.SendAll(g.nodes[0].ind, accountWriteSize, g.memberInds())
+}
+
+// replicateHot commits the hot state of the executor and ships the diff of
+// the block to the other members, which apply it and check they reach the
+// same hot root. A diverged replica is resynced from the executor.
+func (g *EcGroup) replicateHot(height int) error {
+	executor := g.nodes[0]
+	start := time.Now()
+	diff := executor.hot.stateDiff()
+	encode := time.Since(start)
+
+	start = time.Now()
+	if err := executor.hot.Commit(); err != nil {
+		return err
+	}
+	g.replication.execute += time.Since(start)
+
+	start = time.Now()
+	diff.Root = executor.hot.Root()
+	blob, err := rlp.EncodeToBytes(diff)
+	if err != nil {
+		return err
+	}
+	g.replication.encode += encode + time.Since(start)
+	g.replication.blocks++
+	g.replication.bytes += len(blob)
+
+	for _, n := range g.nodes[1:] {
+		if g.network != nil {
+			g.network.Send(executor.ind, n.ind, len(blob))
+		}
+		start := time.Now()
+		var received hotDiff
+		if err := rlp.DecodeBytes(blob, &received); err != nil {
+			return err
+		}
+		n.hot.applyDiff(&received)
+		if err := n.hot.Commit(); err != nil {
+			return err
+		}
+		g.replication.apply += time.Since(start)
+		g.replication.applies++
+
+		if root := n.hot.Root(); root != received.Root {
+			fmt.Fprintln(os.Stderr, "hot diff", height, "member", n.ind, "root", root, "want", received.Root)
+			g.replication.mismatches++
+			if _, err := n.hot.importState(executor.hot); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// print outputs the bytes shipped and the time the executor spent per block,
+// and the time a replica spent applying a diff, followed by the bytes shipped
+// and the time a replica spent per block when re-executing, since the given
+// stats.
+func (s replicationStats) print(since replicationStats) {
+	blocks, applies := s.blocks-since.blocks, s.applies-since.applies
+	if blocks == 0 {
+		fmt.Print(" -1 -1 -1 -1 -1")
+		return
+	}
+	execute := s.execute - since.execute
+	fmt.Print(" ", (s.bytes-since.bytes)/blocks, " ", (execute+s.encode-since.encode).Nanoseconds()/int64(blocks))
+	if applies == 0 {
+		fmt.Print(" -1")
+	} else {
+		fmt.Print(" ", (s.apply-since.apply).Nanoseconds()/int64(applies))
+	}
+	fmt.Print(" ", (s.txBytes-since.txBytes)/blocks, " ", execute.Nanoseconds()/int64(blocks))
+}
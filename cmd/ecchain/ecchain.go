@@ -3,25 +3,62 @@ package main
 import (
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/urfave/cli/v2"
 	"math"
 	"math/big"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
 type EcGroup struct {
 	k                    int
-	size                 int
+	size                 int // number of cold shards
 	recency              int
 	frequency            float64
 	nodes                []*EcNode
+	owner                []*EcNode            // member holding each cold shard
+	slots                []*shardSlots        // slots of the committed cold accounts of each shard
+	parity               map[int]stripeParity // parity of each stripe of slots
+	coldChanges          []coldChange         // cold changes of the block, applied to the slots at commit
+	agreedRoots          map[int]common.Hash  // committed cold root of each member, as agreed by the group
+	nextInd              int                  // index given to the next member to join
 	blockToExpireNode    *DbNode
 	createdHeightNode    *DbNode
 	accessTimeNode       *DbNode
 	accountsToExpireNode *DbNode
+	prefetcher           *ColdPrefetcher // nil if cold accounts are read on demand
+	prefetchThreads      int             // parallel fetches of the prefetcher
+	network              *NetworkModel   // nil if communication is free
+	scrubInterval        int             // blocks between scrubs, zero to only scrub suspects
+	faultRate            float64         // probability per block of corrupting a cold trie node
+	faultRng             *rand.Rand
+	challengeInterval    int                      // blocks per challenge epoch, zero to not challenge
+	challengeCount       int                      // challenges per member and epoch
+	challengeDeadline    time.Duration            // time allowed to deliver a proof, zero for no deadline
+	challenges           map[int]*challengeRecord // challenge history of each member
+	diffReplication      bool                     // only the first member executes, the others apply its state diffs
+	replication          replicationStats
+	rentConfig           *params.ChainConfig                 // nil if the hot accounts pay no rent
+	rentExpiry           map[int]map[common.Address]struct{} // hot accounts to check for unpaid rent at each height
+
+	lock        sync.Mutex       // Protects the nodes against concurrent RPC access
+	suspectLock sync.Mutex       // Protects suspects, cold reads are concurrent when prefetching
+	suspects    map[int]struct{} // members that served cold data not matching their agreed root
+
+	prefetchLock  sync.Mutex             // Protects prefetchSizes, cold reads are concurrent when prefetching
+	prefetchSizes map[common.Address]int // bytes served per cold account of the block being prefetched
 }
 
 func NewEcGroup(k, recency int, frequency float64) (*EcGroup, error) {
+	if k < 0 || k > maxGroupBits {
+		return nil, fmt.Errorf("invalid group size 2^%d, at most 2^%d shards are supported", k, maxGroupBits)
+	}
 	g := &EcGroup{
 		k:         k,
 		size:      1 << k,
@@ -33,6 +70,16 @@ func NewEcGroup(k, recency int, frequency float64) (*EcGroup, error) {
 	if err != nil {
 		return nil, err
 	}
+	for i := 0; i < g.size; i++ {
+		g.owner = append(g.owner, g.nodes[i])
+		g.slots = append(g.slots, newShardSlots())
+	}
+	g.parity = make(map[int]stripeParity)
+	g.suspects = make(map[int]struct{})
+	g.challenges = make(map[int]*challengeRecord)
+	g.rentExpiry = make(map[int]map[common.Address]struct{})
+	g.agreeColdRoots()
+	g.nextInd = g.size
 	g.blockToExpireNode, err = NewDbNode(g.size)
 	if err != nil {
 		return nil, err
@@ -63,41 +110,52 @@ func GetIndForAddress(k int, address common.Address) int {
 }
 
 func (g *EcGroup) GetNodeForAddress(address common.Address) *EcNode {
-	return g.nodes[GetIndForAddress(g.k, address)]
+	return g.owner[GetIndForAddress(g.k, address)]
+}
+
+// memberInds returns the indices of the current members of the group.
+func (g *EcGroup) memberInds() []int {
+	inds := make([]int, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		inds = append(inds, n.ind)
+	}
+	return inds
 }
 
 var accountCounts [][2]int
 
+// maxExpiryHeight bounds the heights accounts are scheduled to expire at,
+// later ones are beyond the replayed history.
+const maxExpiryHeight = 4000000
+
 func (g *EcGroup) executeTx(tx txFromZip) time.Duration {
 	timeBegin := time.Now()
+	netTime := time.Duration(0)
 	accountsToExpire = make(map[int]map[string]bool)
 	for _, addrString := range []string{tx.sender, tx.to} {
 		addr := common.HexToAddress(addrString)
 		if g.IsHot(addr) { // the address exists and it's hot
-			for _, ecNode := range g.nodes {
+			netTime += g.writeHot(func(ecNode *EcNode) {
 				ecNode.AddBalanceHot(addr, tx.value)
-			}
+			})
 		} else {
 			if g.GetNodeForAddress(addr).cold.Exist(addr) { // the address exists and is cold, move it to hot
 				fmt.Println("cold")
 				// remove addr from cold
-				cold := g.GetNodeForAddress(addr).cold
+				coldBalance, coldNetTime := g.coldBalance(addr)
+				netTime += coldNetTime
 				balance := big.NewInt(0)
-				balance.Add(cold.stateDb.GetBalance(addr), tx.value)
-				cold.Delete(addr)
+				balance.Add(coldBalance, tx.value)
+				g.removeCold(addr, coldBalance)
 
 				// add addr to hot
-				for _, ecNode := range g.nodes {
-					func(ecNode *EcNode) {
-						ecNode.AddBalanceHot(addr, balance)
-					}(ecNode)
-				}
+				netTime += g.writeHot(func(ecNode *EcNode) {
+					ecNode.AddBalanceHot(addr, balance)
+				})
 			} else { // the address doesn't exist, create it
-				for _, ecNode := range g.nodes {
-					func(ecNode *EcNode) {
-						ecNode.AddBalanceHot(addr, tx.value)
-					}(ecNode)
-				}
+				netTime += g.writeHot(func(ecNode *EcNode) {
+					ecNode.AddBalanceHot(addr, tx.value)
+				})
 			}
 		}
 		if _, ok := accessTime[addrString]; !ok {
@@ -112,7 +170,10 @@ func (g *EcGroup) executeTx(tx txFromZip) time.Duration {
 			}
 			return b
 		}(tx.blockNumber+g.recency, int(g.createdHeightNode.GetBalance(addr).Int64())+int(math.Ceil(float64(g.accessTimeNode.GetBalance(addr).Int64())/g.frequency)))
-		if newBlockToExpire < 4000000 {
+		if g.rentConfig != nil {
+			g.chargeRent(addr, tx.blockNumber)
+		}
+		if newBlockToExpire < maxExpiryHeight {
 			g.blockToExpireNode.SetBalance(addr, big.NewInt(int64(newBlockToExpire)))
 			if _, ok := accountsToExpire[newBlockToExpire]; !ok {
 				accountsToExpire[newBlockToExpire] = make(map[string]bool)
@@ -120,16 +181,69 @@ func (g *EcGroup) executeTx(tx txFromZip) time.Duration {
 			accountsToExpire[newBlockToExpire][addrString] = true
 		}
 	}
-	timeSpent := time.Since(timeBegin)
+	timeSpent := time.Since(timeBegin) + netTime
+	if g.diffReplication {
+		g.replication.countTx(tx)
+	}
 	return timeSpent
 }
 
+// moveToCold moves a hot account to its cold shard. It returns the network
+// time of deleting it from the hot replicas and handing it over.
+func (g *EcGroup) moveToCold(addr common.Address) time.Duration {
+	// remove addr from hot
+	balance := g.nodes[0].hot.stateDb.GetBalance(addr)
+	netTime := g.writeHot(func(ecNode *EcNode) {
+		ecNode.hot.Delete(addr)
+		if g.rentConfig != nil {
+			core.ResetRent(ecNode.hot.stateDb, addr)
+		}
+	})
+
+	// add addr to cold
+	return netTime + g.addCold(addr, balance)
+}
+
+// addCold moves an account to the cold shard holding its address. Accounts
+// without balance are empty and dropped from the cold trie at commit, so they
+// get no slot. It returns the network time of the executor handing the
+// account over to the owner of the shard.
+func (g *EcGroup) addCold(address common.Address, balance *big.Int) time.Duration {
+	owner := g.GetNodeForAddress(address)
+	owner.SetBalanceCold(address, balance)
+	if balance.Sign() > 0 {
+		g.coldChanges = append(g.coldChanges, coldChange{address: address, balance: balance, add: true})
+	}
+	if g.network == nil {
+		return 0
+	}
+	return g.network.Send(g.nodes[0].ind, owner.ind, accountWriteSize)
+}
+
+// removeCold deletes a cold account, which has the given balance, from its
+// shard.
+func (g *EcGroup) removeCold(address common.Address, balance *big.Int) {
+	g.GetNodeForAddress(address).cold.Delete(address)
+	g.coldChanges = append(g.coldChanges, coldChange{address: address, balance: balance})
+}
+
 func (g *EcGroup) Commit(height int, measureStorage, measureTime bool) error {
-	for _, n := range g.nodes {
-		if err := n.Commit(); err != nil {
+	if g.diffReplication {
+		if err := g.replicateHot(height); err != nil {
 			return err
 		}
+	} else {
+		for _, n := range g.nodes {
+			if err := n.hot.Commit(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := g.commitCold(height); err != nil {
+		return err
 	}
+	g.applyColdChanges()
+	g.agreeColdRoots()
 	//err := g.metaNode.Commit()
 	//if err != nil {
 	//	return err
@@ -178,16 +292,69 @@ func ecchain(ctx *cli.Context) error {
 	recency := ctx.Int(recencyFlag.Name)
 	frequency := ctx.Float64(frequencyFlag.Name)
 	debugging := ctx.IsSet(debugFlag.Name)
+	compareSnapshot := ctx.IsSet(snapshotCompareFlag.Name)
+	measureMemory := ctx.IsSet(measureMemoryFlag.Name)
+	applyNodeFlags(ctx)
 
 	g, err := NewEcGroup(ctx.Int(ecKFlag.Name), recency, frequency)
 	if err != nil {
 		return err
 	}
+	if g.network, err = newNetworkModel(ctx); err != nil {
+		return err
+	}
+	var churn map[int][]churnEvent
+	if ctx.IsSet(churnFlag.Name) {
+		if churn, err = parseChurnSchedule(ctx.String(churnFlag.Name)); err != nil {
+			return err
+		}
+	}
+	g.scrubInterval = ctx.Int(scrubFlag.Name)
+	g.faultRate = ctx.Float64(faultRateFlag.Name)
+	g.faultRng = rand.New(rand.NewSource(ctx.Int64(faultSeedFlag.Name)))
+	g.challengeInterval = ctx.Int(challengeFlag.Name)
+	g.challengeCount = ctx.Int(challengeCountFlag.Name)
+	g.challengeDeadline = time.Duration(ctx.Float64(challengeDeadlineFlag.Name) * float64(time.Millisecond))
+	g.diffReplication = ctx.IsSet(diffFlag.Name)
+	if g.rentConfig = newRentConfig(ctx.Uint64(rentFlag.Name)); g.rentConfig != nil && g.diffReplication {
+		return fmt.Errorf("--%s can't be used with --%s, the hot diffs don't carry the rent records", rentFlag.Name, diffFlag.Name)
+	}
+	if threads := ctx.Int(prefetchFlag.Name); threads > 0 {
+		g.prefetcher = NewColdPrefetcher(g, threads)
+		g.prefetchThreads = threads
+	}
+	if ctx.IsSet(rpcFlag.Name) {
+		stack, err := startAPINode(ctx, g)
+		if err != nil {
+			return err
+		}
+		defer stack.Close()
+	}
 	timeSum := time.Duration(0)
 	txCount := 0
-	var accountsInCurrentBlock []string
+	var (
+		accountsInCurrentBlock []string
+		txsInCurrentBlock      []txFromZip
+		prefetchStats          ColdPrefetchStats
+		networkStats           NetworkStats
+		replication            replicationStats
+	)
 	lstBlock := -1
-	err = processTxFromZip(func(height int) error {
+	finishBlock := func(height int) error {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+
+		// execute the block if prefetching, fetching its cold accounts ahead
+		if g.prefetcher != nil {
+			timeSum += g.prefetchCold(txsInCurrentBlock)
+			for _, tx := range txsInCurrentBlock {
+				timeSum += g.executeTx(tx)
+				txCount++
+			}
+			txsInCurrentBlock = txsInCurrentBlock[:0]
+			g.prefetcher.Reset()
+		}
+
 		if debugging || height/10000 != lstBlock/10000 {
 			fmt.Print(height, " ")
 			defer fmt.Println("")
@@ -205,40 +372,125 @@ func ecchain(ctx *cli.Context) error {
 		timeSum = 0
 		txCount = 0
 
-		// colding
-		for addrString := range accountsToExpire[height] {
-			addr := common.HexToAddress(addrString)
-			//fmt.Println("Colding", account)
-			// remove addr from hot
-			balance := g.nodes[0].hot.stateDb.GetBalance(addr)
-			for _, ecNode := range g.nodes {
-				ecNode.hot.Delete(addr)
+		// sample the memory before the commit drops the cached state objects
+		var memory *memorySample
+		if measureMemory && (debugging || height/10000 != lstBlock/10000) {
+			nodes := make([]nodeMemory, 0, len(g.nodes))
+			for _, n := range g.nodes {
+				nodes = append(nodes, n.memoryUsage())
 			}
+			memory = sampleMemory(nodes)
+		}
 
-			// add addr to cold
-			g.GetNodeForAddress(addr).SetBalanceCold(addr, balance)
+		// colding
+		for addrString := range accountsToExpire[height] {
+			timeSum += g.moveToCold(common.HexToAddress(addrString))
 		}
 		delete(accountsToExpire, height)
+		if g.rentConfig != nil {
+			timeSum += g.coldRentExpired(height)
+		}
 
+		// scrub the cold shards periodically, or when a read failed to verify
+		g.suspectLock.Lock()
+		suspected := len(g.suspects) > 0
+		g.suspectLock.Unlock()
+		if suspected || (g.scrubInterval > 0 && lstBlock >= 0 && height/g.scrubInterval != lstBlock/g.scrubInterval) {
+			if err := g.scrub(height); err != nil {
+				return err
+			}
+		}
 		if err = g.Commit(height, measureStorage, measureTime); err != nil {
 			return err
 		}
+		if g.challengeInterval > 0 && lstBlock >= 0 && height/g.challengeInterval != lstBlock/g.challengeInterval {
+			g.challenge(height, g.challengeSeed(height))
+		}
+		if events, ok := churn[height]; ok {
+			if err := g.applyChurn(events, height); err != nil {
+				return err
+			}
+		}
+		if err := g.injectFault(height); err != nil {
+			return err
+		}
 		if measureStorage && height/10000 != lstBlock/10000 {
 			for _, n := range g.nodes {
 				fmt.Print(" ", n.StorageCost())
 			}
 		}
+		if compareSnapshot && (debugging || height/10000 != lstBlock/10000) {
+			if err := g.nodes[0].hot.printReadLatency(accountsInCurrentBlock); err != nil {
+				return err
+			}
+		}
+		if g.prefetcher != nil && (debugging || height/10000 != lstBlock/10000) {
+			// prefetch hit rate and the latency saved per tx since the last output
+			stats := g.prefetcher.Stats()
+			hits, misses := stats.Hits-prefetchStats.Hits, stats.Misses-prefetchStats.Misses
+			if hits+misses != 0 {
+				fmt.Print(" ", float64(hits)/float64(hits+misses))
+			} else {
+				fmt.Print(" -1")
+			}
+			fmt.Print(" ", (stats.Saved() - prefetchStats.Saved()).Nanoseconds())
+			prefetchStats = stats
+		}
+		if g.network != nil && (debugging || height/10000 != lstBlock/10000) {
+			// bytes sent over the network since the last output
+			stats := g.network.Stats()
+			fmt.Print(" ", stats.Bytes-networkStats.Bytes)
+			networkStats = stats
+		}
+		if g.diffReplication && (debugging || height/10000 != lstBlock/10000) {
+			// diff size and execution time per block, application time per replica,
+			// then tx size and execution time per block and replica when re-executing
+			g.replication.print(replication)
+			replication = g.replication
+		}
+		if memory != nil {
+			memory.print()
+		}
+		accountsInCurrentBlock = accountsInCurrentBlock[:0]
 		lstBlock = height
 		return nil
-	}, func(tx txFromZip) error {
+	}
+	err = processTxFromZip(finishBlock, func(tx txFromZip) error {
 		accountsInCurrentBlock = append(accountsInCurrentBlock, tx.sender, tx.to)
+		if g.prefetcher != nil {
+			// the block is executed once complete, its accounts known
+			txsInCurrentBlock = append(txsInCurrentBlock, tx)
+			return nil
+		}
+		g.lock.Lock()
 		timeSum += g.executeTx(tx)
+		g.lock.Unlock()
 		txCount++
 		return nil
 	}, prepareFiles(ctx)...)
 	if err != nil {
 		return err
 	}
+	// the last block is still pending when prefetching
+	if len(txsInCurrentBlock) > 0 {
+		if err := finishBlock(txsInCurrentBlock[0].blockNumber); err != nil {
+			return err
+		}
+	}
+	if g.challengeInterval > 0 {
+		g.printChallenges()
+	}
+	if g.diffReplication {
+		fmt.Println("hot diffs", g.replication.blocks, "bytes", g.replication.bytes, "mismatches", g.replication.mismatches,
+			"re-execution bytes", g.replication.txBytes, "cpu", g.replication.execute)
+	}
+	if ctx.IsSet(rpcFlag.Name) {
+		fmt.Println("Replay finished, serving the ec API until interrupted")
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+		<-sigc
+		signal.Stop(sigc)
+	}
 	if ctx.IsSet(cleanFlag.Name) {
 		err = g.Clean()
 		if err != nil {
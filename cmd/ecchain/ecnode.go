@@ -60,12 +60,7 @@ func (ecNode *EcNode) GetNonce(address common.Address) uint64 {
 
 func (ecNode *EcNode) Commit() error {
 	for _, n := range []*DbNode{ecNode.hot, ecNode.cold} {
-		root, err := n.stateDb.Commit(true)
-		if err != nil {
-			return err
-		}
-		err = n.trieDb.Commit(root, false)
-		if err != nil {
+		if err := n.Commit(); err != nil {
 			return err
 		}
 	}
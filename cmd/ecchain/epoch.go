@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/urfave/cli/v2"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+)
+
+// EpochMember is a member of an EpochGroup. It replicates the hot trie of the
+// current epoch and holds its shard of every frozen epoch trie.
+type EpochMember struct {
+	ind    int
+	hot    *DbNode
+	epochs *DbNode       // shards of the epoch tries held by the member
+	roots  []common.Hash // root of the member's shard of each epoch, oldest first
+	parity int           // bytes of the parity of the epoch tries held by the member
+}
+
+func (m *EpochMember) StorageCost() int {
+	return m.hot.StorageCost() + m.epochs.StorageCost() + m.parity/1024
+}
+
+func (m *EpochMember) Clean() error {
+	if err := m.hot.Clean(); err != nil {
+		return err
+	}
+	return m.epochs.Clean()
+}
+
+// EpochGroup is the epoch-based alternative to the per-account expiry of
+// EcGroup. Every epoch the hot trie is frozen into a read-only epoch trie,
+// split across the members by address like the cold shards and erasure coded
+// across the group by the same P+Q parity, and a fresh hot trie starts.
+// Accounts of older epochs are copied forward into the hot trie when they are
+// accessed again.
+type EpochGroup struct {
+	k       int
+	size    int
+	length  int // blocks per epoch
+	members []*EpochMember
+	parity  []*epochParity              // parity of the shards of each epoch, oldest first
+	touched map[common.Address]struct{} // accounts written to the hot trie of the current epoch
+	copied  int                         // accounts copied forward in the current epoch
+	network *NetworkModel               // nil if communication is free
+}
+
+// epochParity is the parity of the shards of an epoch trie. The accounts of
+// every shard are laid out in slots like the cold shards of EcGroup, and the
+// parity of every stripe of slots is held by the members in turn.
+type epochParity struct {
+	slots  []*shardSlots        // slots of the accounts of each shard
+	parity map[int]stripeParity // parity of each stripe of slots
+}
+
+func NewEpochGroup(k, length int) (*EpochGroup, error) {
+	if k < 0 || k > maxGroupBits {
+		return nil, fmt.Errorf("invalid group size 2^%d, at most 2^%d shards are supported", k, maxGroupBits)
+	}
+	g := &EpochGroup{
+		k:       k,
+		size:    1 << k,
+		length:  length,
+		touched: make(map[common.Address]struct{}),
+	}
+	for i := 0; i < g.size; i++ {
+		hot, err := NewDbNode(i)
+		if err != nil {
+			return nil, err
+		}
+		epochs, err := NewDbNode(i)
+		if err != nil {
+			return nil, err
+		}
+		g.members = append(g.members, &EpochMember{ind: i, hot: hot, epochs: epochs})
+	}
+	return g, nil
+}
+
+func (g *EpochGroup) GetMemberForAddress(address common.Address) *EpochMember {
+	return g.members[GetIndForAddress(g.k, address)]
+}
+
+func (g *EpochGroup) memberInds() []int {
+	inds := make([]int, 0, len(g.members))
+	for _, m := range g.members {
+		inds = append(inds, m.ind)
+	}
+	return inds
+}
+
+// lookup searches the epoch tries for the account, newest first, and returns
+// its balance, or nil if it isn't in any epoch, together with the network
+// time of the search. With a network model, every member fetches the proof of
+// the account, or of its absence, in each epoch searched from the holder.
+func (g *EpochGroup) lookup(address common.Address) (*big.Int, time.Duration, error) {
+	var (
+		holder  = g.GetMemberForAddress(address)
+		balance *big.Int
+		size    int
+	)
+	for e := len(holder.roots) - 1; e >= 0 && balance == nil; e-- {
+		root := holder.roots[e]
+		if root == types.EmptyRootHash {
+			continue // nothing of the shard was touched in the epoch
+		}
+		account, read, err := holder.readEpoch(root, address, g.network != nil)
+		size += read
+		if err != nil {
+			// the holder can't serve its shard, recover it from the others
+			fmt.Fprintln(os.Stderr, "fault", "epoch", e, "member", holder.ind, "served", address, "err", err)
+			if account, read, err = g.recoverEpoch(e, address); err != nil {
+				return nil, 0, fmt.Errorf("epoch %d: %v", e, err)
+			}
+			size += read
+		}
+		if account != nil {
+			balance = account.Balance
+		}
+	}
+	var netTime time.Duration
+	if g.network != nil && size > 0 {
+		netTime = g.network.FetchAll(holder.ind, size, g.memberInds())
+	}
+	return balance, netTime, nil
+}
+
+// readEpoch reads an account from the member's shard of an epoch trie, nil if
+// absent, and returns the bytes of the proof if proven.
+func (m *EpochMember) readEpoch(root common.Hash, address common.Address, prove bool) (*types.StateAccount, int, error) {
+	if !prove {
+		tr, err := m.epochs.db.OpenTrie(root)
+		if err != nil {
+			return nil, 0, err
+		}
+		account, err := tr.GetAccount(address)
+		return account, 0, err
+	}
+	proof, _, _, err := m.epochs.GetProofAt(root, address)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := 0
+	for _, node := range proof {
+		size += len(node)
+	}
+	account, err := verifyAccountProof(root, address, proof)
+	return account, size, err
+}
+
+// recoverEpoch reconstructs an account of an epoch trie from the shards of the
+// other members and the parity, without its own holder. It returns the
+// account, nil if not in the epoch, and the bytes fetched.
+func (g *EpochGroup) recoverEpoch(e int, address common.Address) (*types.StateAccount, int, error) {
+	var (
+		ep    = g.parity[e]
+		shard = GetIndForAddress(g.k, address)
+	)
+	i, ok := ep.slots[shard].index[address]
+	if !ok {
+		return nil, 0, nil // not frozen in the epoch
+	}
+	var (
+		slots  = make([]slot, g.size)
+		erased = []int{shard}
+		size   = 2 * slotSize // the parity
+	)
+	for other, otherSlots := range ep.slots {
+		if other == shard || i >= len(otherSlots.addrs) || otherSlots.addrs[i] == (common.Address{}) {
+			continue
+		}
+		account, _, err := g.members[other].readEpoch(g.members[other].roots[e], otherSlots.addrs[i], false)
+		if err != nil || account == nil {
+			erased = append(erased, other)
+			continue
+		}
+		slots[other] = encodeSlot(otherSlots.addrs[i], account.Balance)
+		size += slotSize
+	}
+	if _, err := correctStripe(ep.parity[i], slots, erased); err != nil {
+		return nil, 0, err
+	}
+	recovered, balance := slots[shard].decode()
+	if recovered != address {
+		return nil, 0, fmt.Errorf("stripe %d recovers %x instead of %x", i, recovered, address)
+	}
+	return coldAccount(balance), size, nil
+}
+
+func (g *EpochGroup) executeTx(tx txFromZip) (time.Duration, error) {
+	timeBegin := time.Now()
+	netTime := time.Duration(0)
+	for _, addrString := range []string{tx.sender, tx.to} {
+		addr := common.HexToAddress(addrString)
+		value := tx.value
+		if !g.members[0].hot.Exist(addr) {
+			// not in the current epoch, copy it forward from an older one
+			balance, lookupTime, err := g.lookup(addr)
+			if err != nil {
+				return 0, err
+			}
+			netTime += lookupTime
+			if balance != nil {
+				value = new(big.Int).Add(balance, tx.value)
+				g.copied++
+			}
+		}
+		for _, m := range g.members {
+			m.hot.AddBalance(addr, value)
+		}
+		g.touched[addr] = struct{}{}
+	}
+	timeSpent := time.Since(timeBegin) + netTime
+	return timeSpent, nil
+}
+
+func (g *EpochGroup) Commit() error {
+	for _, m := range g.members {
+		if err := m.hot.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeze turns the committed hot trie into the next epoch trie, writing the
+// shard of every member and the parity across them, and replaces the hot
+// tries by empty ones.
+func (g *EpochGroup) freeze(height int) error {
+	var (
+		hot    = g.members[0].hot
+		leaves = make([]map[common.Hash][]byte, g.size)
+		ep     = &epochParity{slots: make([]*shardSlots, g.size), parity: make(map[int]stripeParity)}
+		addrs  = make([]common.Address, 0, len(g.touched))
+		frozen = 0
+	)
+	for i := range leaves {
+		leaves[i] = make(map[common.Hash][]byte)
+		ep.slots[i] = newShardSlots()
+	}
+	for addr := range g.touched {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	for _, addr := range addrs {
+		if !hot.Exist(addr) {
+			continue // empty, deleted at commit
+		}
+		balance := hot.GetBalance(addr)
+		blob, err := rlp.EncodeToBytes(coldAccount(balance))
+		if err != nil {
+			return err
+		}
+		shard := GetIndForAddress(g.k, addr)
+		leaves[shard][crypto.Keccak256Hash(addr.Bytes())] = blob
+		frozen++
+
+		i, s := ep.slots[shard].add(addr), encodeSlot(addr, balance)
+		parity := ep.parity[i]
+		parity.p.xor(s)
+		parity.q.xor(s.mul(gfExp[shard]))
+		ep.parity[i] = parity
+	}
+	for i := range ep.parity {
+		g.members[i%len(g.members)].parity += 2 * slotSize
+	}
+	g.parity = append(g.parity, ep)
+
+	for i, m := range g.members {
+		root, err := m.epochs.writeTrie(leaves[i])
+		if err != nil {
+			return err
+		}
+		m.roots = append(m.roots, root)
+
+		if err := m.hot.Clean(); err != nil {
+			return err
+		}
+		if m.hot, err = NewDbNode(m.ind); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(os.Stderr, "epoch", len(g.members[0].roots)-1, "height", height, "accounts", frozen, "copied", g.copied)
+	g.touched = make(map[common.Address]struct{})
+	g.copied = 0
+	return nil
+}
+
+func (g *EpochGroup) Clean() error {
+	for _, m := range g.members {
+		if err := m.Clean(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var epochCmd = &cli.Command{
+	Name:   "epoch",
+	Usage:  "Execute transactions with epoch-based state expiry",
+	Action: epochExpiry,
+	Flags: []cli.Flag{
+		cleanFlag,
+		zipDirFlag,
+		ecKFlag,
+		epochFlag,
+		measureTimeFlag,
+		measureStorageFlag,
+		debugFlag,
+		noSnapshotFlag,
+		gcFlag,
+		trieCacheFlag,
+		netTopologyFlag,
+		netLatencyFlag,
+		netJitterFlag,
+		netDistributionFlag,
+		netBandwidthFlag,
+		netLossFlag,
+		netSeedFlag,
+	},
+	Description: "ecchain epoch --epoch 100000 /path/to/my.zip",
+}
+
+func epochExpiry(ctx *cli.Context) error {
+	measureTime := ctx.IsSet(measureTimeFlag.Name)
+	measureStorage := ctx.IsSet(measureStorageFlag.Name)
+	debugging := ctx.IsSet(debugFlag.Name)
+	applyNodeFlags(ctx)
+
+	length := ctx.Int(epochFlag.Name)
+	if length <= 0 {
+		return fmt.Errorf("invalid epoch length %d", length)
+	}
+	g, err := NewEpochGroup(ctx.Int(ecKFlag.Name), length)
+	if err != nil {
+		return err
+	}
+	if g.network, err = newNetworkModel(ctx); err != nil {
+		return err
+	}
+	timeSum := time.Duration(0)
+	txCount := 0
+	var (
+		txsInCurrentBlock []txFromZip
+		networkStats      NetworkStats
+	)
+	lstBlock := -1
+	err = processTxFromZip(func(height int) error {
+		// Freeze the epoch of the last block when entering another one, the
+		// blocks at the boundary may be missing from the trace
+		if lstBlock >= 0 && height/g.length != lstBlock/g.length {
+			if err := g.freeze(lstBlock); err != nil {
+				return err
+			}
+		}
+		for _, tx := range txsInCurrentBlock {
+			elapsed, err := g.executeTx(tx)
+			if err != nil {
+				return err
+			}
+			timeSum += elapsed
+			txCount++
+		}
+		txsInCurrentBlock = txsInCurrentBlock[:0]
+
+		if debugging || height/10000 != lstBlock/10000 {
+			fmt.Print(height, " ")
+			defer fmt.Println("")
+		}
+
+		// measureTime (average tx execution latency)
+		if measureTime && (debugging || height/10000 != lstBlock/10000) {
+			fmt.Print(" ")
+			if txCount != 0 {
+				fmt.Print(float64(timeSum.Nanoseconds()) / float64(txCount))
+			} else {
+				fmt.Print("-1")
+			}
+		}
+		timeSum = 0
+		txCount = 0
+
+		if err := g.Commit(); err != nil {
+			return err
+		}
+		if measureStorage && height/10000 != lstBlock/10000 {
+			for _, m := range g.members {
+				fmt.Print(" ", m.StorageCost())
+			}
+		}
+		if g.network != nil && (debugging || height/10000 != lstBlock/10000) {
+			// bytes sent over the network since the last output
+			stats := g.network.Stats()
+			fmt.Print(" ", stats.Bytes-networkStats.Bytes)
+			networkStats = stats
+		}
+		lstBlock = height
+		return nil
+	}, func(tx txFromZip) error {
+		txsInCurrentBlock = append(txsInCurrentBlock, tx)
+		return nil
+	}, prepareFiles(ctx)...)
+	if err != nil {
+		return err
+	}
+	if ctx.IsSet(cleanFlag.Name) {
+		err = g.Clean()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
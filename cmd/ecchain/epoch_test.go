@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+)
+
+// Tests that every account of a frozen epoch is recovered from the shards of
+// the other members and the parity.
+func TestEpochRecovery(t *testing.T) {
+	g, err := NewEpochGroup(2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Clean()
+
+	balances := make(map[common.Address]*big.Int)
+	for i := 1; i <= 50; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i * 7919)))
+		balances[addr] = big.NewInt(int64(i))
+		for _, m := range g.members {
+			m.hot.AddBalance(addr, balances[addr])
+		}
+		g.touched[addr] = struct{}{}
+	}
+	if err := g.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.freeze(9); err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range balances {
+		account, _, err := g.recoverEpoch(0, addr)
+		if err != nil {
+			t.Fatalf("account %x: %v", addr, err)
+		}
+		if account == nil || account.Balance.Cmp(want) != 0 {
+			t.Fatalf("account %x: recovered %v, want balance %v", addr, account, want)
+		}
+	}
+	if account, _, err := g.recoverEpoch(0, common.Address{0xff}); account != nil || err != nil {
+		t.Fatalf("recovered account not in the epoch: %v, err %v", account, err)
+	}
+}
+
+func TestEpochGroupBound(t *testing.T) {
+	if _, err := NewEpochGroup(maxGroupBits+1, 10); err == nil {
+		t.Fatal("epoch group beyond the distinct Q coefficients accepted")
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "github.com/urfave/cli/v2"
+import (
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/urfave/cli/v2"
+)
 
 var (
 	cleanFlag = &cli.BoolFlag{
@@ -11,13 +14,17 @@ var (
 		Name:  "zipdir",
 		Usage: "Directory of zip files",
 	}
+	accessesRPCFlag = &cli.StringFlag{
+		Name:  "accesses.rpc",
+		Usage: "RPC endpoint of a node recording the state accesses of its blocks (--stateaccesses), analyzed instead of the zip files",
+	}
 	debugFlag = &cli.BoolFlag{
 		Name:  "debug",
 		Usage: "Tell EC-Chain I'm debugging",
 	}
 	ecKFlag = &cli.IntFlag{
 		Name:  "k",
-		Usage: "EC group size is 2^k",
+		Usage: "EC group size is 2^k, with k at most 7",
 		Value: 2,
 	}
 	recencyFlag = &cli.IntFlag{
@@ -43,4 +50,201 @@ var (
 		Name:  "storage",
 		Usage: "Output storage usage information",
 	}
+	rpcFlag = &cli.BoolFlag{
+		Name:  "rpc",
+		Usage: "Serve the ec RPC namespace over HTTP during the run",
+	}
+	rpcAddrFlag = &cli.StringFlag{
+		Name:  "rpc.addr",
+		Usage: "HTTP-RPC server listening interface",
+		Value: node.DefaultHTTPHost,
+	}
+	rpcPortFlag = &cli.IntFlag{
+		Name:  "rpc.port",
+		Usage: "HTTP-RPC server listening port",
+		Value: node.DefaultHTTPPort,
+	}
+	noSnapshotFlag = &cli.BoolFlag{
+		Name:  "nosnapshot",
+		Usage: "Serve state reads from the trie only, without a snapshot",
+	}
+	snapshotCompareFlag = &cli.BoolFlag{
+		Name:  "snapshot.compare",
+		Usage: "Output snapshot-backed and trie-only read latency of the accessed accounts",
+	}
+	gcFlag = &cli.IntFlag{
+		Name:  "gc",
+		Usage: "Keep only the last N state roots in memory and garbage collect stale trie nodes (0 = flush every root to disk)",
+		Value: 0,
+	}
+	prefetchFlag = &cli.IntFlag{
+		Name:  "prefetch",
+		Usage: "Prefetch the cold accounts of each block from their shards with N parallel fetches (0 = fetch on demand)",
+		Value: 0,
+	}
+	netTopologyFlag = &cli.StringFlag{
+		Name:  "net.topology",
+		Usage: "JSON file with the default link and per-link overrides of the network model",
+	}
+	netLatencyFlag = &cli.Float64Flag{
+		Name:  "net.latency",
+		Usage: "Mean one-way link latency in milliseconds",
+	}
+	netJitterFlag = &cli.Float64Flag{
+		Name:  "net.jitter",
+		Usage: "Spread of the link latency in milliseconds",
+	}
+	netDistributionFlag = &cli.StringFlag{
+		Name:  "net.distribution",
+		Usage: "Link latency distribution (constant, normal, uniform, exponential)",
+		Value: "normal",
+	}
+	netBandwidthFlag = &cli.Float64Flag{
+		Name:  "net.bandwidth",
+		Usage: "Link bandwidth in Mbit/s (0 = unlimited)",
+	}
+	netLossFlag = &cli.Float64Flag{
+		Name:  "net.loss",
+		Usage: "Probability that a message is lost and has to be resent",
+	}
+	netSeedFlag = &cli.Int64Flag{
+		Name:  "net.seed",
+		Usage: "Seed of the network model randomness",
+		Value: 1,
+	}
+	churnFlag = &cli.StringFlag{
+		Name:  "churn",
+		Usage: "Membership changes applied after the given blocks, e.g. \"1000:join,2500:leave:3,4000:crash:1\" (a crashed member hands nothing over, its shards are rebuilt from the parity)",
+	}
+	scrubFlag = &cli.IntFlag{
+		Name:  "scrub",
+		Usage: "Scrub the cold shards against their roots and parity every N blocks and verify every cold read (0 = only scrub when a verified read fails)",
+		Value: 0,
+	}
+	faultRateFlag = &cli.Float64Flag{
+		Name:  "fault.rate",
+		Usage: "Probability per block of corrupting a random cold trie node on disk of a random member",
+	}
+	faultSeedFlag = &cli.Int64Flag{
+		Name:  "fault.seed",
+		Usage: "Seed of the fault injection randomness",
+		Value: 1,
+	}
+	epochFlag = &cli.IntFlag{
+		Name:  "epoch",
+		Usage: "Number of blocks after which the hot trie is frozen into an epoch trie",
+		Value: 100000,
+	}
+	testnetNodesFlag = &cli.IntFlag{
+		Name:  "testnet.nodes",
+		Usage: "Number of nodes of the testnet, one sealer and the rest peers",
+		Value: 4,
+	}
+	testnetPeriodFlag = &cli.Uint64Flag{
+		Name:  "testnet.period",
+		Usage: "Clique block period of the testnet in seconds",
+		Value: 15,
+	}
+	testnetPasswordFlag = &cli.StringFlag{
+		Name:  "testnet.password",
+		Usage: "Passphrase of the node keystores",
+	}
+	measureMemoryFlag = &cli.BoolFlag{
+		Name:  "memory",
+		Usage: "Output memory usage information",
+	}
+	trieCacheFlag = &cli.IntFlag{
+		Name:  "trie.cache",
+		Usage: "Memory allowance in MB of the trie cache of each node, for clean and dirty nodes, also sizing its database and snapshot caches (0 = no clean cache)",
+		Value: 0,
+	}
+	predictHeightFlag = &cli.IntFlag{
+		Name:  "predict.height",
+		Usage: "Fit the growth of the hot set and extrapolate it to the given block height",
+	}
+	predictRecenciesFlag = &cli.IntSliceFlag{
+		Name:  "predict.recencies",
+		Usage: "Recency thresholds to fit, e.g. \"1000,10000,100000\" (default = --recency)",
+	}
+	predictFrequenciesFlag = &cli.Float64SliceFlag{
+		Name:  "predict.frequencies",
+		Usage: "Frequency thresholds to fit, e.g. \"0.5,1,2\" (default = --frequency)",
+	}
+	predictBudgetFlag = &cli.Float64Flag{
+		Name:  "predict.budget",
+		Usage: "Storage budget per node in MB for which to recommend the thresholds",
+	}
+	predictAccountSizeFlag = &cli.IntFlag{
+		Name:  "predict.accountsize",
+		Usage: "Estimated bytes a hot account takes in the trie, interior nodes included",
+		Value: 128,
+	}
+	statsOutFlag = &cli.StringFlag{
+		Name:  "stats.out",
+		Usage: "Directory to write the access-pattern statistics to",
+		Value: "stats",
+	}
+	statsFormatFlag = &cli.StringFlag{
+		Name:  "stats.format",
+		Usage: "Output format of the statistics (csv, json)",
+		Value: "csv",
+	}
+	statsWindowFlag = &cli.IntFlag{
+		Name:  "stats.window",
+		Usage: "Number of blocks of the windows over which the hottest accounts are ranked",
+		Value: 10000,
+	}
+	statsStepFlag = &cli.IntFlag{
+		Name:  "stats.step",
+		Usage: "Number of blocks the window slides by (0 = the window length)",
+		Value: 0,
+	}
+	statsTopFlag = &cli.IntFlag{
+		Name:  "stats.top",
+		Usage: "Number of hottest accounts reported per window",
+		Value: 10,
+	}
+	challengeFlag = &cli.IntFlag{
+		Name:  "challenge",
+		Usage: "Challenge every cold shard holder to prove its storage every N blocks (0 = no challenges)",
+		Value: 0,
+	}
+	challengeCountFlag = &cli.IntFlag{
+		Name:  "challenge.count",
+		Usage: "Number of trie nodes each holder is challenged on per epoch, each on the path of a cold account",
+		Value: 4,
+	}
+	challengeDeadlineFlag = &cli.Float64Flag{
+		Name:  "challenge.deadline",
+		Usage: "Time in milliseconds a holder has to deliver the proof of a challenge (0 = no deadline)",
+	}
+	challengeAccountsFlag = &cli.IntFlag{
+		Name:  "challenge.accounts",
+		Usage: "Number of accounts of the simulated cold shard",
+		Value: 10000,
+	}
+	challengeDropFlag = &cli.Float64SliceFlag{
+		Name:  "challenge.drop",
+		Usage: "Fractions of the trie nodes of the shard dropped by the simulated holder",
+		Value: cli.NewFloat64Slice(0.001, 0.01, 0.05, 0.1, 0.5),
+	}
+	challengeTrialsFlag = &cli.IntFlag{
+		Name:  "challenge.trials",
+		Usage: "Number of challenge epochs simulated per dropped fraction",
+		Value: 1000,
+	}
+	challengeSeedFlag = &cli.Int64Flag{
+		Name:  "challenge.seed",
+		Usage: "Seed of the simulated shard and drops",
+		Value: 1,
+	}
+	diffFlag = &cli.BoolFlag{
+		Name:  "diff",
+		Usage: "Replicate the hot state by per-block state diffs of the first member instead of re-executing on every member",
+	}
+	rentFlag = &cli.Uint64Flag{
+		Name:  "rent",
+		Usage: "State rent charged to the hot accounts in wei per byte and block, accounts whose rent has run out are colded (0 = no rent)",
+		Value: 0,
+	}
 )
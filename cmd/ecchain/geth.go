@@ -10,6 +10,9 @@ func geth(ctx *cli.Context) error {
 	measureTime := ctx.IsSet(measureTimeFlag.Name)
 	measureStorage := ctx.IsSet(measureStorageFlag.Name)
 	debugging := ctx.IsSet(debugFlag.Name)
+	compareSnapshot := ctx.IsSet(snapshotCompareFlag.Name)
+	measureMemory := ctx.IsSet(measureMemoryFlag.Name)
+	applyNodeFlags(ctx)
 
 	dbNode, err := NewDbNode(0)
 	if err != nil {
@@ -18,6 +21,7 @@ func geth(ctx *cli.Context) error {
 
 	txCount := 0
 	lstBlock := -1
+	var accountsInCurrentBlock []string
 
 	timeSum := time.Duration(0)
 	err = processTxFromZip(func(height int) error {
@@ -35,15 +39,29 @@ func geth(ctx *cli.Context) error {
 		}
 		timeSum = 0
 		txCount = 0
+		var memory *memorySample
+		if measureMemory && (debugging || height/10000 != lstBlock/10000) {
+			memory = sampleMemory([]nodeMemory{dbNode.memoryUsage()})
+		}
 		if err := dbNode.Commit(); err != nil {
 			return err
 		}
 		if measureStorage && (debugging || height/10000 != lstBlock/10000) {
 			fmt.Print(" ", dbNode.StorageCost())
 		}
+		if compareSnapshot && (debugging || height/10000 != lstBlock/10000) {
+			if err := dbNode.printReadLatency(accountsInCurrentBlock); err != nil {
+				return err
+			}
+		}
+		if memory != nil {
+			memory.print()
+		}
+		accountsInCurrentBlock = accountsInCurrentBlock[:0]
 		lstBlock = height
 		return nil
 	}, func(tx txFromZip) error {
+		accountsInCurrentBlock = append(accountsInCurrentBlock, tx.sender, tx.to)
 		timeSum += dbNode.executeTx(tx)
 		txCount++
 		return nil
@@ -25,6 +25,10 @@ func init() {
 		gethCmd,
 		analyzeCmd,
 		dbGroupCmd,
+		epochCmd,
+		testnetCmd,
+		statsCmd,
+		challengeCmd,
 	}
 	sort.Sort(cli.CommandsByName(app.Commands))
 
@@ -37,6 +41,31 @@ func init() {
 		recencyFlag,
 		frequencyFlag,
 		debugFlag,
+		rpcFlag,
+		rpcAddrFlag,
+		rpcPortFlag,
+		noSnapshotFlag,
+		gcFlag,
+		snapshotCompareFlag,
+		prefetchFlag,
+		netTopologyFlag,
+		netLatencyFlag,
+		netJitterFlag,
+		netDistributionFlag,
+		netBandwidthFlag,
+		netLossFlag,
+		netSeedFlag,
+		churnFlag,
+		scrubFlag,
+		faultRateFlag,
+		faultSeedFlag,
+		measureMemoryFlag,
+		trieCacheFlag,
+		challengeFlag,
+		challengeCountFlag,
+		challengeDeadlineFlag,
+		diffFlag,
+		rentFlag,
 	}
 
 	app.Before = func(ctx *cli.Context) error {
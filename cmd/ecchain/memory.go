@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nodeMemory is the memory held by the tries and state of a node.
+type nodeMemory struct {
+	dirty     common.StorageSize // dirty trie nodes not yet flushed to disk
+	preimages common.StorageSize // trie key preimages not yet flushed to disk
+	objects   int                // state objects cached by the state
+}
+
+func (m *nodeMemory) add(o nodeMemory) {
+	m.dirty += o.dirty
+	m.preimages += o.preimages
+	m.objects += o.objects
+}
+
+// memoryUsage returns the memory currently held by the node.
+func (dbNode *DbNode) memoryUsage() nodeMemory {
+	dirty, preimages := dbNode.trieDb.Size()
+	return nodeMemory{dirty: dirty, preimages: preimages, objects: dbNode.stateDb.CachedObjects()}
+}
+
+func (ecNode *EcNode) memoryUsage() nodeMemory {
+	m := ecNode.hot.memoryUsage()
+	m.add(ecNode.cold.memoryUsage())
+	return m
+}
+
+// memorySample is the memory used by the process and by each of its nodes at
+// a sample point.
+type memorySample struct {
+	heapInuse uint64
+	rss       int64         // resident set size, -1 if unknown
+	gcPause   time.Duration // total GC pause time since the start
+	nodes     []nodeMemory
+}
+
+// sampleMemory samples the memory of the process and of the given nodes. The
+// state objects are only cached until a commit, so sample before committing.
+func sampleMemory(nodes []nodeMemory) *memorySample {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return &memorySample{
+		heapInuse: stats.HeapInuse,
+		rss:       residentSetSize(),
+		gcPause:   time.Duration(stats.PauseTotalNs),
+		nodes:     nodes,
+	}
+}
+
+// print outputs the heap in use, the RSS and the total GC pause of the
+// process, then the dirty trie node and preimage bytes and the cached state
+// objects of each node.
+func (s *memorySample) print() {
+	fmt.Print(" ", s.heapInuse, " ", s.rss, " ", s.gcPause.Nanoseconds())
+	for _, n := range s.nodes {
+		fmt.Print(" ", uint64(n.dirty), " ", uint64(n.preimages), " ", n.objects)
+	}
+}
+
+// residentSetSize returns the resident set size of the process in bytes, or
+// -1 where /proc isn't available.
+func residentSetSize() int64 {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return -1
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return -1
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return pages * int64(os.Getpagesize())
+}
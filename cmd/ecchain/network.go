@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+const (
+	// requestSize is the size in bytes of a request for an account
+	// (address plus message overhead).
+	requestSize = 64
+
+	// crossShardTxSize is the size in bytes of the message forwarding the
+	// effects of a transaction to the shard of its other account.
+	crossShardTxSize = 128
+
+	// accountWriteSize is the size in bytes of the message carrying the new
+	// state of an account (address, balance plus message overhead).
+	accountWriteSize = 96
+)
+
+// LinkConfig describes the characteristics of a network link.
+type LinkConfig struct {
+	Latency      float64 `json:"latency"`      // Mean one-way latency in milliseconds
+	Jitter       float64 `json:"jitter"`       // Spread of the latency in milliseconds
+	Distribution string  `json:"distribution"` // Latency distribution: constant, normal, uniform or exponential
+	Bandwidth    float64 `json:"bandwidth"`    // Bandwidth in Mbit/s, 0 means unlimited
+	Loss         float64 `json:"loss"`         // Probability that a message is lost and resent
+	Timeout      float64 `json:"timeout"`      // Time in milliseconds before a lost message is resent
+}
+
+// Topology is the network configuration of a group, loaded from the file
+// given by --net.topology. Links are undirected and override the default
+// link for the given pair of nodes.
+type Topology struct {
+	Default LinkConfig `json:"default"`
+	Links   []struct {
+		From int `json:"from"`
+		To   int `json:"to"`
+		LinkConfig
+	} `json:"links"`
+}
+
+// NetworkStats are the cumulative counters of a NetworkModel.
+type NetworkStats struct {
+	Messages int           // Number of messages delivered
+	Lost     int           // Number of messages lost and resent
+	Bytes    int           // Number of bytes delivered
+	Time     time.Duration // Summed one-way delivery time of the messages
+}
+
+// NetworkModel charges simulated communication costs to the transfers between
+// the nodes of a group, e.g. vehicle-to-infrastructure links. It doesn't
+// delay anything, it only computes how long the transfers would take.
+type NetworkModel struct {
+	def   LinkConfig
+	links map[[2]int]LinkConfig
+
+	// The model is only used from the replay goroutine, for the draws of
+	// the random source to follow the replay and a seed to reproduce a run
+	rng   *rand.Rand
+	stats NetworkStats
+}
+
+// newNetworkModel creates a network model from the --net.* flags, or returns
+// nil if none is set and communication is free.
+func newNetworkModel(ctx *cli.Context) (*NetworkModel, error) {
+	topo := new(Topology)
+	if ctx.IsSet(netTopologyFlag.Name) {
+		blob, err := os.ReadFile(ctx.String(netTopologyFlag.Name))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(blob, topo); err != nil {
+			return nil, fmt.Errorf("invalid topology file: %v", err)
+		}
+	} else if !ctx.IsSet(netLatencyFlag.Name) && !ctx.IsSet(netBandwidthFlag.Name) && !ctx.IsSet(netLossFlag.Name) {
+		return nil, nil
+	}
+	// Flags override the default link of the topology file
+	if ctx.IsSet(netLatencyFlag.Name) {
+		topo.Default.Latency = ctx.Float64(netLatencyFlag.Name)
+	}
+	if ctx.IsSet(netJitterFlag.Name) {
+		topo.Default.Jitter = ctx.Float64(netJitterFlag.Name)
+	}
+	if ctx.IsSet(netDistributionFlag.Name) {
+		topo.Default.Distribution = ctx.String(netDistributionFlag.Name)
+	}
+	if ctx.IsSet(netBandwidthFlag.Name) {
+		topo.Default.Bandwidth = ctx.Float64(netBandwidthFlag.Name)
+	}
+	if ctx.IsSet(netLossFlag.Name) {
+		topo.Default.Loss = ctx.Float64(netLossFlag.Name)
+	}
+	return NewNetworkModel(topo, ctx.Int64(netSeedFlag.Name))
+}
+
+// NewNetworkModel creates a network model for the given topology.
+func NewNetworkModel(topo *Topology, seed int64) (*NetworkModel, error) {
+	m := &NetworkModel{
+		def:   topo.Default,
+		links: make(map[[2]int]LinkConfig),
+		rng:   rand.New(rand.NewSource(seed)),
+	}
+	if err := m.def.sanitize(); err != nil {
+		return nil, err
+	}
+	for _, link := range topo.Links {
+		config := link.LinkConfig
+		if err := config.sanitize(); err != nil {
+			return nil, fmt.Errorf("link %d-%d: %v", link.From, link.To, err)
+		}
+		m.links[[2]int{link.From, link.To}] = config
+		m.links[[2]int{link.To, link.From}] = config
+	}
+	return m, nil
+}
+
+func (c *LinkConfig) sanitize() error {
+	switch c.Distribution {
+	case "":
+		c.Distribution = "normal"
+	case "constant", "normal", "uniform", "exponential":
+	default:
+		return fmt.Errorf("unknown latency distribution %q", c.Distribution)
+	}
+	if c.Latency < 0 || c.Jitter < 0 || c.Bandwidth < 0 {
+		return fmt.Errorf("negative link parameter")
+	}
+	if c.Loss < 0 || c.Loss >= 1 {
+		return fmt.Errorf("loss %v out of range [0, 1)", c.Loss)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = math.Max(200, 4*c.Latency)
+	}
+	return nil
+}
+
+func (m *NetworkModel) link(from, to int) LinkConfig {
+	if config, ok := m.links[[2]int{from, to}]; ok {
+		return config
+	}
+	return m.def
+}
+
+// latency samples the one-way latency of the link in milliseconds.
+func (m *NetworkModel) latency(c LinkConfig) float64 {
+	var ms float64
+	switch c.Distribution {
+	case "constant":
+		ms = c.Latency
+	case "normal":
+		ms = c.Latency + m.rng.NormFloat64()*c.Jitter
+	case "uniform":
+		ms = c.Latency + (2*m.rng.Float64()-1)*c.Jitter
+	case "exponential":
+		ms = c.Latency + m.rng.ExpFloat64()*c.Jitter
+	}
+	return math.Max(ms, 0)
+}
+
+// Send returns the time to deliver a message of the given size from one node
+// to another, including the resends of lost messages.
+func (m *NetworkModel) Send(from, to, size int) time.Duration {
+	if from == to {
+		return 0
+	}
+	var (
+		c  = m.link(from, to)
+		ms float64
+	)
+	for m.rng.Float64() < c.Loss {
+		ms += c.Timeout
+		m.stats.Lost++
+	}
+	ms += m.latency(c)
+	if c.Bandwidth > 0 {
+		ms += float64(size) * 8 / (c.Bandwidth * 1e6) * 1e3
+	}
+	elapsed := time.Duration(ms * float64(time.Millisecond))
+
+	m.stats.Messages++
+	m.stats.Bytes += size
+	m.stats.Time += elapsed
+	return elapsed
+}
+
+// Fetch returns the round-trip time of a node requesting data of the given
+// size from another node.
+func (m *NetworkModel) Fetch(from, to, size int) time.Duration {
+	return m.Send(from, to, requestSize) + m.Send(to, from, size)
+}
+
+// FetchAll returns the time until all the given members have fetched data of
+// the given size from the owner node in parallel.
+func (m *NetworkModel) FetchAll(owner, size int, members []int) time.Duration {
+	var slowest time.Duration
+	for _, member := range members {
+		if elapsed := m.Fetch(member, owner, size); elapsed > slowest {
+			slowest = elapsed
+		}
+	}
+	return slowest
+}
+
+// SendAll returns the time until all the given members have received a
+// message of the given size from the sender in parallel.
+func (m *NetworkModel) SendAll(from, size int, members []int) time.Duration {
+	var slowest time.Duration
+	for _, member := range members {
+		if elapsed := m.Send(from, member, size); elapsed > slowest {
+			slowest = elapsed
+		}
+	}
+	return slowest
+}
+
+// Stats returns the cumulative counters of the network.
+func (m *NetworkModel) Stats() NetworkStats {
+	return m.stats
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+)
+
+// slotSize is the size of a slot of a cold shard: the address and the balance
+// of the account stored in it. Cold accounts only ever carry a balance in the
+// simulator, so the rest of the account is implied.
+const slotSize = common.AddressLength + 32
+
+// slot is the fixed-size encoding of a cold account, all zero if free.
+type slot [slotSize]byte
+
+func encodeSlot(address common.Address, balance *big.Int) (s slot) {
+	copy(s[:common.AddressLength], address.Bytes())
+	balance.FillBytes(s[common.AddressLength:])
+	return s
+}
+
+func (s slot) decode() (common.Address, *big.Int) {
+	return common.BytesToAddress(s[:common.AddressLength]), new(big.Int).SetBytes(s[common.AddressLength:])
+}
+
+func (s *slot) xor(o slot) {
+	for i := range s {
+		s[i] ^= o[i]
+	}
+}
+
+func (s slot) isZero() bool {
+	return s == slot{}
+}
+
+// mul returns the slot multiplied bytewise by c in GF(2^8).
+func (s slot) mul(c byte) (r slot) {
+	for i := range s {
+		r[i] = gfMul(s[i], c)
+	}
+	return r
+}
+
+// GF(2^8) with the polynomial x^8+x^4+x^3+x^2+1 and generator 2, as used by
+// RAID-6.
+var gfExp, gfLog = func() (exp [510]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = byte(x), byte(x)
+		log[x] = byte(i)
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	return
+}()
+
+// maxGroupBits bounds the group size so that every shard gets its own Q
+// coefficient, as the generator only has 255 distinct powers.
+const maxGroupBits = 7
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// shardSlots assigns the cold accounts of a shard to slots. The slots with the
+// same index in every shard form a stripe covered by the group's parity.
+type shardSlots struct {
+	addrs []common.Address       // account in each slot, zero for free slots
+	index map[common.Address]int // slot of each account
+	free  []int                  // free slots, reused before growing
+}
+
+func newShardSlots() *shardSlots {
+	return &shardSlots{index: make(map[common.Address]int)}
+}
+
+func (s *shardSlots) add(address common.Address) int {
+	var i int
+	if n := len(s.free); n > 0 {
+		i, s.free = s.free[n-1], s.free[:n-1]
+		s.addrs[i] = address
+	} else {
+		i = len(s.addrs)
+		s.addrs = append(s.addrs, address)
+	}
+	s.index[address] = i
+	return i
+}
+
+func (s *shardSlots) remove(address common.Address) (int, bool) {
+	i, ok := s.index[address]
+	if !ok {
+		return 0, false
+	}
+	delete(s.index, address)
+	s.addrs[i] = common.Address{}
+	s.free = append(s.free, i)
+	return i, true
+}
+
+func (s *shardSlots) len() int {
+	return len(s.index)
+}
+
+// stripeParity are the two parity slots of a stripe, P = sum of D_s and
+// Q = sum of g^s * D_s over the shards s, which locate and correct a single
+// wrong slot or recover two unreadable ones.
+type stripeParity struct {
+	p, q slot
+}
+
+// parityHolders returns the members holding the P and Q parity of the given
+// stripe. The parity rotates over the members stripe by stripe, as in RAID-6,
+// so that the members share its storage and the load of serving it for
+// repairs. P and Q are held by consecutive members, so that a member leaving
+// abruptly takes at most one of them. The holders of a stripe change when
+// members join or leave the group, the moved parity isn't charged.
+func (g *EcGroup) parityHolders(stripe int) (*EcNode, *EcNode) {
+	return g.nodes[stripe%len(g.nodes)], g.nodes[(stripe+1)%len(g.nodes)]
+}
+
+// coldChange is a cold account added to or removed from its shard, applied
+// to the slots and the parity once the shard commits it.
+type coldChange struct {
+	address common.Address
+	balance *big.Int
+	add     bool
+}
+
+// applyColdChanges updates the slots and parity by the cold changes committed
+// since the last call.
+func (g *EcGroup) applyColdChanges() {
+	for _, change := range g.coldChanges {
+		shard := GetIndForAddress(g.k, change.address)
+		var i int
+		if change.add {
+			i = g.slots[shard].add(change.address)
+		} else {
+			var ok bool
+			if i, ok = g.slots[shard].remove(change.address); !ok {
+				continue
+			}
+		}
+		delta := encodeSlot(change.address, change.balance)
+		parity := g.parity[i]
+		parity.p.xor(delta)
+		parity.q.xor(delta.mul(gfExp[shard]))
+		if parity.p.isZero() && parity.q.isZero() {
+			delete(g.parity, i)
+		} else {
+			g.parity[i] = parity
+		}
+	}
+	g.coldChanges = g.coldChanges[:0]
+}
+
+// errUnrecoverable is returned when a stripe has more faulty slots than the
+// parity can correct.
+var errUnrecoverable = errors.New("too many faulty slots in stripe")
+
+// correctStripe checks the slots of a stripe against its parity and corrects
+// them in place. Unreadable slots are given as erasures; at most two can be
+// recovered. Without erasures a single wrong slot is located and corrected.
+// It returns the shards whose slots were corrected.
+func correctStripe(parity stripeParity, slots []slot, erased []int) ([]int, error) {
+	var sp, sq slot // syndromes
+	sp, sq = parity.p, parity.q
+	for shard, s := range slots {
+		sp.xor(s)
+		sq.xor(s.mul(gfExp[shard]))
+	}
+	switch len(erased) {
+	case 0:
+		if sp.isZero() && sq.isZero() {
+			return nil, nil
+		}
+		// A single wrong slot z gives sq = g^z * sp in every byte
+		z := -1
+		for i := range sp {
+			if sp[i] == 0 && sq[i] == 0 {
+				continue
+			}
+			if sp[i] == 0 || sq[i] == 0 {
+				return nil, errUnrecoverable
+			}
+			loc := int(gfLog[gfDiv(sq[i], sp[i])])
+			if z != -1 && loc != z {
+				return nil, errUnrecoverable
+			}
+			z = loc
+		}
+		if z >= len(slots) {
+			return nil, errUnrecoverable
+		}
+		slots[z].xor(sp)
+		return []int{z}, nil
+
+	case 1:
+		// The erased slots were zeroed by the caller, so the syndrome is the slot
+		slots[erased[0]] = sp
+		return erased, nil
+
+	case 2:
+		// sp = Dx + Dy and sq = g^x*Dx + g^y*Dy, so
+		// Dx = (sq + g^y*sp) / (g^x + g^y)
+		x, y := erased[0], erased[1]
+		gx, gy := gfExp[x], gfExp[y]
+		dx := sq
+		dx.xor(sp.mul(gy))
+		for i := range dx {
+			dx[i] = gfDiv(dx[i], gx^gy)
+		}
+		dy := sp
+		dy.xor(dx)
+		slots[x], slots[y] = dx, dy
+		return erased, nil
+	}
+	return nil, fmt.Errorf("%w: %d unreadable", errUnrecoverable, len(erased))
+}
+
+// rebuildStripe recovers the erased slots of a stripe in place from the parity
+// that is left, after a member holding part of it left abruptly. With both P
+// and Q it's correctStripe, with only one of them a single erased slot can be
+// recovered.
+func rebuildStripe(parity stripeParity, hasP, hasQ bool, slots []slot, erased []int) error {
+	if hasP && hasQ {
+		_, err := correctStripe(parity, slots, erased)
+		return err
+	}
+	switch {
+	case len(erased) == 0:
+		return nil
+	case len(erased) > 1:
+		return fmt.Errorf("%w: %d unreadable with a single parity", errUnrecoverable, len(erased))
+	}
+	x := erased[0]
+	switch {
+	case hasP:
+		// The erased slot was zeroed by the caller, so the syndrome is the slot
+		sp := parity.p
+		for _, s := range slots {
+			sp.xor(s)
+		}
+		slots[x] = sp
+	case hasQ:
+		// sq = g^x * Dx
+		sq := parity.q
+		for shard, s := range slots {
+			sq.xor(s.mul(gfExp[shard]))
+		}
+		for i := range sq {
+			sq[i] = gfDiv(sq[i], gfExp[x])
+		}
+		slots[x] = sq
+	default:
+		return fmt.Errorf("%w: no parity left", errUnrecoverable)
+	}
+	return nil
+}
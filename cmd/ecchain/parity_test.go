@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// randomStripe returns the slots of a stripe over the given number of shards,
+// some of them free, together with their parity.
+func randomStripe(rng *rand.Rand, shards int) ([]slot, stripeParity) {
+	var (
+		slots  = make([]slot, shards)
+		parity stripeParity
+	)
+	for shard := range slots {
+		if rng.Intn(4) == 0 {
+			continue // free slot
+		}
+		var address common.Address
+		rng.Read(address[:])
+		slots[shard] = encodeSlot(address, new(big.Int).SetUint64(rng.Uint64()))
+
+		parity.p.xor(slots[shard])
+		parity.q.xor(slots[shard].mul(gfExp[shard]))
+	}
+	return slots, parity
+}
+
+// Tests that every single and double erasure of a stripe is recovered from
+// the P and Q parity.
+func TestParityErasures(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, shards := range []int{2, 4, 16, 64} {
+		want, parity := randomStripe(rng, shards)
+		check := func(erased ...int) {
+			t.Helper()
+
+			have := append([]slot{}, want...)
+			for _, shard := range erased {
+				have[shard] = slot{}
+			}
+			corrected, err := correctStripe(parity, have, erased)
+			if err != nil {
+				t.Fatalf("shards %d, erased %v: %v", shards, erased, err)
+			}
+			if len(corrected) != len(erased) {
+				t.Errorf("shards %d, erased %v: corrected %v", shards, erased, corrected)
+			}
+			for shard := range want {
+				if have[shard] != want[shard] {
+					t.Fatalf("shards %d, erased %v: slot %d mismatch", shards, erased, shard)
+				}
+			}
+		}
+		for x := 0; x < shards; x++ {
+			check(x)
+			for y := x + 1; y < shards; y++ {
+				check(x, y)
+			}
+		}
+	}
+}
+
+// Tests that a single wrong slot of a stripe is located and corrected from the
+// P and Q parity, and that more faults than the parity covers are reported.
+func TestParityCorruption(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, shards := range []int{2, 4, 16, 64} {
+		want, parity := randomStripe(rng, shards)
+
+		// Intact stripes are left alone
+		if corrected, err := correctStripe(parity, append([]slot{}, want...), nil); err != nil || corrected != nil {
+			t.Fatalf("shards %d: intact stripe corrected %v, err %v", shards, corrected, err)
+		}
+		for z := 0; z < shards; z++ {
+			have := append([]slot{}, want...)
+			for i := 0; i < slotSize; i += 1 + rng.Intn(8) {
+				have[z][i] ^= byte(1 + rng.Intn(255))
+			}
+
+			corrected, err := correctStripe(parity, have, nil)
+			if err != nil {
+				t.Fatalf("shards %d, wrong %d: %v", shards, z, err)
+			}
+			if len(corrected) != 1 || corrected[0] != z {
+				t.Errorf("shards %d, wrong %d: corrected %v", shards, z, corrected)
+			}
+			for shard := range want {
+				if have[shard] != want[shard] {
+					t.Fatalf("shards %d, wrong %d: slot %d mismatch", shards, z, shard)
+				}
+			}
+		}
+		if shards < 3 {
+			continue
+		}
+		if _, err := correctStripe(parity, make([]slot, shards), []int{0, 1, 2}); !errors.Is(err, errUnrecoverable) {
+			t.Errorf("shards %d: triple erasure not reported: %v", shards, err)
+		}
+	}
+}
+
+// Tests that a single erasure of a stripe is recovered from either parity
+// alone, and that more erasures are reported.
+func TestParitySingleParity(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, shards := range []int{2, 4, 16, 64} {
+		want, parity := randomStripe(rng, shards)
+		for _, left := range []struct{ p, q bool }{{true, false}, {false, true}} {
+			for x := 0; x < shards; x++ {
+				have := append([]slot{}, want...)
+				have[x] = slot{}
+				if err := rebuildStripe(parity, left.p, left.q, have, []int{x}); err != nil {
+					t.Fatalf("shards %d, P %v, Q %v, erased %d: %v", shards, left.p, left.q, x, err)
+				}
+				if have[x] != want[x] {
+					t.Fatalf("shards %d, P %v, Q %v, erased %d: slot mismatch", shards, left.p, left.q, x)
+				}
+			}
+			if err := rebuildStripe(parity, left.p, left.q, make([]slot, shards), []int{0, 1}); !errors.Is(err, errUnrecoverable) {
+				t.Errorf("shards %d, P %v, Q %v: double erasure not reported: %v", shards, left.p, left.q, err)
+			}
+		}
+	}
+}
+
+func TestParityGroupBound(t *testing.T) {
+	seen := make(map[byte]int)
+	for shard := 0; shard < 1<<maxGroupBits; shard++ {
+		if prev, ok := seen[gfExp[shard]]; ok {
+			t.Fatalf("shards %d and %d share the coefficient %#x", prev, shard, gfExp[shard])
+		}
+		seen[gfExp[shard]] = shard
+	}
+	if _, err := NewEcGroup(maxGroupBits+1, 100, 1); err == nil {
+		t.Fatal("group beyond the distinct coefficients accepted")
+	}
+}
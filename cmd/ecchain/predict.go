@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"math"
+)
+
+// predictInterval is the number of blocks between two samples of the models.
+const predictInterval = 10000
+
+// tierSample is the state of a tierModel at the end of a sample window.
+type tierSample struct {
+	height    int
+	hot, cold int     // accounts in the hot and cold sets
+	coldRate  float64 // cold reads per transaction in the window
+	migrated  int     // accounts moved between the sets in the window
+}
+
+// tierModel samples the hot/cold bookkeeping of analyze for one pair of
+// recency and frequency thresholds.
+type tierModel struct {
+	*tierSets
+
+	txs     int // transactions of the current window
+	samples []tierSample
+}
+
+func newTierModel(recency int, frequency float64) *tierModel {
+	return &tierModel{tierSets: newTierSets(recency, frequency)}
+}
+
+func (m *tierModel) processTx(tx txFromZip) {
+	m.expire(tx.blockNumber - 1)
+	m.txs++
+	m.access(tx.sender, tx.blockNumber)
+	m.access(tx.to, tx.blockNumber)
+}
+
+func (m *tierModel) sample(height int) {
+	s := tierSample{height: height, hot: len(m.hot), cold: len(m.cold), migrated: m.migrated}
+	if m.txs > 0 {
+		s.coldRate = float64(m.coldReads) / float64(m.txs)
+	}
+	m.samples = append(m.samples, s)
+	m.txs, m.coldReads, m.migrated = 0, 0, 0
+}
+
+// tierPrediction is the extrapolation of a tierModel to a target height.
+type tierPrediction struct {
+	recency    int
+	frequency  float64
+	hotGrowth  float64 // hot accounts added per block
+	hot, cold  int     // accounts in the hot and cold sets at the target height
+	coldRate   float64 // cold reads per transaction
+	migrations float64 // accounts moved between the sets per block
+	nodeBytes  float64 // storage of a member at the target height
+}
+
+// fitLine fits y = a + b*x by least squares.
+func fitLine(xs, ys []float64) (a, b float64) {
+	var sx, sy, sxx, sxy float64
+	n := float64(len(xs))
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	if d := n*sxx - sx*sx; d != 0 {
+		b = (n*sxy - sx*sy) / d
+	}
+	return (sy - b*sx) / n, b
+}
+
+// predict fits the growth of the hot and cold sets over the height and
+// extrapolates them to the target. The cold read and migration rates are the
+// averages of the second half of the samples, once the sets have warmed up.
+// A member stores a replica of the hot set and its share of the cold shards
+// of a group of the given size, plus its share of the P and Q parity.
+func (m *tierModel) predict(target, size int, accountSize float64) (*tierPrediction, error) {
+	if len(m.samples) < 2 {
+		return nil, errors.New("not enough blocks to fit the growth")
+	}
+	var (
+		heights = make([]float64, len(m.samples))
+		hots    = make([]float64, len(m.samples))
+		colds   = make([]float64, len(m.samples))
+	)
+	for i, s := range m.samples {
+		heights[i], hots[i], colds[i] = float64(s.height), float64(s.hot), float64(s.cold)
+	}
+	hotA, hotB := fitLine(heights, hots)
+	coldA, coldB := fitLine(heights, colds)
+
+	p := &tierPrediction{
+		recency:   m.recency,
+		frequency: m.frequency,
+		hotGrowth: hotB,
+		hot:       int(math.Max(0, hotA+hotB*float64(target))),
+		cold:      int(math.Max(0, coldA+coldB*float64(target))),
+	}
+	steady := m.samples[len(m.samples)/2:]
+	for _, s := range steady {
+		p.coldRate += s.coldRate
+		p.migrations += float64(s.migrated) / predictInterval
+	}
+	p.coldRate /= float64(len(steady))
+	p.migrations /= float64(len(steady))
+
+	shard := float64(p.cold) * slotSize * float64(size+2) / float64(size) / float64(size)
+	p.nodeBytes = float64(p.hot)*accountSize + shard
+	return p, nil
+}
+
+// recommend returns the prediction with the fewest cold reads among those
+// fitting the storage budget of a member, preferring fewer migrations on a
+// tie, or nil if none fits.
+func recommend(predictions []*tierPrediction, budget float64) *tierPrediction {
+	var best *tierPrediction
+	for _, p := range predictions {
+		if p.nodeBytes > budget {
+			continue
+		}
+		if best == nil || p.coldRate < best.coldRate || (p.coldRate == best.coldRate && p.migrations < best.migrations) {
+			best = p
+		}
+	}
+	return best
+}
+
+// predict replays the transactions through a model per combination of the
+// recency and frequency thresholds, reports the growth of the hot set, the
+// cold read rate and the migration volume of each, extrapolated to the target
+// height, and recommends the thresholds for the storage budget of a member.
+func predict(ctx *cli.Context) error {
+	recencies := ctx.IntSlice(predictRecenciesFlag.Name)
+	if len(recencies) == 0 {
+		recencies = []int{ctx.Int(recencyFlag.Name)}
+	}
+	frequencies := ctx.Float64Slice(predictFrequenciesFlag.Name)
+	if len(frequencies) == 0 {
+		frequencies = []float64{ctx.Float64(frequencyFlag.Name)}
+	}
+	var models []*tierModel
+	for _, recency := range recencies {
+		for _, frequency := range frequencies {
+			if frequency <= 0 {
+				return fmt.Errorf("invalid frequency %v", frequency)
+			}
+			models = append(models, newTierModel(recency, frequency))
+		}
+	}
+	lstBlock, window := -1, -1
+	err := processTxFromZip(func(height int) error {
+		for _, m := range models {
+			m.expire(height)
+		}
+		return nil
+	}, func(tx txFromZip) error {
+		if w := tx.blockNumber / predictInterval; w != window {
+			if window != -1 {
+				for _, m := range models {
+					m.sample(lstBlock)
+				}
+			}
+			window = w
+		}
+		lstBlock = tx.blockNumber
+		for _, m := range models {
+			m.processTx(tx)
+		}
+		return nil
+	}, prepareFiles(ctx)...)
+	if err != nil {
+		return err
+	}
+	if lstBlock == -1 {
+		return errors.New("no transactions to replay")
+	}
+	for _, m := range models {
+		m.expire(lstBlock)
+		m.sample(lstBlock)
+	}
+	var (
+		target      = ctx.Int(predictHeightFlag.Name)
+		size        = 1 << ctx.Int(ecKFlag.Name)
+		accountSize = float64(ctx.Int(predictAccountSizeFlag.Name))
+		predictions []*tierPrediction
+	)
+	fmt.Println("recency frequency hotGrowth hot cold coldRate migrations nodeBytes")
+	for _, m := range models {
+		p, err := m.predict(target, size, accountSize)
+		if err != nil {
+			return err
+		}
+		fmt.Println(p.recency, p.frequency, p.hotGrowth, p.hot, p.cold, p.coldRate, p.migrations, int64(p.nodeBytes))
+		predictions = append(predictions, p)
+	}
+	if !ctx.IsSet(predictBudgetFlag.Name) {
+		return nil
+	}
+	budget := ctx.Float64(predictBudgetFlag.Name) * 1024 * 1024
+	best := recommend(predictions, budget)
+	if best == nil {
+		return fmt.Errorf("no thresholds fit a budget of %v MB per node at block %d", ctx.Float64(predictBudgetFlag.Name), target)
+	}
+	fmt.Println("recommended recency", best.recency, "frequency", best.frequency)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitLine(t *testing.T) {
+	tests := []struct {
+		xs, ys []float64
+		a, b   float64
+	}{
+		{[]float64{0, 1, 2, 3}, []float64{1, 3, 5, 7}, 1, 2},
+		{[]float64{10, 20, 30}, []float64{5, 5, 5}, 5, 0},
+		{[]float64{0, 0, 2, 2}, []float64{0, 2, 2, 4}, 1, 1}, // noisy, fitted through the means
+		{[]float64{4, 4}, []float64{1, 3}, 2, 0},             // no spread in x, flat at the mean
+	}
+	for i, tt := range tests {
+		a, b := fitLine(tt.xs, tt.ys)
+		if math.Abs(a-tt.a) > 1e-9 || math.Abs(b-tt.b) > 1e-9 {
+			t.Errorf("test %d: have y = %v + %v*x, want y = %v + %v*x", i, a, b, tt.a, tt.b)
+		}
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	predictions := []*tierPrediction{
+		{recency: 1, coldRate: 0.5, migrations: 10, nodeBytes: 100},
+		{recency: 2, coldRate: 0.2, migrations: 20, nodeBytes: 300},
+		{recency: 3, coldRate: 0.2, migrations: 5, nodeBytes: 300},
+		{recency: 4, coldRate: 0.1, migrations: 1, nodeBytes: 1000},
+	}
+	tests := []struct {
+		budget  float64
+		recency int // 0 if none fits
+	}{
+		{50, 0},
+		{100, 1},
+		{299, 1},
+		{300, 3}, // tie on the cold rate, fewer migrations
+		{1000, 4},
+	}
+	for _, tt := range tests {
+		best := recommend(predictions, tt.budget)
+		switch {
+		case best == nil && tt.recency != 0:
+			t.Errorf("budget %v: nothing recommended, want recency %d", tt.budget, tt.recency)
+		case best != nil && best.recency != tt.recency:
+			t.Errorf("budget %v: recommended recency %d, want %d", tt.budget, best.recency, tt.recency)
+		}
+	}
+}
+
+// Tests the hot/cold bookkeeping shared by analyze and its predictions.
+func TestTierSets(t *testing.T) {
+	s := newTierSets(10, 1)
+
+	s.access("a", 1) // new, expires at 11
+	s.access("b", 1)
+	s.access("b", 5) // expires at 15
+	s.expire(11)
+	if _, ok := s.cold["a"]; !ok || len(s.hot) != 1 {
+		t.Fatalf("after block 11: hot %v, cold %v", s.hot, s.cold)
+	}
+	s.access("a", 12) // read back from the cold set
+	if s.coldReads != 3 || s.migrated != 2 {
+		t.Fatalf("cold reads %d, migrated %d, want 3 and 2", s.coldReads, s.migrated)
+	}
+	// accounts expiring beyond the history stay hot
+	s.access("c", maxExpiryHeight)
+	s.expire(maxExpiryHeight + 20)
+	if _, ok := s.hot["c"]; !ok || len(s.hot) != 1 {
+		t.Fatalf("after the history: hot %v, cold %v", s.hot, s.cold)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"math/big"
+	"os"
+	"time"
+)
+
+// verifyAccountProof checks the Merkle proof of an account against the given
+// state root and returns the proven account, or nil if the proof shows that
+// the account does not exist.
+func verifyAccountProof(root common.Hash, address common.Address, proof [][]byte) (*types.StateAccount, error) {
+	proofDb := memorydb.New()
+	for _, node := range proof {
+		if err := proofDb.Put(crypto.Keccak256(node), node); err != nil {
+			return nil, err
+		}
+	}
+	blob, err := trie.VerifyProof(root, crypto.Keccak256(address.Bytes()), proofDb)
+	if err != nil {
+		return nil, err
+	}
+	if len(blob) == 0 {
+		return nil, nil
+	}
+	account := new(types.StateAccount)
+	if err := rlp.DecodeBytes(blob, account); err != nil {
+		return nil, fmt.Errorf("invalid account %x in proof: %v", address, err)
+	}
+	return account, nil
+}
+
+// fetchCold fetches the account from the cold trie of its owning shard and
+// verifies it against the cold root the group agreed on for the holder. It
+// also returns the size of the data sent over the network. If the holder
+// serves an account that doesn't verify, it is suspected and the account is
+// recovered from the other shards of its stripe instead.
+func (g *EcGroup) fetchCold(address common.Address) (*types.StateAccount, int, error) {
+	owner := g.GetNodeForAddress(address)
+	proof, _, _, err := owner.cold.GetProof(address)
+	size := 0
+	for _, node := range proof {
+		size += len(node)
+	}
+	var account *types.StateAccount
+	if err == nil {
+		account, err = verifyAccountProof(g.agreedRoots[owner.ind], address, proof)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fault", "member", owner.ind, "served", address, "err", err)
+		g.suspect(owner)
+		recovered, recoverSize, err := g.recoverCold(address)
+		return recovered, size + recoverSize, err
+	}
+	return account, size, nil
+}
+
+// ReadCold fetches and verifies a cold account for every member of the group.
+// It implements ColdReader for the cold prefetcher. The network time isn't
+// charged here, the reads are concurrent and would draw from the network
+// model in a random order, but by prefetchCold once the reads are done.
+func (g *EcGroup) ReadCold(address common.Address) (*types.StateAccount, error) {
+	account, size, err := g.fetchCold(address)
+	if err != nil {
+		return nil, err
+	}
+	g.prefetchLock.Lock()
+	g.prefetchSizes[address] = size
+	g.prefetchLock.Unlock()
+	return account, nil
+}
+
+// prefetchCold fetches the accounts of the block's txs which are not hot from
+// their shards in parallel, before the txs are executed. It returns the
+// network time the prefetch adds to the block, the fetches being spread
+// evenly over the parallel fetchers.
+func (g *EcGroup) prefetchCold(txs []txFromZip) time.Duration {
+	var (
+		addrs []common.Address
+		seen  = make(map[common.Address]struct{})
+	)
+	for _, tx := range txs {
+		for _, addrString := range []string{tx.sender, tx.to} {
+			addr := common.HexToAddress(addrString)
+			if _, ok := seen[addr]; ok || g.IsHot(addr) {
+				continue
+			}
+			seen[addr] = struct{}{}
+			addrs = append(addrs, addr)
+		}
+	}
+	g.prefetchSizes = make(map[common.Address]int)
+	g.prefetcher.Prefetch(addrs)
+
+	// Charge the fetches in the order of the txs, for --net.seed to reproduce
+	// the network times
+	lanes := g.prefetchThreads
+	if lanes > len(addrs) {
+		lanes = len(addrs)
+	}
+	if g.network == nil || lanes == 0 {
+		return 0
+	}
+	var netTime time.Duration
+	for _, addr := range addrs {
+		if size, ok := g.prefetchSizes[addr]; ok {
+			netTime += g.network.FetchAll(g.GetNodeForAddress(addr).ind, size, g.memberInds())
+		}
+	}
+	return netTime / time.Duration(lanes)
+}
+
+// coldBalance returns the balance of a cold account and the network time the
+// read adds to the tx. Accounts prefetched ahead are served without delay,
+// otherwise every member of the group fetches the account from its shard.
+func (g *EcGroup) coldBalance(address common.Address) (*big.Int, time.Duration) {
+	if g.prefetcher != nil {
+		if account, ok := g.prefetcher.Account(address); ok {
+			if account == nil {
+				return new(big.Int), 0
+			}
+			return account.Balance, 0
+		}
+	}
+	if g.prefetcher == nil && g.network == nil && g.scrubInterval == 0 {
+		return g.GetNodeForAddress(address).cold.stateDb.GetBalance(address), 0
+	}
+	account, size, err := g.fetchCold(address)
+	if err != nil {
+		return g.GetNodeForAddress(address).cold.stateDb.GetBalance(address), 0
+	}
+	var netTime time.Duration
+	if g.network != nil {
+		netTime = g.network.FetchAll(g.GetNodeForAddress(address).ind, size, g.memberInds())
+	}
+	if account == nil {
+		return new(big.Int), netTime
+	}
+	return account.Balance, netTime
+}
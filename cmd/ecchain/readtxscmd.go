@@ -74,6 +74,11 @@ var (
 			measureTimeFlag,
 			measureStorageFlag,
 			debugFlag,
+			noSnapshotFlag,
+			gcFlag,
+			snapshotCompareFlag,
+			measureMemoryFlag,
+			trieCacheFlag,
 		},
 		ArgsUsage:   "",
 		Description: "ecchain geth /path/to/my.zip",
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/params"
+	"math/big"
+	"time"
+)
+
+// newRentConfig returns a chain config charging the given rent per byte and
+// block from the genesis, or nil if no rent is charged.
+func newRentConfig(perByte uint64) *params.ChainConfig {
+	if perByte == 0 {
+		return nil
+	}
+	return &params.ChainConfig{
+		StateRent: &params.StateRentConfig{
+			Block:       new(big.Int),
+			RentPerByte: new(big.Int).SetUint64(perByte),
+		},
+	}
+}
+
+// chargeRent charges a hot account the rent it owes at the given height and
+// schedules a check for the height at which its balance no longer covers the
+// rent.
+func (g *EcGroup) chargeRent(address common.Address, height int) {
+	number := big.NewInt(int64(height))
+	for _, n := range g.hotWriters() {
+		core.ChargeRent(g.rentConfig, n.hot.stateDb, address, number)
+	}
+	statedb := g.nodes[0].hot.stateDb
+	paid := core.RentPaidUntil(statedb, address)
+	if paid == 0 {
+		return // empty, no residency to pay for
+	}
+	perBlock := new(big.Int).SetUint64(core.RentSize(statedb, address))
+	perBlock.Mul(perBlock, g.rentConfig.StateRent.RentPerByte)
+	blocks := new(big.Int).Div(statedb.GetBalance(address), perBlock)
+
+	// the balance covers the rent up to paid+blocks, it runs out a block later
+	expiry := new(big.Int).SetUint64(paid)
+	expiry.Add(expiry, blocks).Add(expiry, common.Big1)
+	if expiry.Cmp(big.NewInt(maxExpiryHeight)) >= 0 {
+		return
+	}
+	h := int(expiry.Int64())
+	if _, ok := g.rentExpiry[h]; !ok {
+		g.rentExpiry[h] = make(map[common.Address]struct{})
+	}
+	g.rentExpiry[h][address] = struct{}{}
+}
+
+// coldRentExpired moves the hot accounts whose rent has run out at the given
+// height to the cold shards. An account charged again since its check was
+// scheduled may have paid up, so the check is made against the hot state.
+func (g *EcGroup) coldRentExpired(height int) time.Duration {
+	var (
+		netTime time.Duration
+		number  = big.NewInt(int64(height))
+	)
+	statedb := g.nodes[0].hot.stateDb
+	for address := range g.rentExpiry[height] {
+		// skip the accounts already colded for not being accessed recently
+		if g.IsHot(address) && !statedb.HasSuicided(address) && core.RentExpired(g.rentConfig, statedb, address, number) {
+			netTime += g.moveToCold(address)
+		}
+	}
+	delete(g.rentExpiry, height)
+	return netTime
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"math/big"
+	"testing"
+)
+
+// Tests that a hot account is colded at the height its balance stops covering
+// its rent, and not before.
+func TestRentExpiry(t *testing.T) {
+	g, err := NewEcGroup(1, 100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Clean()
+	g.rentConfig = newRentConfig(1)
+
+	// rent for ten blocks
+	addr := common.HexToAddress("0x8000000000000000000000000000000000000001")
+	g.writeHot(func(n *EcNode) {
+		n.AddBalanceHot(addr, new(big.Int).SetUint64(10*params.AccountRentSize))
+	})
+	g.chargeRent(addr, 5)
+	if _, ok := g.rentExpiry[16]; !ok {
+		t.Fatalf("expiry scheduled at %v, want 16", g.rentExpiry)
+	}
+	g.rentExpiry[15] = map[common.Address]struct{}{addr: {}}
+	g.coldRentExpired(15)
+	if !g.IsHot(addr) {
+		t.Fatal("account with paid-up rent colded")
+	}
+	g.coldRentExpired(16)
+	if err := g.Commit(16, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if g.IsHot(addr) {
+		t.Fatal("account with unpaid rent still hot")
+	}
+	if balance := g.GetNodeForAddress(addr).cold.GetBalance(addr); balance.Uint64() != 10*params.AccountRentSize {
+		t.Fatalf("cold balance %v, want %d", balance, 10*params.AccountRentSize)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+)
+
+// scrubReport summarises the repair of a member found faulty by a scrub.
+type scrubReport struct {
+	member      int           // index of the faulty member
+	shards      []int         // shards with slots that were wrong or unreadable
+	slots       int           // number of slots recovered from the other members
+	rootChanged bool          // whether the agreed cold root of the member was wrong
+	bytes       int           // bytes fetched from the other members for the repair
+	repair      time.Duration // time to fetch the recovered slots
+}
+
+func (r *scrubReport) String() string {
+	return fmt.Sprintf("member %d shards %v slots %d rootchanged %v bytes %d repair %v",
+		r.member, r.shards, r.slots, r.rootChanged, r.bytes, r.repair)
+}
+
+// agreeColdRoots records the committed cold roots of the members as agreed by
+// the group. Reads from the cold shards are verified against these.
+func (g *EcGroup) agreeColdRoots() {
+	g.agreedRoots = make(map[int]common.Hash, len(g.nodes))
+	for _, n := range g.nodes {
+		g.agreedRoots[n.ind] = n.cold.Root()
+	}
+}
+
+// suspect marks a member whose cold shard served data not matching its agreed
+// root, so that the group scrubs it at the end of the block.
+func (g *EcGroup) suspect(n *EcNode) {
+	g.suspectLock.Lock()
+	defer g.suspectLock.Unlock()
+
+	g.suspects[n.ind] = struct{}{}
+}
+
+// coldTrie opens the committed cold trie of a member, caching it in tries. It
+// returns nil if the root node itself can't be read.
+func coldTrie(tries map[*EcNode]state.Trie, n *EcNode) state.Trie {
+	if tr, ok := tries[n]; ok {
+		return tr
+	}
+	tr, err := n.cold.db.OpenTrie(n.cold.Root())
+	if err != nil {
+		tr = nil
+	}
+	tries[n] = tr
+	return tr
+}
+
+// readStripe reads the slots of a stripe from the committed cold tries of
+// their holders. Slots that can't be read, and the slot of the skipped shard,
+// are zeroed and returned as erased. It also returns the bytes read from each
+// holder.
+func (g *EcGroup) readStripe(i int, tries map[*EcNode]state.Trie, skip int) ([]slot, []int, map[*EcNode]int) {
+	var (
+		slots  = make([]slot, g.size)
+		erased []int
+		sent   = make(map[*EcNode]int)
+	)
+	for shard, shardSlots := range g.slots {
+		if i >= len(shardSlots.addrs) || shardSlots.addrs[i] == (common.Address{}) {
+			continue
+		}
+		if shard == skip {
+			erased = append(erased, shard)
+			continue
+		}
+		address, holder := shardSlots.addrs[i], g.owner[shard]
+		tr := coldTrie(tries, holder)
+		if tr == nil {
+			erased = append(erased, shard)
+			continue
+		}
+		account, err := tr.GetAccount(address)
+		if err != nil || account == nil {
+			erased = append(erased, shard)
+			continue
+		}
+		slots[shard] = encodeSlot(address, account.Balance)
+		sent[holder] += slotSize
+	}
+	return slots, erased, sent
+}
+
+// recoverCold reconstructs a cold account from the other shards of its stripe
+// and the parity, without its own holder. It returns the account and the bytes
+// fetched, the parity included, which the caller charges like the read it
+// replaces.
+func (g *EcGroup) recoverCold(address common.Address) (*types.StateAccount, int, error) {
+	shard := GetIndForAddress(g.k, address)
+	i, ok := g.slots[shard].index[address]
+	if !ok {
+		return nil, 0, nil // not committed to the cold tier
+	}
+	slots, erased, sent := g.readStripe(i, make(map[*EcNode]state.Trie), shard)
+	if _, err := correctStripe(g.parity[i], slots, erased); err != nil {
+		return nil, 0, err
+	}
+	recovered, balance := slots[shard].decode()
+	if recovered != address {
+		return nil, 0, fmt.Errorf("stripe %d recovers %x instead of %x", i, recovered, address)
+	}
+	size := 2 * slotSize // the parity
+	for _, bytes := range sent {
+		size += bytes
+	}
+	return coldAccount(balance), size, nil
+}
+
+func coldAccount(balance *big.Int) *types.StateAccount {
+	return &types.StateAccount{
+		Balance:  balance,
+		Root:     types.EmptyRootHash,
+		CodeHash: types.EmptyCodeHash.Bytes(),
+	}
+}
+
+// scrub checks every stripe of the cold shards against its parity and every
+// member's cold trie against its agreed root, and repairs the faulty members
+// from the others. It must run while the committed cold state matches the
+// slots, i.e. before the cold changes of the block are applied to them.
+func (g *EcGroup) scrub(height int) error {
+	var (
+		tries     = make(map[*EcNode]state.Trie)
+		corrected = make(map[int]map[int]slot) // recovered slots by shard and index
+		stripes   = 0
+	)
+	for _, shardSlots := range g.slots {
+		if len(shardSlots.addrs) > stripes {
+			stripes = len(shardSlots.addrs)
+		}
+	}
+	for i := 0; i < stripes; i++ {
+		slots, erased, _ := g.readStripe(i, tries, -1)
+		shards, err := correctStripe(g.parity[i], slots, erased)
+		if err != nil {
+			return fmt.Errorf("stripe %d: %w", i, err)
+		}
+		for _, shard := range shards {
+			if address, _ := slots[shard].decode(); address != g.slots[shard].addrs[i] {
+				return fmt.Errorf("stripe %d recovers %x instead of %x", i, address, g.slots[shard].addrs[i])
+			}
+			if corrected[shard] == nil {
+				corrected[shard] = make(map[int]slot)
+			}
+			corrected[shard][i] = slots[shard]
+		}
+	}
+	g.suspectLock.Lock()
+	g.suspects = make(map[int]struct{})
+	g.suspectLock.Unlock()
+
+	for _, n := range g.nodes {
+		faulty := false
+		for _, shard := range g.shardsOf(n) {
+			if _, ok := corrected[shard]; ok {
+				faulty = true
+			}
+		}
+		if root, err := n.cold.recomputeRoot(); err != nil || root != g.agreedRoots[n.ind] {
+			faulty = true
+		}
+		if !faulty {
+			continue
+		}
+		report, err := g.repairCold(n, coldTrie(tries, n), corrected)
+		if err != nil {
+			return fmt.Errorf("member %d: %v", n.ind, err)
+		}
+		fmt.Fprintln(os.Stderr, "scrub", height, report)
+	}
+	return nil
+}
+
+// repairCold rewrites the cold trie of a member from the slots of its shards,
+// taking the corrected slots from the given ones and the others from its own
+// trie, then reopens its state and replays the uncommitted cold changes.
+func (g *EcGroup) repairCold(n *EcNode, tr state.Trie, corrected map[int]map[int]slot) (*scrubReport, error) {
+	var (
+		report = &scrubReport{member: n.ind}
+		leaves = make(map[common.Hash][]byte)
+		sent   = make(map[int]int) // bytes fetched from each member
+	)
+	for _, shard := range g.shardsOf(n) {
+		if _, ok := corrected[shard]; ok {
+			report.shards = append(report.shards, shard)
+		}
+		for i, address := range g.slots[shard].addrs {
+			if address == (common.Address{}) {
+				continue
+			}
+			var balance *big.Int
+			if s, ok := corrected[shard][i]; ok {
+				_, balance = s.decode()
+				report.slots++
+
+				// the other slots of the stripe and its parity
+				for other, otherSlots := range g.slots {
+					if other != shard && i < len(otherSlots.addrs) && otherSlots.addrs[i] != (common.Address{}) {
+						sent[g.owner[other].ind] += slotSize
+					}
+				}
+				pHolder, qHolder := g.parityHolders(i)
+				sent[pHolder.ind] += slotSize
+				sent[qHolder.ind] += slotSize
+			} else {
+				if tr == nil {
+					return nil, fmt.Errorf("unreadable cold trie")
+				}
+				account, err := tr.GetAccount(address)
+				if err != nil {
+					return nil, err
+				}
+				if account == nil {
+					return nil, fmt.Errorf("missing cold account %x", address)
+				}
+				balance = account.Balance
+			}
+			blob, err := rlp.EncodeToBytes(coldAccount(balance))
+			if err != nil {
+				return nil, err
+			}
+			leaves[crypto.Keccak256Hash(address.Bytes())] = blob
+		}
+	}
+	sort.Ints(report.shards)
+
+	start := time.Now()
+	root, err := n.cold.writeTrie(leaves)
+	if err != nil {
+		return nil, err
+	}
+	report.rootChanged = root != g.agreedRoots[n.ind]
+	if err := n.cold.resetState(root); err != nil {
+		return nil, err
+	}
+	for _, change := range g.coldChanges {
+		if g.GetNodeForAddress(change.address) != n {
+			continue
+		}
+		if change.add {
+			n.cold.SetBalance(change.address, change.balance)
+		} else {
+			n.cold.Delete(change.address)
+		}
+	}
+	g.agreedRoots[n.ind] = root
+
+	for member, bytes := range sent {
+		report.bytes += bytes
+		if g.network != nil {
+			if elapsed := g.network.Send(member, n.ind, bytes); elapsed > report.repair {
+				report.repair = elapsed
+			}
+		}
+	}
+	if g.network == nil {
+		report.repair = time.Since(start)
+	}
+	return report, nil
+}
+
+// commitCold commits the cold tries of the members. A member whose trie fails
+// to hash the block's changes is corrupted, so the group scrubs before
+// committing anything.
+func (g *EcGroup) commitCold(height int) error {
+	for _, n := range g.nodes {
+		n.cold.stateDb.IntermediateRoot(true)
+		if err := n.cold.stateDb.Error(); err != nil {
+			fmt.Fprintln(os.Stderr, "fault", height, "detected member", n.ind, "err", err)
+			if err := g.scrub(height); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	for _, n := range g.nodes {
+		if err := n.cold.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// injectFault corrupts a random trie node on disk of the cold trie of a
+// random member, with the probability given by --fault.rate.
+func (g *EcGroup) injectFault(height int) error {
+	if g.faultRate == 0 || g.faultRng.Float64() >= g.faultRate {
+		return nil
+	}
+	n := g.nodes[g.faultRng.Intn(len(g.nodes))]
+	hash, ok, err := n.cold.corruptNode(g.faultRng)
+	if err != nil || !ok {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "fault", height, "injected member", n.ind, "node", hash)
+	return nil
+}
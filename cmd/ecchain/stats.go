@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var statsCmd = &cli.Command{
+	Name:   "stats",
+	Usage:  "Export account access-pattern statistics of the ETH transactions from zip",
+	Action: stats,
+	Flags: []cli.Flag{
+		zipDirFlag,
+		statsOutFlag,
+		statsFormatFlag,
+		statsWindowFlag,
+		statsStepFlag,
+		statsTopFlag,
+	},
+	Description: `
+    ecchain stats --stats.format csv /path/to/my.zip
+
+Writes the reuse distance, inter-access time and lifetime histograms of the
+accessed accounts, the contract/EOA split of the accesses and the hottest
+accounts of every window to the output directory, as one CSV file per table
+or as stats.json.`,
+}
+
+// logHistogram counts values in power-of-two buckets: bucket 0 holds 0 and
+// bucket i holds [2^(i-1), 2^i).
+type logHistogram []int
+
+func (h *logHistogram) add(v int) {
+	b := bits.Len(uint(v))
+	for len(*h) <= b {
+		*h = append(*h, 0)
+	}
+	(*h)[b]++
+}
+
+// histogramBucket is a bucket of a logHistogram in the output.
+type histogramBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Count int `json:"count"`
+}
+
+func (h logHistogram) buckets() []histogramBucket {
+	out := make([]histogramBucket, len(h))
+	for i, count := range h {
+		out[i] = histogramBucket{Count: count}
+		if i > 0 {
+			out[i].Min, out[i].Max = 1<<(i-1), 1<<i-1
+		}
+	}
+	return out
+}
+
+// accessTree is a Fenwick tree over the access sequence that grows as
+// accesses are appended. It marks the last access of every account, so the
+// reuse distance of an access is the number of marks since the previous
+// access of the same account.
+type accessTree []int32
+
+// push appends a marked access and returns its position, starting at 1.
+func (t *accessTree) push() int {
+	i := len(*t) + 1
+	*t = append(*t, 1+int32(t.prefix(i-1)-t.prefix(i-i&-i)))
+	return i
+}
+
+func (t accessTree) unmark(i int) {
+	for ; i <= len(t); i += i & -i {
+		t[i-1]--
+	}
+}
+
+// prefix returns the number of marks up to position i.
+func (t accessTree) prefix(i int) int {
+	sum := 0
+	for ; i > 0; i -= i & -i {
+		sum += int(t[i-1])
+	}
+	return sum
+}
+
+// accountStats is the access history of an account.
+type accountStats struct {
+	first, last int // heights of the first and last access
+	position    int // position of the last access in the access sequence
+	contract    bool
+}
+
+// hotAccount is an account of the top-N of a window.
+type hotAccount struct {
+	Address  string `json:"address"`
+	Accesses int    `json:"accesses"`
+}
+
+// hotWindow is the top-N of the accounts accessed within [Start, End].
+type hotWindow struct {
+	Start    int          `json:"start"`
+	End      int          `json:"end"`
+	Accounts []hotAccount `json:"accounts"`
+}
+
+// accessSplit is the split of the accesses and accounts into contracts and
+// EOAs.
+type accessSplit struct {
+	ContractAccesses int `json:"contractAccesses"`
+	EOAAccesses      int `json:"eoaAccesses"`
+	Contracts        int `json:"contracts"`
+	EOAs             int `json:"eoas"`
+	ContractTxs      int `json:"contractTxs"` // transactions calling or creating a contract
+	Txs              int `json:"txs"`
+}
+
+// accessStats gathers the access-pattern statistics of a replay.
+type accessStats struct {
+	accounts    map[string]*accountStats
+	tree        accessTree
+	reuse       logHistogram // distinct accounts accessed between two accesses of an account
+	interAccess logHistogram // blocks between two accesses of an account
+	split       accessSplit
+
+	window, step, top int
+	buckets           []map[string]int // access counts of the steps of the current window, oldest first
+	bucketStart       int              // first height of the newest step
+	windows           []hotWindow
+}
+
+func newAccessStats(window, step, top int) *accessStats {
+	return &accessStats{
+		accounts: make(map[string]*accountStats),
+		window:   window,
+		step:     step,
+		top:      top,
+	}
+}
+
+func (s *accessStats) access(addr string, contract bool, height int) {
+	account, ok := s.accounts[addr]
+	if !ok {
+		account = &accountStats{first: height}
+		s.accounts[addr] = account
+	} else {
+		s.reuse.add(s.tree.prefix(len(s.tree)) - s.tree.prefix(account.position))
+		s.interAccess.add(height - account.last)
+		s.tree.unmark(account.position)
+	}
+	account.last = height
+	account.position = s.tree.push()
+	account.contract = account.contract || contract
+	if contract {
+		s.split.ContractAccesses++
+	} else {
+		s.split.EOAAccesses++
+	}
+	s.buckets[len(s.buckets)-1][addr]++
+}
+
+// advance moves the window forward until it covers the height, emitting the
+// top-N of every full window passed.
+func (s *accessStats) advance(height int) {
+	if s.buckets == nil {
+		s.bucketStart = height - height%s.step
+		s.buckets = []map[string]int{make(map[string]int)}
+		return
+	}
+	for height >= s.bucketStart+s.step {
+		if len(s.buckets)*s.step == s.window {
+			s.emitWindow()
+			s.buckets = s.buckets[1:]
+		}
+		s.bucketStart += s.step
+		s.buckets = append(s.buckets, make(map[string]int))
+	}
+}
+
+// emitWindow records the top-N accounts of the current window.
+func (s *accessStats) emitWindow() {
+	counts := make(map[string]int)
+	for _, bucket := range s.buckets {
+		for addr, n := range bucket {
+			counts[addr] += n
+		}
+	}
+	accounts := make([]hotAccount, 0, len(counts))
+	for addr, n := range counts {
+		accounts = append(accounts, hotAccount{addr, n})
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].Accesses != accounts[j].Accesses {
+			return accounts[i].Accesses > accounts[j].Accesses
+		}
+		return accounts[i].Address < accounts[j].Address
+	})
+	if len(accounts) > s.top {
+		accounts = accounts[:s.top]
+	}
+	s.windows = append(s.windows, hotWindow{
+		Start:    s.bucketStart + s.step - s.window,
+		End:      s.bucketStart + s.step - 1,
+		Accounts: accounts,
+	})
+}
+
+func (s *accessStats) processTx(tx txFromZip) {
+	s.advance(tx.blockNumber)
+	to, toContract := tx.to, isContract(tx.toIsContract)
+	if to == "" {
+		to, toContract = tx.toCreate, true
+	}
+	s.split.Txs++
+	if toContract {
+		s.split.ContractTxs++
+	}
+	s.access(tx.sender, isContract(tx.fromIsContract), tx.blockNumber)
+	s.access(to, toContract, tx.blockNumber)
+}
+
+// isContract parses a fromIsContract/toIsContract column.
+func isContract(field string) bool {
+	return field == "1" || strings.EqualFold(field, "true")
+}
+
+// statsReport is the output of the stats command.
+type statsReport struct {
+	ReuseDistance   []histogramBucket `json:"reuseDistance"`
+	InterAccessTime []histogramBucket `json:"interAccessTime"` // in blocks
+	Lifetime        []histogramBucket `json:"lifetime"`        // blocks between the first and last access
+	Split           accessSplit       `json:"split"`
+	Hottest         []hotWindow       `json:"hottest"`
+}
+
+func (s *accessStats) report() *statsReport {
+	var lifetime logHistogram
+	for _, account := range s.accounts {
+		lifetime.add(account.last - account.first)
+		if account.contract {
+			s.split.Contracts++
+		} else {
+			s.split.EOAs++
+		}
+	}
+	return &statsReport{
+		ReuseDistance:   s.reuse.buckets(),
+		InterAccessTime: s.interAccess.buckets(),
+		Lifetime:        lifetime.buckets(),
+		Split:           s.split,
+		Hottest:         s.windows,
+	}
+}
+
+func (r *statsReport) writeJSON(dir string) error {
+	blob, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "stats.json"), blob, 0644)
+}
+
+func writeCSV(path string, records [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(records); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func histogramRecords(buckets []histogramBucket) [][]string {
+	records := [][]string{{"min", "max", "count"}}
+	for _, b := range buckets {
+		records = append(records, []string{strconv.Itoa(b.Min), strconv.Itoa(b.Max), strconv.Itoa(b.Count)})
+	}
+	return records
+}
+
+func (r *statsReport) writeCSV(dir string) error {
+	for name, buckets := range map[string][]histogramBucket{
+		"reuse.csv":       r.ReuseDistance,
+		"interaccess.csv": r.InterAccessTime,
+		"lifetime.csv":    r.Lifetime,
+	} {
+		if err := writeCSV(filepath.Join(dir, name), histogramRecords(buckets)); err != nil {
+			return err
+		}
+	}
+	split := [][]string{
+		{"kind", "accesses", "accounts"},
+		{"contract", strconv.Itoa(r.Split.ContractAccesses), strconv.Itoa(r.Split.Contracts)},
+		{"eoa", strconv.Itoa(r.Split.EOAAccesses), strconv.Itoa(r.Split.EOAs)},
+	}
+	if err := writeCSV(filepath.Join(dir, "split.csv"), split); err != nil {
+		return err
+	}
+	hottest := [][]string{{"start", "end", "rank", "address", "accesses"}}
+	for _, w := range r.Hottest {
+		for i, a := range w.Accounts {
+			hottest = append(hottest, []string{strconv.Itoa(w.Start), strconv.Itoa(w.End), strconv.Itoa(i + 1), a.Address, strconv.Itoa(a.Accesses)})
+		}
+	}
+	return writeCSV(filepath.Join(dir, "hottest.csv"), hottest)
+}
+
+func stats(ctx *cli.Context) error {
+	var (
+		window = ctx.Int(statsWindowFlag.Name)
+		step   = ctx.Int(statsStepFlag.Name)
+		format = ctx.String(statsFormatFlag.Name)
+		dir    = ctx.String(statsOutFlag.Name)
+	)
+	if step == 0 {
+		step = window
+	}
+	if window <= 0 || step <= 0 || window%step != 0 {
+		return fmt.Errorf("window of %d blocks isn't a multiple of the step of %d blocks", window, step)
+	}
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	s := newAccessStats(window, step, ctx.Int(statsTopFlag.Name))
+	err := processTxFromZip(func(int) error {
+		return nil
+	}, func(tx txFromZip) error {
+		s.processTx(tx)
+		return nil
+	}, prepareFiles(ctx)...)
+	if err != nil {
+		return err
+	}
+	if s.buckets == nil {
+		return errors.New("no transactions to analyze")
+	}
+	// Emit the last window even if it isn't full
+	s.emitWindow()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	r := s.report()
+	if format == "json" {
+		return r.writeJSON(dir)
+	}
+	return r.writeCSV(dir)
+}
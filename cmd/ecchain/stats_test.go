@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// Tests the statistics of a hand-built access sequence, with windows of two
+// steps of two blocks and the top two accounts.
+func TestAccessStats(t *testing.T) {
+	s := newAccessStats(4, 2, 2)
+	for _, a := range []struct {
+		addr   string
+		height int
+	}{
+		{"a", 0}, {"b", 0}, // positions 1 and 2
+		{"a", 1}, // reuse 1 (b), after 1 block
+		{"c", 2},
+		{"b", 3}, // reuse 2 (a, c), after 3 blocks
+		{"a", 5}, // reuse 2 (c, b), after 4 blocks
+		{"c", 6}, // reuse 2 (b, a), after 4 blocks
+	} {
+		s.advance(a.height)
+		s.access(a.addr, a.addr == "c", a.height)
+	}
+	// the last window, covering blocks 4 to 7, ends past the last access
+	s.emitWindow()
+	r := s.report()
+
+	if want := []int{0, 1, 3}; !reflect.DeepEqual(counts(r.ReuseDistance), want) {
+		t.Errorf("reuse distances: have %v, want %v", counts(r.ReuseDistance), want)
+	}
+	if want := []int{0, 1, 1, 2}; !reflect.DeepEqual(counts(r.InterAccessTime), want) {
+		t.Errorf("inter-access times: have %v, want %v", counts(r.InterAccessTime), want)
+	}
+	if want := []int{0, 0, 1, 2}; !reflect.DeepEqual(counts(r.Lifetime), want) {
+		t.Errorf("lifetimes: have %v, want %v", counts(r.Lifetime), want)
+	}
+	if want := (accessSplit{ContractAccesses: 2, EOAAccesses: 5, Contracts: 1, EOAs: 2}); r.Split != want {
+		t.Errorf("split: have %+v, want %+v", r.Split, want)
+	}
+	want := []hotWindow{
+		{Start: 0, End: 3, Accounts: []hotAccount{{"a", 2}, {"b", 2}}},
+		{Start: 2, End: 5, Accounts: []hotAccount{{"a", 1}, {"b", 1}}},
+		{Start: 4, End: 7, Accounts: []hotAccount{{"a", 1}, {"c", 1}}},
+	}
+	if !reflect.DeepEqual(r.Hottest, want) {
+		t.Errorf("hottest accounts: have %+v, want %+v", r.Hottest, want)
+	}
+}
+
+// Tests the reuse distances of the access tree against counting the distinct
+// accounts between two accesses.
+func TestAccessTreeReuse(t *testing.T) {
+	var (
+		rng      = rand.New(rand.NewSource(1))
+		tree     accessTree
+		sequence []int
+		position = make(map[int]int)
+	)
+	for i := 0; i < 1000; i++ {
+		account := rng.Intn(50)
+		if prev, ok := position[account]; ok {
+			distinct := make(map[int]struct{})
+			for _, other := range sequence[prev:] {
+				distinct[other] = struct{}{}
+			}
+			if have := tree.prefix(len(tree)) - tree.prefix(prev); have != len(distinct) {
+				t.Fatalf("access %d: reuse distance %d, want %d", i, have, len(distinct))
+			}
+			tree.unmark(prev)
+		}
+		sequence = append(sequence, account)
+		position[account] = tree.push()
+	}
+}
+
+func counts(buckets []histogramBucket) []int {
+	out := make([]int, len(buckets))
+	for i, b := range buckets {
+		out[i] = b.Count
+	}
+	return out
+}
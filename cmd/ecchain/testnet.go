@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/fdlimit"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/txpool"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/miner"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/urfave/cli/v2"
+	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	testnetChainID   = 63898 // chain id of the genesis template in build/
+	testnetNetworkID = 1114  // network id the build/ scripts run geth with
+
+	testnetStartTimeout = 10 * time.Second // time allowed for the nodes to listen and connect
+)
+
+// testnetCarBalance is the balance each car account is funded with.
+var testnetCarBalance, _ = new(big.Int).SetString("100000000000000000000000000000000000000000000000000000000000000", 16)
+
+// TestnetConfig configures a local EC-Chain testnet.
+type TestnetConfig struct {
+	Nodes    int    // number of nodes, the first one seals
+	Period   uint64 // clique block period in seconds
+	Password string // passphrase of the node keystores
+	HTTPHost string // HTTP-RPC listening interface of every node
+	HTTPPort int    // HTTP-RPC port of the first node, the others follow
+}
+
+// Testnet is a clique network of in-process nodes on localhost, each with a
+// keystore holding its pre-funded car account. It replaces the build/ scripts
+// that set up the network with separate geth processes.
+type Testnet struct {
+	datadir  string
+	accounts []accounts.Account // car account of each node, the first one is the sealer
+	stacks   []*node.Node
+	backends []*eth.Ethereum
+}
+
+// StartTestnet creates the keystores and the genesis, starts the sealer and
+// its peers and connects them. The testnet must be closed by the caller.
+func StartTestnet(config *TestnetConfig) (*Testnet, error) {
+	if config.Nodes < 1 {
+		return nil, fmt.Errorf("invalid testnet size %d", config.Nodes)
+	}
+	// every node has a leveldb database open, raise the allowance on top
+	// of the current one as Raise would otherwise lower a generous limit
+	current, err := fdlimit.Current()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fdlimit.Raise(uint64(current) + uint64(config.Nodes)*256); err != nil {
+		return nil, err
+	}
+	datadir, err := os.MkdirTemp("", "ecchain-testnet")
+	if err != nil {
+		return nil, err
+	}
+	t := &Testnet{datadir: datadir}
+	for i := 0; i < config.Nodes; i++ {
+		ks := keystore.NewKeyStore(t.keyStoreDir(i), keystore.LightScryptN, keystore.LightScryptP)
+		account, err := ks.NewAccount(config.Password)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.accounts = append(t.accounts, account)
+	}
+	genesis := t.genesis(config.Period)
+	for i := 0; i < config.Nodes; i++ {
+		if err := t.startNode(i, config, genesis); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	if err := t.waitPeers(testnetStartTimeout); err != nil {
+		t.Close()
+		return nil, err
+	}
+	if err := t.backends[0].StartMining(1); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Testnet) nodeDir(i int) string {
+	return filepath.Join(t.datadir, "nodes", strconv.Itoa(i))
+}
+
+func (t *Testnet) keyStoreDir(i int) string {
+	return filepath.Join(t.nodeDir(i), "keystore")
+}
+
+// genesis returns the clique genesis of the testnet, with the first car
+// account as the only signer and every car account funded.
+func (t *Testnet) genesis(period uint64) *core.Genesis {
+	genesis := &core.Genesis{
+		Config: &params.ChainConfig{
+			ChainID:             big.NewInt(testnetChainID),
+			HomesteadBlock:      big.NewInt(0),
+			EIP150Block:         big.NewInt(0),
+			EIP155Block:         big.NewInt(0),
+			EIP158Block:         big.NewInt(0),
+			ByzantiumBlock:      big.NewInt(0),
+			ConstantinopleBlock: big.NewInt(0),
+			PetersburgBlock:     big.NewInt(0),
+			IstanbulBlock:       big.NewInt(0),
+			Clique: &params.CliqueConfig{
+				Period: period,
+				Epoch:  30000,
+			},
+		},
+		Timestamp:  uint64(time.Now().Unix()),
+		GasLimit:   0x01ffffffffffff,
+		Difficulty: big.NewInt(1),
+		Alloc:      make(core.GenesisAlloc),
+	}
+	for _, account := range t.accounts {
+		genesis.Alloc[account.Address] = core.GenesisAccount{Balance: testnetCarBalance}
+	}
+	genesis.ExtraData = make([]byte, 32+common.AddressLength+65)
+	copy(genesis.ExtraData[32:], t.accounts[0].Address[:])
+	return genesis
+}
+
+// startNode starts the i-th node of the testnet and connects it to the nodes
+// started before it.
+func (t *Testnet) startNode(i int, config *TestnetConfig, genesis *core.Genesis) error {
+	stack, err := node.New(&node.Config{
+		Name:              clientIdentifier,
+		Version:           params.Version,
+		DataDir:           t.nodeDir(i),
+		HTTPHost:          config.HTTPHost,
+		HTTPPort:          config.HTTPPort + i,
+		HTTPModules:       []string{"admin", "eth", "net", "web3", "miner"},
+		HTTPVirtualHosts:  []string{"localhost"},
+		UseLightweightKDF: true,
+		P2P: p2p.Config{
+			ListenAddr:  "127.0.0.1:0",
+			NoDiscovery: true,
+			MaxPeers:    config.Nodes,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	t.stacks = append(t.stacks, stack)
+
+	ks := keystore.NewKeyStore(stack.KeyStoreDir(), keystore.LightScryptN, keystore.LightScryptP)
+	stack.AccountManager().AddBackend(ks)
+	if i == 0 {
+		if err := ks.Unlock(t.accounts[0], config.Password); err != nil {
+			return err
+		}
+	}
+	backend, err := eth.New(stack, &ethconfig.Config{
+		Genesis:         genesis,
+		NetworkId:       testnetNetworkID,
+		SyncMode:        downloader.FullSync,
+		DatabaseCache:   256,
+		DatabaseHandles: 256,
+		TxPool:          txpool.DefaultConfig,
+		GPO:             ethconfig.Defaults.GPO,
+		Miner: miner.Config{
+			Etherbase: t.accounts[i].Address,
+			GasCeil:   genesis.GasLimit,
+			GasPrice:  big.NewInt(1),
+			Recommit:  time.Second,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	t.backends = append(t.backends, backend)
+	if err := stack.Start(); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(testnetStartTimeout)
+	for stack.Server().NodeInfo().Ports.Listener == 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("node %d not listening after %v", i, testnetStartTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, peer := range t.stacks[:i] {
+		stack.Server().AddPeer(peer.Server().Self())
+	}
+	return nil
+}
+
+// waitPeers waits until every node is connected to all the others.
+func (t *Testnet) waitPeers(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for i, stack := range t.stacks {
+		for stack.Server().PeerCount() < len(t.stacks)-1 {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("node %d connected to %d of %d peers", i, stack.Server().PeerCount(), len(t.stacks)-1)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// Endpoints returns the HTTP-RPC endpoint of every node, the sealer first.
+func (t *Testnet) Endpoints() []string {
+	endpoints := make([]string, len(t.stacks))
+	for i, stack := range t.stacks {
+		endpoints[i] = stack.HTTPEndpoint()
+	}
+	return endpoints
+}
+
+// Accounts returns the car account of every node, the sealer first.
+func (t *Testnet) Accounts() []common.Address {
+	addrs := make([]common.Address, len(t.accounts))
+	for i, account := range t.accounts {
+		addrs[i] = account.Address
+	}
+	return addrs
+}
+
+// Close stops the nodes and removes their data, keystores included.
+func (t *Testnet) Close() error {
+	for _, stack := range t.stacks {
+		stack.Close()
+	}
+	return os.RemoveAll(t.datadir)
+}
+
+var testnetCmd = &cli.Command{
+	Name:   "testnet",
+	Usage:  "Run a local clique testnet of EC-Chain nodes",
+	Action: testnet,
+	Flags: []cli.Flag{
+		testnetNodesFlag,
+		testnetPeriodFlag,
+		testnetPasswordFlag,
+		rpcAddrFlag,
+		rpcPortFlag,
+	},
+	Description: "ecchain testnet --testnet.nodes 4",
+}
+
+func testnet(ctx *cli.Context) error {
+	t, err := StartTestnet(&TestnetConfig{
+		Nodes:    ctx.Int(testnetNodesFlag.Name),
+		Period:   ctx.Uint64(testnetPeriodFlag.Name),
+		Password: ctx.String(testnetPasswordFlag.Name),
+		HTTPHost: ctx.String(rpcAddrFlag.Name),
+		HTTPPort: ctx.Int(rpcPortFlag.Name),
+	})
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	accounts := t.Accounts()
+	for i, endpoint := range t.Endpoints() {
+		fmt.Println("node", i, "account", accounts[i], "rpc", endpoint)
+	}
+	fmt.Println("Testnet running, stop it with an interrupt")
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	<-sigc
+	signal.Stop(sigc)
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that a two-node testnet starts, connects its nodes and that the peer
+// imports the blocks sealed by the first node.
+func TestTestnetTwoNodes(t *testing.T) {
+	net, err := StartTestnet(&TestnetConfig{
+		Nodes:    2,
+		Period:   1,
+		Password: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	if accounts := net.Accounts(); len(accounts) != 2 || accounts[0] == accounts[1] {
+		t.Fatalf("car accounts %v, want two distinct", accounts)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for net.backends[1].BlockChain().CurrentBlock().Number.Uint64() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("peer at block 0, sealer at block %d", net.backends[0].BlockChain().CurrentBlock().Number)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
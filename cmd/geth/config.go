@@ -181,6 +181,10 @@ func makeFullNode(ctx *cli.Context) (*node.Node, ethapi.Backend) {
 	if ctx.IsSet(utils.SyncTargetFlag.Name) && cfg.Eth.SyncMode == downloader.FullSync {
 		utils.RegisterFullSyncTester(stack, eth, ctx.Path(utils.SyncTargetFlag.Name))
 	}
+	// Drive the Engine API of a developer chain configured for the merge
+	if ctx.IsSet(utils.DeveloperFlag.Name) && eth != nil && eth.BlockChain().Config().TerminalTotalDifficulty != nil {
+		utils.RegisterSimulatedBeacon(stack, eth, uint64(ctx.Int(utils.DeveloperPeriodFlag.Name)))
+	}
 	return stack, backend
 }
 
@@ -166,6 +166,10 @@ func pruneState(ctx *cli.Context) error {
 	chaindb := utils.MakeChainDatabase(ctx, stack, false)
 	defer chaindb.Close()
 
+	if rawdb.ReadStateScheme(chaindb) == rawdb.PathScheme {
+		log.Error("Offline pruning is not needed by the path-based state scheme")
+		return errors.New("path-based state scheme")
+	}
 	prunerconfig := pruner.Config{
 		Datadir:   stack.ResolvePath(""),
 		Cachedir:  stack.ResolvePath(config.Eth.TrieCleanCacheJournal),
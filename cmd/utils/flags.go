@@ -74,6 +74,7 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
 	pcsclite "github.com/gballet/go-libpcsclite"
 	gopsutil "github.com/shirou/gopsutil/mem"
 	"github.com/urfave/cli/v2"
@@ -235,6 +236,17 @@ var (
 		Value:    "full",
 		Category: flags.EthCategory,
 	}
+	StateSchemeFlag = &cli.StringFlag{
+		Name:     "state.scheme",
+		Usage:    `Scheme to use for storing the trie nodes ("hash", "path"), defaults to the one of the stored state`,
+		Category: flags.EthCategory,
+	}
+	StateHistoryFlag = &cli.Uint64Flag{
+		Name:     "history.state",
+		Usage:    "Number of recent blocks to keep the state history of for rolling back the path-based state (0 = entire chain)",
+		Value:    ethconfig.Defaults.StateHistory,
+		Category: flags.EthCategory,
+	}
 	SnapshotFlag = &cli.BoolFlag{
 		Name:     "snapshot",
 		Usage:    `Enables snapshot-database mode (default = enable)`,
@@ -247,6 +259,16 @@ var (
 		Value:    ethconfig.Defaults.TxLookupLimit,
 		Category: flags.EthCategory,
 	}
+	ParallelExecutionFlag = &cli.IntFlag{
+		Name:     "parallelexec",
+		Usage:    "Number of transactions of imported blocks executed speculatively in parallel (0 = sequential execution)",
+		Category: flags.EthCategory,
+	}
+	StateAccessesFlag = &cli.BoolFlag{
+		Name:     "stateaccesses",
+		Usage:    "Record the accounts and storage slots accessed by every transaction of imported blocks (served by debug_getStateAccesses)",
+		Category: flags.EthCategory,
+	}
 	LightKDFFlag = &cli.BoolFlag{
 		Name:     "lightkdf",
 		Usage:    "Reduce key-derivation RAM & CPU usage at some expense of KDF strength",
@@ -487,6 +509,11 @@ var (
 		Usage:    "Disable heuristic state prefetch during block import (less CPU and disk IO, more time waiting for data)",
 		Category: flags.PerfCategory,
 	}
+	CacheStorageWorkersFlag = &cli.IntFlag{
+		Name:     "cache.storageworkers",
+		Usage:    "Number of storage tries hashed and committed concurrently during block import (0 = sequential)",
+		Category: flags.PerfCategory,
+	}
 	CachePreimagesFlag = &cli.BoolFlag{
 		Name:     "cache.preimages",
 		Usage:    "Enable recording the SHA3/keccak preimages of trie keys",
@@ -559,6 +586,11 @@ var (
 		Usage:    "Disable remote sealing verification",
 		Category: flags.MinerCategory,
 	}
+	MinerDataAvailabilityFlag = &cli.BoolFlag{
+		Name:     "miner.dataavailability",
+		Usage:    "Commit to the erasure-coded transaction list of sealed blocks in the extra-data vanity, and require it of imported blocks",
+		Category: flags.MinerCategory,
+	}
 	MinerNewPayloadTimeout = &cli.DurationFlag{
 		Name:     "miner.newpayload-timeout",
 		Usage:    "Specify the maximum time allowance for creating a new payload",
@@ -1658,6 +1690,9 @@ func setMiner(ctx *cli.Context, cfg *miner.Config) {
 	if ctx.IsSet(MinerNoVerifyFlag.Name) {
 		cfg.Noverify = ctx.Bool(MinerNoVerifyFlag.Name)
 	}
+	if ctx.IsSet(MinerDataAvailabilityFlag.Name) {
+		cfg.DataAvailability = ctx.Bool(MinerDataAvailabilityFlag.Name)
+	}
 	if ctx.IsSet(MinerNewPayloadTimeout.Name) {
 		cfg.NewPayloadTimeout = ctx.Duration(MinerNewPayloadTimeout.Name)
 	}
@@ -1796,6 +1831,18 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.IsSet(CacheNoPrefetchFlag.Name) {
 		cfg.NoPrefetch = ctx.Bool(CacheNoPrefetchFlag.Name)
 	}
+	if ctx.IsSet(CacheStorageWorkersFlag.Name) {
+		cfg.StorageWorkers = ctx.Int(CacheStorageWorkersFlag.Name)
+	}
+	if ctx.IsSet(StateSchemeFlag.Name) {
+		cfg.StateScheme = parseStateScheme(ctx)
+	}
+	if ctx.IsSet(StateHistoryFlag.Name) {
+		cfg.StateHistory = ctx.Uint64(StateHistoryFlag.Name)
+	}
+	if cfg.StateScheme == rawdb.PathScheme && cfg.NoPruning {
+		Fatalf("--%s=archive is not supported by the path-based state scheme", GCModeFlag.Name)
+	}
 	// Read the value from the flag no matter if it's set or not.
 	cfg.Preimages = ctx.Bool(CachePreimagesFlag.Name)
 	if cfg.NoPruning && !cfg.Preimages {
@@ -1805,6 +1852,12 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.IsSet(TxLookupLimitFlag.Name) {
 		cfg.TxLookupLimit = ctx.Uint64(TxLookupLimitFlag.Name)
 	}
+	if ctx.IsSet(ParallelExecutionFlag.Name) {
+		cfg.ParallelExecution = ctx.Int(ParallelExecutionFlag.Name)
+	}
+	if ctx.IsSet(StateAccessesFlag.Name) {
+		cfg.StateAccesses = ctx.Bool(StateAccessesFlag.Name)
+	}
 	if ctx.IsSet(CacheFlag.Name) || ctx.IsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.Int(CacheFlag.Name) * ctx.Int(CacheTrieFlag.Name) / 100
 	}
@@ -2070,6 +2123,20 @@ func RegisterFullSyncTester(stack *node.Node, eth *eth.Ethereum, path string) {
 	log.Info("Registered full-sync tester", "number", block.NumberU64(), "hash", block.Hash())
 }
 
+// RegisterSimulatedBeacon adds an in-process consensus client driving the
+// Engine API of a developer chain configured for the merge.
+func RegisterSimulatedBeacon(stack *node.Node, eth *eth.Ethereum, period uint64) {
+	sim, err := ethcatalyst.NewSimulatedBeacon(period, eth)
+	if err != nil {
+		Fatalf("Failed to create simulated beacon: %v", err)
+	}
+	if etherbase, err := eth.Etherbase(); err == nil {
+		sim.SetFeeRecipient(etherbase)
+	}
+	ethcatalyst.RegisterSimulatedBeacon(stack, sim)
+	log.Info("Registered simulated beacon", "period", period)
+}
+
 func SetupMetrics(ctx *cli.Context) {
 	if metrics.Enabled {
 		log.Info("Enabling metrics collection")
@@ -2227,6 +2294,36 @@ func MakeGenesis(ctx *cli.Context) *core.Genesis {
 	return genesis
 }
 
+// parseStateScheme returns the state scheme selected by the --state.scheme
+// flag, or an empty string to use the one of the stored state.
+func parseStateScheme(ctx *cli.Context) string {
+	switch scheme := ctx.String(StateSchemeFlag.Name); scheme {
+	case "":
+		return ""
+	case "hash":
+		return rawdb.HashScheme
+	case "path":
+		return rawdb.PathScheme
+	default:
+		Fatalf("--%s must be either 'hash' or 'path', got %q", StateSchemeFlag.Name, scheme)
+		return ""
+	}
+}
+
+// MakeTrieDatabase opens the trie database of the given chain database, with
+// the state scheme selected by the flags or the one of the stored state.
+func MakeTrieDatabase(ctx *cli.Context, disk ethdb.Database, preimages bool) *trie.Database {
+	scheme, err := rawdb.ParseStateScheme(parseStateScheme(ctx), disk)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	config := &trie.Config{Preimages: preimages}
+	if scheme == rawdb.PathScheme {
+		config.PathDB = &trie.PathConfig{StateHistory: ctx.Uint64(StateHistoryFlag.Name)}
+	}
+	return trie.NewDatabaseWithConfig(disk, config)
+}
+
 // MakeChain creates a chain manager from set command line flags.
 func MakeChain(ctx *cli.Context, stack *node.Node, readonly bool) (*core.BlockChain, ethdb.Database) {
 	var (
@@ -2237,11 +2334,15 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readonly bool) (*core.BlockCh
 	if err != nil {
 		Fatalf("%v", err)
 	}
+	qbftConfig, err := core.LoadQBFTConfig(chainDb, gspec)
+	if err != nil {
+		Fatalf("%v", err)
+	}
 	ethashConfig := ethconfig.Defaults.Ethash
 	if ctx.Bool(FakePoWFlag.Name) {
 		ethashConfig.PowMode = ethash.ModeFake
 	}
-	engine := ethconfig.CreateConsensusEngine(stack, &ethashConfig, cliqueConfig, nil, false, chainDb)
+	engine := ethconfig.CreateConsensusEngine(stack, &ethashConfig, cliqueConfig, qbftConfig, nil, false, chainDb)
 	if gcmode := ctx.String(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
 	}
@@ -2253,6 +2354,9 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readonly bool) (*core.BlockCh
 		TrieTimeLimit:       ethconfig.Defaults.TrieTimeout,
 		SnapshotLimit:       ethconfig.Defaults.SnapshotCache,
 		Preimages:           ctx.Bool(CachePreimagesFlag.Name),
+		StateScheme:         parseStateScheme(ctx),
+		StateHistory:        ctx.Uint64(StateHistoryFlag.Name),
+		StorageWorkers:      ctx.Int(CacheStorageWorkersFlag.Name),
 	}
 	if cache.TrieDirtyDisabled && !cache.Preimages {
 		cache.Preimages = true
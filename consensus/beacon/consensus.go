@@ -411,6 +411,15 @@ func (beacon *Beacon) Close() error {
 	return beacon.ethone.Close()
 }
 
+// VerifyState implements consensus.StateVerifier, delegating the pre-merge
+// headers to the eth1 engine if it derives header fields from the state.
+func (beacon *Beacon) VerifyState(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB) error {
+	if verifier, ok := beacon.ethone.(consensus.StateVerifier); ok && !beacon.IsPoSHeader(header) {
+		return verifier.VerifyState(chain, header, state)
+	}
+	return nil
+}
+
 // IsPoSHeader reports the header belongs to the PoS-stage with some special fields.
 // This function is not suitable for a part of APIs like Prepare or CalcDifficulty
 // because the header difficulty is not set yet.
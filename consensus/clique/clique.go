@@ -265,6 +265,10 @@ func (c *Clique) verifyHeader(chain consensus.ChainHeaderReader, header *types.H
 	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
 		return errInvalidCheckpointVote
 	}
+	// Signers governed by contract are not voted on
+	if c.config.SignerContract != nil && (header.Coinbase != (common.Address{}) || !bytes.Equal(header.Nonce[:], nonceDropVote)) {
+		return errVotingDisabled
+	}
 	// Check that the extra-data contains both the vanity and signature
 	if len(header.Extra) < extraVanity {
 		return errMissingVanity
@@ -277,7 +281,7 @@ func (c *Clique) verifyHeader(chain consensus.ChainHeaderReader, header *types.H
 	if !checkpoint && signersBytes != 0 {
 		return errExtraSigners
 	}
-	if checkpoint && signersBytes%common.AddressLength != 0 {
+	if checkpoint && (signersBytes%common.AddressLength != 0 || (c.config.SignerContract != nil && signersBytes == 0)) {
 		return errInvalidCheckpointSigners
 	}
 	// Ensure that the mix digest is zero as we don't have fork protection currently
@@ -353,14 +357,11 @@ func (c *Clique) verifyCascadingFields(chain consensus.ChainHeaderReader, header
 	if err != nil {
 		return err
 	}
-	// If the block is a checkpoint block, verify the signer list
-	if number%c.config.Epoch == 0 {
-		signers := make([]byte, len(snap.Signers)*common.AddressLength)
-		for i, signer := range snap.signers() {
-			copy(signers[i*common.AddressLength:], signer[:])
-		}
+	// If the block is a checkpoint block, verify the signer list. Signers held
+	// by the signer contract are only known from the state, see VerifyState.
+	if number%c.config.Epoch == 0 && c.config.SignerContract == nil {
 		extraSuffix := len(header.Extra) - extraSeal
-		if !bytes.Equal(header.Extra[extraVanity:extraSuffix], signers) {
+		if !bytes.Equal(header.Extra[extraVanity:extraSuffix], signersBytes(snap.signers())) {
 			return errMismatchingCheckpointSigners
 		}
 	}
@@ -397,12 +398,7 @@ func (c *Clique) snapshot(chain consensus.ChainHeaderReader, number uint64, hash
 			checkpoint := chain.GetHeaderByNumber(number)
 			if checkpoint != nil {
 				hash := checkpoint.Hash()
-
-				signers := make([]common.Address, (len(checkpoint.Extra)-extraVanity-extraSeal)/common.AddressLength)
-				for i := 0; i < len(signers); i++ {
-					copy(signers[i][:], checkpoint.Extra[extraVanity+i*common.AddressLength:])
-				}
-				snap = newSnapshot(c.config, c.signatures, number, hash, signers)
+				snap = newSnapshot(c.config, c.signatures, number, hash, checkpointSigners(checkpoint))
 				if err := snap.store(c.db); err != nil {
 					return nil, err
 				}
@@ -511,7 +507,7 @@ func (c *Clique) Prepare(chain consensus.ChainHeaderReader, header *types.Header
 		return err
 	}
 	c.lock.RLock()
-	if number%c.config.Epoch != 0 {
+	if number%c.config.Epoch != 0 && c.config.SignerContract == nil {
 		// Gather all the proposals that make sense voting on
 		addresses := make([]common.Address, 0, len(c.proposals))
 		for address, authorize := range c.proposals {
@@ -544,9 +540,9 @@ func (c *Clique) Prepare(chain consensus.ChainHeaderReader, header *types.Header
 	header.Extra = header.Extra[:extraVanity]
 
 	if number%c.config.Epoch == 0 {
-		for _, signer := range snap.signers() {
-			header.Extra = append(header.Extra, signer[:]...)
-		}
+		// Signers held by the signer contract are replaced by the ones after
+		// the transactions of the block in FinalizeAndAssemble
+		header.Extra = append(header.Extra, signersBytes(snap.signers())...)
 	}
 	header.Extra = append(header.Extra, make([]byte, extraSeal)...)
 
@@ -580,6 +576,18 @@ func (c *Clique) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *
 	// Finalize block
 	c.Finalize(chain, header, state, txs, uncles, nil)
 
+	// Checkpoint the signers held by the signer contract after the block
+	if c.config.SignerContract != nil && header.Number.Uint64()%c.config.Epoch == 0 {
+		signers, err := contractSigners(state, *c.config.SignerContract)
+		if err != nil {
+			return nil, err
+		}
+		extra := make([]byte, extraVanity, extraVanity+len(signers)*common.AddressLength+extraSeal)
+		copy(extra, header.Extra)
+		extra = append(extra, signersBytes(signers)...)
+		header.Extra = append(extra, make([]byte, extraSeal)...)
+	}
+
 	// Assign the final state root to header.
 	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 
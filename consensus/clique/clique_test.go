@@ -17,7 +17,10 @@
 package clique
 
 import (
+	"crypto/ecdsa"
+	"errors"
 	"math/big"
+	"sort"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -123,3 +126,135 @@ func TestSealHash(t *testing.T) {
 		t.Errorf("have %x, want %x", have, want)
 	}
 }
+
+// Tests that signers governed by the signer contract are added through normal
+// transactions taking effect at the next checkpoint, and that checkpoints not
+// matching the contract or votes are rejected.
+func TestSignerContract(t *testing.T) {
+	var (
+		keys     = make([]*ecdsa.PrivateKey, 3)
+		addrs    = make([]common.Address, 3)
+		contract = common.Address{0xcc}
+		base     = crypto.Keccak256Hash(common.Hash{}.Bytes()).Big()
+		signer   = new(types.HomesteadSigner)
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	slot := func(i int64) common.Hash { return common.BigToHash(new(big.Int).Add(base, big.NewInt(i))) }
+
+	config := *params.AllCliqueProtocolChanges
+	config.Clique = &params.CliqueConfig{Period: 0, Epoch: 3, SignerContract: &contract}
+	genspec := &core.Genesis{
+		Config:    &config,
+		ExtraData: make([]byte, extraVanity+extraSeal),
+		Alloc: map[common.Address]core.GenesisAccount{
+			addrs[0]: {Balance: big.NewInt(10000000000000000)},
+			contract: {
+				// SSTORE(CALLDATALOAD(0), CALLDATALOAD(32))
+				Balance: new(big.Int),
+				Code:    common.Hex2Bytes("6020356000355500"),
+				Storage: map[common.Hash]common.Hash{
+					{}:      common.BigToHash(big.NewInt(2)),
+					slot(0): common.BytesToHash(addrs[0][:]),
+					slot(1): common.BytesToHash(addrs[1][:]),
+				},
+			},
+		},
+		BaseFee: big.NewInt(params.InitialBaseFee),
+	}
+	genesisSigners := []common.Address{addrs[0], addrs[1]}
+	sort.Sort(signersAscending(genesisSigners))
+	genspec.ExtraData = append(append(make([]byte, extraVanity), signersBytes(genesisSigners)...), make([]byte, extraSeal)...)
+
+	// Authorize the third signer through the contract in the first block
+	engine := New(config.Clique, rawdb.NewMemoryDatabase())
+	_, blocks, _ := core.GenerateChainWithGenesis(genspec, engine, 6, func(i int, block *core.BlockGen) {
+		block.SetDifficulty(diffNoTurn)
+		if i != 0 {
+			return
+		}
+		for _, write := range [][2]common.Hash{
+			{slot(2), common.BytesToHash(addrs[2][:])},
+			{{}, common.BigToHash(big.NewInt(3))},
+		} {
+			tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(addrs[0]), contract, new(big.Int), 100000, block.BaseFee(), append(write[0][:], write[1][:]...)), signer, keys[0])
+			block.AddTx(tx)
+		}
+	})
+	if have := checkpointSigners(blocks[2].Header()); len(have) != 3 {
+		t.Fatalf("checkpoint signers mismatch: have %d, want 3", len(have))
+	}
+	// seal signs the blocks in turn among the signers of their epoch, the
+	// checkpoint signer list is replaced if given
+	seal := func(blocks []*types.Block, checkpoint []common.Address) []*types.Block {
+		sealed := make([]*types.Block, len(blocks))
+		var (
+			signers = genesisSigners
+			last    common.Address
+		)
+		for i, block := range blocks {
+			header := block.Header()
+			number := header.Number.Uint64()
+			if i > 0 {
+				header.ParentHash = sealed[i-1].Hash()
+			}
+			if number%config.Clique.Epoch != 0 {
+				header.Extra = make([]byte, extraVanity+extraSeal)
+			} else if checkpoint != nil {
+				header.Extra = append(append(make([]byte, extraVanity), signersBytes(checkpoint)...), make([]byte, extraSeal)...)
+			}
+			// Pick the in-turn signer, or the next one if it just signed
+			offset := int(number % uint64(len(signers)))
+			header.Difficulty = diffInTurn
+			if signers[offset] == last {
+				offset, header.Difficulty = (offset+1)%len(signers), diffNoTurn
+			}
+			last = signers[offset]
+			for j, addr := range addrs {
+				if addr == last {
+					sig, _ := crypto.Sign(SealHash(header).Bytes(), keys[j])
+					copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+				}
+			}
+			sealed[i] = block.WithSeal(header)
+			if number%config.Clique.Epoch == 0 {
+				signers = checkpointSigners(header)
+			}
+		}
+		return sealed
+	}
+	newChain := func() *core.BlockChain {
+		chain, _ := core.NewBlockChain(rawdb.NewMemoryDatabase(), nil, genspec, nil, New(config.Clique, rawdb.NewMemoryDatabase()), vm.Config{}, nil, nil)
+		return chain
+	}
+	chain := newChain()
+	defer chain.Stop()
+
+	sealed := seal(blocks, nil)
+	if _, err := chain.InsertChain(sealed); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	signed := make(map[common.Address]bool)
+	for _, block := range sealed[3:] {
+		author, _ := chain.Engine().Author(block.Header())
+		signed[author] = true
+	}
+	if !signed[addrs[2]] {
+		t.Fatal("signer added through the contract did not sign")
+	}
+	// A checkpoint not matching the contract is rejected
+	tampered := newChain()
+	defer tampered.Stop()
+	if _, err := tampered.InsertChain(seal(blocks, genesisSigners)); !errors.Is(err, errMismatchingContractSigners) {
+		t.Fatalf("tampered checkpoint: have %v, want %v", err, errMismatchingContractSigners)
+	}
+	// Votes are rejected
+	header := sealed[3].Header()
+	copy(header.Nonce[:], nonceAuthVote)
+	header.Coinbase = common.Address{0x01}
+	if err := chain.Engine().VerifyHeader(chain, header, true); err != errVotingDisabled {
+		t.Fatalf("vote: have %v, want %v", err, errVotingDisabled)
+	}
+}
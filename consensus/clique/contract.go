@@ -0,0 +1,117 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// maxContractSigners is the maximum number of signers the signer contract may
+// hold, bounding the storage read at every checkpoint.
+const maxContractSigners = 1024
+
+var (
+	// errVotingDisabled is returned if a block casts a vote while the signers
+	// are governed by the signer contract.
+	errVotingDisabled = errors.New("vote cast while signers are governed by contract")
+
+	// errInvalidContractSigners is returned if the signer contract holds no
+	// signers or more than allowed.
+	errInvalidContractSigners = errors.New("invalid signer list in signer contract")
+
+	// errMismatchingContractSigners is returned if a checkpoint block contains a
+	// list of signers different than the one held by the signer contract.
+	errMismatchingContractSigners = errors.New("mismatching signer list on checkpoint block and signer contract")
+)
+
+// contractSigners reads the authorized signers from the storage of the signer
+// contract, in ascending order. The contract keeps them as a dynamic array of
+// addresses at slot 0, i.e. as `address[] signers` declared first in Solidity:
+// slot 0 holds the length and the entries follow from slot keccak256(0).
+// Duplicates and zero addresses are ignored.
+func contractSigners(statedb *state.StateDB, contract common.Address) ([]common.Address, error) {
+	length := statedb.GetState(contract, common.Hash{}).Big()
+	if length.Sign() == 0 || length.Cmp(big.NewInt(maxContractSigners)) > 0 {
+		return nil, errInvalidContractSigners
+	}
+	var (
+		base    = crypto.Keccak256Hash(common.Hash{}.Bytes()).Big()
+		seen    = make(map[common.Address]struct{})
+		signers = make([]common.Address, 0, length.Uint64())
+	)
+	for i := int64(0); i < length.Int64(); i++ {
+		slot := common.BigToHash(new(big.Int).Add(base, big.NewInt(i)))
+		signer := common.BytesToAddress(statedb.GetState(contract, slot).Bytes())
+		if _, ok := seen[signer]; ok || signer == (common.Address{}) {
+			continue
+		}
+		seen[signer] = struct{}{}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, errInvalidContractSigners
+	}
+	sort.Sort(signersAscending(signers))
+	return signers, nil
+}
+
+// checkpointSigners extracts the signer list from the extra-data of a
+// checkpoint header.
+func checkpointSigners(header *types.Header) []common.Address {
+	signers := make([]common.Address, (len(header.Extra)-extraVanity-extraSeal)/common.AddressLength)
+	for i := 0; i < len(signers); i++ {
+		copy(signers[i][:], header.Extra[extraVanity+i*common.AddressLength:])
+	}
+	return signers
+}
+
+// VerifyState implements consensus.StateVerifier. If the signers are governed
+// by the signer contract, the signer list of checkpoint blocks has to match
+// the contract storage after the block. Checkpoints imported without their
+// state, e.g. by header sync, are trusted like the ones of a light client.
+func (c *Clique) VerifyState(chain consensus.ChainHeaderReader, header *types.Header, statedb *state.StateDB) error {
+	if c.config.SignerContract == nil || header.Number.Uint64()%c.config.Epoch != 0 {
+		return nil
+	}
+	signers, err := contractSigners(statedb, *c.config.SignerContract)
+	if err != nil {
+		return err
+	}
+	extra := header.Extra[extraVanity : len(header.Extra)-extraSeal]
+	if !bytes.Equal(extra, signersBytes(signers)) {
+		return errMismatchingContractSigners
+	}
+	return nil
+}
+
+// signersBytes concatenates the signer addresses as in a checkpoint header.
+func signersBytes(signers []common.Address) []byte {
+	blob := make([]byte, 0, len(signers)*common.AddressLength)
+	for _, signer := range signers {
+		blob = append(blob, signer[:]...)
+	}
+	return blob
+}
@@ -294,6 +294,20 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 			}
 			delete(snap.Tally, header.Coinbase)
 		}
+		// Checkpoints of signers governed by contract replace the signer set
+		if s.config.SignerContract != nil && number%s.config.Epoch == 0 {
+			snap.Signers = make(map[common.Address]struct{})
+			for _, signer := range checkpointSigners(header) {
+				snap.Signers[signer] = struct{}{}
+			}
+			// Keep the recent signers of the new limit only
+			limit := uint64(len(snap.Signers)/2 + 1)
+			for seen := range snap.Recents {
+				if seen+limit <= number {
+					delete(snap.Recents, seen)
+				}
+			}
+		}
 		// If we're taking too much time (ecrecover), notify the user once a while
 		if time.Since(logged) > 8*time.Second {
 			log.Info("Reconstructing voting history", "processed", i, "total", len(headers), "elapsed", common.PrettyDuration(time.Since(start)))
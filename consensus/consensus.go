@@ -121,6 +121,13 @@ type Engine interface {
 	Close() error
 }
 
+// StateVerifier is an optional interface of engines deriving header fields
+// from the state, which can only be verified once the block was processed.
+type StateVerifier interface {
+	// VerifyState checks the header against the post-state of its block.
+	VerifyState(chain ChainHeaderReader, header *types.Header, state *state.StateDB) error
+}
+
 // PoW is a consensus engine based on proof-of-work.
 type PoW interface {
 	Engine
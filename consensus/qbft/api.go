@@ -0,0 +1,93 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package qbft
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// API is a user facing RPC API to query the validators of the byzantine fault
+// tolerant proof-of-authority scheme.
+type API struct {
+	chain consensus.ChainHeaderReader
+}
+
+// BlockStatus is the consensus data of a block.
+type BlockStatus struct {
+	Proposer   common.Address   `json:"proposer"`
+	Round      uint64           `json:"round"`
+	Committers []common.Address `json:"committers"`
+}
+
+// header retrieves the requested block header (or current if none requested).
+func (api *API) header(number *rpc.BlockNumber) (*types.Header, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return header, nil
+}
+
+// GetValidators retrieves the list of validators at the specified block.
+func (api *API) GetValidators(number *rpc.BlockNumber) ([]common.Address, error) {
+	header, err := api.header(number)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	return extra.Validators, nil
+}
+
+// GetBlockStatus retrieves the proposer, the round and the validators who
+// committed the specified block.
+func (api *API) GetBlockStatus(number *rpc.BlockNumber) (*BlockStatus, error) {
+	header, err := api.header(number)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	status := &BlockStatus{Round: extra.Round, Committers: []common.Address{}}
+	if header.Number.Sign() == 0 {
+		return status, nil
+	}
+	digest := proposalHash(header, extra)
+	if status.Proposer, err = ecrecover(digest.Bytes(), extra.Seal); err != nil {
+		return nil, err
+	}
+	for _, seal := range extra.CommittedSeals {
+		committer, err := ecrecover(commitPayload(digest), seal)
+		if err != nil {
+			return nil, err
+		}
+		status.Committers = append(status.Committers, committer)
+	}
+	return status, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package qbft
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Codes of the consensus messages.
+const (
+	msgPreprepare  = 0x00 // Proposal of the block of a round
+	msgPrepare     = 0x01 // Vote for the proposal of a round
+	msgCommit      = 0x02 // Commitment to the proposal of a round, once prepared by a quorum
+	msgRoundChange = 0x03 // Request to move to a round, with the block prepared so far
+	msgFinal       = 0x04 // Block committed by a quorum, sealed with the commit seals
+)
+
+var errUnknownMessage = errors.New("unknown message code")
+
+// message is a consensus message of a validator, signed by its sender.
+type message struct {
+	Code          uint64
+	Height        uint64
+	Round         uint64
+	Digest        common.Hash // Proposal hash prepared or committed
+	Block         []byte      // Proposed, prepared or final block, RLP encoded
+	PreparedRound uint64      // Round the block of a round change was prepared in
+	CommitSeal    []byte      // Signature of the validator committing the proposal
+	Justification [][]byte    // Signed messages justifying a round change or a proposal of a later round
+	Signature     []byte      // Signature of the sender over all the above
+
+	sender common.Address // Sender recovered from the signature
+	block  *types.Block   // Decoded block, if any
+}
+
+// signPayload returns the data the sender signs, the message without its
+// signature.
+func (m *message) signPayload() []byte {
+	cpy := *m
+	cpy.Signature = nil
+	blob, err := rlp.EncodeToBytes(&cpy)
+	if err != nil {
+		panic("can't encode: " + err.Error())
+	}
+	return blob
+}
+
+// decodeMessage decodes a consensus message, recovering its sender and its
+// block.
+func decodeMessage(payload []byte) (*message, error) {
+	m := new(message)
+	if err := rlp.DecodeBytes(payload, m); err != nil {
+		return nil, err
+	}
+	if m.Code > msgFinal {
+		return nil, fmt.Errorf("%w: %d", errUnknownMessage, m.Code)
+	}
+	sender, err := ecrecover(m.signPayload(), m.Signature)
+	if err != nil {
+		return nil, err
+	}
+	m.sender = sender
+
+	if len(m.Block) > 0 {
+		block := new(types.Block)
+		if err := rlp.DecodeBytes(m.Block, block); err != nil {
+			return nil, err
+		}
+		if block.NumberU64() != m.Height {
+			return nil, fmt.Errorf("block %d in message of height %d", block.NumberU64(), m.Height)
+		}
+		m.block = block
+	}
+	return m, nil
+}
+
+// encodeBlock RLP encodes a block to embed it in a message.
+func encodeBlock(block *types.Block) []byte {
+	if block == nil {
+		return nil
+	}
+	blob, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		panic("can't encode: " + err.Error())
+	}
+	return blob
+}
+
+// encodeMessage RLP encodes a signed message to send it to the peers.
+func encodeMessage(m *message) ([]byte, error) {
+	return rlp.EncodeToBytes(m)
+}
+
+// encodeJustification RLP encodes the signed messages justifying a message.
+func encodeJustification(msgs []*message) [][]byte {
+	blobs := make([][]byte, 0, len(msgs))
+	for _, m := range msgs {
+		blob, err := encodeMessage(m)
+		if err != nil {
+			panic("can't encode: " + err.Error())
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs
+}
+
+// decodeJustification decodes the signed messages justifying a message,
+// recovering their senders.
+func decodeJustification(blobs [][]byte) ([]*message, error) {
+	msgs := make([]*message, 0, len(blobs))
+	for _, blob := range blobs {
+		m, err := decodeMessage(blob)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package qbft
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+const (
+	// protocolName is the devp2p name of the consensus message protocol.
+	protocolName = "qbft"
+
+	// protocolVersion is the version of the consensus message protocol.
+	protocolVersion = 1
+
+	// consensusMsg is the code of the only message of the protocol, carrying
+	// an encoded consensus message.
+	consensusMsg = 0x00
+
+	// maxMessageSize is the maximum size of a consensus message, which may
+	// carry a block.
+	maxMessageSize = 10 * 1024 * 1024
+
+	// inmemoryMessages is the number of recently relayed messages remembered
+	// to not relay them again.
+	inmemoryMessages = 4096
+
+	// peerQueueSize is the number of messages queued for sending to a peer,
+	// beyond which they are dropped.
+	peerQueueSize = 256
+)
+
+var errMsgTooLarge = errors.New("message too long")
+
+// peer is a remote node exchanging consensus messages.
+type peer struct {
+	id    string
+	queue chan []byte // Messages waiting to be sent
+}
+
+// Protocols returns the devp2p sub-protocol relaying the consensus messages
+// between the nodes.
+func (q *QBFT) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    protocolName,
+		Version: protocolVersion,
+		Length:  1,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return q.runPeer(p.ID().String(), rw)
+		},
+	}}
+}
+
+// runPeer exchanges the consensus messages with a peer until the connection
+// fails.
+func (q *QBFT) runPeer(id string, rw p2p.MsgReadWriter) error {
+	p := &peer{id: id, queue: make(chan []byte, peerQueueSize)}
+
+	q.peersLock.Lock()
+	q.peers[id] = p
+	q.peersLock.Unlock()
+
+	defer func() {
+		q.peersLock.Lock()
+		delete(q.peers, id)
+		q.peersLock.Unlock()
+	}()
+	// Send the queued messages in the background, reading the peer's here
+	var (
+		quit   = make(chan struct{})
+		failed = make(chan error, 1)
+	)
+	defer close(quit)
+	go func() {
+		for {
+			select {
+			case payload := <-p.queue:
+				if err := p2p.Send(rw, consensusMsg, payload); err != nil {
+					failed <- err
+					return
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case err := <-failed:
+			return err
+		default:
+		}
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		if msg.Code != consensusMsg {
+			msg.Discard()
+			return fmt.Errorf("invalid message code %d", msg.Code)
+		}
+		if msg.Size > maxMessageSize {
+			msg.Discard()
+			return fmt.Errorf("%w: %v > %v", errMsgTooLarge, msg.Size, maxMessageSize)
+		}
+		var payload []byte
+		if err := msg.Decode(&payload); err != nil {
+			return err
+		}
+		q.receive(payload, id)
+	}
+}
+
+// receive handles a consensus message received from a peer, relaying it to the
+// other peers and handing it to the consensus state machine. Messages already
+// seen are ignored.
+func (q *QBFT) receive(payload []byte, from string) {
+	hash := crypto.Keccak256Hash(payload)
+	if q.seen.Contains(hash) {
+		return
+	}
+	q.seen.Add(hash, struct{}{})
+
+	m, err := decodeMessage(payload)
+	if err != nil {
+		log.Debug("Dropped invalid consensus message", "peer", from, "err", err)
+		return
+	}
+	q.relay(payload, from)
+
+	q.peersLock.RLock()
+	machine := q.machine
+	q.peersLock.RUnlock()
+
+	if machine != nil {
+		machine.deliver(m)
+	}
+}
+
+// relay queues a consensus message for sending to all the peers but the one
+// it was received from.
+func (q *QBFT) relay(payload []byte, from string) {
+	q.peersLock.RLock()
+	defer q.peersLock.RUnlock()
+
+	for id, p := range q.peers {
+		if id == from {
+			continue
+		}
+		select {
+		case p.queue <- payload:
+		default:
+			log.Debug("Dropped consensus message to slow peer", "peer", id)
+		}
+	}
+}
+
+// broadcast signs a consensus message of the local validator and sends it to
+// all the peers, returning it with its sender set.
+func (q *QBFT) broadcast(m *message) (*message, error) {
+	sig, err := q.sign(m.signPayload())
+	if err != nil {
+		return nil, err
+	}
+	m.Signature, m.sender = sig, q.account()
+
+	payload, err := encodeMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	q.seen.Add(crypto.Keccak256Hash(payload), struct{}{})
+	q.relay(payload, "")
+	return m, nil
+}
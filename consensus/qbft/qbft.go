@@ -0,0 +1,617 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package qbft implements a byzantine fault tolerant proof-of-authority
+// consensus engine with instant finality.
+//
+// The validators agree on every block in rounds of three phases. The proposer
+// of the round sends the block in a PRE-PREPARE message, the validators answer
+// with PREPARE messages and, once a quorum of them prepared the block, with
+// COMMIT messages carrying their commit seal. A block committed by a quorum of
+// validators is final: it is sealed with the commit seals and imported. If no
+// block is committed in time, the validators move to the next round with
+// ROUND-CHANGE messages, carrying the block they prepared, if any, which binds
+// the proposer of the next round. The messages are exchanged over a devp2p
+// sub-protocol.
+package qbft
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	lru "github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	inmemorySignatures = 4096 // Number of recent block proposers to keep in memory
+
+	defaultRequestTimeout = 10000 // Milliseconds before the first round of a height times out
+)
+
+// QBFT protocol constants.
+var (
+	extraVanity = 32 // Fixed number of extra-data prefix bytes reserved for validator vanity
+
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	blockDifficulty = big.NewInt(1) // Difficulty of every block, there is no fork choice
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of validators is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the validator vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errInvalidExtra is returned if the extra-data after the vanity isn't a
+	// valid encoding of the validators and the seals.
+	errInvalidExtra = errors.New("invalid extra-data")
+
+	// errInvalidValidators is returned if a block contains a list of validators
+	// different than the one of its parent.
+	errInvalidValidators = errors.New("invalid validator list")
+
+	// errInvalidNonce is returned if a block's nonce is non-zero.
+	errInvalidNonce = errors.New("non-zero nonce")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errInvalidProposer is returned if a block isn't sealed by the proposer of
+	// its round.
+	errInvalidProposer = errors.New("invalid proposer")
+
+	// errInsufficientCommits is returned if a block doesn't carry the commit
+	// seals of a quorum of validators.
+	errInsufficientCommits = errors.New("insufficient commit seals")
+
+	// errInvalidCommit is returned if a commit seal isn't signed by a validator,
+	// or twice by the same one.
+	errInvalidCommit = errors.New("invalid commit seal")
+
+	// errUnauthorizedValidator is returned if the local signer is asked to seal
+	// a block while not being a validator.
+	errUnauthorizedValidator = errors.New("unauthorized validator")
+
+	// errNotStarted is returned if a block is sealed before the engine was
+	// started.
+	errNotStarted = errors.New("qbft engine not started")
+)
+
+// SignerFn hashes and signs the data to be signed by a backing account.
+type SignerFn func(signer accounts.Account, mimeType string, message []byte) ([]byte, error)
+
+// Extra is the consensus data held in the extra-data of a header, after the
+// vanity prefix.
+type Extra struct {
+	Validators     []common.Address // Validators of the block, the same for the whole chain
+	Round          uint64           // Round the block was committed in
+	Seal           []byte           // Signature of the proposer of the round
+	CommittedSeals [][]byte         // Commit seals of a quorum of validators
+}
+
+// ExtractExtra decodes the consensus data from the extra-data of a header.
+func ExtractExtra(header *types.Header) (*Extra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, errMissingVanity
+	}
+	extra := new(Extra)
+	if err := rlp.DecodeBytes(header.Extra[extraVanity:], extra); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidExtra, err)
+	}
+	return extra, nil
+}
+
+// GenesisExtra returns the extra-data of a genesis block with the given
+// validators, proposing in the given order.
+func GenesisExtra(validators []common.Address) []byte {
+	return encodeExtra(nil, &Extra{Validators: validators})
+}
+
+// encodeExtra appends the encoded consensus data to the vanity prefix of the
+// given extra-data, zero padded if it's shorter.
+func encodeExtra(vanity []byte, extra *Extra) []byte {
+	blob, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		panic("can't encode: " + err.Error())
+	}
+	enc := make([]byte, extraVanity, extraVanity+len(blob))
+	copy(enc, vanity)
+	return append(enc, blob...)
+}
+
+// withExtra returns a copy of the header with its consensus data replaced.
+func withExtra(header *types.Header, extra *Extra) *types.Header {
+	cpy := types.CopyHeader(header)
+	cpy.Extra = encodeExtra(header.Extra, extra)
+	return cpy
+}
+
+// SealHash returns the hash of a block prior to it being sealed, which leaves
+// out the round and all the seals. It identifies the proposed block over its
+// rounds.
+func SealHash(header *types.Header) common.Hash {
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return header.Hash()
+	}
+	return withExtra(header, &Extra{Validators: extra.Validators}).Hash()
+}
+
+// proposalHash returns the hash of a block as proposed in a round, which is
+// signed by the proposer and committed by the validators.
+func proposalHash(header *types.Header, extra *Extra) common.Hash {
+	return withExtra(header, &Extra{Validators: extra.Validators, Round: extra.Round}).Hash()
+}
+
+// commitPayload returns the data a validator signs to commit a proposal.
+func commitPayload(digest common.Hash) []byte {
+	return append(digest.Bytes(), msgCommit)
+}
+
+// ecrecover extracts the Ethereum address that signed the given data.
+func ecrecover(data []byte, signature []byte) (common.Address, error) {
+	pubkey, err := crypto.Ecrecover(crypto.Keccak256(data), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var signer common.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+	return signer, nil
+}
+
+// quorum returns the number of validators needed to commit a block, which any
+// two quorums overlap in at least one honest validator.
+func quorum(validators int) int {
+	return (2*validators + 2) / 3
+}
+
+// faulty returns the number of byzantine validators tolerated.
+func faulty(validators int) int {
+	return (validators - 1) / 3
+}
+
+// proposer returns the validator proposing the block of a height in a round.
+func proposer(validators []common.Address, height uint64, round uint64) common.Address {
+	return validators[(height+round)%uint64(len(validators))]
+}
+
+// isValidator returns whether the address is amongst the validators.
+func isValidator(validators []common.Address, address common.Address) bool {
+	for _, validator := range validators {
+		if validator == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain is the local blockchain the engine imports the committed blocks into.
+type Chain interface {
+	consensus.ChainHeaderReader
+
+	// CurrentBlock retrieves the head of the local chain.
+	CurrentBlock() *types.Header
+
+	// GetBlockByNumber retrieves a canonical block by number.
+	GetBlockByNumber(number uint64) *types.Block
+
+	// InsertChain imports a batch of blocks into the local chain.
+	InsertChain(chain types.Blocks) (int, error)
+
+	// SetFinalized marks a block as final.
+	SetFinalized(header *types.Header)
+}
+
+// QBFT is the byzantine fault tolerant proof-of-authority consensus engine.
+type QBFT struct {
+	config *params.QBFTConfig // Consensus engine configuration parameters
+
+	proposers *lru.Cache[common.Hash, common.Address] // Proposers of recent blocks to speed up verification
+	seen      *lru.Cache[common.Hash, struct{}]       // Recently relayed consensus messages
+
+	signer common.Address // Ethereum address of the signing key
+	signFn SignerFn       // Signer function to authorize hashes with
+	lock   sync.RWMutex   // Protects the signer fields
+
+	machine   *stateMachine    // Consensus state machine, once started
+	peers     map[string]*peer // Peers exchanging consensus messages
+	peersLock sync.RWMutex     // Protects the machine and peers fields
+}
+
+// New creates a QBFT consensus engine. The validators are taken from the
+// extra-data of the genesis block.
+func New(config *params.QBFTConfig) *QBFT {
+	// Set any missing consensus parameters to their defaults
+	conf := *config
+	if conf.RequestTimeout == 0 {
+		conf.RequestTimeout = defaultRequestTimeout
+	}
+	return &QBFT{
+		config:    &conf,
+		proposers: lru.NewCache[common.Hash, common.Address](inmemorySignatures),
+		seen:      lru.NewCache[common.Hash, struct{}](inmemoryMessages),
+		peers:     make(map[string]*peer),
+	}
+}
+
+// Author implements consensus.Engine, returning the proposer of the block,
+// recovered from its seal.
+func (q *QBFT) Author(header *types.Header) (common.Address, error) {
+	hash := header.Hash()
+	if address, known := q.proposers.Get(hash); known {
+		return address, nil
+	}
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	address, err := ecrecover(proposalHash(header, extra).Bytes(), extra.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	q.proposers.Add(hash, address)
+	return address, nil
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (q *QBFT) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	return q.verifyHeader(chain, header, nil, true)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (q *QBFT) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	go func() {
+		for i, header := range headers {
+			err := q.verifyHeader(chain, header, headers[:i], true)
+
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database. The commit seals are only checked if
+// requested, proposals don't carry them yet.
+func (q *QBFT) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header, commits bool) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time > uint64(time.Now().Unix()) {
+		return consensus.ErrFutureBlock
+	}
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return err
+	}
+	// Nonce and mix digest are unused, there are no votes nor fork protection
+	if header.Nonce != (types.BlockNonce{}) {
+		return errInvalidNonce
+	}
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	// Ensure that the block doesn't contain any uncles which are meaningless in PoA
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	if number > 0 && (header.Difficulty == nil || header.Difficulty.Cmp(blockDifficulty) != 0) {
+		return errInvalidDifficulty
+	}
+	// Verify that the gas limit is <= 2^63-1
+	if header.GasLimit > params.MaxGasLimit {
+		return fmt.Errorf("invalid gasLimit: have %v, max %v", header.GasLimit, params.MaxGasLimit)
+	}
+	if chain.Config().IsShanghai(header.Time) {
+		return fmt.Errorf("qbft does not support shanghai fork")
+	}
+	// If all checks passed, validate any special fields for hard forks
+	if err := misc.VerifyForkHashes(chain.Config(), header, false); err != nil {
+		return err
+	}
+	// The genesis block is the always valid dead-end
+	if number == 0 {
+		return nil
+	}
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time+q.config.Period > header.Time {
+		return errInvalidTimestamp
+	}
+	// Verify that the gasUsed is <= gasLimit
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	if !chain.Config().IsLondon(header.Number) {
+		// Verify BaseFee not present before EIP-1559 fork.
+		if header.BaseFee != nil {
+			return fmt.Errorf("invalid baseFee before fork: have %d, want <nil>", header.BaseFee)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+			return err
+		}
+	} else if err := misc.VerifyEip1559Header(chain.Config(), parent, header); err != nil {
+		// Verify the header's EIP-1559 attributes.
+		return err
+	}
+	// The validators never change, they are the ones of the genesis block
+	parentExtra, err := ExtractExtra(parent)
+	if err != nil {
+		return err
+	}
+	if len(extra.Validators) == 0 || !equalValidators(extra.Validators, parentExtra.Validators) {
+		return errInvalidValidators
+	}
+	// Verify the proposer seal and, if requested, the commit seals
+	digest := proposalHash(header, extra)
+	signer, err := ecrecover(digest.Bytes(), extra.Seal)
+	if err != nil {
+		return err
+	}
+	if signer != proposer(extra.Validators, number, extra.Round) {
+		return errInvalidProposer
+	}
+	if !commits {
+		return nil
+	}
+	return verifyCommits(extra.Validators, digest, extra.CommittedSeals)
+}
+
+// verifyCommits checks that the commit seals of a proposal are signed by a
+// quorum of distinct validators.
+func verifyCommits(validators []common.Address, digest common.Hash, seals [][]byte) error {
+	if len(seals) < quorum(len(validators)) {
+		return errInsufficientCommits
+	}
+	committed := make(map[common.Address]struct{}, len(seals))
+	for _, seal := range seals {
+		signer, err := ecrecover(commitPayload(digest), seal)
+		if err != nil {
+			return err
+		}
+		if _, dup := committed[signer]; dup || !isValidator(validators, signer) {
+			return errInvalidCommit
+		}
+		committed[signer] = struct{}{}
+	}
+	return nil
+}
+
+func equalValidators(a, b []common.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (q *QBFT) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (q *QBFT) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	parentExtra, err := ExtractExtra(parent)
+	if err != nil {
+		return err
+	}
+	header.Nonce = types.BlockNonce{}
+	header.MixDigest = common.Hash{}
+	header.Difficulty = new(big.Int).Set(blockDifficulty)
+	header.Extra = encodeExtra(header.Extra, &Extra{Validators: parentExtra.Validators})
+
+	// Ensure the timestamp has the correct delay
+	header.Time = parent.Time + q.config.Period
+	if header.Time < uint64(time.Now().Unix()) {
+		header.Time = uint64(time.Now().Unix())
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine. There is no post-transaction
+// consensus rules in qbft, do nothing here.
+func (q *QBFT) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, withdrawals []*types.Withdrawal) {
+	// No block rewards in PoA, so the state remains as is
+}
+
+// FinalizeAndAssemble implements consensus.Engine, ensuring no uncles are set,
+// nor block rewards given, and returns the final block.
+func (q *QBFT) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt, withdrawals []*types.Withdrawal) (*types.Block, error) {
+	if len(withdrawals) > 0 {
+		return nil, errors.New("qbft does not support withdrawals")
+	}
+	// Assign the final state root to header.
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+
+	// Assemble and return the final block for sealing.
+	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil)), nil
+}
+
+// Authorize injects a private key into the consensus engine to propose and
+// commit blocks with.
+func (q *QBFT) Authorize(signer common.Address, signFn SignerFn) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.signer = signer
+	q.signFn = signFn
+}
+
+// account returns the address of the local signing key.
+func (q *QBFT) account() common.Address {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return q.signer
+}
+
+// sign signs the data with the local signing credentials.
+func (q *QBFT) sign(data []byte) ([]byte, error) {
+	q.lock.RLock()
+	signer, signFn := q.signer, q.signFn
+	q.lock.RUnlock()
+
+	if signFn == nil {
+		return nil, errUnauthorizedValidator
+	}
+	return signFn(accounts.Account{Address: signer}, accounts.MimetypeQBFT, data)
+}
+
+// Start launches the consensus state machine on top of the given chain, which
+// the blocks committed by the validators are imported into.
+func (q *QBFT) Start(chain Chain) {
+	q.peersLock.Lock()
+	defer q.peersLock.Unlock()
+
+	if q.machine != nil {
+		return
+	}
+	q.machine = newStateMachine(q, chain)
+	go q.machine.loop()
+}
+
+// Seal implements consensus.Engine, handing the block to the consensus state
+// machine. It's proposed once the local validator is the proposer of a round,
+// and returned on the results channel once committed by the validators.
+func (q *QBFT) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	extra, err := ExtractExtra(parent)
+	if err != nil {
+		return err
+	}
+	if !isValidator(extra.Validators, q.account()) {
+		return errUnauthorizedValidator
+	}
+	q.peersLock.RLock()
+	machine := q.machine
+	q.peersLock.RUnlock()
+
+	if machine == nil {
+		return errNotStarted
+	}
+	machine.seal(&sealRequest{block: block, results: results, stop: stop})
+	return nil
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
+// that a new block should have, which is always 1.
+func (q *QBFT) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(blockDifficulty)
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (q *QBFT) SealHash(header *types.Header) common.Hash {
+	return SealHash(header)
+}
+
+// Close implements consensus.Engine, terminating the consensus state machine.
+func (q *QBFT) Close() error {
+	q.peersLock.Lock()
+	machine := q.machine
+	q.machine = nil
+	q.peersLock.Unlock()
+
+	if machine != nil {
+		machine.close()
+	}
+	return nil
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC API to query
+// the validators.
+func (q *QBFT) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return []rpc.API{{
+		Namespace: "qbft",
+		Service:   &API{chain: chain},
+	}}
+}
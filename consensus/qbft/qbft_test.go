@@ -0,0 +1,560 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package qbft
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// testValidator is a validator of an in-process network, with its own chain
+// and a minimal miner proposing empty blocks.
+type testValidator struct {
+	address common.Address
+	engine  *QBFT
+	chain   *core.BlockChain
+}
+
+// testNetwork is a network of validators exchanging their consensus messages
+// over in-memory pipes.
+type testNetwork struct {
+	validators []*testValidator
+	pipes      []*p2p.MsgPipeRW
+	quit       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// newTestGenesis creates the keys of n validators and a genesis block of theirs.
+func newTestGenesis(n int) ([]*ecdsa.PrivateKey, []common.Address, *core.Genesis) {
+	keys := make([]*ecdsa.PrivateKey, n)
+	addrs := make([]common.Address, n)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	config := *params.AllCliqueProtocolChanges
+	config.Clique = nil
+	config.QBFT = &params.QBFTConfig{RequestTimeout: 200}
+
+	genesis := &core.Genesis{
+		Config:     &config,
+		ExtraData:  GenesisExtra(addrs),
+		Difficulty: big.NewInt(1),
+		BaseFee:    big.NewInt(params.InitialBaseFee),
+	}
+	return keys, addrs, genesis
+}
+
+// newTestNetwork creates a network of n validators, starting all of them but
+// the offline ones.
+func newTestNetwork(t *testing.T, n int, offline ...int) *testNetwork {
+	t.Helper()
+
+	keys, addrs, genesis := newTestGenesis(n)
+	net := &testNetwork{quit: make(chan struct{})}
+	for i, key := range keys {
+		key := key
+		engine := New(genesis.Config.QBFT)
+		engine.Authorize(addrs[i], func(signer accounts.Account, mimeType string, message []byte) ([]byte, error) {
+			return crypto.Sign(crypto.Keccak256(message), key)
+		})
+		chain, err := core.NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create chain: %v", err)
+		}
+		net.validators = append(net.validators, &testValidator{address: addrs[i], engine: engine, chain: chain})
+	}
+	isOffline := func(i int) bool {
+		for _, j := range offline {
+			if i == j {
+				return true
+			}
+		}
+		return false
+	}
+	for i, v := range net.validators {
+		if isOffline(i) {
+			continue
+		}
+		for j := i + 1; j < n; j++ {
+			if isOffline(j) {
+				continue
+			}
+			a, b := p2p.MsgPipe()
+			net.pipes = append(net.pipes, a, b)
+			go v.engine.runPeer(fmt.Sprint(j), a)
+			go net.validators[j].engine.runPeer(fmt.Sprint(i), b)
+		}
+		v.engine.Start(v.chain)
+
+		net.wg.Add(1)
+		go net.mine(v)
+	}
+	return net
+}
+
+// mine proposes an empty block on top of every new head of the validator and
+// imports the blocks committed for it, like the miner does.
+func (net *testNetwork) mine(v *testValidator) {
+	defer net.wg.Done()
+
+	var (
+		sealed  common.Hash
+		results = make(chan *types.Block, 1)
+		ticker  = time.NewTicker(10 * time.Millisecond)
+	)
+	defer ticker.Stop()
+	for {
+		select {
+		case block := <-results:
+			v.chain.InsertChain(types.Blocks{block})
+		case <-ticker.C:
+			head := v.chain.CurrentBlock()
+			if head.Hash() == sealed {
+				continue
+			}
+			sealed = head.Hash()
+			header := &types.Header{
+				ParentHash: head.Hash(),
+				Number:     new(big.Int).Add(head.Number, common.Big1),
+				GasLimit:   head.GasLimit,
+				BaseFee:    misc.CalcBaseFee(v.chain.Config(), head),
+			}
+			if err := v.engine.Prepare(v.chain, header); err != nil {
+				panic(err)
+			}
+			statedb, err := v.chain.StateAt(head.Root)
+			if err != nil {
+				panic(err)
+			}
+			block, err := v.engine.FinalizeAndAssemble(v.chain, header, statedb, nil, nil, nil, nil)
+			if err != nil {
+				panic(err)
+			}
+			if err := v.engine.Seal(v.chain, block, results, nil); err != nil {
+				panic(err)
+			}
+		case <-net.quit:
+			return
+		}
+	}
+}
+
+func (net *testNetwork) stop() {
+	close(net.quit)
+	net.wg.Wait()
+	for _, pipe := range net.pipes {
+		pipe.Close()
+	}
+	for _, v := range net.validators {
+		v.engine.Close()
+		v.chain.Stop()
+	}
+}
+
+// waitHeight waits until the given validators reached a height.
+func (net *testNetwork) waitHeight(t *testing.T, height uint64, validators ...int) {
+	t.Helper()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for _, i := range validators {
+		for net.validators[i].chain.CurrentBlock().Number.Uint64() < height {
+			if time.Now().After(deadline) {
+				t.Fatalf("validator %d stuck at height %d, want %d", i, net.validators[i].chain.CurrentBlock().Number, height)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// checkChains verifies that the validators imported the same blocks up to a
+// height, each committed by a quorum and proposed by the proposer of its round.
+func (net *testNetwork) checkChains(t *testing.T, height uint64, validators ...int) {
+	t.Helper()
+
+	first := net.validators[validators[0]]
+	for number := uint64(1); number <= height; number++ {
+		header := first.chain.GetHeaderByNumber(number)
+		for _, i := range validators[1:] {
+			if other := net.validators[i].chain.GetHeaderByNumber(number); other.Hash() != header.Hash() {
+				t.Fatalf("block %d: validator %d imported %x, validator %d %x", number, validators[0], header.Hash(), i, other.Hash())
+			}
+		}
+		if err := first.engine.VerifyHeader(first.chain, header, true); err != nil {
+			t.Fatalf("block %d: invalid header: %v", number, err)
+		}
+		extra, err := ExtractExtra(header)
+		if err != nil {
+			t.Fatalf("block %d: invalid extra-data: %v", number, err)
+		}
+		if len(extra.CommittedSeals) < quorum(len(net.validators)) {
+			t.Fatalf("block %d: commit seals mismatch: have %d, want at least %d", number, len(extra.CommittedSeals), quorum(len(net.validators)))
+		}
+		author, err := first.engine.Author(header)
+		if err != nil {
+			t.Fatalf("block %d: failed to recover proposer: %v", number, err)
+		}
+		if want := proposer(extra.Validators, number, extra.Round); author != want {
+			t.Fatalf("block %d: proposer mismatch: have %x, want %x", number, author, want)
+		}
+	}
+}
+
+func TestCommit(t *testing.T) {
+	net := newTestNetwork(t, 4)
+	defer net.stop()
+
+	net.waitHeight(t, 6, 0, 1, 2, 3)
+	net.checkChains(t, 6, 0, 1, 2, 3)
+
+	// Every validator proposed in turn, in the first round
+	for number := uint64(1); number <= 4; number++ {
+		header := net.validators[0].chain.GetHeaderByNumber(number)
+		extra, _ := ExtractExtra(header)
+		if extra.Round != 0 {
+			t.Errorf("block %d: committed in round %d, want 0", number, extra.Round)
+		}
+	}
+	// Imported blocks are final
+	for i, v := range net.validators {
+		if final := v.chain.CurrentFinalBlock(); final == nil || final.Number.Uint64() == 0 {
+			t.Errorf("validator %d: no final block", i)
+		}
+	}
+}
+
+func TestRoundChange(t *testing.T) {
+	// The proposer of the first round of block 1 is offline
+	net := newTestNetwork(t, 4, 1)
+	defer net.stop()
+
+	net.waitHeight(t, 3, 0, 2, 3)
+	net.checkChains(t, 3, 0, 2, 3)
+
+	header := net.validators[0].chain.GetHeaderByNumber(1)
+	extra, _ := ExtractExtra(header)
+	if extra.Round == 0 {
+		t.Fatalf("block 1 committed in the round of the offline proposer")
+	}
+	author, _ := net.validators[0].engine.Author(header)
+	if author == net.validators[1].address {
+		t.Fatalf("block 1 proposed by the offline validator")
+	}
+}
+
+func TestVerifyCommits(t *testing.T) {
+	net := newTestNetwork(t, 4)
+	net.waitHeight(t, 1, 0, 1, 2, 3)
+	net.stop()
+
+	v := net.validators[0]
+	header := v.chain.GetHeaderByNumber(1)
+	extra, _ := ExtractExtra(header)
+
+	// Dropping commit seals below the quorum invalidates the block
+	short := *extra
+	short.CommittedSeals = extra.CommittedSeals[:quorum(4)-1]
+	if err := v.engine.VerifyHeader(v.chain, withExtra(header, &short), true); err != errInsufficientCommits {
+		t.Fatalf("error mismatch: have %v, want %v", err, errInsufficientCommits)
+	}
+	// So does counting a validator twice
+	dup := *extra
+	dup.CommittedSeals = [][]byte{extra.CommittedSeals[0], extra.CommittedSeals[0], extra.CommittedSeals[0]}
+	if err := v.engine.VerifyHeader(v.chain, withExtra(header, &dup), true); err != errInvalidCommit {
+		t.Fatalf("error mismatch: have %v, want %v", err, errInvalidCommit)
+	}
+	// Or a proposer seal of another round
+	other := *extra
+	other.Round++
+	if err := v.engine.VerifyHeader(v.chain, withExtra(header, &other), true); err != errInvalidProposer {
+		t.Fatalf("error mismatch: have %v, want %v", err, errInvalidProposer)
+	}
+}
+
+// testMachine is the state machine of the first validator of a network, driven
+// by hand with messages forged with the keys of the other validators.
+type testMachine struct {
+	*stateMachine
+	keys  []*ecdsa.PrivateKey
+	addrs []common.Address
+}
+
+// newTestMachine creates the state machine of the first of n validators, at
+// the first height.
+func newTestMachine(t *testing.T, n int) *testMachine {
+	t.Helper()
+
+	keys, addrs, genesis := newTestGenesis(n)
+	engine := New(genesis.Config.QBFT)
+	engine.Authorize(addrs[0], func(signer accounts.Account, mimeType string, message []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(message), keys[0])
+	})
+	chain, err := core.NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	t.Cleanup(chain.Stop)
+
+	c := newStateMachine(engine, chain)
+	c.newHeight(chain.CurrentBlock())
+	return &testMachine{stateMachine: c, keys: keys, addrs: addrs}
+}
+
+// forge signs a message of a validator and decodes it as received.
+func (tm *testMachine) forge(t *testing.T, i int, m *message) *message {
+	t.Helper()
+
+	sig, err := crypto.Sign(crypto.Keccak256(m.signPayload()), tm.keys[i])
+	if err != nil {
+		t.Fatalf("failed to sign message: %v", err)
+	}
+	m.Signature = sig
+	blob, err := encodeMessage(m)
+	if err != nil {
+		t.Fatalf("failed to encode message: %v", err)
+	}
+	decoded, err := decodeMessage(blob)
+	if err != nil {
+		t.Fatalf("failed to decode message: %v", err)
+	}
+	return decoded
+}
+
+// newProposal creates an empty block on top of the head, proposed by a validator
+// in a round. Proposals of distinct vanities are distinct blocks.
+func (tm *testMachine) newProposal(t *testing.T, i int, round uint64, vanity byte) *types.Block {
+	t.Helper()
+
+	head := tm.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: head.Hash(),
+		Number:     new(big.Int).Add(head.Number, common.Big1),
+		GasLimit:   head.GasLimit,
+		BaseFee:    misc.CalcBaseFee(tm.chain.Config(), head),
+		Extra:      []byte{vanity},
+	}
+	if err := tm.engine.Prepare(tm.chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	statedb, err := tm.chain.(*core.BlockChain).StateAt(head.Root)
+	if err != nil {
+		t.Fatalf("failed to open state: %v", err)
+	}
+	block, err := tm.engine.FinalizeAndAssemble(tm.chain, header, statedb, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to assemble block: %v", err)
+	}
+	return tm.reseal(t, i, round, block)
+}
+
+// reseal seals a block as proposed by a validator in a round.
+func (tm *testMachine) reseal(t *testing.T, i int, round uint64, block *types.Block) *types.Block {
+	t.Helper()
+
+	header := block.Header()
+	extra, _ := ExtractExtra(header)
+	extra = &Extra{Validators: extra.Validators, Round: round}
+	seal, err := crypto.Sign(crypto.Keccak256(proposalHash(header, extra).Bytes()), tm.keys[i])
+	if err != nil {
+		t.Fatalf("failed to seal proposal: %v", err)
+	}
+	extra.Seal = seal
+	return block.WithSeal(withExtra(header, extra))
+}
+
+// forgePreprepare forges the proposal of a block by a validator, justified by
+// the given round changes.
+func (tm *testMachine) forgePreprepare(t *testing.T, i int, round uint64, block *types.Block, changes ...*message) *message {
+	return tm.forge(t, i, &message{
+		Code:          msgPreprepare,
+		Height:        block.NumberU64(),
+		Round:         round,
+		Block:         encodeBlock(block),
+		Justification: encodeJustification(changes),
+	})
+}
+
+// forgePrepares forges the prepares of validators for a block of a round.
+func (tm *testMachine) forgePrepares(t *testing.T, round uint64, block *types.Block, validators ...int) []*message {
+	extra, _ := ExtractExtra(block.Header())
+	digest := proposalHash(block.Header(), extra)
+
+	var prepares []*message
+	for _, i := range validators {
+		prepares = append(prepares, tm.forge(t, i, &message{Code: msgPrepare, Height: block.NumberU64(), Round: round, Digest: digest}))
+	}
+	return prepares
+}
+
+// forgeRoundChange forges the round change of a validator, with the block it
+// prepared and the prepares proving it, if any.
+func (tm *testMachine) forgeRoundChange(t *testing.T, i int, round uint64, prepared *types.Block, preparedRound uint64, prepares []*message) *message {
+	return tm.forge(t, i, &message{
+		Code:          msgRoundChange,
+		Height:        tm.height,
+		Round:         round,
+		PreparedRound: preparedRound,
+		Block:         encodeBlock(prepared),
+		Justification: encodeJustification(prepares),
+	})
+}
+
+// Tests that round changes claiming a block prepared without the prepares of
+// a quorum are rejected.
+func TestByzantineRoundChange(t *testing.T) {
+	tm := newTestMachine(t, 4)
+	tm.changeRound(1)
+
+	// Validators 2 and 3 are byzantine, and claim to have prepared a block
+	block := tm.newProposal(t, 1, 0, 1)
+	tm.handle(tm.forgeRoundChange(t, 3, 1, block, 0, tm.forgePrepares(t, 0, block, 2, 3)))
+	if tm.roundChanges[tm.addrs[3]] != nil {
+		t.Fatalf("round change accepted with the prepares of a minority")
+	}
+	tm.handle(tm.forgeRoundChange(t, 3, 1, block, 0, tm.forgePrepares(t, 0, block, 2, 3, 3, 3)))
+	if tm.roundChanges[tm.addrs[3]] != nil {
+		t.Fatalf("round change accepted with duplicate prepares")
+	}
+	other := tm.newProposal(t, 1, 0, 2)
+	tm.handle(tm.forgeRoundChange(t, 3, 1, block, 0, tm.forgePrepares(t, 0, other, 1, 2, 3)))
+	if tm.roundChanges[tm.addrs[3]] != nil {
+		t.Fatalf("round change accepted with the prepares of another block")
+	}
+	tm.handle(tm.forgeRoundChange(t, 3, 1, block, 1, tm.forgePrepares(t, 1, block, 1, 2, 3)))
+	if tm.roundChanges[tm.addrs[3]] != nil {
+		t.Fatalf("round change accepted with a block prepared in its own round")
+	}
+	// The block prepared by a quorum is accepted
+	tm.handle(tm.forgeRoundChange(t, 3, 1, block, 0, tm.forgePrepares(t, 0, block, 1, 2, 3)))
+	if tm.roundChanges[tm.addrs[3]] == nil {
+		t.Fatalf("round change rejected with the prepares of a quorum")
+	}
+}
+
+// Tests that the proposals of later rounds are only accepted with the round
+// changes of a quorum, and only for the latest block prepared in them.
+func TestByzantineProposer(t *testing.T) {
+	tm := newTestMachine(t, 4)
+	tm.changeRound(1)
+
+	// Validator 1 prepared a block in the first round, validator 2 is the
+	// byzantine proposer of the second round
+	prepared := tm.newProposal(t, 1, 0, 1)
+	changes := []*message{
+		tm.roundChanges[tm.addrs[0]],
+		tm.forgeRoundChange(t, 1, 1, prepared, 0, tm.forgePrepares(t, 0, prepared, 0, 1, 3)),
+		tm.forgeRoundChange(t, 3, 1, nil, 0, nil),
+	}
+	block := tm.newProposal(t, 2, 1, 2)
+	tm.handle(tm.forgePreprepare(t, 2, 1, block))
+	if tm.proposal != nil {
+		t.Fatalf("proposal accepted without round changes")
+	}
+	tm.handle(tm.forgePreprepare(t, 2, 1, block, changes[0], changes[2]))
+	if tm.proposal != nil {
+		t.Fatalf("proposal accepted with the round changes of a minority")
+	}
+	tm.handle(tm.forgePreprepare(t, 2, 1, block, changes[0], changes[2], changes[2]))
+	if tm.proposal != nil {
+		t.Fatalf("proposal accepted with duplicate round changes")
+	}
+	tm.handle(tm.forgePreprepare(t, 2, 1, block, changes...))
+	if tm.proposal != nil {
+		t.Fatalf("proposal accepted instead of the prepared block")
+	}
+	// Proposing the prepared block again is accepted
+	tm.handle(tm.forgePreprepare(t, 2, 1, tm.reseal(t, 2, 1, prepared), changes...))
+	if tm.proposal == nil {
+		t.Fatalf("justified proposal rejected")
+	}
+	if SealHash(tm.proposal.Header()) != SealHash(prepared.Header()) {
+		t.Fatalf("proposal mismatch: have %x, want %x", SealHash(tm.proposal.Header()), SealHash(prepared.Header()))
+	}
+}
+
+// Tests that a locked validator accepts a conflicting proposal of a later
+// round once it's justified, and that its round change carries the prepares
+// of its lock.
+func TestUnlock(t *testing.T) {
+	tm := newTestMachine(t, 4)
+
+	// Lock a block in the first round
+	locked := tm.newProposal(t, 1, 0, 1)
+	tm.handle(tm.forgePreprepare(t, 1, 0, locked))
+	for _, prepare := range tm.forgePrepares(t, 0, locked, 1, 3) {
+		tm.handle(prepare)
+	}
+	if tm.locked == nil || tm.lockedRound != 0 {
+		t.Fatalf("block not locked")
+	}
+	tm.changeRound(1)
+	if change := tm.roundChanges[tm.addrs[0]]; change == nil || change.block == nil || len(change.Justification) < quorum(4) {
+		t.Fatalf("round change without the locked block and its prepares")
+	}
+	// A conflicting proposal is rejected unless justified
+	block := tm.newProposal(t, 2, 1, 2)
+	tm.handle(tm.forgePreprepare(t, 2, 1, block))
+	if tm.proposal != nil {
+		t.Fatalf("unjustified conflicting proposal accepted")
+	}
+	// The other validators didn't see the prepares, the lock is released
+	tm.handle(tm.forgePreprepare(t, 2, 1, block,
+		tm.forgeRoundChange(t, 1, 1, nil, 0, nil),
+		tm.forgeRoundChange(t, 2, 1, nil, 0, nil),
+		tm.forgeRoundChange(t, 3, 1, nil, 0, nil),
+	))
+	if tm.proposal == nil || SealHash(tm.proposal.Header()) != SealHash(block.Header()) {
+		t.Fatalf("justified conflicting proposal rejected")
+	}
+	for _, prepare := range tm.forgePrepares(t, 1, block, 1, 2) {
+		tm.handle(prepare)
+	}
+	if tm.lockedRound != 1 || SealHash(tm.locked.Header()) != SealHash(block.Header()) {
+		t.Fatalf("lock not moved to the justified proposal")
+	}
+}
+
+// Tests that only the messages of validators for the next few heights are kept
+// for later.
+func TestFutureMessages(t *testing.T) {
+	tm := newTestMachine(t, 4)
+
+	outsider, _ := crypto.GenerateKey()
+	tm.keys = append(tm.keys, outsider)
+
+	tm.handle(tm.forge(t, 1, &message{Code: msgPrepare, Height: tm.height + 1}))
+	tm.handle(tm.forge(t, 4, &message{Code: msgPrepare, Height: tm.height + 1}))
+	tm.handle(tm.forge(t, 1, &message{Code: msgPrepare, Height: tm.height + maxFutureHeights + 1}))
+	if len(tm.future) != 1 {
+		t.Fatalf("future messages mismatch: have %d, want 1", len(tm.future))
+	}
+}
@@ -0,0 +1,706 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package qbft
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	headPollInterval  = 100 * time.Millisecond // Interval to check for a new head and a pending proposal
+	maxRoundBackoff   = 10                     // Maximum doublings of the round timeout
+	maxFutureRounds   = 10                     // Maximum rounds ahead to keep the votes of
+	maxFutureHeights  = 10                     // Maximum heights ahead to keep the messages of
+	maxFutureMessages = 1024                   // Maximum messages of later heights to keep
+	resendInterval    = time.Second            // Minimum interval between resending a final block
+)
+
+// sealRequest is a block of the local miner to propose.
+type sealRequest struct {
+	block   *types.Block
+	results chan<- *types.Block
+	stop    <-chan struct{}
+}
+
+// stateMachine is the consensus state machine of a node. It runs the rounds of the
+// height on top of the head of the local chain, and moves to the next height
+// once the committed block is imported.
+type stateMachine struct {
+	engine *QBFT
+	chain  Chain
+
+	height     uint64           // Number of the block agreed on
+	round      uint64           // Current round of the height
+	parent     *types.Header    // Head of the local chain the block is built on
+	validators []common.Address // Validators of the height
+
+	proposal *types.Block // Proposal accepted in the current round
+	digest   common.Hash  // Proposal hash of the accepted proposal
+	proposed bool         // Whether the local validator proposed in the current round
+	prepared bool         // Whether a quorum prepared the proposal and it was committed
+	final    *types.Block // Block committed at the height, waiting to be imported
+
+	locked         *types.Block // Latest block prepared by a quorum, carried by the round changes
+	lockedRound    uint64       // Round the locked block was prepared in
+	lockedPrepares []*message   // Prepares of the quorum which prepared the locked block
+
+	preprepares  map[uint64]*message                    // Proposals by round
+	prepares     map[uint64]map[common.Address]*message // Prepares by round and sender
+	commits      map[uint64]map[common.Address]*message // Commits by round and sender
+	roundChanges map[common.Address]*message            // Latest round change of each validator
+	future       []*message                             // Messages of later heights
+
+	request    *sealRequest // Latest block of the local miner
+	resent     uint64       // Height of the final block last resent
+	resentTime time.Time    // Time the final block was last resent
+
+	timer  *time.Timer
+	msgCh  chan *message
+	sealCh chan *sealRequest
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+func newStateMachine(engine *QBFT, chain Chain) *stateMachine {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	return &stateMachine{
+		engine: engine,
+		chain:  chain,
+		timer:  timer,
+		msgCh:  make(chan *message, peerQueueSize),
+		sealCh: make(chan *sealRequest),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// loop is the single goroutine running the state machine.
+func (c *stateMachine) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(headPollInterval)
+	defer ticker.Stop()
+
+	c.checkHead()
+	for {
+		select {
+		case m := <-c.msgCh:
+			c.handle(m)
+		case req := <-c.sealCh:
+			c.request = req
+			c.propose()
+		case <-c.timer.C:
+			c.timeout()
+		case <-ticker.C:
+			// A proposal may be waiting for its timestamp
+			c.propose()
+		case <-c.quit:
+			return
+		}
+		c.checkHead()
+	}
+}
+
+// close terminates the state machine.
+func (c *stateMachine) close() {
+	close(c.quit)
+	<-c.done
+}
+
+// deliver hands a consensus message received from a peer to the state machine.
+func (c *stateMachine) deliver(m *message) {
+	select {
+	case c.msgCh <- m:
+	case <-c.quit:
+	}
+}
+
+// seal hands a block of the local miner to the state machine.
+func (c *stateMachine) seal(req *sealRequest) {
+	select {
+	case c.sealCh <- req:
+	case <-c.quit:
+	}
+}
+
+// checkHead moves to the next height once the head of the local chain changed.
+func (c *stateMachine) checkHead() {
+	head := c.chain.CurrentBlock()
+	if c.parent != nil && head.Hash() == c.parent.Hash() {
+		return
+	}
+	c.newHeight(head)
+}
+
+// newHeight starts agreeing on the block on top of the given head.
+func (c *stateMachine) newHeight(head *types.Header) {
+	c.parent, c.height = head, head.Number.Uint64()+1
+
+	extra, err := ExtractExtra(head)
+	if err != nil {
+		log.Error("Invalid consensus data in head", "number", head.Number, "hash", head.Hash(), "err", err)
+		c.validators = nil
+		return
+	}
+	// Blocks are final once imported, there are no forks
+	if head.Number.Sign() > 0 {
+		c.chain.SetFinalized(head)
+	}
+	c.validators = extra.Validators
+	c.final, c.locked, c.lockedRound, c.lockedPrepares = nil, nil, 0, nil
+	c.preprepares = make(map[uint64]*message)
+	c.prepares = make(map[uint64]map[common.Address]*message)
+	c.commits = make(map[uint64]map[common.Address]*message)
+	c.roundChanges = make(map[common.Address]*message)
+
+	if c.request != nil && c.request.block.ParentHash() != head.Hash() {
+		c.request = nil
+	}
+	c.startRound(0)
+
+	future := c.future
+	c.future = nil
+	for _, m := range future {
+		c.handle(m)
+	}
+}
+
+// startRound moves to a round of the current height.
+func (c *stateMachine) startRound(round uint64) {
+	c.round = round
+	c.proposal, c.digest, c.proposed, c.prepared = nil, common.Hash{}, false, false
+
+	timeout := time.Duration(c.engine.config.RequestTimeout) * time.Millisecond
+	if round < maxRoundBackoff {
+		timeout <<= round
+	} else {
+		timeout <<= maxRoundBackoff
+	}
+	if round == 0 {
+		// Leave the proposer the block period on top
+		if wait := time.Until(time.Unix(int64(c.parent.Time+c.engine.config.Period), 0)); wait > 0 {
+			timeout += wait
+		}
+	}
+	c.resetTimer(timeout)
+
+	c.propose()
+	if m := c.preprepares[round]; m != nil && c.proposal == nil {
+		c.handlePreprepare(m)
+	}
+}
+
+func (c *stateMachine) resetTimer(timeout time.Duration) {
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+	c.timer.Reset(timeout)
+}
+
+// timeout moves to the next round if no block was committed in time.
+func (c *stateMachine) timeout() {
+	if c.final != nil {
+		// The committed block was handed to the miner, import it if it didn't
+		if c.chain.CurrentBlock().Hash() == c.parent.Hash() {
+			c.insert(c.final)
+		}
+		return
+	}
+	log.Debug("Consensus round timed out", "number", c.height, "round", c.round)
+	c.changeRound(c.round + 1)
+}
+
+// changeRound moves to a later round, asking the other validators to follow.
+// The locked block is sent along with the prepares of the quorum proving it
+// was prepared.
+func (c *stateMachine) changeRound(round uint64) {
+	c.startRound(round)
+	c.send(&message{
+		Code:          msgRoundChange,
+		Height:        c.height,
+		Round:         round,
+		PreparedRound: c.lockedRound,
+		Block:         encodeBlock(c.locked),
+		Justification: encodeJustification(c.lockedPrepares),
+		block:         c.locked,
+	})
+}
+
+// local returns whether the local signer is a validator of the height.
+func (c *stateMachine) local() (common.Address, bool) {
+	signer := c.engine.account()
+	return signer, c.validators != nil && isValidator(c.validators, signer)
+}
+
+// send signs a message of the local validator, sends it to the peers and
+// handles it as if received.
+func (c *stateMachine) send(m *message) {
+	if _, ok := c.local(); !ok {
+		return
+	}
+	signed, err := c.engine.broadcast(m)
+	if err != nil {
+		log.Warn("Failed to send consensus message", "code", m.Code, "err", err)
+		return
+	}
+	if signed.Code != msgFinal {
+		c.handle(signed)
+	}
+}
+
+// handle processes a consensus message, received or sent.
+func (c *stateMachine) handle(m *message) {
+	switch {
+	case c.validators == nil:
+		return
+	case m.Height < c.height:
+		// Help validators lagging behind with the block they're still agreeing on
+		if m.Code == msgRoundChange {
+			c.resend(c.chain.GetBlockByNumber(m.Height))
+		}
+		return
+	case m.Height > c.height:
+		// Keep the messages of the next few heights for when the head catches
+		// up, only from validators as they never change
+		if m.Height <= c.height+maxFutureHeights && isValidator(c.validators, m.sender) && len(c.future) < maxFutureMessages {
+			c.future = append(c.future, m)
+		}
+		return
+	case !isValidator(c.validators, m.sender):
+		return
+	case c.final != nil:
+		if m.Code == msgRoundChange {
+			c.resend(c.final)
+		}
+		return
+	}
+	switch m.Code {
+	case msgRoundChange:
+		c.handleRoundChange(m)
+	case msgFinal:
+		c.handleFinal(m)
+	default:
+		if m.Round > c.round+maxFutureRounds {
+			return
+		}
+		switch m.Code {
+		case msgPreprepare:
+			c.handlePreprepare(m)
+		case msgPrepare:
+			c.handlePrepare(m)
+		case msgCommit:
+			c.handleCommit(m)
+		}
+	}
+}
+
+// propose sends the block of the local validator if it's the proposer of the
+// round. In later rounds it waits for a quorum of round changes and proposes
+// the latest block prepared in them, if any, justified by the round changes.
+func (c *stateMachine) propose() {
+	if c.validators == nil || c.final != nil || c.proposed || c.proposal != nil {
+		return
+	}
+	if signer, ok := c.local(); !ok || signer != proposer(c.validators, c.height, c.round) {
+		return
+	}
+	block, justification := c.proposalBlock()
+	if block == nil {
+		return
+	}
+	header := block.Header()
+	extra := &Extra{Validators: c.validators, Round: c.round}
+	seal, err := c.engine.sign(proposalHash(header, extra).Bytes())
+	if err != nil {
+		log.Warn("Failed to seal proposal", "number", c.height, "round", c.round, "err", err)
+		return
+	}
+	extra.Seal = seal
+	header.Extra = encodeExtra(header.Extra, extra)
+
+	block = block.WithSeal(header)
+
+	c.proposed = true
+	log.Debug("Proposing block", "number", c.height, "round", c.round, "sealhash", SealHash(header))
+	c.send(&message{
+		Code:          msgPreprepare,
+		Height:        c.height,
+		Round:         c.round,
+		Block:         encodeBlock(block),
+		Justification: encodeJustification(justification),
+		block:         block,
+	})
+}
+
+// proposalBlock returns the block to propose in the current round, if known.
+// In later rounds it's returned with the round changes justifying it.
+func (c *stateMachine) proposalBlock() (*types.Block, []*message) {
+	var changes []*message
+	if c.round > 0 {
+		var latest *message
+		for _, m := range c.roundChanges {
+			if m.Round < c.round || (m.block != nil && m.PreparedRound >= c.round) {
+				continue
+			}
+			changes = append(changes, m)
+			if m.block != nil && (latest == nil || m.PreparedRound > latest.PreparedRound) {
+				latest = m
+			}
+		}
+		if len(changes) < quorum(len(c.validators)) {
+			return nil, nil
+		}
+		if latest != nil {
+			return latest.block, changes
+		}
+	}
+	req := c.request
+	if req == nil || req.block.ParentHash() != c.parent.Hash() || stopped(req.stop) {
+		return nil, nil
+	}
+	if time.Now().Unix() < int64(req.block.Time()) {
+		return nil, nil
+	}
+	return req.block, changes
+}
+
+// verifyProposal checks a block proposed in a round on top of the head.
+func (c *stateMachine) verifyProposal(block *types.Block, round uint64) error {
+	header := block.Header()
+	if header.ParentHash != c.parent.Hash() {
+		return errors.New("proposal not on top of the head")
+	}
+	if err := c.engine.verifyHeader(c.chain, header, []*types.Header{c.parent}, false); err != nil {
+		return err
+	}
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return err
+	}
+	if extra.Round != round {
+		return errors.New("proposal of another round")
+	}
+	if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != header.TxHash {
+		return errors.New("transactions of the proposal don't match its header")
+	}
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// verifyPrepared checks that the block of a round change was prepared by a
+// quorum in the round it claims, from the signed prepares it carries.
+func (c *stateMachine) verifyPrepared(m *message) error {
+	if m.block == nil {
+		if m.PreparedRound != 0 || len(m.Justification) > 0 {
+			return errors.New("prepared round without a block")
+		}
+		return nil
+	}
+	if m.PreparedRound >= m.Round {
+		return errors.New("block prepared in a later round")
+	}
+	if err := c.verifyProposal(m.block, m.PreparedRound); err != nil {
+		return err
+	}
+	extra, _ := ExtractExtra(m.block.Header())
+	digest := proposalHash(m.block.Header(), extra)
+
+	prepares, err := decodeJustification(m.Justification)
+	if err != nil {
+		return err
+	}
+	voters := make(map[common.Address]struct{})
+	for _, prepare := range prepares {
+		if prepare.Code != msgPrepare || prepare.Height != c.height || prepare.Round != m.PreparedRound || prepare.Digest != digest {
+			return errors.New("prepare of another proposal")
+		}
+		if !isValidator(c.validators, prepare.sender) {
+			return errors.New("prepare of a non-validator")
+		}
+		voters[prepare.sender] = struct{}{}
+	}
+	if len(voters) < quorum(len(c.validators)) {
+		return errors.New("block not prepared by a quorum")
+	}
+	return nil
+}
+
+// verifyJustification checks that the proposal of a later round is justified
+// by the round changes of a quorum, and that it's the latest block prepared in
+// them, if any. A byzantine proposer can't replace a block which may have been
+// committed: a quorum committing it also locked it, and any quorum of round
+// changes includes one of them.
+func (c *stateMachine) verifyJustification(m *message) error {
+	changes, err := decodeJustification(m.Justification)
+	if err != nil {
+		return err
+	}
+	var (
+		senders = make(map[common.Address]struct{})
+		latest  *message
+	)
+	for _, change := range changes {
+		if change.Code != msgRoundChange || change.Height != c.height || change.Round < m.Round {
+			return errors.New("round change of another round")
+		}
+		if !isValidator(c.validators, change.sender) {
+			return errors.New("round change of a non-validator")
+		}
+		if err := c.verifyPrepared(change); err != nil {
+			return err
+		}
+		if change.block != nil && change.PreparedRound >= m.Round {
+			return errors.New("block prepared in the round of the proposal")
+		}
+		senders[change.sender] = struct{}{}
+		if change.block != nil && (latest == nil || change.PreparedRound > latest.PreparedRound) {
+			latest = change
+		}
+	}
+	if len(senders) < quorum(len(c.validators)) {
+		return errors.New("proposal not justified by a quorum of round changes")
+	}
+	if latest != nil && SealHash(latest.block.Header()) != SealHash(m.block.Header()) {
+		return errors.New("proposal isn't the latest prepared block")
+	}
+	return nil
+}
+
+// handlePreprepare accepts the proposal of the round, if it's valid and
+// justified, and prepares it. A justified proposal of a later round than the
+// locked block's is accepted even if it conflicts: the lock moves to it once
+// prepared.
+func (c *stateMachine) handlePreprepare(m *message) {
+	if m.Round > c.round {
+		c.preprepares[m.Round] = m
+		return
+	}
+	if m.Round < c.round || c.proposal != nil || m.block == nil {
+		return
+	}
+	if m.sender != proposer(c.validators, c.height, m.Round) {
+		return
+	}
+	if err := c.verifyProposal(m.block, m.Round); err != nil {
+		log.Debug("Rejected invalid proposal", "number", c.height, "round", m.Round, "err", err)
+		return
+	}
+	if m.Round > 0 {
+		if err := c.verifyJustification(m); err != nil {
+			log.Debug("Rejected unjustified proposal", "number", c.height, "round", m.Round, "err", err)
+			return
+		}
+	}
+	if c.locked != nil && SealHash(m.block.Header()) != SealHash(c.locked.Header()) {
+		if m.Round <= c.lockedRound {
+			log.Debug("Rejected proposal conflicting with the locked block", "number", c.height, "round", m.Round)
+			return
+		}
+		log.Debug("Unlocked by a justified proposal", "number", c.height, "round", m.Round, "locked", c.lockedRound)
+	}
+	extra, _ := ExtractExtra(m.block.Header())
+	c.proposal, c.digest = m.block, proposalHash(m.block.Header(), extra)
+
+	c.send(&message{Code: msgPrepare, Height: c.height, Round: c.round, Digest: c.digest})
+	c.checkPrepared()
+	c.checkCommitted()
+}
+
+// handlePrepare records the vote of a validator for a proposal.
+func (c *stateMachine) handlePrepare(m *message) {
+	c.record(c.prepares, m)
+	if m.Round == c.round {
+		c.checkPrepared()
+	}
+}
+
+// checkPrepared commits the proposal once prepared by a quorum, locking it.
+func (c *stateMachine) checkPrepared() {
+	if c.proposal == nil || c.prepared || c.count(c.prepares[c.round]) < quorum(len(c.validators)) {
+		return
+	}
+	var prepares []*message
+	for _, m := range c.prepares[c.round] {
+		if m.Digest == c.digest {
+			prepares = append(prepares, m)
+		}
+	}
+	c.prepared = true
+	c.locked, c.lockedRound, c.lockedPrepares = c.proposal, c.round, prepares
+
+	seal, err := c.engine.sign(commitPayload(c.digest))
+	if err != nil {
+		log.Warn("Failed to seal commit", "number", c.height, "round", c.round, "err", err)
+		return
+	}
+	c.send(&message{Code: msgCommit, Height: c.height, Round: c.round, Digest: c.digest, CommitSeal: seal})
+}
+
+// handleCommit records the commitment of a validator to a proposal.
+func (c *stateMachine) handleCommit(m *message) {
+	if signer, err := ecrecover(commitPayload(m.Digest), m.CommitSeal); err != nil || signer != m.sender {
+		return
+	}
+	c.record(c.commits, m)
+	if m.Round == c.round {
+		c.checkCommitted()
+	}
+}
+
+// checkCommitted seals the proposal with the commit seals once committed by a
+// quorum. Only the proposer does, for all the validators to import the same
+// block: it carries the seals the proposer collected.
+func (c *stateMachine) checkCommitted() {
+	if c.proposal == nil || c.final != nil || c.count(c.commits[c.round]) < quorum(len(c.validators)) {
+		return
+	}
+	if signer, _ := c.local(); signer != proposer(c.validators, c.height, c.round) {
+		return
+	}
+	var seals [][]byte
+	for _, validator := range c.validators {
+		if m := c.commits[c.round][validator]; m != nil && m.Digest == c.digest {
+			seals = append(seals, m.CommitSeal)
+		}
+	}
+	header := c.proposal.Header()
+	extra, _ := ExtractExtra(header)
+	extra.CommittedSeals = seals
+	header.Extra = encodeExtra(header.Extra, extra)
+
+	c.final = c.proposal.WithSeal(header)
+	log.Info("Committed block", "number", c.height, "round", c.round, "hash", c.final.Hash(), "commits", len(seals))
+
+	c.send(&message{Code: msgFinal, Height: c.height, Round: c.round, Block: encodeBlock(c.final)})
+	c.insert(c.final)
+}
+
+// handleRoundChange records the round change of a validator, following the
+// validators in later rounds once enough of them are for one to be honest.
+func (c *stateMachine) handleRoundChange(m *message) {
+	if err := c.verifyPrepared(m); err != nil {
+		log.Debug("Rejected unjustified round change", "number", c.height, "round", m.Round, "sender", m.sender, "err", err)
+		return
+	}
+	if prev := c.roundChanges[m.sender]; prev != nil && prev.Round >= m.Round {
+		return
+	}
+	c.roundChanges[m.sender] = m
+
+	switch {
+	case m.Round == c.round:
+		c.propose()
+	case m.Round > c.round:
+		var (
+			ahead  int
+			target = m.Round
+		)
+		for _, change := range c.roundChanges {
+			if change.Round > c.round {
+				ahead++
+				if change.Round < target {
+					target = change.Round
+				}
+			}
+		}
+		if ahead > faulty(len(c.validators)) {
+			c.changeRound(target)
+		}
+	}
+}
+
+// handleFinal imports the block committed at the height.
+func (c *stateMachine) handleFinal(m *message) {
+	if m.block == nil {
+		return
+	}
+	if err := c.engine.verifyHeader(c.chain, m.block.Header(), []*types.Header{c.parent}, true); err != nil {
+		log.Debug("Rejected invalid final block", "number", c.height, "err", err)
+		return
+	}
+	c.final = m.block
+	c.insert(c.final)
+}
+
+// insert imports a committed block, handing it to the miner if it's the block
+// requested by the local miner.
+func (c *stateMachine) insert(block *types.Block) {
+	if req := c.request; req != nil && !stopped(req.stop) && SealHash(req.block.Header()) == SealHash(block.Header()) {
+		select {
+		case req.results <- block:
+			return
+		default:
+		}
+	}
+	if _, err := c.chain.InsertChain(types.Blocks{block}); err != nil {
+		log.Error("Failed to import committed block", "number", block.Number(), "hash", block.Hash(), "err", err)
+	}
+}
+
+// resend sends a final block again to validators still agreeing on it.
+func (c *stateMachine) resend(block *types.Block) {
+	if block == nil {
+		return
+	}
+	number := block.NumberU64()
+	if c.resent == number && time.Since(c.resentTime) < resendInterval {
+		return
+	}
+	c.resent, c.resentTime = number, time.Now()
+	c.send(&message{Code: msgFinal, Height: number, Block: encodeBlock(block)})
+}
+
+// record stores a vote of a validator for a round.
+func (c *stateMachine) record(votes map[uint64]map[common.Address]*message, m *message) {
+	if votes[m.Round] == nil {
+		votes[m.Round] = make(map[common.Address]*message)
+	}
+	votes[m.Round][m.sender] = m
+}
+
+// count returns the number of votes for the accepted proposal.
+func (c *stateMachine) count(votes map[common.Address]*message) int {
+	var n int
+	for _, m := range votes {
+		if m.Digest == c.digest {
+			n++
+		}
+	}
+	return n
+}
+
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -19,12 +19,14 @@ package core
 import (
 	"crypto/ecdsa"
 	"math/big"
+	"runtime"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -204,6 +206,66 @@ func benchInsertChain(b *testing.B, disk bool, gen func(int, *BlockGen)) {
 	}
 }
 
+func BenchmarkStorageHash_sequential(b *testing.B) {
+	benchStorageTries(b, 1, false)
+}
+func BenchmarkStorageHash_parallel(b *testing.B) {
+	benchStorageTries(b, runtime.NumCPU(), false)
+}
+func BenchmarkStorageCommit_sequential(b *testing.B) {
+	benchStorageTries(b, 1, true)
+}
+func BenchmarkStorageCommit_parallel(b *testing.B) {
+	benchStorageTries(b, runtime.NumCPU(), true)
+}
+
+// benchStorageTries measures the time spent hashing, or hashing and committing,
+// the storage tries of a set of contracts with the given number of workers.
+func benchStorageTries(b *testing.B, workers int, commit bool) {
+	const (
+		accounts = 200
+		slots    = 100
+	)
+	// Create a state with the storage of the contracts populated
+	var (
+		db         = state.NewDatabase(rawdb.NewMemoryDatabase())
+		statedb, _ = state.New(types.EmptyRootHash, db, nil)
+	)
+	for i := 0; i < accounts; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		for j := 0; j < slots; j++ {
+			statedb.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i*slots+j+1))))
+		}
+	}
+	root, err := statedb.Commit(false)
+	if err != nil {
+		b.Fatalf("failed to commit state: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		// Overwrite half of the slots of every contract
+		b.StopTimer()
+		statedb, _ = state.New(root, db, nil)
+		statedb.SetStorageWorkers(workers)
+		for i := 0; i < accounts; i++ {
+			addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+			for j := 0; j < slots; j += 2 {
+				statedb.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(n+1)<<32|int64(j))))
+			}
+		}
+		b.StartTimer()
+
+		if !commit {
+			statedb.IntermediateRoot(false)
+			continue
+		}
+		if _, err := statedb.Commit(false); err != nil {
+			b.Fatalf("failed to commit state: %v", err)
+		}
+	}
+}
+
 func BenchmarkChainRead_header_10k(b *testing.B) {
 	benchReadChain(b, false, 10000)
 }
@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/params"
@@ -34,6 +35,8 @@ type BlockValidator struct {
 	config *params.ChainConfig // Chain configuration options
 	bc     *BlockChain         // Canonical block chain
 	engine consensus.Engine    // Consensus engine used for validating
+
+	dataAvailability bool // Whether blocks commit to their erasure-coded transaction list
 }
 
 // NewBlockValidator returns a new block validator which is safe for re-use
@@ -80,6 +83,21 @@ func (v *BlockValidator) ValidateBody(block *types.Block) error {
 		// Withdrawals are not allowed prior to shanghai fork
 		return fmt.Errorf("withdrawals present in block body")
 	}
+	// The erasure-coded transaction list is committed to in the extra-data
+	// vanity if data availability sampling is enabled.
+	if v.dataAvailability {
+		root, err := das.HeaderRoot(header)
+		if err != nil {
+			return err
+		}
+		matrix, err := das.NewTxMatrix(block.Transactions())
+		if err != nil {
+			return err
+		}
+		if hash := matrix.Root(); hash != root {
+			return fmt.Errorf("data root mismatch (header value %x, calculated %x)", root, hash)
+		}
+	}
 
 	if !v.bc.HasBlockAndState(block.ParentHash(), block.NumberU64()-1) {
 		if !v.bc.HasBlock(block.ParentHash(), block.NumberU64()-1) {
@@ -113,6 +131,10 @@ func (v *BlockValidator) ValidateState(block *types.Block, statedb *state.StateD
 	if root := statedb.IntermediateRoot(v.config.IsEIP158(header.Number)); header.Root != root {
 		return fmt.Errorf("invalid merkle root (remote: %x local: %x) dberr: %w", header.Root, root, statedb.Error())
 	}
+	// Validate the header fields the consensus engine derives from the state
+	if verifier, ok := v.engine.(consensus.StateVerifier); ok {
+		return verifier.VerifyState(v.bc, header, statedb)
+	}
 	return nil
 }
 
@@ -141,3 +163,13 @@ func CalcGasLimit(parentGasLimit, desiredLimit uint64) uint64 {
 	}
 	return limit
 }
+
+// SetDataAvailability sets whether the blocks imported from now on have to commit
+// to their erasure-coded transaction list in the extra-data vanity, as sealed
+// by miners with data availability sampling enabled. It must not be called
+// concurrently with block imports.
+func (bc *BlockChain) SetDataAvailability(enabled bool) {
+	validator := NewBlockValidator(bc.chainConfig, bc, bc.engine)
+	validator.dataAvailability = enabled
+	bc.validator = validator
+}
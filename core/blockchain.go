@@ -136,11 +136,30 @@ type CacheConfig struct {
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
 	SnapshotLimit       int           // Memory allowance (MB) to use for caching snapshot entries in memory
 	Preimages           bool          // Whether to store preimage of trie key to the disk
+	StateScheme         string        // Scheme used to store the trie nodes, hash or path (stored scheme if empty)
+	StateHistory        uint64        // Number of recent blocks to keep the state history of, 0 for all (path scheme only)
+	StorageWorkers      int           // Number of storage tries hashed and committed concurrently, sequential if 1 or less
 
 	SnapshotNoBuild bool // Whether the background generation is allowed
 	SnapshotWait    bool // Wait for snapshot construction on startup. TODO(karalabe): This is a dirty hack for testing, nuke it
 }
 
+// triedbConfig derives the configuration of the trie database.
+func (c *CacheConfig) triedbConfig() *trie.Config {
+	config := &trie.Config{
+		Cache:     c.TrieCleanLimit,
+		Journal:   c.TrieCleanJournal,
+		Preimages: c.Preimages,
+	}
+	if c.StateScheme == rawdb.PathScheme {
+		config.PathDB = &trie.PathConfig{
+			StateHistory: c.StateHistory,
+			DirtyCache:   c.TrieDirtyLimit,
+		}
+	}
+	return config
+}
+
 // defaultCacheConfig are the default caching values if none are specified by the
 // user (also used during testing).
 var defaultCacheConfig = &CacheConfig{
@@ -224,6 +243,7 @@ type BlockChain struct {
 	processor  Processor // Block transaction processor interface
 	forker     *ForkChoice
 	vmConfig   vm.Config
+	accessHook StateAccessHook // Tracer of the state accesses of processed blocks, nil if not tracing
 }
 
 // NewBlockChain returns a fully initialised block chain using information
@@ -233,13 +253,21 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *Genesis
 	if cacheConfig == nil {
 		cacheConfig = defaultCacheConfig
 	}
-
+	// Resolve the state scheme against the one of the stored state
+	scheme, err := rawdb.ParseStateScheme(cacheConfig.StateScheme, db)
+	if err != nil {
+		return nil, err
+	}
+	if scheme != cacheConfig.StateScheme {
+		config := *cacheConfig
+		config.StateScheme = scheme
+		cacheConfig = &config
+	}
+	if scheme == rawdb.PathScheme && cacheConfig.TrieDirtyDisabled {
+		return nil, errors.New("archive mode is not supported by the path-based state scheme")
+	}
 	// Open trie database with provided config
-	triedb := trie.NewDatabaseWithConfig(db, &trie.Config{
-		Cache:     cacheConfig.TrieCleanLimit,
-		Journal:   cacheConfig.TrieCleanJournal,
-		Preimages: cacheConfig.Preimages,
-	})
+	triedb := trie.NewDatabaseWithConfig(db, cacheConfig.triedbConfig())
 	// Setup the genesis block, commit the provided genesis specification
 	// to database if the genesis block is not present yet, or load the
 	// stored one from database.
@@ -279,7 +307,6 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *Genesis
 	bc.prefetcher = newStatePrefetcher(chainConfig, bc, engine)
 	bc.processor = NewStateProcessor(chainConfig, bc, engine)
 
-	var err error
 	bc.hc, err = NewHeaderChain(db, chainConfig, engine, bc.insertStopped)
 	if err != nil {
 		return nil, err
@@ -645,7 +672,7 @@ func (bc *BlockChain) setHeadBeyondRoot(head uint64, time uint64, root common.Ha
 					if root != (common.Hash{}) && !beyondRoot && newHeadBlock.Root() == root {
 						beyondRoot, rootNumber = true, newHeadBlock.NumberU64()
 					}
-					if !bc.HasState(newHeadBlock.Root()) {
+					if !bc.HasState(newHeadBlock.Root()) && !bc.triedb.Recoverable(newHeadBlock.Root()) {
 						log.Trace("Block state missing, rewinding further", "number", newHeadBlock.NumberU64(), "hash", newHeadBlock.Hash())
 						if pivot == nil || newHeadBlock.NumberU64() > *pivot {
 							parent := bc.GetBlock(newHeadBlock.ParentHash(), newHeadBlock.NumberU64()-1)
@@ -661,6 +688,14 @@ func (bc *BlockChain) setHeadBeyondRoot(head uint64, time uint64, root common.Ha
 						}
 					}
 					if beyondRoot || newHeadBlock.NumberU64() == 0 {
+						// Roll the persistent state back if the state is only
+						// available from the history of the path-based scheme
+						if !bc.HasState(newHeadBlock.Root()) && bc.triedb.Recoverable(newHeadBlock.Root()) {
+							if err := bc.triedb.Recover(newHeadBlock.Root()); err != nil {
+								log.Crit("Failed to roll back state", "err", err)
+							}
+							log.Debug("Rolled back state", "number", newHeadBlock.NumberU64(), "hash", newHeadBlock.Hash(), "root", newHeadBlock.Root())
+						}
 						if newHeadBlock.NumberU64() == 0 {
 							// Recommit the genesis state into disk in case the rewinding destination
 							// is genesis block and the relevant state is gone. In the future this
@@ -668,6 +703,12 @@ func (bc *BlockChain) setHeadBeyondRoot(head uint64, time uint64, root common.Ha
 							// if the historical chain pruning is enabled. In that case the logic
 							// needs to be improved here.
 							if !bc.HasState(bc.genesisBlock.Root()) {
+								// The path-based scheme rebuilds the state from scratch
+								if bc.triedb.Scheme() == rawdb.PathScheme {
+									if err := bc.triedb.Reset(types.EmptyRootHash); err != nil {
+										log.Crit("Failed to reset state", "err", err)
+									}
+								}
 								if err := CommitGenesisState(bc.db, bc.triedb, bc.genesisBlock.Hash()); err != nil {
 									log.Crit("Failed to commit genesis state", "err", err)
 								}
@@ -786,6 +827,13 @@ func (bc *BlockChain) SnapSyncCommitHead(hash common.Hash) error {
 		return fmt.Errorf("non existent block [%x..]", hash[:4])
 	}
 	root := block.Root()
+	// The synced state was written straight to disk, make it the persistent
+	// state of the path-based scheme
+	if bc.triedb.Scheme() == rawdb.PathScheme {
+		if err := bc.triedb.Reset(root); err != nil {
+			return err
+		}
+	}
 	if !bc.HasState(root) {
 		return fmt.Errorf("non existent state [%x..]", root[:4])
 	}
@@ -956,7 +1004,15 @@ func (bc *BlockChain) Stop() {
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
 	//  - HEAD-1:   So we don't do large reorgs if our HEAD becomes an uncle
 	//  - HEAD-127: So we have a hard limit on the number of blocks reexecuted
-	if !bc.cacheConfig.TrieDirtyDisabled {
+	//
+	// The path-based scheme only keeps the state of HEAD, the older ones are
+	// recovered from the state history.
+	if bc.triedb.Scheme() == rawdb.PathScheme {
+		log.Info("Writing cached state to disk", "block", bc.CurrentBlock().Number, "hash", bc.CurrentBlock().Hash(), "root", bc.CurrentBlock().Root)
+		if err := bc.triedb.Commit(bc.CurrentBlock().Root, true); err != nil {
+			log.Error("Failed to commit recent state trie", "err", err)
+		}
+	} else if !bc.cacheConfig.TrieDirtyDisabled {
 		triedb := bc.triedb
 
 		for _, offset := range []uint64{0, 1, TriesInMemory - 1} {
@@ -1351,6 +1407,10 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	rawdb.WriteBlock(blockBatch, block)
 	rawdb.WriteReceipts(blockBatch, block.Hash(), block.NumberU64(), receipts)
 	rawdb.WritePreimages(blockBatch, state.Preimages())
+	if tracer := state.AccessTracer(); tracer != nil && bc.accessHook != nil {
+		state.SetAccessTracer(nil)
+		bc.accessHook.BlockProcessed(blockBatch, block, tracer)
+	}
 	if err := blockBatch.Write(); err != nil {
 		log.Crit("Failed to write block into disk", "err", err)
 	}
@@ -1359,6 +1419,16 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	if err != nil {
 		return err
 	}
+	// State executed against a witness has a trie database of its own, flush
+	// the changes of the block from it to the local store right away.
+	if triedb := state.Database().TrieDB(); triedb != bc.triedb {
+		return triedb.Commit(root, false)
+	}
+	// The path-based scheme keeps the recent states in memory and flushes the
+	// older ones by itself
+	if bc.triedb.Scheme() == rawdb.PathScheme {
+		return nil
+	}
 	// If we're running an archive node, always flush
 	if bc.cacheConfig.TrieDirtyDisabled {
 		return bc.triedb.Commit(root, false)
@@ -1728,6 +1798,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, setHead bool)
 		if err != nil {
 			return it.index, err
 		}
+		statedb.SetStorageWorkers(bc.cacheConfig.StorageWorkers)
 
 		// Enable prefetching to pull in trie node paths while processing transactions
 		statedb.StartPrefetcher("chain")
@@ -1751,6 +1822,11 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, setHead bool)
 			}
 		}
 
+		// Trace the state accesses of the block if requested, they are handed
+		// to the hook when the block is written
+		if bc.accessHook != nil {
+			statedb.SetAccessTracer(bc.accessHook.TraceBlock(block))
+		}
 		// Process block using the parent state as reference point
 		pstart := time.Now()
 		receipts, logs, usedGas, err := bc.processor.Process(block, statedb, bc.vmConfig)
@@ -0,0 +1,67 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// StateAccessHook traces the state accesses of the blocks imported into the
+// chain, e.g. to drive hot/cold decisions from the live access pattern.
+type StateAccessHook interface {
+	// TraceBlock returns the tracer of the accesses made while processing the
+	// block, including its finalization.
+	TraceBlock(block *types.Block) state.StateAccessTracer
+
+	// BlockProcessed is called with the tracer of a block once the block was
+	// processed and validated, as it's written. Data stored into db is written
+	// atomically with the block. Blocks failing either are not reported.
+	BlockProcessed(db ethdb.KeyValueWriter, block *types.Block, tracer state.StateAccessTracer)
+}
+
+// SetStateAccessHook sets the hook tracing the state accesses of the blocks
+// imported from now on, nil to stop tracing. Blocks mined locally are not
+// processed by the chain, so they are not traced. It must not be called
+// concurrently with block imports.
+func (bc *BlockChain) SetStateAccessHook(hook StateAccessHook) {
+	bc.accessHook = hook
+}
+
+// stateAccessWriter is a StateAccessHook persisting the distinct accesses of
+// every processed block.
+type stateAccessWriter struct{}
+
+// NewStateAccessWriter creates a hook storing the state accesses of every
+// processed block along with the block, from where they are served by
+// rawdb.ReadStateAccesses. They are kept when the block is moved to the
+// freezer.
+func NewStateAccessWriter() StateAccessHook {
+	return &stateAccessWriter{}
+}
+
+// TraceBlock implements StateAccessHook.
+func (w *stateAccessWriter) TraceBlock(block *types.Block) state.StateAccessTracer {
+	return state.NewAccessRecorder()
+}
+
+// BlockProcessed implements StateAccessHook.
+func (w *stateAccessWriter) BlockProcessed(db ethdb.KeyValueWriter, block *types.Block, tracer state.StateAccessTracer) {
+	rawdb.WriteStateAccesses(db, block.Hash(), block.NumberU64(), tracer.(*state.AccessRecorder).Accesses())
+}
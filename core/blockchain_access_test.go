@@ -0,0 +1,108 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that the state accesses of imported blocks are attributed to their
+// transactions and persisted by the built-in writer.
+func TestStateAccessWriter(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		store    = common.Address{0xcc}
+		coinbase = common.Address{0xcb}
+		// SSTORE(CALLDATALOAD(0), 1)
+		code   = common.Hex2Bytes("600160003555" + "00")
+		signer = types.HomesteadSigner{}
+		gspec  = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				sender: {Balance: big.NewInt(params.Ether)},
+				store:  {Balance: big.NewInt(1), Code: code},
+			},
+		}
+		slot = common.Hash{31: 0x01}
+	)
+	_, chain, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 2, func(i int, b *BlockGen) {
+		b.SetCoinbase(coinbase)
+		if i == 1 {
+			return
+		}
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(sender), common.Address{0x01}, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+		b.AddTx(tx)
+		tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(sender), store, new(big.Int), 100000, b.header.BaseFee, slot[:]), signer, key)
+		b.AddTx(tx)
+	})
+	db := rawdb.NewMemoryDatabase()
+	bc, _ := NewBlockChain(db, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer bc.Stop()
+
+	bc.SetStateAccessHook(NewStateAccessWriter())
+	if n, err := bc.InsertChain(chain); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	accesses := rawdb.ReadStateAccesses(db, chain[0].Hash(), chain[0].NumberU64())
+	seen := make(map[types.StateAccess]bool)
+	for _, access := range accesses {
+		if seen[access] {
+			t.Fatalf("duplicate access %+v", access)
+		}
+		seen[access] = true
+	}
+	for _, want := range []types.StateAccess{
+		{TxIndex: 0, Address: sender, Write: true},
+		{TxIndex: 0, Address: common.Address{0x01}, Write: true},
+		{TxIndex: 1, Address: store},
+		{TxIndex: 1, Address: store, Storage: true, Slot: slot, Write: true},
+		{TxIndex: 2, Address: coinbase, Write: true},
+	} {
+		if !seen[want] {
+			t.Errorf("missing access %+v", want)
+		}
+	}
+	for access := range seen {
+		if access.TxIndex == 0 && access.Storage {
+			t.Errorf("storage access %+v attributed to a transfer", access)
+		}
+	}
+	// Blocks without transactions still record their finalization
+	accesses = rawdb.ReadStateAccesses(db, chain[1].Hash(), chain[1].NumberU64())
+	if len(accesses) == 0 || accesses[0] != (types.StateAccess{Address: coinbase, Write: true}) {
+		t.Fatalf("empty block accesses mismatch: have %+v", accesses)
+	}
+	// Accesses are kept when the block is frozen, removed along with the block
+	rawdb.DeleteBlockWithoutNumber(db, chain[0].Hash(), chain[0].NumberU64())
+	if accesses := rawdb.ReadStateAccesses(db, chain[0].Hash(), chain[0].NumberU64()); len(accesses) == 0 {
+		t.Fatal("accesses of frozen block dropped")
+	}
+	rawdb.DeleteBlock(db, chain[0].Hash(), chain[0].NumberU64())
+	if accesses := rawdb.ReadStateAccesses(db, chain[0].Hash(), chain[0].NumberU64()); accesses != nil {
+		t.Fatalf("accesses of deleted block still stored: %+v", accesses)
+	}
+}
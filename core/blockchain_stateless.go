@@ -0,0 +1,129 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// InsertStatelessChain inserts a contiguous chain of blocks, executing each
+// against its witness and the state held locally instead of its full parent
+// state. The witnesses are supplied by the caller, e.g. taken from the blocks
+// or fetched from the shard holders of the state; the chain doesn't source
+// them itself and no node mode imports through this path yet. The changes of
+// every block are written to the local store, so a stateless verifier only
+// ever holds the state it touched.
+//
+// After insertion is done, the index of the failing block is returned with
+// the error, if any.
+func (bc *BlockChain) InsertStatelessChain(chain types.Blocks, witnesses []*state.Witness) (int, error) {
+	if len(chain) != len(witnesses) {
+		return 0, fmt.Errorf("witness count mismatch: have %d, want %d", len(witnesses), len(chain))
+	}
+	// The snapshot can't follow state the chain doesn't hold
+	if bc.snaps != nil {
+		return 0, errStatelessSnapshot
+	}
+	for i := 1; i < len(chain); i++ {
+		block, prev := chain[i], chain[i-1]
+		if block.NumberU64() != prev.NumberU64()+1 || block.ParentHash() != prev.Hash() {
+			return 0, fmt.Errorf("non contiguous insert: item %d is #%d [%x..], item %d is #%d [%x..] (parent [%x..])", i-1, prev.NumberU64(),
+				prev.Hash().Bytes()[:4], i, block.NumberU64(), block.Hash().Bytes()[:4], block.ParentHash().Bytes()[:4])
+		}
+	}
+	if !bc.chainmu.TryLock() {
+		return 0, errChainStopped
+	}
+	defer bc.chainmu.Unlock()
+
+	for i, block := range chain {
+		if err := bc.insertStatelessBlock(block, witnesses[i], i == len(chain)-1); err != nil {
+			return i, err
+		}
+	}
+	return len(chain), nil
+}
+
+// insertStatelessBlock verifies, executes and writes a single block against
+// its witness. A block reading state missing from both the witness and the
+// local store fails with ErrIncompleteWitness and isn't reported as bad.
+func (bc *BlockChain) insertStatelessBlock(block *types.Block, witness *state.Witness, emitHeadEvent bool) error {
+	if err := bc.engine.VerifyHeader(bc, block.Header(), true); err != nil {
+		return err
+	}
+	if err := bc.validator.ValidateBody(block); err != nil {
+		if errors.Is(err, ErrKnownBlock) {
+			return nil
+		}
+		return err
+	}
+	parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	statedb, err := state.New(parent.Root, state.NewWitnessDatabase(bc.db, witness), nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrIncompleteWitness, err)
+	}
+	receipts, logs, usedGas, err := bc.processor.Process(block, statedb, bc.vmConfig)
+	if err == nil {
+		err = bc.validator.ValidateState(block, statedb, receipts, usedGas)
+	}
+	if dbErr := statedb.Error(); dbErr != nil {
+		return fmt.Errorf("%w: %v", ErrIncompleteWitness, dbErr)
+	}
+	if err != nil {
+		bc.reportBlock(block, receipts, err)
+		return err
+	}
+	_, err = bc.writeBlockAndSetHead(block, receipts, logs, statedb, emitHeadEvent)
+	return err
+}
+
+// BuildWitness executes a block on top of its parent state, which must be
+// available locally, and returns the trie nodes and contract codes it reads
+// for a stateless verifier to execute the block with. The parent state is
+// only read, whether it was flushed to disk or not. Witnesses are made of
+// nodes keyed by hash, so the chain must use the hash-based scheme.
+func (bc *BlockChain) BuildWitness(block *types.Block) (*state.Witness, error) {
+	if bc.triedb.Scheme() != rawdb.HashScheme {
+		return nil, errWitnessScheme
+	}
+	parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	db := state.NewRecordingDatabase(bc.db, bc.triedb)
+	statedb, err := state.New(parent.Root, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	receipts, _, usedGas, err := bc.processor.Process(block, statedb, bc.vmConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.validator.ValidateState(block, statedb, receipts, usedGas); err != nil {
+		return nil, err
+	}
+	return db.Witness(), nil
+}
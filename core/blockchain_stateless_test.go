@@ -0,0 +1,179 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// statelessTestChain generates a chain of transfers and storage writes, next
+// to accounts that are never touched, imports it into a full node and builds
+// the witness of every block.
+func statelessTestChain(t *testing.T) (*Genesis, []*types.Block, []*state.Witness) {
+	t.Helper()
+
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender = crypto.PubkeyToAddress(key.PublicKey)
+		store  = common.Address{0xcc}
+		// SSTORE(CALLDATALOAD(0), 1)
+		code   = common.Hex2Bytes("600160003555" + "00")
+		signer = types.HomesteadSigner{}
+		gspec  = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				sender: {Balance: big.NewInt(params.Ether)},
+				store:  {Balance: big.NewInt(1), Code: code},
+			},
+		}
+	)
+	for i := 0; i < 16; i++ {
+		gspec.Alloc[common.Address{0xd0, byte(i)}] = GenesisAccount{Balance: big.NewInt(1)}
+	}
+	_, chain, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 4, func(i int, b *BlockGen) {
+		var slot common.Hash
+		slot[31] = byte(i + 1)
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(sender), store, new(big.Int), 100000, b.header.BaseFee, slot[:]), signer, key)
+		b.AddTx(tx)
+		tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(sender), common.Address{byte(i + 1)}, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+		b.AddTx(tx)
+	})
+	fulldb := rawdb.NewMemoryDatabase()
+	full, _ := NewBlockChain(fulldb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer full.Stop()
+	if n, err := full.InsertChain(chain); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	witnesses := make([]*state.Witness, len(chain))
+	for i, block := range chain {
+		witness, err := full.BuildWitness(block)
+		if err != nil {
+			t.Fatalf("block %d: failed to build witness: %v", block.NumberU64(), err)
+		}
+		witnesses[i] = witness
+	}
+	// The parent states are only held in memory and read from there
+	if parent := chain[len(chain)-2].Root(); rawdb.HasLegacyTrieNode(fulldb, parent) {
+		t.Fatalf("parent state %x flushed to disk", parent)
+	}
+	return gspec, chain, witnesses
+}
+
+// newStatelessVerifier creates a chain holding only the root node of the
+// genesis state, none of the accounts or codes below it. It has no clean
+// cache, which would still hold the genesis nodes.
+func newStatelessVerifier(t *testing.T, gspec *Genesis) (*BlockChain, ethdb.Database) {
+	t.Helper()
+
+	db := rawdb.NewMemoryDatabase()
+	cacheConfig := &CacheConfig{TrieDirtyLimit: 256, TrieTimeLimit: 5 * time.Minute}
+	chain, err := NewBlockChain(db, cacheConfig, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+	var (
+		root = chain.Genesis().Root()
+		keys [][]byte
+		it   = db.NewIterator(nil, nil)
+	)
+	for it.Next() {
+		isCode, _ := rawdb.IsCodeKey(it.Key())
+		if isCode || (len(it.Key()) == common.HashLength && common.BytesToHash(it.Key()) != root) {
+			keys = append(keys, common.CopyBytes(it.Key()))
+		}
+	}
+	it.Release()
+	for _, key := range keys {
+		db.Delete(key)
+	}
+	return chain, db
+}
+
+func TestStatelessInsert(t *testing.T) {
+	gspec, chain, witnesses := statelessTestChain(t)
+
+	verifier, db := newStatelessVerifier(t, gspec)
+	defer verifier.Stop()
+	if n, err := verifier.InsertStatelessChain(chain, witnesses); err != nil {
+		t.Fatalf("block %d: failed to insert statelessly: %v", n, err)
+	}
+	head := chain[len(chain)-1]
+	if verifier.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("head mismatch: have %d, want %d", verifier.CurrentBlock().Number, head.NumberU64())
+	}
+	// The touched accounts are held locally, the untouched ones aren't
+	statedb, err := verifier.State()
+	if err != nil {
+		t.Fatalf("failed to open head state: %v", err)
+	}
+	if balance := statedb.GetBalance(common.Address{byte(len(chain))}); balance.Cmp(big.NewInt(1)) != 0 {
+		t.Fatalf("recipient balance mismatch: have %v, want 1", balance)
+	}
+	if statedb.Error() != nil {
+		t.Fatalf("touched account not held locally: %v", statedb.Error())
+	}
+	tr, err := state.NewDatabase(db).OpenTrie(head.Root())
+	if err != nil {
+		t.Fatalf("failed to open head trie: %v", err)
+	}
+	it := tr.NodeIterator(nil)
+	for it.Next(true) {
+	}
+	if it.Error() == nil {
+		t.Fatalf("untouched accounts held locally")
+	}
+}
+
+func TestStatelessIncompleteWitness(t *testing.T) {
+	gspec, chain, witnesses := statelessTestChain(t)
+
+	verifier, db := newStatelessVerifier(t, gspec)
+	defer verifier.Stop()
+
+	// Drop the code of the contract from the witness of the first block
+	partial := *witnesses[0]
+	partial.Codes = nil
+	if _, err := verifier.InsertStatelessChain(chain[:1], []*state.Witness{&partial}); !errors.Is(err, ErrIncompleteWitness) {
+		t.Fatalf("insert error mismatch: have %v, want %v", err, ErrIncompleteWitness)
+	}
+	if n, err := verifier.InsertStatelessChain(chain[:1], []*state.Witness{{}}); n != 0 || !errors.Is(err, ErrIncompleteWitness) {
+		t.Fatalf("insert error mismatch: have %d %v, want 0 %v", n, err, ErrIncompleteWitness)
+	}
+	if verifier.CurrentBlock().Number.Uint64() != 0 {
+		t.Fatalf("head moved to %d", verifier.CurrentBlock().Number)
+	}
+	if rawdb.ReadBadBlock(db, chain[0].Hash()) != nil {
+		t.Fatalf("block reported bad for an incomplete witness")
+	}
+	// The full witness still goes through
+	if n, err := verifier.InsertStatelessChain(chain, witnesses); err != nil {
+		t.Fatalf("block %d: failed to insert statelessly: %v", n, err)
+	}
+}
@@ -4340,3 +4340,124 @@ func TestEIP3651(t *testing.T) {
 		t.Fatalf("sender balance incorrect: expected %d, got %d", expected, actual)
 	}
 }
+
+// Tests that a chain storing its state with the path-based scheme rolls the
+// state back on SetHead, and persists the head state when stopped.
+func TestPathSchemeSetHead(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}}}
+		signer = types.LatestSigner(gspec.Config)
+		engine = ethash.NewFaker()
+		db     = rawdb.NewMemoryDatabase()
+		config = &CacheConfig{
+			TrieCleanLimit: 256,
+			TrieDirtyLimit: 256,
+			TrieTimeLimit:  5 * time.Minute,
+			StateScheme:    rawdb.PathScheme,
+		}
+	)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 2*TriesInMemory, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{byte(i)}, big.NewInt(1000), params.TxGas, b.header.BaseFee, nil), signer, key)
+		b.AddTx(tx)
+	})
+	chain, err := NewBlockChain(db, config, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	// The states of the old blocks are only available from the history
+	old := blocks[TriesInMemory/2]
+	if chain.HasState(old.Root()) {
+		t.Fatalf("state of block %d still in memory", old.NumberU64())
+	}
+	if err := chain.SetHead(old.NumberU64()); err != nil {
+		t.Fatalf("failed to set head: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != old.Hash() {
+		t.Fatalf("head mismatch: have %d, want %d", head.Number, old.NumberU64())
+	}
+	if !chain.HasState(old.Root()) {
+		t.Fatalf("state of block %d not recovered", old.NumberU64())
+	}
+	if n, err := chain.InsertChain(blocks[old.NumberU64():]); err != nil {
+		t.Fatalf("block %d: failed to reinsert into chain: %v", n, err)
+	}
+	chain.Stop()
+
+	// The stored scheme is picked up, and the head state is available
+	if _, err := NewBlockChain(db, &CacheConfig{StateScheme: rawdb.HashScheme}, gspec, nil, engine, vm.Config{}, nil, nil); err == nil {
+		t.Fatalf("chain created with mismatching state scheme")
+	}
+	chain, err = NewBlockChain(db, nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to recreate chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if scheme := chain.TrieDB().Scheme(); scheme != rawdb.PathScheme {
+		t.Fatalf("state scheme mismatch: have %s, want %s", scheme, rawdb.PathScheme)
+	}
+	head := blocks[len(blocks)-1]
+	if current := chain.CurrentBlock(); current.Hash() != head.Hash() {
+		t.Fatalf("head mismatch after restart: have %d, want %d", current.Number, head.NumberU64())
+	}
+	if !chain.HasState(head.Root()) {
+		t.Fatalf("head state missing after restart")
+	}
+}
+
+// Tests that in the path-based scheme the storage trie nodes of a destructed
+// contract are wiped from the database.
+func TestPathSchemeDestructWipesStorage(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		aa     = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+		gspec  = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				addr: {Balance: big.NewInt(params.Ether)},
+				aa: {
+					Code:    []byte{byte(vm.PC), byte(vm.SELFDESTRUCT)},
+					Balance: big.NewInt(0),
+					Storage: map[common.Hash]common.Hash{
+						{0x01}: {0x01},
+						{0x02}: {0x02},
+						{0x03}: {0x03},
+					},
+				},
+			},
+		}
+		signer = types.LatestSigner(gspec.Config)
+		engine = ethash.NewFaker()
+		db     = rawdb.NewMemoryDatabase()
+		owner  = crypto.Keccak256Hash(aa.Bytes())
+	)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), aa, big.NewInt(0), 50000, b.header.BaseFee, nil), signer, key)
+		b.AddTx(tx)
+	})
+	chain, err := NewBlockChain(db, &CacheConfig{StateScheme: rawdb.PathScheme}, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if blob, _ := rawdb.ReadStorageTrieNode(db, owner, nil); len(blob) == 0 {
+		t.Fatalf("storage root of the genesis contract missing")
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	chain.Stop()
+
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		if ok, account, path := rawdb.IsStorageTrieNode(it.Key()); ok && account == owner {
+			t.Fatalf("storage trie node %x of the destructed contract left", path)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package das implements data availability sampling of block bodies.
+//
+// The transaction list of a block is split into chunks laid out as a k*k data
+// square, which is extended into a 2k*2k square by Reed-Solomon encoding every
+// row and then every column. Any k chunks of a row or column recover it, so a
+// block producer has to withhold at least (k+1)^2 chunks of the extended
+// square to make the block unrecoverable. Every chunk is committed to by the
+// Merkle roots of its row and column, and the roots are committed to in the
+// header, so that light clients sampling a few random chunks with their proofs
+// detect withholding with high confidence without downloading the block.
+package das
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	ChunkSize = 256 // Size of a chunk of the data square in bytes
+	MaxWidth  = 128 // Maximum width of the data square, limiting the block data to 4MB
+
+	MaxDataSize = MaxWidth * MaxWidth * ChunkSize // Maximum size of the block data fitting in the data square
+)
+
+var (
+	// ErrWithheld is returned when proving a chunk that is not available.
+	ErrWithheld = errors.New("chunk withheld")
+
+	errTooLarge        = errors.New("block data too large")
+	errInvalidWidth    = errors.New("invalid data square width")
+	errInvalidChunk    = errors.New("invalid chunk size")
+	errInvalidProof    = errors.New("invalid proof length")
+	errWrongPosition   = errors.New("sample of the wrong position")
+	errRootMismatch    = errors.New("sample not committed to by the data root")
+	errMissingDataRoot = errors.New("header has no data root")
+)
+
+// Matrix is the extended data square of a block.
+type Matrix struct {
+	width  int             // width of the data square, the extended square is twice as wide
+	chunks [][][]byte      // chunks of the extended square by row, nil if withheld
+	leaves [][]common.Hash // hashes of the chunks by row
+	roots  [][]common.Hash // levels of the Merkle tree over the row and column roots
+}
+
+// NewMatrix lays out the data as the smallest square fitting it and extends it.
+func NewMatrix(data []byte) (*Matrix, error) {
+	n := (len(data) + ChunkSize - 1) / ChunkSize
+	width := 1
+	for width*width < n {
+		width <<= 1
+	}
+	if width > MaxWidth {
+		return nil, errTooLarge
+	}
+	m := &Matrix{
+		width:  width,
+		chunks: make([][][]byte, 2*width),
+		leaves: make([][]common.Hash, 2*width),
+	}
+	for r := range m.chunks {
+		m.chunks[r] = make([][]byte, 2*width)
+	}
+	for i := 0; i < width*width; i++ {
+		chunk := make([]byte, ChunkSize)
+		if start := i * ChunkSize; start < len(data) {
+			copy(chunk, data[start:])
+		}
+		m.chunks[i/width][i%width] = chunk
+	}
+	enc := newEncoder(width)
+	for r := 0; r < width; r++ {
+		copy(m.chunks[r][width:], enc.extend(m.chunks[r][:width]))
+	}
+	column := make([][]byte, width)
+	for c := 0; c < 2*width; c++ {
+		for r := 0; r < width; r++ {
+			column[r] = m.chunks[r][c]
+		}
+		for p, chunk := range enc.extend(column) {
+			m.chunks[width+p][c] = chunk
+		}
+	}
+	// Commit to the rows and columns of the extended square
+	var (
+		rowRoots = make([]common.Hash, 2*width)
+		colRoots = make([]common.Hash, 2*width)
+	)
+	for r, row := range m.chunks {
+		m.leaves[r] = make([]common.Hash, 2*width)
+		for c, chunk := range row {
+			m.leaves[r][c] = crypto.Keccak256Hash(chunk)
+		}
+		rowRoots[r] = merkleRoot(m.leaves[r])
+	}
+	leaves := make([]common.Hash, 2*width)
+	for c := range colRoots {
+		for r := range leaves {
+			leaves[r] = m.leaves[r][c]
+		}
+		colRoots[c] = merkleRoot(leaves)
+	}
+	m.roots = merkleLevels(append(rowRoots, colRoots...))
+	return m, nil
+}
+
+// NewTxMatrix extends the RLP encoding of a transaction list.
+func NewTxMatrix(txs types.Transactions) (*Matrix, error) {
+	data, err := rlp.EncodeToBytes(txs)
+	if err != nil {
+		return nil, err
+	}
+	return NewMatrix(data)
+}
+
+// Width returns the width of the data square.
+func (m *Matrix) Width() int {
+	return m.width
+}
+
+// Root returns the data root committing to the extended square.
+func (m *Matrix) Root() common.Hash {
+	return dataRoot(uint16(m.width), m.roots[len(m.roots)-1][0])
+}
+
+// Withhold drops a chunk of the extended square, so it can't be proven anymore.
+func (m *Matrix) Withhold(row, col int) {
+	m.chunks[row][col] = nil
+}
+
+// Sample proves the chunk at the given position. The position is reduced
+// modulo the width of the extended square, so positions drawn uniformly at
+// random by a client not knowing the width sample uniformly.
+func (m *Matrix) Sample(row, col uint16) (*Sample, error) {
+	r, c := int(row)%(2*m.width), int(col)%(2*m.width)
+	if m.chunks[r][c] == nil {
+		return nil, ErrWithheld
+	}
+	return &Sample{
+		Width:     uint16(m.width),
+		Row:       uint16(r),
+		Col:       uint16(c),
+		Chunk:     common.CopyBytes(m.chunks[r][c]),
+		RowProof:  merkleProof(merkleLevels(m.leaves[r]), c),
+		RootsRoot: m.roots[len(m.roots)-1][0],
+		RootProof: merkleProof(m.roots, r),
+	}, nil
+}
+
+// Sample is a chunk of the extended square of a block together with the
+// proof that the data root of the block commits to it.
+type Sample struct {
+	Width     uint16        // Width of the data square
+	Row, Col  uint16        // Position of the chunk in the extended square
+	Chunk     []byte        // Content of the chunk
+	RowProof  []common.Hash // Proof of the chunk against the root of its row
+	RootsRoot common.Hash   // Root of the tree over the row and column roots
+	RootProof []common.Hash // Proof of the row root against the roots root
+}
+
+// Verify checks that the sample is the chunk at the requested position of the
+// extended square committed to by the data root.
+func (s *Sample) Verify(root common.Hash, row, col uint16) error {
+	width := int(s.Width)
+	if width == 0 || width > MaxWidth || width&(width-1) != 0 {
+		return errInvalidWidth
+	}
+	if int(s.Row) != int(row)%(2*width) || int(s.Col) != int(col)%(2*width) {
+		return errWrongPosition
+	}
+	if len(s.Chunk) != ChunkSize {
+		return errInvalidChunk
+	}
+	// The extended square has 2*width rows and columns and as many of each root
+	depth := bits.Len(uint(2*width)) - 1
+	if len(s.RowProof) != depth || len(s.RootProof) != depth+1 {
+		return errInvalidProof
+	}
+	rowRoot := merkleVerify(crypto.Keccak256Hash(s.Chunk), int(s.Col), s.RowProof)
+	if merkleVerify(rowRoot, int(s.Row), s.RootProof) != s.RootsRoot || dataRoot(s.Width, s.RootsRoot) != root {
+		return errRootMismatch
+	}
+	return nil
+}
+
+// Confidence returns the probability that the given number of samples, drawn
+// independently from the extended square of the given width, detects a block
+// producer withholding enough chunks to make the block unrecoverable.
+func Confidence(width, samples int) float64 {
+	k := float64(width)
+	miss := 1 - (k+1)*(k+1)/(4*k*k)
+	return 1 - math.Pow(miss, float64(samples))
+}
+
+// HeaderRoot returns the data root committed to in the extra-data vanity of
+// the header.
+func HeaderRoot(header *types.Header) (common.Hash, error) {
+	if len(header.Extra) < common.HashLength {
+		return common.Hash{}, errMissingDataRoot
+	}
+	return common.BytesToHash(header.Extra[:common.HashLength]), nil
+}
+
+// SetHeaderRoot commits to the data root in the extra-data vanity of the
+// header. The vanity has to be reserved for the data root by the sealer, any
+// content it holds being overwritten.
+func SetHeaderRoot(header *types.Header, root common.Hash) {
+	extra := make([]byte, common.HashLength)
+	if len(header.Extra) > len(extra) {
+		extra = make([]byte, len(header.Extra))
+	}
+	copy(extra, header.Extra)
+	copy(extra, root[:])
+	header.Extra = extra
+}
+
+// dataRoot commits to the width of the data square and the row and column
+// roots of the extended square.
+func dataRoot(width uint16, rootsRoot common.Hash) common.Hash {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], width)
+	return crypto.Keccak256Hash(buf[:], rootsRoot[:])
+}
+
+// merkleLevels returns the levels of the binary Merkle tree over a power of
+// two number of leaves, from the leaves up to the root.
+func merkleLevels(leaves []common.Hash) [][]common.Hash {
+	levels := [][]common.Hash{leaves}
+	for level := leaves; len(level) > 1; {
+		parent := make([]common.Hash, len(level)/2)
+		for i := range parent {
+			parent[i] = crypto.Keccak256Hash(level[2*i][:], level[2*i+1][:])
+		}
+		levels = append(levels, parent)
+		level = parent
+	}
+	return levels
+}
+
+func merkleRoot(leaves []common.Hash) common.Hash {
+	levels := merkleLevels(leaves)
+	return levels[len(levels)-1][0]
+}
+
+// merkleProof returns the siblings of the path from a leaf to the root.
+func merkleProof(levels [][]common.Hash, index int) []common.Hash {
+	proof := make([]common.Hash, 0, len(levels)-1)
+	for _, level := range levels[:len(levels)-1] {
+		proof = append(proof, level[index^1])
+		index >>= 1
+	}
+	return proof
+}
+
+// merkleVerify returns the root of the path from a leaf up along the proof.
+func merkleVerify(leaf common.Hash, index int, proof []common.Hash) common.Hash {
+	for _, sibling := range proof {
+		if index&1 == 0 {
+			leaf = crypto.Keccak256Hash(leaf[:], sibling[:])
+		} else {
+			leaf = crypto.Keccak256Hash(sibling[:], leaf[:])
+		}
+		index >>= 1
+	}
+	return leaf
+}
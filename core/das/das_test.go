@@ -0,0 +1,155 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package das
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestFieldArithmetic(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if got := gfDiv(gfMul(byte(a), byte(b)), byte(b)); got != byte(a) {
+				t.Fatalf("%d * %d / %d = %d", a, b, b, got)
+			}
+		}
+	}
+}
+
+func TestMatrixWidth(t *testing.T) {
+	for _, tt := range []struct {
+		size, width int
+	}{
+		{0, 1}, {1, 1}, {ChunkSize, 1}, {ChunkSize + 1, 2}, {4 * ChunkSize, 2}, {5 * ChunkSize, 4},
+		{MaxWidth * MaxWidth * ChunkSize, MaxWidth},
+	} {
+		m, err := NewMatrix(make([]byte, tt.size))
+		if err != nil {
+			t.Fatalf("size %d: %v", tt.size, err)
+		}
+		if m.Width() != tt.width {
+			t.Errorf("size %d: width mismatch: have %d, want %d", tt.size, m.Width(), tt.width)
+		}
+	}
+	if _, err := NewMatrix(make([]byte, MaxWidth*MaxWidth*ChunkSize+1)); err != errTooLarge {
+		t.Errorf("oversized data: have %v, want %v", err, errTooLarge)
+	}
+}
+
+// Tests that the extended rows of the parity half are the extension of the
+// parity columns, i.e. every row and column of the square is a codeword.
+func TestMatrixExtension(t *testing.T) {
+	data := make([]byte, 40*ChunkSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	m, err := NewMatrix(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := m.Width()
+	if !bytes.Equal(m.chunks[0][0], data[:ChunkSize]) || !bytes.Equal(m.chunks[1][0], data[width*ChunkSize:(width+1)*ChunkSize]) {
+		t.Fatal("data square not laid out by row")
+	}
+	enc := newEncoder(width)
+	for r := width; r < 2*width; r++ {
+		for p, chunk := range enc.extend(m.chunks[r][:width]) {
+			if !bytes.Equal(chunk, m.chunks[r][width+p]) {
+				t.Fatalf("row %d: parity chunk %d mismatch", r, p)
+			}
+		}
+	}
+}
+
+func TestSampleVerify(t *testing.T) {
+	data := make([]byte, 10*ChunkSize)
+	rand.New(rand.NewSource(2)).Read(data)
+	m, err := NewMatrix(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := m.Root()
+	for r := 0; r < 2*m.Width(); r++ {
+		for c := 0; c < 2*m.Width(); c++ {
+			s, err := m.Sample(uint16(r), uint16(c))
+			if err != nil {
+				t.Fatalf("sample (%d, %d): %v", r, c, err)
+			}
+			if err := s.Verify(root, uint16(r), uint16(c)); err != nil {
+				t.Fatalf("sample (%d, %d): %v", r, c, err)
+			}
+		}
+	}
+	// Positions beyond the extended square are reduced
+	row, col := uint16(2*m.Width()+1), uint16(65535)
+	s, err := m.Sample(row, col)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(root, row, col); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(root, row-1, col); err != errWrongPosition {
+		t.Fatalf("wrong position: have %v, want %v", err, errWrongPosition)
+	}
+	if err := s.Verify(common.Hash{1}, row, col); err != errRootMismatch {
+		t.Fatalf("wrong root: have %v, want %v", err, errRootMismatch)
+	}
+	s.Chunk[0]++
+	if err := s.Verify(root, row, col); err != errRootMismatch {
+		t.Fatalf("tampered chunk: have %v, want %v", err, errRootMismatch)
+	}
+	s.Chunk[0]--
+	s.RowProof = s.RowProof[1:]
+	if err := s.Verify(root, row, col); err != errInvalidProof {
+		t.Fatalf("short proof: have %v, want %v", err, errInvalidProof)
+	}
+	m.Withhold(1, 2)
+	if _, err := m.Sample(1, 2); !errors.Is(err, ErrWithheld) {
+		t.Fatalf("withheld chunk: have %v, want %v", err, ErrWithheld)
+	}
+}
+
+func TestHeaderRoot(t *testing.T) {
+	root := common.Hash{0xda}
+	for _, extra := range [][]byte{nil, {1, 2, 3}, make([]byte, 32+65)} {
+		header := &types.Header{Extra: common.CopyBytes(extra)}
+		if _, err := HeaderRoot(header); len(extra) < common.HashLength && err == nil {
+			t.Fatalf("extra %x: missing data root not detected", extra)
+		}
+		SetHeaderRoot(header, root)
+		if have, err := HeaderRoot(header); err != nil || have != root {
+			t.Fatalf("extra %x: data root mismatch: have %x, %v", extra, have, err)
+		}
+		if len(extra) > common.HashLength && !bytes.Equal(header.Extra[common.HashLength:], extra[common.HashLength:]) {
+			t.Fatalf("extra %x: seal fields overwritten", extra)
+		}
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	if c := Confidence(1, 1); c != 1 {
+		t.Errorf("width 1: unrecoverable blocks withhold every chunk, have confidence %v", c)
+	}
+	if c := Confidence(MaxWidth, 30); c < 0.99 {
+		t.Errorf("30 samples: have confidence %v, want at least 0.99", c)
+	}
+}
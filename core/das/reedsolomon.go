@@ -0,0 +1,93 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package das
+
+// Arithmetic over GF(2^8) with the reducing polynomial x^8+x^4+x^3+x^2+1, in
+// which 2 generates the multiplicative group.
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i], gfExp[i+255] = byte(x), byte(x)
+		gfLog[x] = byte(i)
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// encoder extends k chunks, the evaluations of a polynomial of degree below k
+// at the points 0..k-1, with its evaluations at the points k..2k-1.
+type encoder struct {
+	k      int
+	coeffs [][256]byte // multiplication tables of the Lagrange coefficient of every data chunk, per parity chunk
+}
+
+func newEncoder(k int) *encoder {
+	e := &encoder{k: k, coeffs: make([][256]byte, k*k)}
+	for p := 0; p < k; p++ {
+		x := byte(k + p)
+		for i := 0; i < k; i++ {
+			// L_i(x) = prod_{j != i} (x - j) / (i - j), subtraction being xor
+			c := byte(1)
+			for j := 0; j < k; j++ {
+				if j != i {
+					c = gfMul(c, gfDiv(x^byte(j), byte(i^j)))
+				}
+			}
+			table := &e.coeffs[p*k+i]
+			for b := 0; b < 256; b++ {
+				table[b] = gfMul(c, byte(b))
+			}
+		}
+	}
+	return e
+}
+
+// extend computes the k parity chunks of the k data chunks.
+func (e *encoder) extend(data [][]byte) [][]byte {
+	parity := make([][]byte, e.k)
+	for p := range parity {
+		chunk := make([]byte, ChunkSize)
+		for i, d := range data {
+			table := &e.coeffs[p*e.k+i]
+			for b, v := range d {
+				chunk[b] ^= table[v]
+			}
+		}
+		parity[p] = chunk
+	}
+	return parity
+}
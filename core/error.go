@@ -32,6 +32,14 @@ var (
 	// ErrNoGenesis is returned when there is no Genesis Block.
 	ErrNoGenesis = errors.New("genesis not found in chain")
 
+	// ErrIncompleteWitness is returned if a block executed statelessly reads
+	// state that is neither in its witness nor held locally.
+	ErrIncompleteWitness = errors.New("incomplete witness")
+
+	errStatelessSnapshot = errors.New("stateless insertion requires snapshots to be disabled")
+
+	errWitnessScheme = errors.New("witnesses require the hash-based state scheme")
+
 	errSideChainReceipts = errors.New("side blocks can't be accepted as ancient chain data")
 )
 
@@ -317,7 +317,7 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, triedb *trie.Database, gen
 	// We have the genesis block in database(perhaps in ancient database)
 	// but the corresponding state is missing.
 	header := rawdb.ReadHeader(db, stored, 0)
-	if header.Root != types.EmptyRootHash && !rawdb.HasLegacyTrieNode(db, header.Root) {
+	if header.Root != types.EmptyRootHash && !triedb.Initialized(header.Root) {
 		if genesis == nil {
 			genesis = DefaultGenesisBlock()
 		}
@@ -384,6 +384,29 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, triedb *trie.Database, gen
 // provided genesis specification. Note the returned clique config can
 // be nil if we are not in the clique network.
 func LoadCliqueConfig(db ethdb.Database, genesis *Genesis) (*params.CliqueConfig, error) {
+	config, err := loadChainConfig(db, genesis)
+	if config == nil {
+		return nil, err
+	}
+	return config.Clique, nil
+}
+
+// LoadQBFTConfig loads the stored qbft config if the chain config is already
+// present in database, otherwise, return the config in the provided genesis
+// specification. Note the returned qbft config can be nil if we are not in a
+// qbft network.
+func LoadQBFTConfig(db ethdb.Database, genesis *Genesis) (*params.QBFTConfig, error) {
+	config, err := loadChainConfig(db, genesis)
+	if config == nil {
+		return nil, err
+	}
+	return config.QBFT, nil
+}
+
+// loadChainConfig loads the stored chain config if it's already present in
+// database, otherwise, returns the config in the provided genesis specification.
+// It returns nil if there is neither.
+func loadChainConfig(db ethdb.Database, genesis *Genesis) (*params.ChainConfig, error) {
 	// Load the stored chain config from the database. It can be nil
 	// in case the database is empty. Notably, we only care about the
 	// chain config corresponds to the canonical chain.
@@ -391,10 +414,10 @@ func LoadCliqueConfig(db ethdb.Database, genesis *Genesis) (*params.CliqueConfig
 	if stored != (common.Hash{}) {
 		storedcfg := rawdb.ReadChainConfig(db, stored)
 		if storedcfg != nil {
-			return storedcfg.Clique, nil
+			return storedcfg, nil
 		}
 	}
-	// Load the clique config from the provided genesis specification.
+	// Load the chain config from the provided genesis specification.
 	if genesis != nil {
 		// Reject invalid genesis spec without valid chain config
 		if genesis.Config == nil {
@@ -407,7 +430,7 @@ func LoadCliqueConfig(db ethdb.Database, genesis *Genesis) (*params.CliqueConfig
 		if stored != (common.Hash{}) && genesis.ToBlock().Hash() != stored {
 			return nil, &GenesisMismatchError{stored, genesis.ToBlock().Hash()}
 		}
-		return genesis.Config.Clique, nil
+		return genesis.Config, nil
 	}
 	// There is no stored chain config and no new config provided,
 	// In this case the default chain config(mainnet) will be used,
@@ -576,6 +599,10 @@ func DeveloperGenesisBlock(period uint64, gasLimit uint64, faucet common.Address
 		Period: period,
 		Epoch:  config.Clique.Epoch,
 	}
+	// Transition to proof-of-stake at genesis, blocks being produced through
+	// the Engine API by a simulated beacon rather than sealed by clique
+	config.TerminalTotalDifficulty = common.Big0
+	config.TerminalTotalDifficultyPassed = true
 
 	// Assemble and return the genesis with the precompiles and faucet pre-funded
 	return &Genesis{
@@ -583,7 +610,7 @@ func DeveloperGenesisBlock(period uint64, gasLimit uint64, faucet common.Address
 		ExtraData:  append(append(make([]byte, 32), faucet[:]...), make([]byte, crypto.SignatureLength)...),
 		GasLimit:   gasLimit,
 		BaseFee:    big.NewInt(params.InitialBaseFee),
-		Difficulty: big.NewInt(1),
+		Difficulty: big.NewInt(0),
 		Alloc: map[common.Address]GenesisAccount{
 			common.BytesToAddress([]byte{1}): {Balance: big.NewInt(1)}, // ECRecover
 			common.BytesToAddress([]byte{2}): {Balance: big.NewInt(1)}, // SHA256
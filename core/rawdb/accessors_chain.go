@@ -674,6 +674,40 @@ func DeleteReceipts(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	}
 }
 
+// ReadStateAccesses retrieves the state accesses recorded while processing a
+// block, or nil if none were recorded.
+func ReadStateAccesses(db ethdb.Reader, hash common.Hash, number uint64) []types.StateAccess {
+	data, _ := db.Get(stateAccessesKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	var accesses []types.StateAccess
+	if err := rlp.DecodeBytes(data, &accesses); err != nil {
+		log.Error("Invalid state access list RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return accesses
+}
+
+// WriteStateAccesses stores the state accesses recorded while processing a
+// block.
+func WriteStateAccesses(db ethdb.KeyValueWriter, hash common.Hash, number uint64, accesses []types.StateAccess) {
+	bytes, err := rlp.EncodeToBytes(accesses)
+	if err != nil {
+		log.Crit("Failed to encode state accesses", "err", err)
+	}
+	if err := db.Put(stateAccessesKey(number, hash), bytes); err != nil {
+		log.Crit("Failed to store state accesses", "err", err)
+	}
+}
+
+// DeleteStateAccesses removes the state accesses recorded for a block.
+func DeleteStateAccesses(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Delete(stateAccessesKey(number, hash)); err != nil {
+		log.Crit("Failed to delete state accesses", "err", err)
+	}
+}
+
 // storedReceiptRLP is the storage encoding of a receipt.
 // Re-definition in core/types/receipt.go.
 // TODO: Re-use the existing definition.
@@ -815,13 +849,15 @@ func writeAncientBlock(op ethdb.AncientWriteOp, block *types.Block, header *type
 // DeleteBlock removes all block data associated with a hash.
 func DeleteBlock(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	DeleteReceipts(db, hash, number)
+	DeleteStateAccesses(db, hash, number)
 	DeleteHeader(db, hash, number)
 	DeleteBody(db, hash, number)
 	DeleteTd(db, hash, number)
 }
 
 // DeleteBlockWithoutNumber removes all block data associated with a hash, except
-// the hash to number mapping.
+// the hash to number mapping and the state accesses, which aren't moved to the
+// freezer.
 func DeleteBlockWithoutNumber(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	DeleteReceipts(db, hash, number)
 	deleteHeaderWithoutNumber(db, hash, number)
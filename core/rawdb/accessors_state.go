@@ -17,6 +17,9 @@
 package rawdb
 
 import (
+	"bytes"
+	"encoding/binary"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
@@ -92,3 +95,108 @@ func DeleteCode(db ethdb.KeyValueWriter, hash common.Hash) {
 		log.Crit("Failed to delete contract code", "err", err)
 	}
 }
+
+// ReadStateID retrieves the id of the state with the provided root, as tracked
+// by the path-based trie node scheme.
+func ReadStateID(db ethdb.KeyValueReader, root common.Hash) *uint64 {
+	data, err := db.Get(stateIDKey(root))
+	if err != nil || len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+// WriteStateID writes the id of the state with the provided root.
+func WriteStateID(db ethdb.KeyValueWriter, root common.Hash, id uint64) {
+	if err := db.Put(stateIDKey(root), encodeBlockNumber(id)); err != nil {
+		log.Crit("Failed to store state id", "err", err)
+	}
+}
+
+// DeleteStateID deletes the id of the state with the provided root.
+func DeleteStateID(db ethdb.KeyValueWriter, root common.Hash) {
+	if err := db.Delete(stateIDKey(root)); err != nil {
+		log.Crit("Failed to delete state id", "err", err)
+	}
+}
+
+// ReadPersistentStateID retrieves the id of the state stored on disk.
+func ReadPersistentStateID(db ethdb.KeyValueReader) uint64 {
+	data, _ := db.Get(persistentStateIDKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// WritePersistentStateID writes the id of the state stored on disk.
+func WritePersistentStateID(db ethdb.KeyValueWriter, id uint64) {
+	if err := db.Put(persistentStateIDKey, encodeBlockNumber(id)); err != nil {
+		log.Crit("Failed to store the persistent state id", "err", err)
+	}
+}
+
+// ReadReverseDiff retrieves the reverse diff rolling the state with the given
+// id back to its parent state.
+func ReadReverseDiff(db ethdb.KeyValueReader, id uint64) []byte {
+	data, _ := db.Get(reverseDiffKey(id))
+	return data
+}
+
+// HasReverseDiff checks the presence of the reverse diff with the given id.
+func HasReverseDiff(db ethdb.KeyValueReader, id uint64) bool {
+	ok, _ := db.Has(reverseDiffKey(id))
+	return ok
+}
+
+// WriteReverseDiff writes the reverse diff with the given id.
+func WriteReverseDiff(db ethdb.KeyValueWriter, id uint64, blob []byte) {
+	if err := db.Put(reverseDiffKey(id), blob); err != nil {
+		log.Crit("Failed to store reverse diff", "err", err)
+	}
+}
+
+// DeleteReverseDiff deletes the reverse diff with the given id.
+func DeleteReverseDiff(db ethdb.KeyValueWriter, id uint64) {
+	if err := db.Delete(reverseDiffKey(id)); err != nil {
+		log.Crit("Failed to delete reverse diff", "err", err)
+	}
+}
+
+// DeleteStateHistory deletes all the reverse diffs and state ids, along with
+// the persistent state id.
+func DeleteStateHistory(db ethdb.KeyValueStore) {
+	batch := db.NewBatch()
+	for _, prefix := range [][]byte{stateIDPrefix, reverseDiffPrefix} {
+		it := db.NewIterator(prefix, nil)
+		for it.Next() {
+			key := it.Key()
+			if !isStateIDKey(key) && !isReverseDiffKey(key) {
+				continue
+			}
+			batch.Delete(key)
+			if batch.ValueSize() > ethdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					log.Crit("Failed to delete state history", "err", err)
+				}
+				batch.Reset()
+			}
+		}
+		it.Release()
+	}
+	batch.Delete(persistentStateIDKey)
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to delete state history", "err", err)
+	}
+}
+
+// isStateIDKey reports whether a key is the one of a state id.
+func isStateIDKey(key []byte) bool {
+	return bytes.HasPrefix(key, stateIDPrefix) && len(key) == len(stateIDPrefix)+common.HashLength
+}
+
+// isReverseDiffKey reports whether a key is the one of a reverse diff.
+func isReverseDiffKey(key []byte) bool {
+	return bytes.HasPrefix(key, reverseDiffPrefix) && len(key) == len(reverseDiffPrefix)+8
+}
@@ -17,6 +17,7 @@
 package rawdb
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 
@@ -261,3 +262,121 @@ func DeleteTrieNode(db ethdb.KeyValueWriter, owner common.Hash, path []byte, has
 		panic(fmt.Sprintf("Unknown scheme %v", scheme))
 	}
 }
+
+// ReadStateScheme reports the scheme of the state stored in the database, or
+// an empty string if there is none.
+func ReadStateScheme(db ethdb.Reader) string {
+	// The path-based scheme always stores the root of the account trie
+	if blob, _ := ReadAccountTrieNode(db, nil); len(blob) != 0 {
+		return PathScheme
+	}
+	// The hash-based scheme always keeps the genesis state on disk, so it's
+	// enough to look it up
+	header := ReadHeader(db, ReadCanonicalHash(db, 0), 0)
+	if header == nil {
+		return ""
+	}
+	if blob := ReadLegacyTrieNode(db, header.Root); len(blob) == 0 {
+		return ""
+	}
+	return HashScheme
+}
+
+// ParseStateScheme checks the provided state scheme against the one of the
+// stored state, defaulting to the stored one, or the hash-based scheme for an
+// empty database.
+func ParseStateScheme(provided string, db ethdb.Reader) (string, error) {
+	if provided != "" && provided != HashScheme && provided != PathScheme {
+		return "", fmt.Errorf("unknown state scheme %q", provided)
+	}
+	stored := ReadStateScheme(db)
+	if provided == "" {
+		if stored == "" {
+			return HashScheme, nil
+		}
+		return stored, nil
+	}
+	if stored != "" && stored != provided {
+		return "", fmt.Errorf("incompatible state scheme, stored: %s, provided: %s", stored, provided)
+	}
+	return provided, nil
+}
+
+// IsAccountTrieNode reports whether a database key is the one of an account
+// trie node of the path-based scheme, returning the node path if so.
+func IsAccountTrieNode(key []byte) (bool, []byte) {
+	if !bytes.HasPrefix(key, trieNodeAccountPrefix) {
+		return false, nil
+	}
+	// Leaves are always wrapped in short nodes, so paths are shorter than 64
+	path := key[len(trieNodeAccountPrefix):]
+	if len(path) >= 2*common.HashLength || !isHexPath(path) {
+		return false, nil
+	}
+	return true, path
+}
+
+// IsStorageTrieNode reports whether a database key is the one of a storage
+// trie node of the path-based scheme, returning the owner account hash and
+// the node path if so.
+func IsStorageTrieNode(key []byte) (bool, common.Hash, []byte) {
+	if !bytes.HasPrefix(key, trieNodeStoragePrefix) {
+		return false, common.Hash{}, nil
+	}
+	if len(key) < len(trieNodeStoragePrefix)+common.HashLength {
+		return false, common.Hash{}, nil
+	}
+	path := key[len(trieNodeStoragePrefix)+common.HashLength:]
+	if len(path) >= 2*common.HashLength || !isHexPath(path) {
+		return false, common.Hash{}, nil
+	}
+	return true, common.BytesToHash(key[len(trieNodeStoragePrefix) : len(trieNodeStoragePrefix)+common.HashLength]), path
+}
+
+// isAccountTrieNodeKey reports whether a key is the one of an account trie node.
+func isAccountTrieNodeKey(key []byte) bool {
+	ok, _ := IsAccountTrieNode(key)
+	return ok
+}
+
+// isStorageTrieNodeKey reports whether a key is the one of a storage trie node.
+func isStorageTrieNodeKey(key []byte) bool {
+	ok, _, _ := IsStorageTrieNode(key)
+	return ok
+}
+
+// isHexPath reports whether a node path only consists of nibbles.
+func isHexPath(path []byte) bool {
+	for _, nibble := range path {
+		if nibble > 0x0f {
+			return false
+		}
+	}
+	return true
+}
+
+// DeletePathTrieNodes deletes all the trie nodes stored with the path-based
+// scheme.
+func DeletePathTrieNodes(db ethdb.KeyValueStore) {
+	batch := db.NewBatch()
+	for _, prefix := range [][]byte{trieNodeAccountPrefix, trieNodeStoragePrefix} {
+		it := db.NewIterator(prefix, nil)
+		for it.Next() {
+			key := it.Key()
+			if !isAccountTrieNodeKey(key) && !isStorageTrieNodeKey(key) {
+				continue
+			}
+			batch.Delete(key)
+			if batch.ValueSize() > ethdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					log.Crit("Failed to delete trie nodes", "err", err)
+				}
+				batch.Reset()
+			}
+		}
+		it.Release()
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to delete trie nodes", "err", err)
+	}
+}
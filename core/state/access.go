@@ -0,0 +1,80 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// StateAccessTracer is notified of every read and write of an account or a
+// storage slot made through a StateDB.
+type StateAccessTracer interface {
+	OnStateAccess(access types.StateAccess)
+}
+
+// SetAccessTracer sets the tracer notified of the state accesses, nil to stop
+// tracing. Copies of the StateDB are not traced.
+func (s *StateDB) SetAccessTracer(tracer StateAccessTracer) {
+	s.accessTracer = tracer
+}
+
+// AccessTracer returns the tracer notified of the state accesses, nil if not
+// tracing.
+func (s *StateDB) AccessTracer() StateAccessTracer {
+	return s.accessTracer
+}
+
+// traceAccount notifies the tracer of an access to an account.
+func (s *StateDB) traceAccount(addr common.Address, write bool) {
+	if s.accessTracer != nil {
+		s.accessTracer.OnStateAccess(types.StateAccess{TxIndex: uint(s.txIndex), Address: addr, Write: write})
+	}
+}
+
+// traceStorage notifies the tracer of an access to a storage slot.
+func (s *StateDB) traceStorage(addr common.Address, slot common.Hash, write bool) {
+	if s.accessTracer != nil {
+		s.accessTracer.OnStateAccess(types.StateAccess{TxIndex: uint(s.txIndex), Address: addr, Storage: true, Slot: slot, Write: write})
+	}
+}
+
+// AccessRecorder is a StateAccessTracer collecting the distinct accesses, in
+// the order they were first made.
+type AccessRecorder struct {
+	seen     map[types.StateAccess]struct{}
+	accesses []types.StateAccess
+}
+
+// NewAccessRecorder creates an empty access recorder.
+func NewAccessRecorder() *AccessRecorder {
+	return &AccessRecorder{seen: make(map[types.StateAccess]struct{})}
+}
+
+// OnStateAccess implements StateAccessTracer.
+func (r *AccessRecorder) OnStateAccess(access types.StateAccess) {
+	if _, ok := r.seen[access]; ok {
+		return
+	}
+	r.seen[access] = struct{}{}
+	r.accesses = append(r.accesses, access)
+}
+
+// Accesses returns the recorded accesses.
+func (r *AccessRecorder) Accesses() []types.StateAccess {
+	return r.accesses
+}
@@ -263,21 +263,36 @@ func (s *stateObject) finalise(prefetch bool) {
 // updateTrie writes cached storage modifications into the object's storage trie.
 // It will return nil if the trie has not been loaded and no changes have been
 // made. An error will be returned if the trie can't be loaded/updated correctly.
+//
+// The storage tries of distinct objects may be updated concurrently, the fields
+// of the StateDB shared between them are only modified under its storageLock.
 func (s *stateObject) updateTrie(db Database) (Trie, error) {
 	// Make sure all dirty slots are finalized into the pending storage area
 	s.finalise(false) // Don't prefetch anymore, pull directly if need be
 	if len(s.pendingStorage) == 0 {
 		return s.trie, nil
 	}
-	// Track the amount of time wasted on updating the storage trie
-	if metrics.EnabledExpensive {
-		defer func(start time.Time) { s.db.StorageUpdates += time.Since(start) }(time.Now())
-	}
-	// The snapshot storage map for the object
+	// The snapshot storage map for the object and the update statistics
 	var (
 		storage map[common.Hash][]byte
-		hasher  = s.db.hasher
+		hasher  crypto.KeccakState
+
+		updated int
+		deleted int
 	)
+	defer func(start time.Time) {
+		s.db.storageLock.Lock()
+		defer s.db.storageLock.Unlock()
+
+		s.db.StorageUpdated += updated
+		s.db.StorageDeleted += deleted
+
+		// Track the amount of time wasted on updating the storage trie
+		if metrics.EnabledExpensive {
+			s.db.StorageUpdates += time.Since(start)
+		}
+	}(time.Now())
+
 	tr, err := s.getTrie(db)
 	if err != nil {
 		s.db.setError(err)
@@ -298,7 +313,7 @@ func (s *stateObject) updateTrie(db Database) (Trie, error) {
 				s.db.setError(err)
 				return nil, err
 			}
-			s.db.StorageDeleted += 1
+			deleted += 1
 		} else {
 			// Encoding []byte cannot fail, ok to ignore the error.
 			v, _ = rlp.EncodeToBytes(common.TrimLeftZeroes(value[:]))
@@ -306,16 +321,20 @@ func (s *stateObject) updateTrie(db Database) (Trie, error) {
 				s.db.setError(err)
 				return nil, err
 			}
-			s.db.StorageUpdated += 1
+			updated += 1
 		}
 		// If state snapshotting is active, cache the data til commit
 		if s.db.snap != nil {
 			if storage == nil {
 				// Retrieve the old storage map, if available, create a new one otherwise
+				s.db.storageLock.Lock()
 				if storage = s.db.snapStorage[s.addrHash]; storage == nil {
 					storage = make(map[common.Hash][]byte)
 					s.db.snapStorage[s.addrHash] = storage
 				}
+				s.db.storageLock.Unlock()
+
+				hasher = crypto.NewKeccakState()
 			}
 			storage[crypto.HashData(hasher, key[:])] = v // v will be nil if it's deleted
 		}
@@ -343,7 +362,11 @@ func (s *stateObject) updateRoot(db Database) {
 	}
 	// Track the amount of time wasted on hashing the storage trie
 	if metrics.EnabledExpensive {
-		defer func(start time.Time) { s.db.StorageHashes += time.Since(start) }(time.Now())
+		defer func(start time.Time) {
+			s.db.storageLock.Lock()
+			s.db.StorageHashes += time.Since(start)
+			s.db.storageLock.Unlock()
+		}(time.Now())
 	}
 	s.data.Root = tr.Hash()
 }
@@ -361,7 +384,11 @@ func (s *stateObject) commitTrie(db Database) (*trie.NodeSet, error) {
 	}
 	// Track the amount of time wasted on committing the storage trie
 	if metrics.EnabledExpensive {
-		defer func(start time.Time) { s.db.StorageCommits += time.Since(start) }(time.Now())
+		defer func(start time.Time) {
+			s.db.storageLock.Lock()
+			s.db.StorageCommits += time.Since(start)
+			s.db.storageLock.Unlock()
+		}(time.Now())
 	}
 	root, nodes := tr.Commit(false)
 	s.data.Root = root
@@ -18,10 +18,12 @@
 package state
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/big"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -52,6 +54,17 @@ func (n *proofList) Delete(key []byte) error {
 	panic("not supported")
 }
 
+// Archive reports which accounts and storage slots are not part of the local
+// (hot) state but live erasure-coded in the cold shards. Accesses to them are
+// charged more gas, so it must be derived from the consensus state.
+type Archive interface {
+	// IsArchived returns whether the account lives in a cold shard.
+	IsArchived(addr common.Address) bool
+
+	// IsSlotArchived returns whether the storage slot lives in a cold shard.
+	IsSlotArchived(addr common.Address, slot common.Hash) bool
+}
+
 // StateDB structs within the ethereum protocol are used to store anything
 // within the merkle trie. StateDBs take care of caching and storing
 // nested states. It's the general query interface to retrieve:
@@ -87,6 +100,11 @@ type StateDB struct {
 	// when accessing state of accounts.
 	dbErr error
 
+	// Number of storage tries hashed and committed concurrently, and the lock
+	// guarding the fields shared by them (error, snapshot storage and metrics)
+	storageWorkers int
+	storageLock    sync.Mutex
+
 	// The refund counter, also used by state transitioning.
 	refund uint64
 
@@ -103,6 +121,13 @@ type StateDB struct {
 	// Transient storage
 	transientStorage transientStorage
 
+	// Index of the accounts and slots living in the cold shards, nil if all
+	// of the state is held locally
+	archive Archive
+
+	// Tracer of the account and storage accesses, nil if not tracing
+	accessTracer StateAccessTracer
+
 	// Journal of state modifications. This is the backbone of
 	// Snapshot and RevertToSnapshot.
 	journal        *journal
@@ -150,6 +175,7 @@ func New(root common.Hash, db Database, snaps *snapshot.Tree) (*StateDB, error)
 		accessList:           newAccessList(),
 		transientStorage:     newTransientStorage(),
 		hasher:               crypto.NewKeccakState(),
+		storageWorkers:       1,
 	}
 	if sdb.snaps != nil {
 		if sdb.snap = sdb.snaps.Snapshot(root); sdb.snap != nil {
@@ -173,6 +199,45 @@ func (s *StateDB) StartPrefetcher(namespace string) {
 	}
 }
 
+// SetStorageWorkers sets the number of storage tries hashed and committed
+// concurrently, one or less disabling the concurrency.
+func (s *StateDB) SetStorageWorkers(workers int) {
+	s.storageWorkers = workers
+}
+
+// forEachStorage runs the given task over the storage tries of the objects on
+// a bounded pool of workers, returning once all of them are done.
+func (s *StateDB) forEachStorage(objs []*stateObject, task func(i int, obj *stateObject)) {
+	workers := s.storageWorkers
+	if workers > len(objs) {
+		workers = len(objs)
+	}
+	if workers <= 1 {
+		for i, obj := range objs {
+			task(i, obj)
+		}
+		return
+	}
+	var (
+		tasks = make(chan int)
+		pend  sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+			for i := range tasks {
+				task(i, objs[i])
+			}
+		}()
+	}
+	for i := range objs {
+		tasks <- i
+	}
+	close(tasks)
+	pend.Wait()
+}
+
 // StopPrefetcher terminates a running prefetcher and reports any leftover stats
 // from the gathered metrics.
 func (s *StateDB) StopPrefetcher() {
@@ -184,6 +249,9 @@ func (s *StateDB) StopPrefetcher() {
 
 // setError remembers the first non-nil error it is called with.
 func (s *StateDB) setError(err error) {
+	s.storageLock.Lock()
+	defer s.storageLock.Unlock()
+
 	if s.dbErr == nil {
 		s.dbErr = err
 	}
@@ -194,6 +262,11 @@ func (s *StateDB) Error() error {
 	return s.dbErr
 }
 
+// CachedObjects returns the number of state objects held in memory.
+func (s *StateDB) CachedObjects() int {
+	return len(s.stateObjects)
+}
+
 func (s *StateDB) AddLog(log *types.Log) {
 	s.journal.append(addLogChange{txhash: s.thash})
 
@@ -257,18 +330,21 @@ func (s *StateDB) SubRefund(gas uint64) {
 // Exist reports whether the given account address exists in the state.
 // Notably this also returns true for suicided accounts.
 func (s *StateDB) Exist(addr common.Address) bool {
+	s.traceAccount(addr, false)
 	return s.getStateObject(addr) != nil
 }
 
 // Empty returns whether the state object is either non-existent
 // or empty according to the EIP161 specification (balance = nonce = code = 0)
 func (s *StateDB) Empty(addr common.Address) bool {
+	s.traceAccount(addr, false)
 	so := s.getStateObject(addr)
 	return so == nil || so.empty()
 }
 
 // GetBalance retrieves the balance from the given address or 0 if object not found
 func (s *StateDB) GetBalance(addr common.Address) *big.Int {
+	s.traceAccount(addr, false)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.Balance()
@@ -277,6 +353,7 @@ func (s *StateDB) GetBalance(addr common.Address) *big.Int {
 }
 
 func (s *StateDB) GetNonce(addr common.Address) uint64 {
+	s.traceAccount(addr, false)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.Nonce()
@@ -291,6 +368,7 @@ func (s *StateDB) TxIndex() int {
 }
 
 func (s *StateDB) GetCode(addr common.Address) []byte {
+	s.traceAccount(addr, false)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.Code(s.db)
@@ -299,6 +377,7 @@ func (s *StateDB) GetCode(addr common.Address) []byte {
 }
 
 func (s *StateDB) GetCodeSize(addr common.Address) int {
+	s.traceAccount(addr, false)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.CodeSize(s.db)
@@ -307,6 +386,7 @@ func (s *StateDB) GetCodeSize(addr common.Address) int {
 }
 
 func (s *StateDB) GetCodeHash(addr common.Address) common.Hash {
+	s.traceAccount(addr, false)
 	stateObject := s.getStateObject(addr)
 	if stateObject == nil {
 		return common.Hash{}
@@ -316,6 +396,7 @@ func (s *StateDB) GetCodeHash(addr common.Address) common.Hash {
 
 // GetState retrieves a value from the given account's storage trie.
 func (s *StateDB) GetState(addr common.Address, hash common.Hash) common.Hash {
+	s.traceStorage(addr, hash, false)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.GetState(s.db, hash)
@@ -354,6 +435,7 @@ func (s *StateDB) GetStorageProof(a common.Address, key common.Hash) ([][]byte,
 
 // GetCommittedState retrieves a value from the given account's committed storage trie.
 func (s *StateDB) GetCommittedState(addr common.Address, hash common.Hash) common.Hash {
+	s.traceStorage(addr, hash, false)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.GetCommittedState(s.db, hash)
@@ -389,12 +471,38 @@ func (s *StateDB) HasSuicided(addr common.Address) bool {
 	return false
 }
 
+// StorageDeltas returns the accounts modified by the current transaction and,
+// for each of them, the change in its number of non-empty storage slots. It
+// must be called before the transaction is finalised.
+func (s *StateDB) StorageDeltas() map[common.Address]int {
+	deltas := make(map[common.Address]int, len(s.journal.dirties))
+	for addr := range s.journal.dirties {
+		obj, exist := s.stateObjects[addr]
+		if !exist {
+			continue
+		}
+		delta := 0
+		for key, value := range obj.dirtyStorage {
+			prev := obj.GetCommittedState(s.db, key)
+			switch {
+			case prev == (common.Hash{}) && value != (common.Hash{}):
+				delta++
+			case prev != (common.Hash{}) && value == (common.Hash{}):
+				delta--
+			}
+		}
+		deltas[addr] = delta
+	}
+	return deltas
+}
+
 /*
  * SETTERS
  */
 
 // AddBalance adds amount to the account associated with addr.
 func (s *StateDB) AddBalance(addr common.Address, amount *big.Int) {
+	s.traceAccount(addr, true)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.AddBalance(amount)
@@ -403,6 +511,7 @@ func (s *StateDB) AddBalance(addr common.Address, amount *big.Int) {
 
 // SubBalance subtracts amount from the account associated with addr.
 func (s *StateDB) SubBalance(addr common.Address, amount *big.Int) {
+	s.traceAccount(addr, true)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SubBalance(amount)
@@ -410,6 +519,7 @@ func (s *StateDB) SubBalance(addr common.Address, amount *big.Int) {
 }
 
 func (s *StateDB) SetBalance(addr common.Address, amount *big.Int) {
+	s.traceAccount(addr, true)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetBalance(amount)
@@ -417,6 +527,7 @@ func (s *StateDB) SetBalance(addr common.Address, amount *big.Int) {
 }
 
 func (s *StateDB) SetNonce(addr common.Address, nonce uint64) {
+	s.traceAccount(addr, true)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetNonce(nonce)
@@ -424,6 +535,7 @@ func (s *StateDB) SetNonce(addr common.Address, nonce uint64) {
 }
 
 func (s *StateDB) SetCode(addr common.Address, code []byte) {
+	s.traceAccount(addr, true)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetCode(crypto.Keccak256Hash(code), code)
@@ -431,6 +543,7 @@ func (s *StateDB) SetCode(addr common.Address, code []byte) {
 }
 
 func (s *StateDB) SetState(addr common.Address, key, value common.Hash) {
+	s.traceStorage(addr, key, true)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetState(s.db, key, value)
@@ -448,6 +561,7 @@ func (s *StateDB) SetStorage(addr common.Address, storage map[common.Hash]common
 	s.stateObjectsDestruct[addr] = struct{}{}
 	stateObject := s.GetOrNewStateObject(addr)
 	for k, v := range storage {
+		s.traceStorage(addr, k, true)
 		stateObject.SetState(s.db, k, v)
 	}
 }
@@ -458,6 +572,7 @@ func (s *StateDB) SetStorage(addr common.Address, storage map[common.Hash]common
 // The account's state object is still available until the state is committed,
 // getStateObject will return a non-nil account after Suicide.
 func (s *StateDB) Suicide(addr common.Address) bool {
+	s.traceAccount(addr, true)
 	stateObject := s.getStateObject(addr)
 	if stateObject == nil {
 		return false
@@ -667,6 +782,7 @@ func (s *StateDB) createObject(addr common.Address) (newobj, prev *stateObject)
 //
 // Carrying over the balance ensures that Ether doesn't disappear.
 func (s *StateDB) CreateAccount(addr common.Address) {
+	s.traceAccount(addr, true)
 	newObj, prev := s.createObject(addr)
 	if prev != nil {
 		newObj.setBalance(prev.data.Balance)
@@ -724,6 +840,7 @@ func (s *StateDB) Copy() *StateDB {
 		preimages:            make(map[common.Hash][]byte, len(s.preimages)),
 		journal:              newJournal(),
 		hasher:               crypto.NewKeccakState(),
+		storageWorkers:       s.storageWorkers,
 	}
 	// Copy the dirty states, logs, and preimages
 	for addr := range s.journal.dirties {
@@ -890,6 +1007,53 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 	s.clearJournalAndRefund()
 }
 
+// DirtyAccounts finalises the state and returns the accounts modified since
+// the last commit, including the deleted ones, in no particular order. It
+// allows shipping the changes of a block to replicas of the state instead of
+// having them re-execute it.
+func (s *StateDB) DirtyAccounts(deleteEmptyObjects bool) []common.Address {
+	s.Finalise(deleteEmptyObjects)
+
+	addrs := make([]common.Address, 0, len(s.stateObjectsDirty))
+	for addr := range s.stateObjectsDirty {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// MergeTx merges the changes made to the given accounts by the transaction last
+// applied and finalised on another state, along with its logs and preimages.
+// It allows executing transactions speculatively on copies of the state, the
+// caller making sure none of the accounts was modified on s since the copy
+// was made. The transaction context of s must be set beforehand.
+func (s *StateDB) MergeTx(other *StateDB, addrs []common.Address) {
+	for _, addr := range addrs {
+		obj := other.stateObjects[addr]
+		if obj == nil {
+			continue // created and reverted
+		}
+		obj = obj.deepCopy(s)
+		s.setStateObject(obj)
+		if _, ok := other.stateObjectsDestruct[addr]; ok {
+			s.stateObjectsDestruct[addr] = struct{}{}
+		}
+		if obj.deleted && s.snap != nil {
+			delete(s.snapAccounts, obj.addrHash)
+			delete(s.snapStorage, obj.addrHash)
+		}
+		s.stateObjectsPending[addr] = struct{}{}
+		s.stateObjectsDirty[addr] = struct{}{}
+	}
+	for _, log := range other.logs[other.thash] {
+		cpy := new(types.Log)
+		*cpy = *log
+		s.AddLog(cpy)
+	}
+	for hash, preimage := range other.preimages {
+		s.AddPreimage(hash, preimage)
+	}
+}
+
 // IntermediateRoot computes the current root hash of the state trie.
 // It is called in between transactions to get the root hash that
 // goes into transaction receipts.
@@ -916,11 +1080,15 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 	// the account prefetcher. Instead, let's process all the storage updates
 	// first, giving the account prefetches just a few more milliseconds of time
 	// to pull useful data from disk.
+	objs := make([]*stateObject, 0, len(s.stateObjectsPending))
 	for addr := range s.stateObjectsPending {
 		if obj := s.stateObjects[addr]; !obj.deleted {
-			obj.updateRoot(s.db)
+			objs = append(objs, obj)
 		}
 	}
+	s.forEachStorage(objs, func(i int, obj *stateObject) {
+		obj.updateRoot(s.db)
+	})
 	// Now we're about to start to write changes to the trie. The trie is so far
 	// _untouched_. We can check with the prefetcher, if it can give us a trie
 	// which has the same root, but also has some content loaded into it.
@@ -969,6 +1137,47 @@ func (s *StateDB) clearJournalAndRefund() {
 	s.validRevisions = s.validRevisions[:0] // Snapshots can be created without journal entries
 }
 
+// destructedStorage returns the paths of the storage trie nodes of the
+// accounts destructed in the block, keyed by the hash of the account address.
+// The nodes are only collected in the path-based scheme, in which they're
+// owned by a single account and can be wiped when the account is destructed.
+func (s *StateDB) destructedStorage() (map[common.Hash][][]byte, error) {
+	if len(s.stateObjectsDestruct) == 0 || s.db.TrieDB().Scheme() != rawdb.PathScheme {
+		return nil, nil
+	}
+	tr, err := s.db.OpenTrie(s.originalRoot)
+	if err != nil {
+		return nil, err
+	}
+	wipes := make(map[common.Hash][][]byte)
+	for addr := range s.stateObjectsDestruct {
+		acc, err := tr.GetAccount(addr)
+		if err != nil {
+			return nil, err
+		}
+		if acc == nil || acc.Root == types.EmptyRootHash {
+			continue
+		}
+		addrHash := crypto.Keccak256Hash(addr.Bytes())
+		storage, err := s.db.OpenStorageTrie(s.originalRoot, addrHash, acc.Root)
+		if err != nil {
+			return nil, err
+		}
+		var paths [][]byte
+		it := storage.NodeIterator(nil)
+		for it.Next(true) {
+			if it.Hash() != (common.Hash{}) {
+				paths = append(paths, common.CopyBytes(it.Path()))
+			}
+		}
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+		wipes[addrHash] = paths
+	}
+	return wipes, nil
+}
+
 // Commit writes the state to the underlying in-memory trie database.
 func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 	// Short circuit in case any database failure occurred earlier.
@@ -986,6 +1195,7 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 		storageTrieNodesDeleted int
 		nodes                   = trie.NewMergedNodeSet()
 		codeWriter              = s.db.DiskDB().NewBatch()
+		objs                    = make([]*stateObject, 0, len(s.stateObjectsDirty))
 	)
 	for addr := range s.stateObjectsDirty {
 		if obj := s.stateObjects[addr]; !obj.deleted {
@@ -994,27 +1204,63 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 				rawdb.WriteCode(codeWriter, common.BytesToHash(obj.CodeHash()), obj.code)
 				obj.dirtyCode = false
 			}
-			// Write any storage changes in the state object to its storage trie
-			set, err := obj.commitTrie(s.db)
-			if err != nil {
-				return common.Hash{}, err
+			objs = append(objs, obj)
+		}
+		// If the contract is destructed in the hash-based scheme, the storage
+		// is still left in the database as dangling data, as it's extremely
+		// hard to determine if the trie nodes are also referenced by other
+		// storage. In the path-based scheme they are wiped below.
+	}
+	wipes, err := s.destructedStorage()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	// Write any storage changes in the state objects to their storage tries,
+	// then merge the dirty nodes into the global set in a deterministic order
+	sort.Slice(objs, func(i, j int) bool {
+		return bytes.Compare(objs[i].addrHash[:], objs[j].addrHash[:]) < 0
+	})
+	var (
+		sets = make([]*trie.NodeSet, len(objs))
+		errs = make([]error, len(objs))
+	)
+	s.forEachStorage(objs, func(i int, obj *stateObject) {
+		sets[i], errs[i] = obj.commitTrie(s.db)
+	})
+	for i, set := range sets {
+		if errs[i] != nil {
+			return common.Hash{}, errs[i]
+		}
+		// Wipe the stale nodes of a storage trie recreated after destruction
+		if paths, ok := wipes[objs[i].addrHash]; ok {
+			if set == nil {
+				set = trie.NewNodeSet(objs[i].addrHash, nil)
 			}
-			// Merge the dirty nodes of storage trie into global set
-			if set != nil {
-				if err := nodes.Merge(set); err != nil {
-					return common.Hash{}, err
-				}
-				updates, deleted := set.Size()
-				storageTrieNodesUpdated += updates
-				storageTrieNodesDeleted += deleted
+			for _, path := range paths {
+				set.AddDeleted(path)
+			}
+			delete(wipes, objs[i].addrHash)
+		}
+		// Merge the dirty nodes of storage trie into global set
+		if set != nil {
+			if err := nodes.Merge(set); err != nil {
+				return common.Hash{}, err
 			}
+			updates, deleted := set.Size()
+			storageTrieNodesUpdated += updates
+			storageTrieNodesDeleted += deleted
 		}
-		// If the contract is destructed, the storage is still left in the
-		// database as dangling data. Theoretically it's should be wiped from
-		// database as well, but in hash-based-scheme it's extremely hard to
-		// determine that if the trie nodes are also referenced by other storage,
-		// and in path-based-scheme some technical challenges are still unsolved.
-		// Although it won't affect the correctness but please fix it TODO(rjl493456442).
+	}
+	// Wipe the storage tries of the contracts destructed for good
+	for owner, paths := range wipes {
+		set := trie.NewNodeSet(owner, nil)
+		for _, path := range paths {
+			set.AddDeleted(path)
+		}
+		if err := nodes.Merge(set); err != nil {
+			return common.Hash{}, err
+		}
+		storageTrieNodesDeleted += len(paths)
 	}
 	if len(s.stateObjectsDirty) > 0 {
 		s.stateObjectsDirty = make(map[common.Address]struct{})
@@ -1084,7 +1330,7 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 	}
 	if root != origin {
 		start := time.Now()
-		if err := s.db.TrieDB().Update(nodes); err != nil {
+		if err := s.db.TrieDB().Update(root, origin, nodes); err != nil {
 			return common.Hash{}, err
 		}
 		s.originalRoot = root
@@ -1171,6 +1417,24 @@ func (s *StateDB) SlotInAccessList(addr common.Address, slot common.Hash) (addre
 	return s.accessList.Contains(addr, slot)
 }
 
+// SetArchive sets the accounts and storage slots living in the cold shards,
+// against which archived accesses are charged. The archive isn't carried over
+// to copies of the state, as it may be bound to the state it is set on.
+func (s *StateDB) SetArchive(archive Archive) {
+	s.archive = archive
+}
+
+// AddressInArchive returns true if the given address lives in a cold shard.
+func (s *StateDB) AddressInArchive(addr common.Address) bool {
+	return s.archive != nil && s.archive.IsArchived(addr)
+}
+
+// SlotInArchive returns true if the given (address, slot)-tuple lives in a
+// cold shard.
+func (s *StateDB) SlotInArchive(addr common.Address, slot common.Hash) bool {
+	return s.archive != nil && s.archive.IsSlotArchived(addr, slot)
+}
+
 // convertAccountSet converts a provided account set from address keyed to hash keyed.
 func (s *StateDB) convertAccountSet(set map[common.Address]struct{}) map[common.Hash]struct{} {
 	ret := make(map[common.Hash]struct{})
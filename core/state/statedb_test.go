@@ -24,6 +24,7 @@ import (
 	"math/big"
 	"math/rand"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -32,6 +33,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
 )
 
 // Tests that updating a state trie does not leak any database writes prior to
@@ -998,3 +1000,95 @@ func TestStateDBTransientStorage(t *testing.T) {
 		t.Fatalf("transient storage mismatch: have %x, want %x", got, value)
 	}
 }
+
+// Tests that the dirty accounts span all transactions since the last commit,
+// including deleted accounts, and are reset by the commit.
+func TestDirtyAccounts(t *testing.T) {
+	var (
+		state, _ = New(types.EmptyRootHash, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		addr1    = common.Address{0x01}
+		addr2    = common.Address{0x02}
+		addr3    = common.Address{0x03}
+	)
+	state.AddBalance(addr1, big.NewInt(1))
+	state.AddBalance(addr3, big.NewInt(1))
+	root, _ := state.Commit(true)
+	state, _ = New(root, state.db, nil)
+
+	state.AddBalance(addr1, big.NewInt(1))
+	state.Finalise(true)
+	state.AddBalance(addr2, big.NewInt(2))
+	state.Suicide(addr3)
+	state.GetBalance(common.Address{0x04})
+
+	dirty := state.DirtyAccounts(true)
+	sort.Slice(dirty, func(i, j int) bool { return bytes.Compare(dirty[i][:], dirty[j][:]) < 0 })
+	if want := []common.Address{addr1, addr2, addr3}; !reflect.DeepEqual(dirty, want) {
+		t.Fatalf("dirty accounts mismatch: have %x, want %x", dirty, want)
+	}
+	if state.Exist(addr3) {
+		t.Fatal("deleted account still exists")
+	}
+	if _, err := state.Commit(true); err != nil {
+		t.Fatal(err)
+	}
+	if dirty := state.DirtyAccounts(true); len(dirty) != 0 {
+		t.Fatalf("dirty accounts after commit: %x", dirty)
+	}
+}
+
+// Tests that hashing and committing the storage tries concurrently yields the
+// same state as doing it sequentially.
+func TestStorageWorkers(t *testing.T) {
+	build := func(workers int) (common.Hash, common.Hash, ethdb.Database) {
+		disk := rawdb.NewMemoryDatabase()
+		state, _ := New(types.EmptyRootHash, NewDatabase(disk), nil)
+		state.SetStorageWorkers(workers)
+
+		for i := 0; i < 64; i++ {
+			addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+			state.SetNonce(addr, 1)
+			for j := 0; j < i; j++ {
+				state.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i*j+1))))
+			}
+		}
+		intermediate := state.IntermediateRoot(false)
+
+		// Delete some of the slots before committing
+		for i := 0; i < 64; i += 3 {
+			addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+			for j := 0; j < i; j += 2 {
+				state.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.Hash{})
+			}
+		}
+		root, err := state.Commit(false)
+		if err != nil {
+			t.Fatalf("failed to commit state with %d workers: %v", workers, err)
+		}
+		if err := state.Database().TrieDB().Commit(root, false); err != nil {
+			t.Fatalf("failed to flush state with %d workers: %v", workers, err)
+		}
+		return intermediate, root, disk
+	}
+	wantIntermediate, wantRoot, wantDisk := build(1)
+	for _, workers := range []int{2, 8, 128} {
+		intermediate, root, disk := build(workers)
+		if intermediate != wantIntermediate {
+			t.Errorf("intermediate root mismatch with %d workers: have %x, want %x", workers, intermediate, wantIntermediate)
+		}
+		if root != wantRoot {
+			t.Errorf("root mismatch with %d workers: have %x, want %x", workers, root, wantRoot)
+		}
+		var have, want int
+		for it := disk.NewIterator(nil, nil); it.Next(); have++ {
+			if blob, _ := wantDisk.Get(it.Key()); !bytes.Equal(blob, it.Value()) {
+				t.Fatalf("database entry %x mismatch with %d workers", it.Key(), workers)
+			}
+		}
+		for it := wantDisk.NewIterator(nil, nil); it.Next(); want++ {
+		}
+		if have != want {
+			t.Errorf("database size mismatch with %d workers: have %d, want %d", workers, have, want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Witness is the set of trie nodes and contract codes a block reads from its
+// parent state, which lets a node execute the block without holding that
+// state. Both are identified by their hash.
+type Witness struct {
+	Nodes [][]byte
+	Codes [][]byte
+}
+
+// witnessStore serves the nodes and codes of a witness on top of a local
+// key-value store. Writes go to the local store.
+type witnessStore struct {
+	ethdb.KeyValueStore
+	nodes map[common.Hash][]byte
+	codes map[common.Hash][]byte
+}
+
+func (s *witnessStore) lookup(key []byte) ([]byte, bool) {
+	if len(key) == common.HashLength {
+		blob, ok := s.nodes[common.BytesToHash(key)]
+		return blob, ok
+	}
+	if ok, hash := rawdb.IsCodeKey(key); ok {
+		blob, ok := s.codes[common.BytesToHash(hash)]
+		return blob, ok
+	}
+	return nil, false
+}
+
+func (s *witnessStore) Has(key []byte) (bool, error) {
+	if _, ok := s.lookup(key); ok {
+		return true, nil
+	}
+	return s.KeyValueStore.Has(key)
+}
+
+func (s *witnessStore) Get(key []byte) ([]byte, error) {
+	if blob, ok := s.lookup(key); ok {
+		return blob, nil
+	}
+	return s.KeyValueStore.Get(key)
+}
+
+// NewWitnessDatabase creates a state database that reads the trie nodes and
+// contract codes from the witness and from the state held in the local store,
+// such as the hot state of a stateless verifier. It has no snapshot or cache
+// in front of either, so anything missing from both fails with a missing node
+// error rather than being served from elsewhere. The changes committed to the
+// database are written to the local store.
+func NewWitnessDatabase(local ethdb.KeyValueStore, witness *Witness) Database {
+	store := &witnessStore{
+		KeyValueStore: local,
+		nodes:         make(map[common.Hash][]byte, len(witness.Nodes)),
+		codes:         make(map[common.Hash][]byte, len(witness.Codes)),
+	}
+	for _, blob := range witness.Nodes {
+		store.nodes[crypto.Keccak256Hash(blob)] = blob
+	}
+	for _, code := range witness.Codes {
+		store.codes[crypto.Keccak256Hash(code)] = code
+	}
+	db := rawdb.NewDatabase(store)
+	return NewDatabaseWithNodeDB(db, trie.NewDatabase(db))
+}
+
+// errReadOnlyStore is returned if a write reaches a recording store.
+var errReadOnlyStore = errors.New("recording store is read-only")
+
+// recordingStore records the trie nodes and contract codes read from a
+// key-value store. Trie nodes are read through the trie database holding the
+// state, so those it only holds in memory are visible too. Writes are
+// rejected.
+type recordingStore struct {
+	ethdb.KeyValueStore
+	triedb *trie.Database
+
+	lock  sync.Mutex
+	nodes map[common.Hash][]byte
+	codes map[common.Hash][]byte
+}
+
+func (s *recordingStore) Get(key []byte) ([]byte, error) {
+	var (
+		blob []byte
+		err  error
+	)
+	if len(key) == common.HashLength {
+		blob, err = s.triedb.Node(common.BytesToHash(key))
+	} else {
+		blob, err = s.KeyValueStore.Get(key)
+	}
+	if err != nil || len(blob) == 0 {
+		return blob, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(key) == common.HashLength {
+		s.nodes[common.BytesToHash(key)] = common.CopyBytes(blob)
+	} else if ok, hash := rawdb.IsCodeKey(key); ok {
+		s.codes[common.BytesToHash(hash)] = common.CopyBytes(blob)
+	}
+	return blob, nil
+}
+
+func (s *recordingStore) Put(key []byte, value []byte) error {
+	return errReadOnlyStore
+}
+
+func (s *recordingStore) Delete(key []byte) error {
+	return errReadOnlyStore
+}
+
+// RecordingDatabase is a state database that records the trie nodes and
+// contract codes read from its store, to build the witness of a block.
+type RecordingDatabase struct {
+	Database
+	store *recordingStore
+}
+
+// NewRecordingDatabase creates a read-only state database over the state held
+// by the hash-based trie database triedb on top of db, recording everything
+// read from it. The state doesn't need to be flushed to disk first.
+func NewRecordingDatabase(db ethdb.KeyValueStore, triedb *trie.Database) *RecordingDatabase {
+	store := &recordingStore{
+		KeyValueStore: db,
+		triedb:        triedb,
+		nodes:         make(map[common.Hash][]byte),
+		codes:         make(map[common.Hash][]byte),
+	}
+	diskdb := rawdb.NewDatabase(store)
+	return &RecordingDatabase{
+		Database: NewDatabaseWithNodeDB(diskdb, trie.NewDatabase(diskdb)),
+		store:    store,
+	}
+}
+
+// Witness returns the trie nodes and contract codes read so far, sorted by
+// hash.
+func (db *RecordingDatabase) Witness() *Witness {
+	db.store.lock.Lock()
+	defer db.store.lock.Unlock()
+
+	return &Witness{
+		Nodes: sortedBlobs(db.store.nodes),
+		Codes: sortedBlobs(db.store.codes),
+	}
+}
+
+func sortedBlobs(blobs map[common.Hash][]byte) [][]byte {
+	hashes := make([]common.Hash, 0, len(blobs))
+	for hash := range blobs {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+
+	sorted := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		sorted[i] = blobs[hash]
+	}
+	return sorted
+}
@@ -0,0 +1,376 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ParallelStateProcessor is a Processor executing the transactions of a block
+// optimistically in parallel, in the spirit of Block-STM. Every transaction is
+// first executed speculatively on its own copy of the state at the start of
+// the block, recording the accounts and storage slots it accesses. The outcomes
+// are then committed in order: the changes of a transaction which accessed
+// nothing written by the transactions before it are merged into the state,
+// the other transactions are executed again on the state itself. The receipts
+// and the resulting state are thus always the same as with the sequential
+// StateProcessor.
+//
+// The credits of the coinbase are held aside during the speculative execution,
+// otherwise the fees paid by every transaction would make them all conflict.
+//
+// ParallelStateProcessor implements Processor.
+type ParallelStateProcessor struct {
+	config     *params.ChainConfig // Chain configuration options
+	bc         *BlockChain         // Canonical block chain
+	sequential *StateProcessor     // Processor falling back to sequential execution
+	workers    int                 // Number of transactions executed speculatively at once
+}
+
+// NewParallelStateProcessor initialises a new ParallelStateProcessor executing
+// the given number of transactions speculatively at once.
+func NewParallelStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consensus.Engine, workers int) *ParallelStateProcessor {
+	return &ParallelStateProcessor{
+		config:     config,
+		bc:         bc,
+		sequential: NewStateProcessor(config, bc, engine),
+		workers:    workers,
+	}
+}
+
+// Process processes the state changes according to the Ethereum rules, exactly
+// as StateProcessor.Process does, executing the transactions speculatively in
+// parallel where possible.
+//
+// Blocks charging state rent, making every transaction write the rent registry,
+// and executions being traced, either through an EVM tracer or a state access
+// tracer, are processed sequentially.
+func (p *ParallelStateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, uint64, error) {
+	txs := block.Transactions()
+	if p.workers <= 1 || cfg.Debug || statedb.AccessTracer() != nil || p.config.IsStateRent(block.Number()) {
+		return p.sequential.Process(block, statedb, cfg)
+	}
+	var (
+		receipts    types.Receipts
+		usedGas     = new(uint64)
+		header      = block.Header()
+		blockHash   = block.Hash()
+		blockNumber = block.Number()
+		allLogs     []*types.Log
+		gp          = new(GasPool).AddGas(block.GasLimit())
+	)
+	// Mutate the block and state according to any hard-fork specs
+	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	// Start executing the transactions speculatively on copies of the state
+	base := statedb.Copy()
+	base.StopPrefetcher()
+
+	specs, stop := p.speculate(header, txs, base, cfg)
+	defer stop()
+
+	// Commit the transactions in order, executing again the conflicting ones
+	var (
+		blockContext = NewEVMBlockContext(header, p.bc, nil)
+		vmenv        = vm.NewEVM(blockContext, vm.TxContext{}, statedb, p.config, cfg)
+		written      = newWriteSet()
+	)
+	for i, tx := range txs {
+		spec := specs[i]
+		<-spec.done
+
+		if spec.msgErr != nil {
+			return nil, nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), spec.msgErr)
+		}
+		statedb.SetTxContext(tx.Hash(), i)
+
+		var receipt *types.Receipt
+		if spec.valid(written, header.Coinbase) && gp.Gas() >= spec.msg.GasLimit {
+			receipt = p.commit(spec, statedb, header.Coinbase, gp, blockNumber, blockHash, tx, usedGas)
+			written.add(spec.accesses)
+			if spec.fees != nil {
+				written.accounts[header.Coinbase] = struct{}{}
+			}
+		} else {
+			recorder := state.NewAccessRecorder()
+			statedb.SetAccessTracer(recorder)
+
+			var err error
+			receipt, err = applyTransaction(spec.msg, p.config, gp, statedb, blockNumber, blockHash, tx, usedGas, vmenv)
+			statedb.SetAccessTracer(nil)
+			if err != nil {
+				return nil, nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+			}
+			written.add(recorder.Accesses())
+		}
+		spec.state = nil // Release the copy of the state
+
+		receipts = append(receipts, receipt)
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+	// Fail if Shanghai not enabled and len(withdrawals) is non-zero.
+	withdrawals := block.Withdrawals()
+	if len(withdrawals) > 0 && !p.config.IsShanghai(block.Time()) {
+		return nil, nil, 0, fmt.Errorf("withdrawals before shanghai")
+	}
+	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards).
+	statedb.SetTxContext(common.Hash{}, len(txs))
+	p.sequential.engine.Finalize(p.bc, header, statedb, txs, block.Uncles(), withdrawals)
+
+	return receipts, allLogs, *usedGas, nil
+}
+
+// speculate starts executing the transactions on copies of the given state,
+// on a bounded pool of workers picking them in order. It returns the outcomes,
+// each one becoming available once its done channel is closed, and a function
+// stopping the execution of the remaining transactions.
+func (p *ParallelStateProcessor) speculate(header *types.Header, txs types.Transactions, base *state.StateDB, cfg vm.Config) ([]*speculativeTx, func()) {
+	var (
+		specs = make([]*speculativeTx, len(txs))
+		tasks = make(chan int, len(txs))
+		quit  = make(chan struct{})
+		pend  sync.WaitGroup
+	)
+	for i := range txs {
+		specs[i] = &speculativeTx{done: make(chan struct{})}
+		tasks <- i
+	}
+	close(tasks)
+
+	workers := p.workers
+	if workers > len(txs) {
+		workers = len(txs)
+	}
+	for i := 0; i < workers; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+
+			// The block context caches the ancestor hashes, it can't be shared
+			var (
+				signer       = types.MakeSigner(p.config, header.Number)
+				blockContext = NewEVMBlockContext(header, p.bc, nil)
+			)
+			for i := range tasks {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				specs[i].execute(p.config, signer, header, blockContext, txs[i], i, base, cfg)
+				close(specs[i].done)
+			}
+		}()
+	}
+	stop := func() {
+		close(quit)
+		pend.Wait()
+	}
+	return specs, stop
+}
+
+// speculativeTx is the outcome of the speculative execution of a transaction.
+type speculativeTx struct {
+	msg    *Message
+	msgErr error // Failure to derive the message, the transaction is invalid
+
+	state    *state.StateDB      // Copy of the state the transaction was executed on
+	result   *ExecutionResult    // Result of the execution, nil if failed
+	err      error               // Failure to execute the message on the copy
+	accesses []types.StateAccess // Accounts and storage slots accessed
+	fees     *big.Int            // Credits of the coinbase held aside, nil if not credited
+
+	done chan struct{} // Closed once the execution is over
+}
+
+// execute executes the transaction on a copy of the given state, recording the
+// state accesses made.
+func (spec *speculativeTx) execute(config *params.ChainConfig, signer types.Signer, header *types.Header, blockContext vm.BlockContext, tx *types.Transaction, index int, base *state.StateDB, cfg vm.Config) {
+	spec.msg, spec.msgErr = TransactionToMessage(tx, signer, header.BaseFee)
+	if spec.msgErr != nil {
+		return
+	}
+	var (
+		statedb  = base.Copy()
+		recorder = state.NewAccessRecorder()
+		overlay  = &coinbaseOverlay{StateDB: statedb, coinbase: header.Coinbase}
+	)
+	statedb.SetAccessTracer(recorder)
+	statedb.SetTxContext(tx.Hash(), index)
+
+	evm := vm.NewEVM(blockContext, NewEVMTxContext(spec.msg), overlay, config, cfg)
+	spec.result, spec.err = ApplyMessage(evm, spec.msg, new(GasPool).AddGas(header.GasLimit))
+	if spec.err == nil {
+		statedb.Finalise(config.IsByzantium(header.Number) || config.IsEIP158(header.Number))
+	}
+	spec.state, spec.accesses, spec.fees = statedb, recorder.Accesses(), overlay.fees
+}
+
+// valid returns whether the speculative execution is the one the transaction
+// would have on the state once the given writes are made: it succeeded and
+// accessed nothing written. The coinbase must not have been accessed either
+// if credited, as its balance was observed without the credits.
+func (spec *speculativeTx) valid(written *writeSet, coinbase common.Address) bool {
+	if spec.err != nil || written.conflicts(spec.accesses) {
+		return false
+	}
+	if spec.fees != nil {
+		for _, access := range spec.accesses {
+			if access.Address == coinbase {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// commit merges the changes of a transaction executed speculatively into the
+// state, crediting the coinbase, and creates its receipt.
+func (p *ParallelStateProcessor) commit(spec *speculativeTx, statedb *state.StateDB, coinbase common.Address, gp *GasPool, blockNumber *big.Int, blockHash common.Hash, tx *types.Transaction, usedGas *uint64) *types.Receipt {
+	var (
+		addrs []common.Address
+		seen  = make(map[common.Address]struct{})
+	)
+	for _, access := range spec.accesses {
+		if _, ok := seen[access.Address]; ok || !access.Write {
+			continue
+		}
+		seen[access.Address] = struct{}{}
+		addrs = append(addrs, access.Address)
+	}
+	statedb.MergeTx(spec.state, addrs)
+	if spec.fees != nil {
+		statedb.AddBalance(coinbase, spec.fees)
+	}
+	// Update the state with pending changes, as applyTransaction does
+	var root []byte
+	if p.config.IsByzantium(blockNumber) {
+		statedb.Finalise(true)
+	} else {
+		root = statedb.IntermediateRoot(p.config.IsEIP158(blockNumber)).Bytes()
+	}
+	gp.SubGas(spec.result.UsedGas) // Covered by the gas limit of the message
+	*usedGas += spec.result.UsedGas
+
+	return makeReceipt(spec.msg, spec.result, statedb, blockNumber, blockHash, tx, *usedGas, root)
+}
+
+// coinbaseOverlay is a view of a state holding the credits of the coinbase aside
+// instead of writing them. The other accesses to the coinbase go through.
+type coinbaseOverlay struct {
+	*state.StateDB
+	coinbase common.Address
+
+	fees      *big.Int      // Credits held aside, nil if not credited
+	revisions []feeRevision // Credits at the snapshots of the state
+}
+
+// feeRevision is the credits held aside at a snapshot of the state.
+type feeRevision struct {
+	id   int
+	fees *big.Int
+}
+
+// AddBalance implements vm.StateDB, holding the credits of the coinbase aside.
+func (o *coinbaseOverlay) AddBalance(addr common.Address, amount *big.Int) {
+	if addr != o.coinbase {
+		o.StateDB.AddBalance(addr, amount)
+		return
+	}
+	fees := new(big.Int).Set(amount)
+	if o.fees != nil {
+		fees.Add(fees, o.fees)
+	}
+	o.fees = fees
+}
+
+// Snapshot implements vm.StateDB, tracking the credits held aside.
+func (o *coinbaseOverlay) Snapshot() int {
+	id := o.StateDB.Snapshot()
+	o.revisions = append(o.revisions, feeRevision{id: id, fees: o.fees})
+	return id
+}
+
+// RevertToSnapshot implements vm.StateDB, restoring the credits held aside.
+func (o *coinbaseOverlay) RevertToSnapshot(id int) {
+	o.StateDB.RevertToSnapshot(id)
+
+	idx := sort.Search(len(o.revisions), func(i int) bool {
+		return o.revisions[i].id >= id
+	})
+	o.fees = o.revisions[idx].fees
+	o.revisions = o.revisions[:idx]
+}
+
+// writeSet tracks the accounts written by the transactions committed so far.
+// Writes are tracked per account rather than per storage slot, as merging a
+// transaction executed speculatively replaces the whole accounts it wrote: a
+// later transaction writing another slot of the same account would otherwise
+// drop the earlier write.
+type writeSet struct {
+	accounts map[common.Address]struct{}
+}
+
+func newWriteSet() *writeSet {
+	return &writeSet{
+		accounts: make(map[common.Address]struct{}),
+	}
+}
+
+// add records the accounts written among the given accesses, storage writes
+// included.
+func (w *writeSet) add(accesses []types.StateAccess) {
+	for _, access := range accesses {
+		if access.Write {
+			w.accounts[access.Address] = struct{}{}
+		}
+	}
+}
+
+// conflicts returns whether any of the given accesses, reads or writes, is to
+// an account or to the storage of an account written.
+func (w *writeSet) conflicts(accesses []types.StateAccess) bool {
+	for _, access := range accesses {
+		if _, ok := w.accounts[access.Address]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SetParallelExecution sets the number of transactions of the blocks imported
+// from now on executed speculatively at once, one or less to execute them
+// sequentially. It must not be called concurrently with block imports.
+func (bc *BlockChain) SetParallelExecution(workers int) {
+	if workers > 1 {
+		bc.processor = NewParallelStateProcessor(bc.chainConfig, bc, bc.engine, workers)
+	} else {
+		bc.processor = NewStateProcessor(bc.chainConfig, bc, bc.engine)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	// parallelCounter increments a shared counter, stores the block number in
+	// a slot of the caller and logs the caller.
+	parallelCounter = common.FromHex("6000546001016000554333553360005260" + "2a60206000a100")
+
+	// parallelRecorder stores the block number in a slot of the caller and logs
+	// the caller.
+	parallelRecorder = common.FromHex("43335533600052602a60206000a100")
+
+	// parallelCoinbaseReader stores the balance of the coinbase in a slot of the
+	// caller.
+	parallelCoinbaseReader = common.FromHex("4131335500")
+
+	// parallelTipper sends a wei to the coinbase, and reverts if called with a
+	// value, or with no value before byzantium.
+	parallelTipper = common.FromHex("60006000600060006001415af150341560185760006000fd5b00")
+
+	// parallelDestructor self-destructs to the coinbase.
+	parallelDestructor = common.FromHex("41ff")
+
+	// parallelInitCode sets a slot of the created contract and deploys no code.
+	parallelInitCode = common.FromHex("600160005560006000f3")
+)
+
+// parallelTestChain generates a chain whose transactions conflict in various
+// ways: nonces of the same sender, shared storage slots, transfers to and from
+// the coinbase, reads of the coinbase, self-destructs and reverted calls.
+func parallelTestChain(config *params.ChainConfig, blocks int) (*Genesis, []*types.Block) {
+	var (
+		keys      = make([]*ecdsa.PrivateKey, 8)
+		addrs     = make([]common.Address, len(keys))
+		counter   = common.HexToAddress("0xc0")
+		recorder  = common.HexToAddress("0xc1")
+		reader    = common.HexToAddress("0xc2")
+		tipper    = common.HexToAddress("0xc3")
+		destroyed = common.HexToAddress("0xc4")
+		funds     = new(big.Int).Mul(big.NewInt(1000), big.NewInt(params.Ether))
+		alloc     = GenesisAlloc{
+			counter:   {Code: parallelCounter, Balance: common.Big0},
+			recorder:  {Code: parallelRecorder, Balance: common.Big0},
+			reader:    {Code: parallelCoinbaseReader, Balance: common.Big0},
+			tipper:    {Code: parallelTipper, Balance: big.NewInt(params.Ether)},
+			destroyed: {Code: parallelDestructor, Balance: big.NewInt(params.Ether)},
+		}
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+		alloc[addrs[i]] = GenesisAccount{Balance: funds}
+	}
+	gspec := &Genesis{Config: config, GasLimit: 30_000_000, Alloc: alloc}
+
+	_, chain, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), blocks, func(i int, gen *BlockGen) {
+		signer := types.MakeSigner(config, gen.Number())
+		gasPrice := big.NewInt(params.GWei)
+		if fee := gen.header.BaseFee; fee != nil {
+			gasPrice = new(big.Int).Mul(fee, big.NewInt(2))
+		}
+		send := func(key *ecdsa.PrivateKey, to *common.Address, value int64, data []byte) {
+			var (
+				from  = crypto.PubkeyToAddress(key.PublicKey)
+				inner = &types.LegacyTx{Nonce: gen.TxNonce(from), GasPrice: gasPrice, Gas: 200_000, To: to, Value: big.NewInt(value), Data: data}
+			)
+			tx, err := types.SignNewTx(key, signer, inner)
+			if err != nil {
+				panic(err)
+			}
+			gen.AddTx(tx)
+		}
+		// Pay the fees to one of the senders in some blocks
+		if i%3 == 1 {
+			gen.SetCoinbase(addrs[0])
+		}
+		for j, key := range keys {
+			switch (i + j) % 6 {
+			case 0:
+				send(key, &counter, 0, nil)
+			case 1:
+				send(key, &recorder, 0, nil)
+			case 2:
+				send(key, &addrs[(j+1)%len(addrs)], 1000, nil)
+			case 3:
+				send(key, &tipper, int64(j%2), nil)
+			case 4:
+				send(key, nil, 0, parallelInitCode)
+			case 5:
+				send(key, &recorder, 0, nil)
+				send(key, &reader, 0, nil)
+			}
+		}
+		coinbase := gen.header.Coinbase
+		send(keys[i%len(keys)], &coinbase, params.Ether, nil)
+		if i == blocks/2 {
+			send(keys[0], &destroyed, 0, nil)
+			send(keys[1], &destroyed, 1, nil)
+		}
+	})
+	return gspec, chain
+}
+
+// checkParallelImport imports the chain sequentially and with the parallel
+// processor, checking the receipts are the same.
+func checkParallelImport(t *testing.T, gspec *Genesis, engine *ethash.Ethash, chain []*types.Block) {
+	t.Helper()
+
+	sequential, _ := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	defer sequential.Stop()
+	if n, err := sequential.InsertChain(chain); err != nil {
+		t.Fatalf("failed to import block %d sequentially: %v", n, err)
+	}
+	parallel, _ := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	defer parallel.Stop()
+	parallel.SetParallelExecution(4)
+	if n, err := parallel.InsertChain(chain); err != nil {
+		t.Fatalf("failed to import block %d in parallel: %v", n, err)
+	}
+	for _, block := range chain {
+		want, _ := json.Marshal(sequential.GetReceiptsByHash(block.Hash()))
+		have, _ := json.Marshal(parallel.GetReceiptsByHash(block.Hash()))
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("block %d: receipts mismatch:\nhave %s\nwant %s", block.NumberU64(), have, want)
+		}
+	}
+}
+
+// Tests that executing the transactions of generated blocks in parallel yields
+// the same receipts and state as executing them sequentially.
+func TestParallelProcessorGenerated(t *testing.T) {
+	t.Run("latest", func(t *testing.T) {
+		config := *params.TestChainConfig
+		gspec, chain := parallelTestChain(&config, 12)
+		checkParallelImport(t, gspec, ethash.NewFaker(), chain)
+	})
+	t.Run("frontier", func(t *testing.T) {
+		config := params.ChainConfig{ChainID: big.NewInt(1), Ethash: new(params.EthashConfig)}
+		gspec, chain := parallelTestChain(&config, 12)
+		checkParallelImport(t, gspec, ethash.NewFaker(), chain)
+	})
+	t.Run("forking", func(t *testing.T) {
+		config := params.ChainConfig{
+			ChainID:        big.NewInt(1),
+			HomesteadBlock: big.NewInt(0),
+			EIP150Block:    big.NewInt(0),
+			EIP155Block:    big.NewInt(0),
+			EIP158Block:    big.NewInt(4),
+			ByzantiumBlock: big.NewInt(8),
+			Ethash:         new(params.EthashConfig),
+		}
+		gspec, chain := parallelTestChain(&config, 12)
+		checkParallelImport(t, gspec, ethash.NewFaker(), chain)
+	})
+}
+
+// Tests that executing the transactions of a recorded chain in parallel yields
+// the same receipts and state as executing them sequentially.
+func TestParallelProcessorRecorded(t *testing.T) {
+	blob, err := os.ReadFile("../cmd/devp2p/internal/ethtest/testdata/genesis.json")
+	if err != nil {
+		t.Fatalf("failed to read genesis: %v", err)
+	}
+	gspec := new(Genesis)
+	if err := json.Unmarshal(blob, gspec); err != nil {
+		t.Fatalf("failed to decode genesis: %v", err)
+	}
+	file, err := os.Open("../cmd/devp2p/internal/ethtest/testdata/chain.rlp")
+	if err != nil {
+		t.Fatalf("failed to open chain: %v", err)
+	}
+	defer file.Close()
+
+	var (
+		stream = rlp.NewStream(file, 0)
+		chain  []*types.Block
+	)
+	for {
+		block := new(types.Block)
+		if err := stream.Decode(block); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatalf("failed to decode block %d: %v", len(chain), err)
+		}
+		if block.NumberU64() > 0 {
+			chain = append(chain, block)
+		}
+	}
+	checkParallelImport(t, gspec, ethash.NewFaker(), chain)
+}
+
+// Tests that the parallel processor rejects invalid blocks with the same errors
+// as the sequential one.
+func TestParallelProcessorErrors(t *testing.T) {
+	var (
+		config = params.TestChainConfig
+		signer = types.LatestSigner(config)
+		key, _ = crypto.GenerateKey()
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		other  = common.HexToAddress("0xc0")
+		gspec  = &Genesis{
+			Config: config,
+			Alloc:  GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+		}
+		genesis = gspec.MustCommit(rawdb.NewMemoryDatabase())
+	)
+	makeTx := func(nonce uint64, value *big.Int, gas uint64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, other, value, gas, big.NewInt(params.InitialBaseFee), nil), signer, key)
+		return tx
+	}
+	for i, txs := range []types.Transactions{
+		{makeTx(0, common.Big1, params.TxGas), makeTx(0, common.Big1, params.TxGas)},                      // nonce too low
+		{makeTx(0, common.Big1, params.TxGas), makeTx(2, common.Big1, params.TxGas)},                      // nonce too high
+		{makeTx(0, common.Big1, params.TxGas), makeTx(1, big.NewInt(params.Ether), params.TxGas)},         // insufficient funds
+		{makeTx(0, common.Big1, params.TxGas), makeTx(1, common.Big1, genesis.GasLimit()-params.TxGas+1)}, // gas limit reached
+	} {
+		block := GenerateBadBlock(genesis, ethash.NewFaker(), txs, config)
+
+		sequential, _ := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+		_, want := sequential.InsertChain(types.Blocks{block})
+		sequential.Stop()
+
+		parallel, _ := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+		parallel.SetParallelExecution(4)
+		_, have := parallel.InsertChain(types.Blocks{block})
+		parallel.Stop()
+
+		if want == nil {
+			t.Fatalf("test %d: invalid block accepted", i)
+		}
+		if have == nil || have.Error() != want.Error() {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, have, want)
+		}
+	}
+}
+
+// Tests that transactions writing different storage slots of the same contract
+// are not merged over each other.
+func TestParallelProcessorSameContract(t *testing.T) {
+	var (
+		recorder = common.HexToAddress("0xc1")
+		written  = newWriteSet()
+	)
+	written.add([]types.StateAccess{{Address: recorder, Storage: true, Slot: common.Hash{0x01}, Write: true}})
+	if !written.conflicts([]types.StateAccess{{Address: recorder, Storage: true, Slot: common.Hash{0x02}, Write: true}}) {
+		t.Fatal("write to another slot of a written contract not detected as a conflict")
+	}
+	var (
+		config = params.TestChainConfig
+		keys   = make([]*ecdsa.PrivateKey, 8)
+		alloc  = GenesisAlloc{recorder: {Code: parallelRecorder, Balance: common.Big0}}
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		alloc[crypto.PubkeyToAddress(keys[i].PublicKey)] = GenesisAccount{Balance: big.NewInt(params.Ether)}
+	}
+	gspec := &Genesis{Config: config, GasLimit: 30_000_000, Alloc: alloc}
+	_, chain, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 4, func(i int, gen *BlockGen) {
+		signer := types.MakeSigner(config, gen.Number())
+		for _, key := range keys {
+			inner := &types.LegacyTx{
+				Nonce:    gen.TxNonce(crypto.PubkeyToAddress(key.PublicKey)),
+				GasPrice: new(big.Int).Mul(gen.header.BaseFee, big.NewInt(2)),
+				Gas:      100_000,
+				To:       &recorder,
+			}
+			gen.AddTx(types.MustSignNewTx(key, signer, inner))
+		}
+	})
+	checkParallelImport(t, gspec, ethash.NewFaker(), chain)
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// RentRegistryAddress is the system account whose storage records, for every
+// account paying state rent, the height up to which its rent is paid and its
+// number of storage slots. The registry itself pays no rent.
+var RentRegistryAddress = common.HexToAddress("0x000000000000000000000000000000000000ec00")
+
+// rentPaidKey is the registry slot holding the paid-up height of an account.
+func rentPaidKey(addr common.Address) common.Hash {
+	return crypto.Keccak256Hash(addr.Bytes(), []byte{0})
+}
+
+// rentSlotsKey is the registry slot holding the storage slot count of an account.
+func rentSlotsKey(addr common.Address) common.Hash {
+	return crypto.Keccak256Hash(addr.Bytes(), []byte{1})
+}
+
+// RentPaidUntil returns the height up to which the account has paid rent, or
+// zero if it has never been charged.
+func RentPaidUntil(statedb vm.StateDB, addr common.Address) uint64 {
+	return statedb.GetState(RentRegistryAddress, rentPaidKey(addr)).Big().Uint64()
+}
+
+// RentSize returns the number of bytes the account is charged rent for: a
+// fixed account overhead, its code and its storage slots. Only the slots
+// written since state rent is active are counted.
+func RentSize(statedb vm.StateDB, addr common.Address) uint64 {
+	slots := statedb.GetState(RentRegistryAddress, rentSlotsKey(addr)).Big().Uint64()
+	return params.AccountRentSize + uint64(statedb.GetCodeSize(addr)) + slots*params.StorageSlotRentSize
+}
+
+// RentDue returns the rent the account owes at the given height.
+func RentDue(config *params.ChainConfig, statedb vm.StateDB, addr common.Address, number *big.Int) *big.Int {
+	paid := RentPaidUntil(statedb, addr)
+	if !config.IsStateRent(number) || paid == 0 || paid >= number.Uint64() {
+		return new(big.Int)
+	}
+	due := new(big.Int).SetUint64(number.Uint64() - paid)
+	due.Mul(due, new(big.Int).SetUint64(RentSize(statedb, addr)))
+	return due.Mul(due, config.StateRent.RentPerByte)
+}
+
+// RentExpired reports whether the account can't pay the rent it owes at the
+// given height. Such accounts are no longer worth keeping in the replicated hot
+// state and are eligible for colding; the chain itself doesn't delete them.
+func RentExpired(config *params.ChainConfig, statedb vm.StateDB, addr common.Address, number *big.Int) bool {
+	if !statedb.Exist(addr) {
+		return false
+	}
+	return statedb.GetBalance(addr).Cmp(RentDue(config, statedb, addr, number)) < 0
+}
+
+// rentArchive archives the accounts whose rent has run out, along with their
+// storage. Every node derives it from the rent registry, so the accounts
+// eligible for colding are charged the archived access costs on all of them.
+type rentArchive struct {
+	config  *params.ChainConfig
+	statedb vm.StateDB
+	number  *big.Int
+}
+
+func newRentArchive(config *params.ChainConfig, statedb vm.StateDB, number *big.Int) *rentArchive {
+	return &rentArchive{config: config, statedb: statedb, number: number}
+}
+
+// IsArchived implements state.Archive, returning whether the account can't pay
+// the rent it owes.
+func (a *rentArchive) IsArchived(addr common.Address) bool {
+	return RentExpired(a.config, a.statedb, addr, a.number)
+}
+
+// IsSlotArchived implements state.Archive, returning whether the account of the
+// slot is archived.
+func (a *rentArchive) IsSlotArchived(addr common.Address, slot common.Hash) bool {
+	return a.IsArchived(addr)
+}
+
+// setRentRecord writes a value of an account to the registry. The registry is
+// given a nonce, so that it isn't deleted as an empty account.
+func setRentRecord(statedb vm.StateDB, key common.Hash, value uint64) {
+	if statedb.GetNonce(RentRegistryAddress) == 0 {
+		statedb.SetNonce(RentRegistryAddress, 1)
+	}
+	statedb.SetState(RentRegistryAddress, key, common.BigToHash(new(big.Int).SetUint64(value)))
+}
+
+// chargeRent charges the accounts modified by the transaction the rent they
+// owe up to the current block, and updates their storage slot counts.
+func (st *StateTransition) chargeRent() {
+	for addr, delta := range st.state.StorageDeltas() {
+		chargeAccountRent(st.evm.ChainConfig(), st.state, addr, delta, st.evm.Context.BlockNumber.Uint64())
+	}
+}
+
+// ChargeRent charges an account the rent it owes up to the given height, as
+// done for the accounts modified by a transaction. It's meant for tools
+// replaying the accesses of a chain outside of the EVM.
+func ChargeRent(config *params.ChainConfig, statedb vm.StateDB, addr common.Address, number *big.Int) {
+	if config.IsStateRent(number) {
+		chargeAccountRent(config, statedb, addr, 0, number.Uint64())
+	}
+}
+
+// ResetRent drops the rent records of an account, so that its residency starts
+// over when it's next charged.
+func ResetRent(statedb vm.StateDB, addr common.Address) {
+	if RentPaidUntil(statedb, addr) != 0 {
+		setRentRecord(statedb, rentPaidKey(addr), 0)
+		setRentRecord(statedb, rentSlotsKey(addr), 0)
+	}
+}
+
+// chargeAccountRent charges an account the rent it owes up to the given height
+// and adds delta to its storage slot count. The residency of an account starts
+// when it is first charged. An account whose balance doesn't cover the rent
+// pays for as many blocks as it can, leaving its paid-up height behind the
+// chain.
+func chargeAccountRent(config *params.ChainConfig, statedb vm.StateDB, addr common.Address, delta int, number uint64) {
+	if addr == RentRegistryAddress {
+		return
+	}
+	var (
+		paidKey  = rentPaidKey(addr)
+		slotsKey = rentSlotsKey(addr)
+		paid     = RentPaidUntil(statedb, addr)
+	)
+	if statedb.HasSuicided(addr) || statedb.Empty(addr) {
+		// Gone at the end of the transaction, drop its records
+		ResetRent(statedb, addr)
+		return
+	}
+	if delta != 0 {
+		slots := int64(statedb.GetState(RentRegistryAddress, slotsKey).Big().Uint64()) + int64(delta)
+		if slots < 0 {
+			slots = 0 // slots written before state rent weren't counted
+		}
+		setRentRecord(statedb, slotsKey, uint64(slots))
+	}
+	if paid == 0 {
+		setRentRecord(statedb, paidKey, number)
+		return
+	}
+	if paid >= number {
+		return
+	}
+	perBlock := new(big.Int).SetUint64(RentSize(statedb, addr))
+	perBlock.Mul(perBlock, config.StateRent.RentPerByte)
+	if perBlock.Sign() == 0 {
+		setRentRecord(statedb, paidKey, number)
+		return
+	}
+	var (
+		balance = statedb.GetBalance(addr)
+		blocks  = new(big.Int).SetUint64(number - paid)
+	)
+	if affordable := new(big.Int).Div(balance, perBlock); affordable.Cmp(blocks) < 0 {
+		blocks = affordable
+	}
+	statedb.SubBalance(addr, new(big.Int).Mul(blocks, perBlock))
+	setRentRecord(statedb, paidKey, paid+blocks.Uint64())
+
+	// Only a drained account without nonce and code is empty and deleted at
+	// the end of the transaction; contracts and used accounts stay, with their
+	// rent in arrears
+	if statedb.Empty(addr) {
+		ResetRent(statedb, addr)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// rentTestChain generates a chain with state rent from block 1 on, calling gen
+// for every block, imports it and returns the state at its head.
+func rentTestChain(t *testing.T, alloc GenesisAlloc, blocks int, gen func(int, *BlockGen)) (*params.ChainConfig, *state.StateDB) {
+	t.Helper()
+
+	config := *params.TestChainConfig
+	config.StateRent = &params.StateRentConfig{Block: big.NewInt(1), RentPerByte: big.NewInt(1)}
+	gspec := &Genesis{Config: &config, Alloc: alloc}
+	_, chain, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), blocks, gen)
+
+	// Import the chain, which checks the state roots computed by the processor
+	blockchain, _ := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer blockchain.Stop()
+	if n, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	statedb, err := blockchain.State()
+	if err != nil {
+		t.Fatalf("failed to get state: %v", err)
+	}
+	return &config, statedb
+}
+
+func TestStateRentCharge(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		tenant   = common.Address{0xaa}
+		funds    = big.NewInt(params.Ether)
+		signer   = types.HomesteadSigner{}
+		perBlock = int64(params.AccountRentSize) // one wei per byte and block
+	)
+	alloc := GenesisAlloc{
+		sender: {Balance: funds},
+		tenant: {Balance: funds, Nonce: 1},
+	}
+	config, statedb := rentTestChain(t, alloc, 5, func(i int, b *BlockGen) {
+		// touch the tenant in the first and the last block
+		if i == 0 || i == 4 {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(sender), tenant, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	// Residency starts at block 1 and blocks 1 to 5 are paid at block 5
+	if paid := RentPaidUntil(statedb, tenant); paid != 5 {
+		t.Fatalf("tenant paid-up height mismatch: have %d, want 5", paid)
+	}
+	want := new(big.Int).Add(funds, big.NewInt(2-4*perBlock))
+	if balance := statedb.GetBalance(tenant); balance.Cmp(want) != 0 {
+		t.Fatalf("tenant balance mismatch: have %v, want %v", balance, want)
+	}
+	if RentExpired(config, statedb, tenant, big.NewInt(100)) {
+		t.Fatalf("funded tenant expired")
+	}
+	if statedb.GetNonce(RentRegistryAddress) != 1 {
+		t.Fatalf("rent registry not kept alive")
+	}
+}
+
+func TestStateRentStorage(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender = crypto.PubkeyToAddress(key.PublicKey)
+		store  = common.Address{0xcc}
+		// SSTORE(CALLDATALOAD(0), 1)
+		code   = common.Hex2Bytes("600160003555" + "00")
+		funds  = big.NewInt(params.Ether)
+		signer = types.HomesteadSigner{}
+	)
+	alloc := GenesisAlloc{
+		sender: {Balance: funds},
+		store:  {Balance: funds, Nonce: 1, Code: code},
+	}
+	_, statedb := rentTestChain(t, alloc, 3, func(i int, b *BlockGen) {
+		var slot common.Hash
+		switch i {
+		case 0:
+			slot[31] = 1
+		case 2:
+			slot[31] = 2
+		default:
+			return
+		}
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(sender), store, new(big.Int), 100000, b.header.BaseFee, slot[:]), signer, key)
+		b.AddTx(tx)
+	})
+	size := params.AccountRentSize + uint64(len(code)) + 2*params.StorageSlotRentSize
+	if have := RentSize(statedb, store); have != size {
+		t.Fatalf("rent size mismatch: have %d, want %d", have, size)
+	}
+	// Blocks 1 to 3 are charged at the size including the slot written in block 3
+	want := new(big.Int).Sub(funds, new(big.Int).SetUint64(2*size))
+	if balance := statedb.GetBalance(store); balance.Cmp(want) != 0 {
+		t.Fatalf("contract balance mismatch: have %v, want %v", balance, want)
+	}
+}
+
+func TestStateRentExpiry(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		poor     = common.Address{0xbb}
+		signer   = types.HomesteadSigner{}
+		perBlock = int64(params.AccountRentSize)
+	)
+	alloc := GenesisAlloc{
+		sender: {Balance: big.NewInt(params.Ether)},
+		poor:   {Balance: big.NewInt(10*perBlock + 20), Nonce: 1},
+	}
+	config, statedb := rentTestChain(t, alloc, 20, func(i int, b *BlockGen) {
+		if i == 0 || i == 19 {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(sender), poor, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	// The poor account, with the two transfers, could only pay blocks 1 to 11
+	if paid := RentPaidUntil(statedb, poor); paid != 11 {
+		t.Fatalf("paid-up height mismatch: have %d, want 11", paid)
+	}
+	if balance := statedb.GetBalance(poor); balance.Cmp(big.NewInt(22)) != 0 {
+		t.Fatalf("balance mismatch: have %v, want 22", balance)
+	}
+	if !RentExpired(config, statedb, poor, big.NewInt(20)) {
+		t.Fatalf("account with unpaid rent not reported expired")
+	}
+	if RentExpired(config, statedb, sender, big.NewInt(20)) {
+		t.Fatalf("funded sender reported expired")
+	}
+}
+
+// Tests that reading an account whose rent has run out is charged the archived
+// access cost once the archived access fork is active.
+func TestStateRentArchivedAccess(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		poor     = common.Address{0xbb}
+		rich     = common.Address{0xcc}
+		signer   = types.HomesteadSigner{}
+		perBlock = int64(params.AccountRentSize)
+	)
+	// PUSH20 addr BALANCE POP
+	balance := func(addr common.Address) []byte {
+		return append(append([]byte{byte(vm.PUSH20)}, addr[:]...), byte(vm.BALANCE), byte(vm.POP))
+	}
+	alloc := GenesisAlloc{
+		sender:            {Balance: big.NewInt(params.Ether)},
+		poor:              {Balance: big.NewInt(2 * perBlock), Nonce: 1},
+		rich:              {Balance: big.NewInt(params.Ether), Nonce: 1},
+		common.Address{1}: {Balance: big.NewInt(params.Ether), Code: balance(poor)},
+		common.Address{2}: {Balance: big.NewInt(params.Ether), Code: balance(rich)},
+	}
+	config := *params.TestChainConfig
+	config.StateRent = &params.StateRentConfig{Block: big.NewInt(1), RentPerByte: big.NewInt(1)}
+	config.ArchivedAccessBlock = big.NewInt(1)
+	gspec := &Genesis{Config: &config, Alloc: alloc}
+	_, _, receipts := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 10, func(i int, b *BlockGen) {
+		switch i {
+		case 0:
+			// start the residency of both accounts
+			for _, to := range []common.Address{poor, rich} {
+				tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(sender), to, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+				b.AddTx(tx)
+			}
+		case 9:
+			for _, to := range []common.Address{{1}, {2}} {
+				tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(sender), to, new(big.Int), 100000, b.header.BaseFee, nil), signer, key)
+				b.AddTx(tx)
+			}
+		}
+	})
+	archived, cold := receipts[9][0].GasUsed, receipts[9][1].GasUsed
+	if diff := archived - cold; diff != params.ArchivedAccountAccessCost-params.ColdAccountAccessCostEIP2929 {
+		t.Fatalf("archived access surcharge mismatch: have %d, want %d", diff, params.ArchivedAccountAccessCost-params.ColdAccountAccessCostEIP2929)
+	}
+}
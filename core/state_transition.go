@@ -362,6 +362,9 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 	// - prepare accessList(post-berlin)
 	// - reset transient storage(eip 1153)
 	st.state.Prepare(rules, msg.From, st.evm.Context.Coinbase, msg.To, vm.ActivePrecompiles(rules), msg.AccessList)
+	if rules.IsArchivedAccess {
+		st.state.SetArchive(newRentArchive(st.evm.ChainConfig(), st.state, st.evm.Context.BlockNumber))
+	}
 
 	var (
 		ret   []byte
@@ -396,6 +399,9 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 		fee.Mul(fee, effectiveTip)
 		st.state.AddBalance(st.evm.Context.Coinbase, fee)
 	}
+	if st.evm.ChainConfig().IsStateRent(st.evm.Context.BlockNumber) {
+		st.chargeRent()
+	}
 
 	return &ExecutionResult{
 		UsedGas:    st.gasUsed(),
@@ -0,0 +1,33 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// StateAccess is a read or write of an account or a storage slot made while
+// processing a block. Accesses made after the last transaction, e.g. paying
+// the block reward or the withdrawals, carry the number of transactions as
+// their index.
+type StateAccess struct {
+	TxIndex uint           `json:"transactionIndex"`
+	Address common.Address `json:"address"`
+	Storage bool           `json:"storage"` // whether a storage slot of the account was accessed
+	Slot    common.Hash    `json:"slot"`    // accessed slot, zero for account accesses
+	Write   bool           `json:"write"`
+}
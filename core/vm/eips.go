@@ -151,6 +151,23 @@ func enable2929(jt *JumpTable) {
 	jt[SELFDESTRUCT].dynamicGas = gasSelfdestructEIP2929
 }
 
+// enableArchivedAccess adds a third tier to the EIP-2929 access costs: the
+// first access to an account or storage slot living in an erasure-coded cold
+// shard is charged the archived access cost instead of the cold one, as it
+// costs network round-trips to the shard holders. The archived accounts are
+// those whose state rent has run out. It requires EIP-2929.
+func enableArchivedAccess(jt *JumpTable) {
+	jt[SLOAD].dynamicGas = gasSLoadArchived
+	jt[EXTCODECOPY].dynamicGas = gasExtCodeCopyArchived
+	jt[EXTCODESIZE].dynamicGas = gasAccountCheckArchived
+	jt[EXTCODEHASH].dynamicGas = gasAccountCheckArchived
+	jt[BALANCE].dynamicGas = gasAccountCheckArchived
+	jt[CALL].dynamicGas = gasCallArchived
+	jt[CALLCODE].dynamicGas = gasCallCodeArchived
+	jt[STATICCALL].dynamicGas = gasStaticCallArchived
+	jt[DELEGATECALL].dynamicGas = gasDelegateCallArchived
+}
+
 // enable3529 enabled "EIP-3529: Reduction in refunds":
 // - Removes refunds for selfdestructs
 // - Reduces refunds for SSTORE
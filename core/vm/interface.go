@@ -20,6 +20,7 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/params"
 )
@@ -69,6 +70,12 @@ type StateDB interface {
 	// AddSlotToAccessList adds the given (address,slot) to the access list. This operation is safe to perform
 	// even if the feature/fork is not active yet
 	AddSlotToAccessList(addr common.Address, slot common.Hash)
+	// SetArchive sets the accounts and slots living in the cold shards.
+	SetArchive(archive state.Archive)
+	// AddressInArchive returns whether the account lives in a cold shard.
+	AddressInArchive(addr common.Address) bool
+	// SlotInArchive returns whether the (address,slot) lives in a cold shard.
+	SlotInArchive(addr common.Address, slot common.Hash) bool
 	Prepare(rules params.Rules, sender, coinbase common.Address, dest *common.Address, precompiles []common.Address, txAccesses types.AccessList)
 
 	RevertToSnapshot(int)
@@ -76,6 +83,10 @@ type StateDB interface {
 
 	AddLog(*types.Log)
 	AddPreimage(common.Hash, []byte)
+
+	// StorageDeltas returns the accounts modified by the current transaction
+	// with the change in their number of non-empty storage slots.
+	StorageDeltas() map[common.Address]int
 }
 
 // CallContext provides a basic interface for the EVM calling conventions. The EVM
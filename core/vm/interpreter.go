@@ -80,6 +80,11 @@ func NewEVMInterpreter(evm *EVM) *EVMInterpreter {
 	default:
 		table = &frontierInstructionSet
 	}
+	if evm.chainRules.IsBerlin && evm.chainRules.IsArchivedAccess {
+		// Deep-copy jumptable to charge archived accesses on top of the fork
+		table = copyJumpTable(table)
+		enableArchivedAccess(table)
+	}
 	var extraEips []int
 	if len(evm.Config.ExtraEips) > 0 {
 		// Deep-copy jumptable to prevent modification of opcodes in other tables
@@ -25,6 +25,16 @@ import (
 // LookupInstructionSet returns the instructionset for the fork configured by
 // the rules.
 func LookupInstructionSet(rules params.Rules) (JumpTable, error) {
+	jt, err := lookupForkInstructionSet(rules)
+	if rules.IsBerlin && rules.IsArchivedAccess {
+		enableArchivedAccess(&jt)
+	}
+	return jt, err
+}
+
+// lookupForkInstructionSet returns the instructionset for the hard fork
+// configured by the rules.
+func lookupForkInstructionSet(rules params.Rules) (JumpTable, error) {
 	switch {
 	case rules.IsPrague:
 		return newShanghaiInstructionSet(), errors.New("prague-fork not defined yet")
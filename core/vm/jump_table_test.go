@@ -17,8 +17,15 @@
 package vm
 
 import (
+	"math/big"
+	"reflect"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,3 +40,85 @@ func TestJumpTableCopy(t *testing.T) {
 	require.Equal(t, uint64(100), deepCopy[SLOAD].constantGas)
 	require.Equal(t, uint64(0), tbl[SLOAD].constantGas)
 }
+
+// testArchive archives the accounts in the set along with all their slots.
+type testArchive map[common.Address]bool
+
+func (a testArchive) IsArchived(addr common.Address) bool { return a[addr] }
+
+func (a testArchive) IsSlotArchived(addr common.Address, slot common.Hash) bool { return a[addr] }
+
+// TestArchivedAccessGas tests that the archived access fork charges the first
+// access to cold-shard accounts and slots the archived costs, and leaves the
+// accesses to the hot state at their EIP-2929 costs.
+func TestArchivedAccessGas(t *testing.T) {
+	var (
+		hot      = common.HexToAddress("0x1111111111111111111111111111111111111111")
+		archived = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	)
+	balance := func(addr common.Address) string {
+		return "0x73" + common.Bytes2Hex(addr[:]) + "3150" // PUSH20 addr BALANCE POP
+	}
+	call := func(addr common.Address) string {
+		return "0x6000600060006000600073" + common.Bytes2Hex(addr[:]) + "6000f150" // CALL(0, addr, 0, 0, 0, 0, 0) POP
+	}
+	sload := "0x60005450" // PUSH1 0 SLOAD POP
+
+	tests := []struct {
+		fork     bool
+		contract common.Address
+		code     string
+		used     uint64
+	}{
+		{false, hot, balance(archived), 3 + params.ColdAccountAccessCostEIP2929 + 2},
+		{true, hot, balance(hot), 3 + params.ColdAccountAccessCostEIP2929 + 2},
+		{true, hot, balance(archived), 3 + params.ArchivedAccountAccessCost + 2},
+		{false, hot, call(archived), 7*3 + params.ColdAccountAccessCostEIP2929 + 2},
+		{true, hot, call(hot), 7*3 + params.ColdAccountAccessCostEIP2929 + 2},
+		{true, hot, call(archived), 7*3 + params.ArchivedAccountAccessCost + 2},
+		{false, archived, sload, 3 + params.ColdSloadCostEIP2929 + 2},
+		{true, hot, sload, 3 + params.ColdSloadCostEIP2929 + 2},
+		{true, archived, sload, 3 + params.ArchivedSloadCost + 2},
+	}
+	for i, tt := range tests {
+		config := *params.AllEthashProtocolChanges
+		if tt.fork {
+			config.ArchivedAccessBlock = big.NewInt(0)
+		}
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedb.SetArchive(testArchive{archived: true})
+		statedb.SetCode(tt.contract, hexutil.MustDecode(tt.code))
+		vmctx := BlockContext{
+			BlockNumber: big.NewInt(0),
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		}
+		vmenv := NewEVM(vmctx, TxContext{}, statedb, &config, Config{})
+
+		_, gas, err := vmenv.Call(AccountRef(common.Address{}), tt.contract, nil, 100000, new(big.Int))
+		if err != nil {
+			t.Fatalf("test %d: call failed: %v", i, err)
+		}
+		if used := 100000 - gas; used != tt.used {
+			t.Errorf("test %d: gas used mismatch: have %v, want %v", i, used, tt.used)
+		}
+	}
+}
+
+// TestArchivedAccessJumpTable tests that the archived access costs are only
+// layered onto the instruction set once the fork and EIP-2929 are active.
+func TestArchivedAccessJumpTable(t *testing.T) {
+	berlin, _ := LookupInstructionSet(params.Rules{IsBerlin: true})
+	archived, _ := LookupInstructionSet(params.Rules{IsBerlin: true, IsArchivedAccess: true})
+	istanbul, _ := LookupInstructionSet(params.Rules{IsIstanbul: true, IsArchivedAccess: true})
+
+	for _, op := range []OpCode{SLOAD, BALANCE, EXTCODESIZE, EXTCODEHASH, EXTCODECOPY, CALL, CALLCODE, STATICCALL, DELEGATECALL} {
+		require.Equal(t, berlin[op].constantGas, archived[op].constantGas, op.String())
+		require.NotEqual(t, reflect.ValueOf(berlin[op].dynamicGas).Pointer(), reflect.ValueOf(archived[op].dynamicGas).Pointer(), op.String())
+	}
+	require.Equal(t, reflect.ValueOf(berlin[SSTORE].dynamicGas).Pointer(), reflect.ValueOf(archived[SSTORE].dynamicGas).Pointer())
+	require.NotEqual(t, reflect.ValueOf(istanbul[SLOAD].dynamicGas).Pointer(), reflect.ValueOf(archived[SLOAD].dynamicGas).Pointer())
+
+	// The shared berlin table must be left untouched
+	require.Equal(t, reflect.ValueOf(gasSLoadEIP2929).Pointer(), reflect.ValueOf(berlinInstructionSet[SLOAD].dynamicGas).Pointer())
+}
@@ -242,3 +242,104 @@ func makeSelfdestructGasFn(refundsEnabled bool) gasFunc {
 	}
 	return gasFunc
 }
+
+// coldAccountCost returns the cost of the first access to an account within a
+// transaction: COLD_ACCOUNT_ACCESS_COST, or the archived access cost if the
+// account has to be fetched from a cold shard.
+func coldAccountCost(evm *EVM, addr common.Address) uint64 {
+	if evm.StateDB.AddressInArchive(addr) {
+		return params.ArchivedAccountAccessCost
+	}
+	return params.ColdAccountAccessCostEIP2929
+}
+
+// gasSLoadArchived calculates dynamic gas for SLOAD like EIP-2929, but charges
+// the archived sload cost instead of COLD_SLOAD_COST if the slot lives in a
+// cold shard.
+func gasSLoadArchived(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	loc := stack.peek()
+	slot := common.Hash(loc.Bytes32())
+	// Check slot presence in the access list
+	if _, slotPresent := evm.StateDB.SlotInAccessList(contract.Address(), slot); !slotPresent {
+		// If the caller cannot afford the cost, this change will be rolled back
+		evm.StateDB.AddSlotToAccessList(contract.Address(), slot)
+		if evm.StateDB.SlotInArchive(contract.Address(), slot) {
+			return params.ArchivedSloadCost, nil
+		}
+		return params.ColdSloadCostEIP2929, nil
+	}
+	return params.WarmStorageReadCostEIP2929, nil
+}
+
+// gasExtCodeCopyArchived implements extcodecopy like EIP-2929, charging the
+// archived access cost for targets living in a cold shard.
+func gasExtCodeCopyArchived(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	// memory expansion first (dynamic part of pre-2929 implementation)
+	gas, err := gasExtCodeCopy(evm, contract, stack, mem, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	addr := common.Address(stack.peek().Bytes20())
+	// Check slot presence in the access list
+	if !evm.StateDB.AddressInAccessList(addr) {
+		evm.StateDB.AddAddressToAccessList(addr)
+		var overflow bool
+		// We charge (cold-warm), since 'warm' is already charged as constantGas
+		if gas, overflow = math.SafeAdd(gas, coldAccountCost(evm, addr)-params.WarmStorageReadCostEIP2929); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+	return gas, nil
+}
+
+// gasAccountCheckArchived is gasEip2929AccountCheck charging the archived
+// access cost for accounts living in a cold shard. It is used by extcodehash,
+// extcodesize and (ext) balance.
+func gasAccountCheckArchived(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	addr := common.Address(stack.peek().Bytes20())
+	// Check slot presence in the access list
+	if !evm.StateDB.AddressInAccessList(addr) {
+		// If the caller cannot afford the cost, this change will be rolled back
+		evm.StateDB.AddAddressToAccessList(addr)
+		// The warm storage read cost is already charged as constantGas
+		return coldAccountCost(evm, addr) - params.WarmStorageReadCostEIP2929, nil
+	}
+	return 0, nil
+}
+
+// makeCallVariantGasCallArchived is makeCallVariantGasCallEIP2929 charging the
+// archived access cost for callees living in a cold shard.
+func makeCallVariantGasCallArchived(oldCalculator gasFunc) gasFunc {
+	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+		addr := common.Address(stack.Back(1).Bytes20())
+		// Check slot presence in the access list
+		warmAccess := evm.StateDB.AddressInAccessList(addr)
+		if warmAccess {
+			return oldCalculator(evm, contract, stack, mem, memorySize)
+		}
+		// The warm cost is already deducted as constant cost. Charge the
+		// remaining difference here already, to correctly calculate available
+		// gas for call
+		coldCost := coldAccountCost(evm, addr) - params.WarmStorageReadCostEIP2929
+		evm.StateDB.AddAddressToAccessList(addr)
+		if !contract.UseGas(coldCost) {
+			return 0, ErrOutOfGas
+		}
+		gas, err := oldCalculator(evm, contract, stack, mem, memorySize)
+		if err != nil {
+			return gas, err
+		}
+		// Add the cold charge back and return it as part of the dynamic gas, so
+		// that it is reported to tracers.
+		contract.Gas += coldCost
+		return gas + coldCost, nil
+	}
+}
+
+var (
+	gasCallArchived         = makeCallVariantGasCallArchived(gasCall)
+	gasDelegateCallArchived = makeCallVariantGasCallArchived(gasDelegateCall)
+	gasStaticCallArchived   = makeCallVariantGasCallArchived(gasStaticCall)
+	gasCallCodeArchived     = makeCallVariantGasCallArchived(gasCallCode)
+)
@@ -31,6 +31,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/beacon"
 	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/qbft"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/bloombits"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -141,7 +142,11 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
-	engine := ethconfig.CreateConsensusEngine(stack, &ethashConfig, cliqueConfig, config.Miner.Notify, config.Miner.Noverify, chainDb)
+	qbftConfig, err := core.LoadQBFTConfig(chainDb, config.Genesis)
+	if err != nil {
+		return nil, err
+	}
+	engine := ethconfig.CreateConsensusEngine(stack, &ethashConfig, cliqueConfig, qbftConfig, config.Miner.Notify, config.Miner.Noverify, chainDb)
 
 	eth := &Ethereum{
 		config:            config,
@@ -191,6 +196,9 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 			TrieTimeLimit:       config.TrieTimeout,
 			SnapshotLimit:       config.SnapshotCache,
 			Preimages:           config.Preimages,
+			StateScheme:         config.StateScheme,
+			StateHistory:        config.StateHistory,
+			StorageWorkers:      config.StorageWorkers,
 		}
 	)
 	// Override the chain config with provided settings.
@@ -202,6 +210,15 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.ParallelExecution > 1 {
+		eth.blockchain.SetParallelExecution(config.ParallelExecution)
+	}
+	if config.Miner.DataAvailability {
+		eth.blockchain.SetDataAvailability(true)
+	}
+	if config.StateAccesses {
+		eth.blockchain.SetStateAccessHook(core.NewStateAccessWriter())
+	}
 	eth.bloomIndexer.Start(eth.blockchain)
 
 	if config.TxPool.Journal != "" {
@@ -440,6 +457,14 @@ func (s *Ethereum) StartMining(threads int) error {
 			}
 			cli.Authorize(eb, wallet.SignData)
 		}
+		if q := s.qbftEngine(); q != nil {
+			wallet, err := s.accountManager.Find(accounts.Account{Address: eb})
+			if wallet == nil || err != nil {
+				log.Error("Etherbase account unavailable locally", "err", err)
+				return fmt.Errorf("validator missing: %v", err)
+			}
+			q.Authorize(eb, wallet.SignData)
+		}
 		// If mining is started, we can disable the transaction rejection mechanism
 		// introduced to speed sync times.
 		atomic.StoreUint32(&s.handler.acceptTxs, 1)
@@ -491,9 +516,22 @@ func (s *Ethereum) Protocols() []p2p.Protocol {
 	if s.config.SnapshotCache > 0 {
 		protos = append(protos, snap.MakeProtocols((*snapHandler)(s.handler), s.snapDialCandidates)...)
 	}
+	if q := s.qbftEngine(); q != nil {
+		protos = append(protos, q.Protocols()...)
+	}
 	return protos
 }
 
+// qbftEngine returns the qbft consensus engine, if the chain runs on it.
+func (s *Ethereum) qbftEngine() *qbft.QBFT {
+	if b, ok := s.engine.(*beacon.Beacon); ok {
+		q, _ := b.InnerEngine().(*qbft.QBFT)
+		return q
+	}
+	q, _ := s.engine.(*qbft.QBFT)
+	return q
+}
+
 // Start implements node.Lifecycle, starting all internal goroutines needed by the
 // Ethereum protocol implementation.
 func (s *Ethereum) Start() error {
@@ -502,6 +540,11 @@ func (s *Ethereum) Start() error {
 	// Start the bloom bits servicing goroutines
 	s.startBloomHandlers(params.BloomBitsBlocks)
 
+	// Start agreeing on the blocks with the other validators
+	if q := s.qbftEngine(); q != nil {
+		q.Start(s.blockchain)
+	}
+
 	// Regularly update shutdown marker
 	s.shutdownTracker.Start()
 
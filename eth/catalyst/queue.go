@@ -73,7 +73,22 @@ func (q *payloadQueue) put(id engine.PayloadID, payload *miner.Payload) {
 }
 
 // get retrieves a previously stored payload item or nil if it does not exist.
-func (q *payloadQueue) get(id engine.PayloadID) *engine.ExecutionPayloadEnvelope {
+// If full is set, it waits for the payload with transactions to be built first.
+func (q *payloadQueue) get(id engine.PayloadID, full bool) *engine.ExecutionPayloadEnvelope {
+	// Resolve the payload without holding the lock, waiting for a full payload
+	// may block until it's built
+	payload := q.find(id)
+	if payload == nil {
+		return nil
+	}
+	if full && payload.ResolveFull() == nil {
+		return nil
+	}
+	return payload.Resolve()
+}
+
+// find retrieves the payload being built with the given id, if tracked.
+func (q *payloadQueue) find(id engine.PayloadID) *miner.Payload {
 	q.lock.RLock()
 	defer q.lock.RUnlock()
 
@@ -82,7 +97,7 @@ func (q *payloadQueue) get(id engine.PayloadID) *engine.ExecutionPayloadEnvelope
 			return nil // no more items
 		}
 		if item.id == id {
-			return item.payload.Resolve()
+			return item.payload
 		}
 	}
 	return nil
@@ -0,0 +1,325 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package catalyst
+
+import (
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/beacon/engine"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// devEpochLength is the number of blocks after which the simulated beacon
+	// finalizes the chain, like an epoch of the beacon chain.
+	devEpochLength = 32
+
+	// maxWithdrawals is the maximum number of withdrawals in a block.
+	maxWithdrawals = 16
+
+	// withdrawalQueueSize is the number of withdrawals waiting to be included
+	// beyond which new ones are rejected.
+	withdrawalQueueSize = 256
+)
+
+var (
+	errNotMerged           = errors.New("chain not configured for the merge")
+	errWithdrawalQueueFull = errors.New("withdrawal queue full")
+)
+
+// withdrawalQueue holds the withdrawals waiting to be included in a block.
+type withdrawalQueue struct {
+	pending chan *types.Withdrawal
+}
+
+// add queues a withdrawal for the next blocks.
+func (w *withdrawalQueue) add(withdrawal *types.Withdrawal) error {
+	select {
+	case w.pending <- withdrawal:
+		return nil
+	default:
+		return errWithdrawalQueueFull
+	}
+}
+
+// gatherPending returns up to maxCount queued withdrawals, never nil.
+func (w *withdrawalQueue) gatherPending(maxCount int) []*types.Withdrawal {
+	withdrawals := []*types.Withdrawal{}
+	for len(withdrawals) < maxCount {
+		select {
+		case withdrawal := <-w.pending:
+			withdrawals = append(withdrawals, withdrawal)
+		default:
+			return withdrawals
+		}
+	}
+	return withdrawals
+}
+
+// SimulatedBeacon is an in-process consensus client driving the Engine API of
+// a post-merge chain, which lets a developer chain progress without a beacon
+// node. It seals a block every period, or on demand when the period is zero:
+// once transactions or withdrawals are pending, or when asked over RPC. Every
+// epoch of blocks is marked final.
+type SimulatedBeacon struct {
+	eth         *eth.Ethereum
+	engineAPI   *ConsensusAPI
+	period      uint64
+	withdrawals withdrawalQueue
+
+	feeRecipient     common.Address
+	feeRecipientLock sync.Mutex // Protects the fee recipient
+
+	sealLock      sync.Mutex               // Serializes the sealing of the blocks
+	forkchoice    engine.ForkchoiceStateV1 // Head, safe and final blocks last sent
+	lastBlockTime uint64                   // Timestamp of the last sealed block
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewSimulatedBeacon creates a simulated beacon sealing a block every period
+// seconds, or on demand if the period is zero. The chain must be configured
+// for the merge, and it's transitioned to proof-of-stake if still at genesis.
+func NewSimulatedBeacon(period uint64, eth *eth.Ethereum) (*SimulatedBeacon, error) {
+	if eth.BlockChain().Config().TerminalTotalDifficulty == nil {
+		return nil, errNotMerged
+	}
+	head := eth.BlockChain().CurrentBlock()
+	current := engine.ForkchoiceStateV1{
+		HeadBlockHash:      head.Hash(),
+		SafeBlockHash:      head.Hash(),
+		FinalizedBlockHash: head.Hash(),
+	}
+	engineAPI := newConsensusAPIWithoutHeartbeat(eth)
+
+	// If at genesis, send a forkchoice update to transition to proof-of-stake
+	if head.Number.Sign() == 0 {
+		if _, err := engineAPI.ForkchoiceUpdatedV1(current, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &SimulatedBeacon{
+		eth:           eth,
+		engineAPI:     engineAPI,
+		period:        period,
+		withdrawals:   withdrawalQueue{pending: make(chan *types.Withdrawal, withdrawalQueueSize)},
+		forkchoice:    current,
+		lastBlockTime: head.Time,
+		shutdownCh:    make(chan struct{}),
+	}, nil
+}
+
+// SetFeeRecipient sets the recipient of the fees of the next blocks.
+func (c *SimulatedBeacon) SetFeeRecipient(feeRecipient common.Address) {
+	c.feeRecipientLock.Lock()
+	c.feeRecipient = feeRecipient
+	c.feeRecipientLock.Unlock()
+}
+
+// Start implements node.Lifecycle, launching the periodic or on-demand sealing.
+func (c *SimulatedBeacon) Start() error {
+	c.wg.Add(1)
+	if c.period == 0 {
+		// Subscribe before returning to not miss the transactions added meanwhile
+		newTxs := make(chan core.NewTxsEvent)
+		sub := c.eth.TxPool().SubscribeNewTxsEvent(newTxs)
+		go c.loopOnDemand(newTxs, sub)
+	} else {
+		go c.loop()
+	}
+	return nil
+}
+
+// Stop implements node.Lifecycle, stopping the sealing.
+func (c *SimulatedBeacon) Stop() error {
+	close(c.shutdownCh)
+	c.wg.Wait()
+	return nil
+}
+
+// sealBlock builds a block with the pending transactions and the given
+// withdrawals on top of the head through the Engine API, and makes it the new
+// head.
+func (c *SimulatedBeacon) sealBlock(withdrawals []*types.Withdrawal) error {
+	c.sealLock.Lock()
+	defer c.sealLock.Unlock()
+
+	timestamp := uint64(time.Now().Unix())
+	if timestamp <= c.lastBlockTime {
+		timestamp = c.lastBlockTime + 1
+	}
+	// Withdrawals are only allowed, and required, after Shanghai
+	if !c.eth.BlockChain().Config().IsShanghai(timestamp) {
+		if len(withdrawals) > 0 {
+			return errors.New("withdrawals before shanghai")
+		}
+		withdrawals = nil
+	} else if withdrawals == nil {
+		withdrawals = []*types.Withdrawal{}
+	}
+	c.feeRecipientLock.Lock()
+	feeRecipient := c.feeRecipient
+	c.feeRecipientLock.Unlock()
+
+	// Build on the current head, in case the chain was rewound meanwhile
+	if head := c.eth.BlockChain().CurrentBlock(); head.Hash() != c.forkchoice.HeadBlockHash {
+		finalized := c.finalizedBlockHash(head.Number.Uint64())
+		if finalized == nil {
+			return errors.New("chain rewind interrupted calculation of finalized block hash")
+		}
+		c.setCurrentState(head.Hash(), *finalized)
+	}
+	var random common.Hash
+	rand.Read(random[:])
+
+	response, err := c.engineAPI.ForkchoiceUpdatedV2(c.forkchoice, &engine.PayloadAttributes{
+		Timestamp:             timestamp,
+		Random:                random,
+		SuggestedFeeRecipient: feeRecipient,
+		Withdrawals:           withdrawals,
+	})
+	if err != nil {
+		return err
+	}
+	if response.PayloadStatus.Status != engine.VALID || response.PayloadID == nil {
+		return errors.New("chain rewind prevented invocation of payload creation")
+	}
+	envelope, err := c.engineAPI.getPayload(*response.PayloadID, true)
+	if err != nil {
+		return err
+	}
+	payload := envelope.ExecutionPayload
+
+	// Every epoch of blocks is final
+	finalized := payload.BlockHash
+	if payload.Number%devEpochLength != 0 {
+		hash := c.finalizedBlockHash(payload.Number)
+		if hash == nil {
+			return errors.New("chain rewind interrupted calculation of finalized block hash")
+		}
+		finalized = *hash
+	}
+	// Import the payload and make it the head
+	if status, err := c.engineAPI.NewPayloadV2(*payload); err != nil {
+		return err
+	} else if status.Status != engine.VALID {
+		return errors.New("payload rejected")
+	}
+	c.setCurrentState(payload.BlockHash, finalized)
+	if _, err := c.engineAPI.ForkchoiceUpdatedV2(c.forkchoice, nil); err != nil {
+		return err
+	}
+	c.lastBlockTime = payload.Timestamp
+	return nil
+}
+
+// loopOnDemand seals a block whenever transactions or withdrawals are pending.
+func (c *SimulatedBeacon) loopOnDemand(newTxs chan core.NewTxsEvent, sub event.Subscription) {
+	defer c.wg.Done()
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-c.shutdownCh:
+			return
+		case withdrawal := <-c.withdrawals.pending:
+			withdrawals := append([]*types.Withdrawal{withdrawal}, c.withdrawals.gatherPending(maxWithdrawals-1)...)
+			if err := c.sealBlock(withdrawals); err != nil {
+				log.Warn("Error performing sealing work", "err", err)
+			}
+		case <-newTxs:
+			if err := c.sealBlock(c.withdrawals.gatherPending(maxWithdrawals)); err != nil {
+				log.Warn("Error performing sealing work", "err", err)
+			}
+		}
+	}
+}
+
+// loop seals a block every period.
+func (c *SimulatedBeacon) loop() {
+	defer c.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.shutdownCh:
+			return
+		case <-timer.C:
+			if err := c.sealBlock(c.withdrawals.gatherPending(maxWithdrawals)); err != nil {
+				log.Warn("Error performing sealing work", "err", err)
+			}
+			timer.Reset(time.Second * time.Duration(c.period))
+		}
+	}
+}
+
+// finalizedBlockHash returns the hash of the last block of the epoch before
+// the given block, or the block itself if it ends an epoch.
+func (c *SimulatedBeacon) finalizedBlockHash(number uint64) *common.Hash {
+	var finalized uint64
+	if number%devEpochLength == 0 {
+		finalized = number
+	} else {
+		finalized = (number - 1) / devEpochLength * devEpochLength
+	}
+	if block := c.eth.BlockChain().GetBlockByNumber(finalized); block != nil {
+		hash := block.Hash()
+		return &hash
+	}
+	return nil
+}
+
+// setCurrentState sets the forkchoice state to send with the next updates.
+func (c *SimulatedBeacon) setCurrentState(head, finalized common.Hash) {
+	c.forkchoice = engine.ForkchoiceStateV1{
+		HeadBlockHash:      head,
+		SafeBlockHash:      head,
+		FinalizedBlockHash: finalized,
+	}
+}
+
+// Commit seals a block with the pending transactions and withdrawals, and
+// returns the hash of the new head.
+func (c *SimulatedBeacon) Commit() (common.Hash, error) {
+	if err := c.sealBlock(c.withdrawals.gatherPending(maxWithdrawals)); err != nil {
+		return common.Hash{}, err
+	}
+	return c.eth.BlockChain().CurrentBlock().Hash(), nil
+}
+
+// RegisterSimulatedBeacon registers the simulated beacon into the node stack
+// for launching and stopping it with the node, and adds its API.
+func RegisterSimulatedBeacon(stack *node.Node, sim *SimulatedBeacon) {
+	stack.RegisterLifecycle(sim)
+	stack.RegisterAPIs([]rpc.API{{
+		Namespace: "dev",
+		Service:   &simulatedBeaconAPI{sim: sim},
+	}})
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package catalyst
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// simulatedBeaconAPI is the "dev" RPC namespace controlling a simulated beacon.
+type simulatedBeaconAPI struct {
+	sim *SimulatedBeacon
+}
+
+// AddWithdrawal queues a withdrawal for inclusion in the next blocks.
+func (a *simulatedBeaconAPI) AddWithdrawal(ctx context.Context, withdrawal *types.Withdrawal) error {
+	if !a.sim.eth.BlockChain().Config().IsShanghai(uint64(time.Now().Unix())) {
+		return errors.New("withdrawals before shanghai")
+	}
+	return a.sim.withdrawals.add(withdrawal)
+}
+
+// SetFeeRecipient sets the recipient of the fees of the next blocks.
+func (a *simulatedBeaconAPI) SetFeeRecipient(ctx context.Context, feeRecipient common.Address) {
+	a.sim.SetFeeRecipient(feeRecipient)
+}
+
+// Commit seals a block with the pending transactions and withdrawals right
+// away, returning the hash of the new head.
+func (a *simulatedBeaconAPI) Commit(ctx context.Context) (common.Hash, error) {
+	return a.sim.Commit()
+}
@@ -0,0 +1,197 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package catalyst
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// startSimulatedBeacon starts a node with a merged chain past Shanghai, sealed
+// by a simulated beacon with the given period.
+func startSimulatedBeacon(t *testing.T, period uint64) (*node.Node, *eth.Ethereum, *SimulatedBeacon) {
+	t.Helper()
+
+	config := *params.AllEthashProtocolChanges
+	config.TerminalTotalDifficulty = common.Big0
+	config.TerminalTotalDifficultyPassed = true
+	config.ShanghaiTime = new(uint64)
+
+	genesis := &core.Genesis{
+		Config:     &config,
+		Alloc:      core.GenesisAlloc{testAddr: {Balance: testBalance}},
+		BaseFee:    big.NewInt(params.InitialBaseFee),
+		Difficulty: big.NewInt(0),
+		GasLimit:   30_000_000,
+	}
+	n, ethservice := startEthService(t, genesis, nil)
+
+	sim, err := NewSimulatedBeacon(period, ethservice)
+	if err != nil {
+		n.Close()
+		t.Fatal("can't create simulated beacon:", err)
+	}
+	sim.Start()
+	t.Cleanup(func() { sim.Stop() })
+	return n, ethservice, sim
+}
+
+// waitHead waits until the chain reached a height.
+func waitHead(t *testing.T, ethservice *eth.Ethereum, number uint64) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for ethservice.BlockChain().CurrentBlock().Number.Uint64() < number {
+		if time.Now().After(deadline) {
+			t.Fatalf("chain stuck at %d, want %d", ethservice.BlockChain().CurrentBlock().Number, number)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSimulatedBeaconOnDemand(t *testing.T) {
+	n, ethservice, _ := startSimulatedBeacon(t, 0)
+	defer n.Close()
+
+	// Pending transactions trigger a block including them
+	var (
+		signer    = types.LatestSigner(ethservice.BlockChain().Config())
+		recipient = common.Address{0x01}
+		txs       []*types.Transaction
+	)
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		tx := types.MustSignNewTx(testKey, signer, &types.DynamicFeeTx{
+			ChainID:   ethservice.BlockChain().Config().ChainID,
+			Nonce:     nonce,
+			GasTipCap: big.NewInt(1),
+			GasFeeCap: big.NewInt(2 * params.InitialBaseFee),
+			Gas:       params.TxGas,
+			To:        &recipient,
+			Value:     big.NewInt(1000),
+		})
+		txs = append(txs, tx)
+	}
+	for _, err := range ethservice.TxPool().AddLocals(txs) {
+		if err != nil {
+			t.Fatal("can't add transaction:", err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if pending, _ := ethservice.TxPool().Stats(); pending == 0 && ethservice.BlockChain().CurrentBlock().Number.Sign() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("transactions not included")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	state, _ := ethservice.BlockChain().State()
+	if balance := state.GetBalance(recipient); balance.Cmp(big.NewInt(3000)) != 0 {
+		t.Fatalf("recipient balance mismatch: have %v, want %v", balance, 3000)
+	}
+	// The blocks are imported as proof-of-stake ones
+	head := ethservice.BlockChain().CurrentBlock()
+	if head.Difficulty.Sign() != 0 {
+		t.Fatalf("head difficulty mismatch: have %v, want 0", head.Difficulty)
+	}
+}
+
+func TestSimulatedBeaconWithdrawals(t *testing.T) {
+	n, ethservice, sim := startSimulatedBeacon(t, 0)
+	defer n.Close()
+
+	api := &simulatedBeaconAPI{sim: sim}
+	for i := uint64(0); i < 20; i++ {
+		withdrawal := &types.Withdrawal{Index: i, Validator: i, Address: common.Address{0x02, byte(i)}, Amount: 10}
+		if err := api.AddWithdrawal(context.Background(), withdrawal); err != nil {
+			t.Fatal("can't add withdrawal:", err)
+		}
+	}
+	// The withdrawals are spread over blocks of at most maxWithdrawals
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		state, _ := ethservice.BlockChain().State()
+		if state.GetBalance(common.Address{0x02, 19}).Sign() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("withdrawals not included")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var included int
+	for number := uint64(1); number <= ethservice.BlockChain().CurrentBlock().Number.Uint64(); number++ {
+		withdrawals := ethservice.BlockChain().GetBlockByNumber(number).Withdrawals()
+		if len(withdrawals) > maxWithdrawals {
+			t.Fatalf("block %d: too many withdrawals: %d", number, len(withdrawals))
+		}
+		included += len(withdrawals)
+	}
+	if included != 20 {
+		t.Fatalf("included withdrawals mismatch: have %d, want 20", included)
+	}
+	state, _ := ethservice.BlockChain().State()
+	want := new(big.Int).Mul(big.NewInt(10), big.NewInt(params.GWei))
+	if balance := state.GetBalance(common.Address{0x02, 0}); balance.Cmp(want) != 0 {
+		t.Fatalf("withdrawal balance mismatch: have %v, want %v", balance, want)
+	}
+}
+
+func TestSimulatedBeaconCommit(t *testing.T) {
+	n, ethservice, sim := startSimulatedBeacon(t, 0)
+	defer n.Close()
+
+	// Committing without anything pending forces an empty block
+	api := &simulatedBeaconAPI{sim: sim}
+	api.SetFeeRecipient(context.Background(), common.Address{0x03})
+
+	for i := uint64(1); i <= devEpochLength+1; i++ {
+		hash, err := api.Commit(context.Background())
+		if err != nil {
+			t.Fatal("can't commit block:", err)
+		}
+		head := ethservice.BlockChain().CurrentBlock()
+		if head.Hash() != hash || head.Number.Uint64() != i {
+			t.Fatalf("head mismatch: have %d %x, want %d %x", head.Number, head.Hash(), i, hash)
+		}
+		if head.Coinbase != (common.Address{0x03}) {
+			t.Fatalf("fee recipient mismatch: have %x", head.Coinbase)
+		}
+	}
+	// The first epoch is final
+	if final := ethservice.BlockChain().CurrentFinalBlock(); final == nil || final.Number.Uint64() != devEpochLength {
+		t.Fatalf("final block mismatch: have %v, want %d", final, devEpochLength)
+	}
+}
+
+func TestSimulatedBeaconPeriod(t *testing.T) {
+	n, ethservice, _ := startSimulatedBeacon(t, 1)
+	defer n.Close()
+
+	// Blocks are sealed every period, even without transactions
+	waitHead(t, ethservice, 2)
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/beacon"
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/qbft"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/txpool"
 	"github.com/ethereum/go-ethereum/eth/downloader"
@@ -74,6 +75,7 @@ var Defaults = Config{
 	},
 	NetworkId:               1,
 	TxLookupLimit:           2350000,
+	StateHistory:            90000,
 	LightPeers:              100,
 	UltraLightFraction:      75,
 	DatabaseCache:           512,
@@ -133,8 +135,17 @@ type Config struct {
 	NoPruning  bool // Whether to disable pruning and flush everything to disk
 	NoPrefetch bool // Whether to disable prefetching and only load state on demand
 
+	StateScheme  string `toml:",omitempty"` // Scheme used to store the trie nodes, hash or path (stored scheme if empty)
+	StateHistory uint64 `toml:",omitempty"` // Number of recent blocks to keep the state history of, 0 for all (path scheme only)
+
+	StorageWorkers int `toml:",omitempty"` // Number of storage tries hashed and committed concurrently, sequential if 1 or less
+
 	TxLookupLimit uint64 `toml:",omitempty"` // The maximum number of blocks from head whose tx indices are reserved.
 
+	StateAccesses bool `toml:",omitempty"` // Whether to record the state accesses of every imported block
+
+	ParallelExecution int `toml:",omitempty"` // Number of transactions of imported blocks executed speculatively at once, sequential execution if 1 or less
+
 	// RequiredBlocks is a set of block number -> hash mappings which must be in the
 	// canonical chain of all remote peers. Setting the option makes geth verify the
 	// presence of these blocks for every new peer connection.
@@ -210,11 +221,13 @@ type Config struct {
 }
 
 // CreateConsensusEngine creates a consensus engine for the given chain configuration.
-func CreateConsensusEngine(stack *node.Node, ethashConfig *ethash.Config, cliqueConfig *params.CliqueConfig, notify []string, noverify bool, db ethdb.Database) consensus.Engine {
+func CreateConsensusEngine(stack *node.Node, ethashConfig *ethash.Config, cliqueConfig *params.CliqueConfig, qbftConfig *params.QBFTConfig, notify []string, noverify bool, db ethdb.Database) consensus.Engine {
 	// If proof-of-authority is requested, set it up
 	var engine consensus.Engine
 	if cliqueConfig != nil {
 		engine = clique.New(cliqueConfig, db)
+	} else if qbftConfig != nil {
+		engine = qbft.New(qbftConfig)
 	} else {
 		switch ethashConfig.PowMode {
 		case ethash.ModeFake:
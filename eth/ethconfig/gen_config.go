@@ -25,7 +25,12 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		SnapDiscoveryURLs       []string
 		NoPruning               bool
 		NoPrefetch              bool
+		StateScheme             string                 `toml:",omitempty"`
+		StateHistory            uint64                 `toml:",omitempty"`
+		StorageWorkers          int                    `toml:",omitempty"`
 		TxLookupLimit           uint64                 `toml:",omitempty"`
+		StateAccesses           bool                   `toml:",omitempty"`
+		ParallelExecution       int                    `toml:",omitempty"`
 		RequiredBlocks          map[uint64]common.Hash `toml:"-"`
 		LightServ               int                    `toml:",omitempty"`
 		LightIngress            int                    `toml:",omitempty"`
@@ -70,7 +75,12 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.SnapDiscoveryURLs = c.SnapDiscoveryURLs
 	enc.NoPruning = c.NoPruning
 	enc.NoPrefetch = c.NoPrefetch
+	enc.StateScheme = c.StateScheme
+	enc.StateHistory = c.StateHistory
+	enc.StorageWorkers = c.StorageWorkers
 	enc.TxLookupLimit = c.TxLookupLimit
+	enc.StateAccesses = c.StateAccesses
+	enc.ParallelExecution = c.ParallelExecution
 	enc.RequiredBlocks = c.RequiredBlocks
 	enc.LightServ = c.LightServ
 	enc.LightIngress = c.LightIngress
@@ -119,7 +129,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		SnapDiscoveryURLs       []string
 		NoPruning               *bool
 		NoPrefetch              *bool
+		StateScheme             *string                `toml:",omitempty"`
+		StateHistory            *uint64                `toml:",omitempty"`
+		StorageWorkers          *int                   `toml:",omitempty"`
 		TxLookupLimit           *uint64                `toml:",omitempty"`
+		StateAccesses           *bool                  `toml:",omitempty"`
+		ParallelExecution       *int                   `toml:",omitempty"`
 		RequiredBlocks          map[uint64]common.Hash `toml:"-"`
 		LightServ               *int                   `toml:",omitempty"`
 		LightIngress            *int                   `toml:",omitempty"`
@@ -181,9 +196,24 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.NoPrefetch != nil {
 		c.NoPrefetch = *dec.NoPrefetch
 	}
+	if dec.StateScheme != nil {
+		c.StateScheme = *dec.StateScheme
+	}
+	if dec.StateHistory != nil {
+		c.StateHistory = *dec.StateHistory
+	}
+	if dec.StorageWorkers != nil {
+		c.StorageWorkers = *dec.StorageWorkers
+	}
 	if dec.TxLookupLimit != nil {
 		c.TxLookupLimit = *dec.TxLookupLimit
 	}
+	if dec.StateAccesses != nil {
+		c.StateAccesses = *dec.StateAccesses
+	}
+	if dec.ParallelExecution != nil {
+		c.ParallelExecution = *dec.ParallelExecution
+	}
 	if dec.RequiredBlocks != nil {
 		c.RequiredBlocks = dec.RequiredBlocks
 	}
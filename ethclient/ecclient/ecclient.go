@@ -0,0 +1,140 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ecclient provides an RPC client for the EC-Chain specific APIs.
+package ecclient
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Client is a wrapper around rpc.Client that implements the ec namespace,
+// which reports the shard placement and temperature of accounts.
+type Client struct {
+	c *rpc.Client
+}
+
+// New creates a client that uses the given RPC client.
+func New(c *rpc.Client) *Client {
+	return &Client{c}
+}
+
+// GroupMember describes one node of the EC group.
+type GroupMember struct {
+	Index    uint        `json:"index"`
+	Shards   []uint      `json:"shards"`
+	HotRoot  common.Hash `json:"hotRoot"`
+	ColdRoot common.Hash `json:"coldRoot"`
+}
+
+// ColdProof is the result of a GetColdProof operation.
+type ColdProof struct {
+	Address      common.Address `json:"address"`
+	Shard        uint           `json:"shard"`
+	ColdRoot     common.Hash    `json:"coldRoot"`
+	AccountProof []string       `json:"accountProof"`
+	Balance      *big.Int       `json:"balance"`
+	Nonce        uint64         `json:"nonce"`
+}
+
+// IsHot reports whether the account lives in the replicated hot trie.
+func (ec *Client) IsHot(ctx context.Context, account common.Address) (bool, error) {
+	var hot bool
+	err := ec.c.CallContext(ctx, &hot, "ec_isHot", account)
+	return hot, err
+}
+
+// ShardOf returns the index of the cold shard of the account. The member holding
+// the shard is listed by GroupMembers.
+func (ec *Client) ShardOf(ctx context.Context, account common.Address) (uint, error) {
+	var shard hexutil.Uint
+	err := ec.c.CallContext(ctx, &shard, "ec_shardOf", account)
+	return uint(shard), err
+}
+
+// ExpiryHeight returns the block height at which the account is scheduled to
+// move to the cold tier. Zero means the account has never been accessed.
+func (ec *Client) ExpiryHeight(ctx context.Context, account common.Address) (uint64, error) {
+	var height hexutil.Uint64
+	err := ec.c.CallContext(ctx, &height, "ec_expiryHeight", account)
+	return uint64(height), err
+}
+
+// ColdRoot returns the last committed cold trie root of the member holding the
+// given shard.
+func (ec *Client) ColdRoot(ctx context.Context, shard uint) (common.Hash, error) {
+	var root common.Hash
+	err := ec.c.CallContext(ctx, &root, "ec_coldRoot", hexutil.Uint(shard))
+	return root, err
+}
+
+// GroupMembers returns the members of the EC group with their committed roots.
+func (ec *Client) GroupMembers(ctx context.Context) ([]GroupMember, error) {
+	type member struct {
+		Index    hexutil.Uint   `json:"index"`
+		Shards   []hexutil.Uint `json:"shards"`
+		HotRoot  common.Hash    `json:"hotRoot"`
+		ColdRoot common.Hash    `json:"coldRoot"`
+	}
+	var res []member
+	if err := ec.c.CallContext(ctx, &res, "ec_groupMembers"); err != nil {
+		return nil, err
+	}
+	members := make([]GroupMember, 0, len(res))
+	for _, m := range res {
+		shards := make([]uint, 0, len(m.Shards))
+		for _, shard := range m.Shards {
+			shards = append(shards, uint(shard))
+		}
+		members = append(members, GroupMember{
+			Index:    uint(m.Index),
+			Shards:   shards,
+			HotRoot:  m.HotRoot,
+			ColdRoot: m.ColdRoot,
+		})
+	}
+	return members, nil
+}
+
+// GetColdProof returns the Merkle proof of the account against the cold trie
+// of its owning shard. For accounts that are not cold the proof shows absence.
+func (ec *Client) GetColdProof(ctx context.Context, account common.Address) (*ColdProof, error) {
+	type coldProof struct {
+		Address      common.Address `json:"address"`
+		Shard        hexutil.Uint   `json:"shard"`
+		ColdRoot     common.Hash    `json:"coldRoot"`
+		AccountProof []string       `json:"accountProof"`
+		Balance      *hexutil.Big   `json:"balance"`
+		Nonce        hexutil.Uint64 `json:"nonce"`
+	}
+	var res coldProof
+	if err := ec.c.CallContext(ctx, &res, "ec_getColdProof", account); err != nil {
+		return nil, err
+	}
+	return &ColdProof{
+		Address:      res.Address,
+		Shard:        uint(res.Shard),
+		ColdRoot:     res.ColdRoot,
+		AccountProof: res.AccountProof,
+		Balance:      res.Balance.ToInt(),
+		Nonce:        uint64(res.Nonce),
+	}, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ecclient
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	testAddr     = common.HexToAddress("0xc0ffee254729296a45a3885639AC7E10F9d54979")
+	testHotRoot  = common.HexToHash("0x1111")
+	testColdRoot = common.HexToHash("0x2222")
+)
+
+// testService mimics the ec namespace served by the ecchain simulator.
+type testService struct{}
+
+func (s *testService) IsHot(address common.Address) bool {
+	return address == testAddr
+}
+
+func (s *testService) ShardOf(address common.Address) hexutil.Uint {
+	return hexutil.Uint(address[0] >> 6)
+}
+
+func (s *testService) ExpiryHeight(address common.Address) hexutil.Uint64 {
+	return 10042
+}
+
+func (s *testService) ColdRoot(shard hexutil.Uint) (common.Hash, error) {
+	if shard >= 4 {
+		return common.Hash{}, fmt.Errorf("shard %d out of range", shard)
+	}
+	return testColdRoot, nil
+}
+
+func (s *testService) GroupMembers() []map[string]interface{} {
+	var members []map[string]interface{}
+	for i := 0; i < 4; i++ {
+		members = append(members, map[string]interface{}{
+			"index":    hexutil.Uint(i),
+			"shards":   []hexutil.Uint{hexutil.Uint(i)},
+			"hotRoot":  testHotRoot,
+			"coldRoot": testColdRoot,
+		})
+	}
+	return members
+}
+
+func (s *testService) GetColdProof(address common.Address) map[string]interface{} {
+	return map[string]interface{}{
+		"address":      address,
+		"shard":        hexutil.Uint(3),
+		"coldRoot":     testColdRoot,
+		"accountProof": []string{"0xf8"},
+		"balance":      (*hexutil.Big)(big.NewInt(1000)),
+		"nonce":        hexutil.Uint64(7),
+	}
+}
+
+func newTestClient(t *testing.T) *Client {
+	server := rpc.NewServer()
+	if err := server.RegisterName("ec", new(testService)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	c := rpc.DialInProc(server)
+	t.Cleanup(c.Close)
+	return New(c)
+}
+
+func TestClient(t *testing.T) {
+	var (
+		ec  = newTestClient(t)
+		ctx = context.Background()
+	)
+	if hot, err := ec.IsHot(ctx, testAddr); err != nil || !hot {
+		t.Fatalf("IsHot: have %v (err %v), want true", hot, err)
+	}
+	if shard, err := ec.ShardOf(ctx, testAddr); err != nil || shard != 3 {
+		t.Fatalf("ShardOf: have %d (err %v), want 3", shard, err)
+	}
+	if height, err := ec.ExpiryHeight(ctx, testAddr); err != nil || height != 10042 {
+		t.Fatalf("ExpiryHeight: have %d (err %v), want 10042", height, err)
+	}
+	if root, err := ec.ColdRoot(ctx, 1); err != nil || root != testColdRoot {
+		t.Fatalf("ColdRoot: have %x (err %v), want %x", root, err, testColdRoot)
+	}
+	if _, err := ec.ColdRoot(ctx, 4); err == nil {
+		t.Fatal("ColdRoot: expected error for out of range shard")
+	}
+	members, err := ec.GroupMembers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 4 {
+		t.Fatalf("GroupMembers: have %d members, want 4", len(members))
+	}
+	for i, m := range members {
+		want := GroupMember{Index: uint(i), Shards: []uint{uint(i)}, HotRoot: testHotRoot, ColdRoot: testColdRoot}
+		if !reflect.DeepEqual(m, want) {
+			t.Fatalf("GroupMembers: member %d mismatch, have %+v, want %+v", i, m, want)
+		}
+	}
+	proof, err := ec.GetColdProof(ctx, testAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ColdProof{
+		Address:      testAddr,
+		Shard:        3,
+		ColdRoot:     testColdRoot,
+		AccountProof: []string{"0xf8"},
+		Balance:      big.NewInt(1000),
+		Nonce:        7,
+	}
+	if !reflect.DeepEqual(proof, want) {
+		t.Fatalf("GetColdProof: have %+v, want %+v", proof, want)
+	}
+}
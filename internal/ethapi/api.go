@@ -36,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/consensus/misc"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
@@ -1986,6 +1987,20 @@ func (api *DebugAPI) GetRawReceipts(ctx context.Context, blockNrOrHash rpc.Block
 	return result, nil
 }
 
+// GetStateAccesses returns the accounts and storage slots read and written by
+// every transaction of a block, as recorded when the node imported it.
+func (api *DebugAPI) GetStateAccesses(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]types.StateAccess, error) {
+	header, err := api.b.HeaderByNumberOrHash(ctx, blockNrOrHash)
+	if header == nil || err != nil {
+		return nil, err
+	}
+	accesses := rawdb.ReadStateAccesses(api.b.ChainDb(), header.Hash(), header.Number.Uint64())
+	if accesses == nil {
+		return nil, fmt.Errorf("no state accesses recorded for block #%d", header.Number)
+	}
+	return accesses, nil
+}
+
 // GetRawTransaction returns the bytes of the transaction for the given hash.
 func (s *DebugAPI) GetRawTransaction(ctx context.Context, hash common.Hash) (hexutil.Bytes, error) {
 	// Retrieve a finalized transaction, or a pooled otherwise
@@ -30,6 +30,8 @@ var Modules = map[string]string{
 	"txpool":   TxpoolJs,
 	"les":      LESJs,
 	"vflux":    VfluxJs,
+	"qbft":     QBFTJs,
+	"dev":      DevJs,
 }
 
 const CliqueJs = `
@@ -89,6 +91,49 @@ web3._extend({
 });
 `
 
+const QBFTJs = `
+web3._extend({
+	property: 'qbft',
+	methods: [
+		new web3._extend.Method({
+			name: 'getValidators',
+			call: 'qbft_getValidators',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getBlockStatus',
+			call: 'qbft_getBlockStatus',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+	]
+});
+`
+
+const DevJs = `
+web3._extend({
+	property: 'dev',
+	methods: [
+		new web3._extend.Method({
+			name: 'addWithdrawal',
+			call: 'dev_addWithdrawal',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'setFeeRecipient',
+			call: 'dev_setFeeRecipient',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'commit',
+			call: 'dev_commit',
+			params: 0
+		}),
+	]
+});
+`
+
 const EthashJs = `
 web3._extend({
 	property: 'ethash',
@@ -238,6 +283,11 @@ web3._extend({
 			call: 'debug_getRawReceipts',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'getStateAccesses',
+			call: 'debug_getStateAccesses',
+			params: 1
+		}),
 		new web3._extend.Method({
 			name: 'getRawTransaction',
 			call: 'debug_getRawTransaction',
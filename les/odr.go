@@ -94,6 +94,7 @@ const (
 	MsgProofsV2
 	MsgHelperTrieProofs
 	MsgTxStatus
+	MsgDataSamples
 )
 
 // Msg encodes a LES message that delivers reply data for a request
@@ -22,6 +22,7 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -43,6 +44,7 @@ var (
 	errCHTHashMismatch     = errors.New("cht hash mismatch")
 	errCHTNumberMismatch   = errors.New("cht number mismatch")
 	errUselessNodes        = errors.New("useless nodes in merkle proof nodeset")
+	errInvalidSample       = errors.New("invalid data sample")
 )
 
 type LesOdrRequest interface {
@@ -68,6 +70,8 @@ func LesRequest(req light.OdrRequest) LesOdrRequest {
 		return (*BloomRequest)(r)
 	case *light.TxStatusRequest:
 		return (*TxStatusRequest)(r)
+	case *light.DataSamplesRequest:
+		return (*DataSamplesRequest)(r)
 	default:
 		return nil
 	}
@@ -513,6 +517,69 @@ func (r *TxStatusRequest) Validate(db ethdb.Database, msg *Msg) error {
 	return nil
 }
 
+type DataSampleReq struct {
+	BHash    common.Hash
+	Row, Col uint16
+}
+
+// DataSamplesRequest is the ODR request type for sampling erasure-coded block data
+type DataSamplesRequest light.DataSamplesRequest
+
+// GetCost returns the cost of the given ODR request according to the serving
+// peer's cost table (implementation of LesOdrRequest)
+func (r *DataSamplesRequest) GetCost(peer *serverPeer) uint64 {
+	return peer.getRequestCost(GetDataSamplesMsg, len(r.Positions))
+}
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *DataSamplesRequest) CanSend(peer *serverPeer) bool {
+	return peer.version >= lpv5 && peer.HasBlock(r.Hash, r.Number, false)
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *DataSamplesRequest) Request(reqID uint64, peer *serverPeer) error {
+	peer.Log().Debug("Requesting data samples", "hash", r.Hash, "count", len(r.Positions))
+	reqs := make([]DataSampleReq, len(r.Positions))
+	for i, pos := range r.Positions {
+		reqs[i] = DataSampleReq{BHash: r.Hash, Row: pos.Row, Col: pos.Col}
+	}
+	return peer.requestDataSamples(reqID, reqs)
+}
+
+// Validate processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (r *DataSamplesRequest) Validate(db ethdb.Database, msg *Msg) error {
+	log.Debug("Validating data samples", "hash", r.Hash, "count", len(r.Positions))
+
+	if msg.MsgType != MsgDataSamples {
+		return errInvalidMessageType
+	}
+	root, err := das.HeaderRoot(r.Header)
+	if err != nil {
+		return err
+	}
+	// The reply holds the samples in the order requested, leaving out the
+	// chunks withheld. Every sample has to prove the chunk of its position.
+	var (
+		samples = msg.Obj.([]*das.Sample)
+		result  = make([]*das.Sample, len(r.Positions))
+	)
+	for i, pos := range r.Positions {
+		if len(samples) == 0 {
+			break
+		}
+		if samples[0].Verify(root, pos.Row, pos.Col) == nil {
+			result[i], samples = samples[0], samples[1:]
+		}
+	}
+	if len(samples) != 0 {
+		return errInvalidSample
+	}
+	r.Samples = result
+	return nil
+}
+
 // readTraceDB stores the keys of database reads. We use this to check that received node
 // sets contain only the trie nodes necessary to make proofs pass.
 type readTraceDB struct {
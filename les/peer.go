@@ -30,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/mclock"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/forkid"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/les/flowcontrol"
@@ -481,6 +482,13 @@ func (p *serverPeer) requestTxStatus(reqID uint64, txHashes []common.Hash) error
 	return p.sendRequest(GetTxStatusMsg, reqID, txHashes, len(txHashes))
 }
 
+// requestDataSamples fetches a batch of chunks of erasure-coded block data
+// together with their proofs from a remote node.
+func (p *serverPeer) requestDataSamples(reqID uint64, reqs []DataSampleReq) error {
+	p.Log().Debug("Sampling block data", "count", len(reqs))
+	return p.sendRequest(GetDataSamplesMsg, reqID, reqs, len(reqs))
+}
+
 // sendTxs creates a reply with a batch of transactions to be added to the remote transaction pool.
 func (p *serverPeer) sendTxs(reqID uint64, amount int, txs rlp.RawValue) error {
 	p.Log().Debug("Sending batch of transactions", "amount", amount, "size", len(txs))
@@ -666,6 +674,10 @@ func (p *serverPeer) Handshake(genesis common.Hash, forkid forkid.ID, forkFilter
 
 		if !p.onlyAnnounce {
 			for msgCode := range reqAvgTimeCost {
+				// Messages introduced in later versions aren't supported
+				if msgCode >= ProtocolLengths[uint(p.version)] {
+					continue
+				}
 				if p.fcCosts[msgCode] == nil {
 					return errResp(ErrUselessPeer, "peer does not support message %d", msgCode)
 				}
@@ -924,6 +936,13 @@ func (p *clientPeer) replyTxStatus(reqID uint64, stats []light.TxStatus) *reply
 	return &reply{p.rw, TxStatusMsg, reqID, data}
 }
 
+// replyDataSamples creates a reply with a batch of proven chunks of erasure-coded
+// block data, leaving out the ones withheld.
+func (p *clientPeer) replyDataSamples(reqID uint64, samples []*das.Sample) *reply {
+	data, _ := rlp.EncodeToBytes(samples)
+	return &reply{p.rw, DataSamplesMsg, reqID, data}
+}
+
 // sendAnnounce announces the availability of a number of blocks through
 // a hash notification.
 func (p *clientPeer) sendAnnounce(request announceData) error {
@@ -36,17 +36,18 @@ const (
 	lpv2 = 2
 	lpv3 = 3
 	lpv4 = 4
+	lpv5 = 5
 )
 
 // Supported versions of the les protocol (first is primary)
 var (
-	ClientProtocolVersions    = []uint{lpv2, lpv3, lpv4}
-	ServerProtocolVersions    = []uint{lpv2, lpv3, lpv4}
+	ClientProtocolVersions    = []uint{lpv2, lpv3, lpv4, lpv5}
+	ServerProtocolVersions    = []uint{lpv2, lpv3, lpv4, lpv5}
 	AdvertiseProtocolVersions = []uint{lpv2} // clients are searching for the first advertised protocol in the list
 )
 
 // ProtocolLengths is the number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = map[uint]uint64{lpv2: 22, lpv3: 24, lpv4: 24}
+var ProtocolLengths = map[uint]uint64{lpv2: 22, lpv3: 24, lpv4: 24, lpv5: 26}
 
 const (
 	NetworkId          = 1
@@ -82,6 +83,9 @@ const (
 	// Protocol messages introduced in LPV3
 	StopMsg   = 0x16
 	ResumeMsg = 0x17
+	// Protocol messages introduced in LPV5
+	GetDataSamplesMsg = 0x18
+	DataSamplesMsg    = 0x19
 )
 
 // GetBlockHeadersData represents a block header query (the request ID is not included)
@@ -140,6 +144,12 @@ type GetTxStatusPacket struct {
 	Hashes []common.Hash
 }
 
+// GetDataSamplesPacket represents a data availability sampling request
+type GetDataSamplesPacket struct {
+	ReqID uint64
+	Reqs  []DataSampleReq
+}
+
 type requestInfo struct {
 	name                          string
 	maxCount                      uint64
@@ -166,6 +176,7 @@ var (
 		GetHelperTrieProofsMsg: {"GetHelperTrieProofs", MaxHelperTrieProofsFetch, 10, 100},
 		SendTxV2Msg:            {"SendTxV2", MaxTxSend, 1, 0},
 		GetTxStatusMsg:         {"GetTxStatus", MaxTxStatus, 10, 0},
+		GetDataSamplesMsg:      {"GetDataSamples", MaxDataSampleFetch, 10, 0},
 	}
 	requestList    []vfc.RequestInfo
 	requestMapping map[uint32]reqMapping
@@ -0,0 +1,129 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/das"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/light"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that a light client sampling the erasure-coded data of a block gains
+// confidence in its availability from an honest server, and detects a server
+// withholding enough chunks to make the block unrecoverable.
+func TestDataAvailabilitySampling(t *testing.T) {
+	server, client, tearDown := newClientServerEnv(t, testnetConfig{
+		blocks:    4,
+		protocol:  lpv5,
+		connect:   true,
+		nopruning: true,
+	})
+	defer tearDown()
+
+	// Seal a block committing to its data root on top of the server chain, with
+	// enough data to span several chunks per row of the data square.
+	bc := server.handler.blockchain
+	statedb, err := bc.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3000)
+	rand.Read(data)
+	tx, _ := types.SignTx(types.NewTransaction(statedb.GetNonce(bankAddr), userAddr1, big.NewInt(1), 100000, big.NewInt(params.InitialBaseFee), data), types.HomesteadSigner{}, bankKey)
+	matrix, err := das.NewTxMatrix(types.Transactions{tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := bc.GetBlockByHash(bc.CurrentBlock().Hash())
+	blocks, _ := core.GenerateChain(params.AllEthashProtocolChanges, parent, ethash.NewFaker(), server.db, 1, func(i int, gen *core.BlockGen) {
+		gen.AddTx(tx)
+		gen.SetExtra(matrix.Root().Bytes())
+	})
+	if _, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	// Wait for the client to sync the announced header
+	var (
+		hash   = blocks[0].Hash()
+		header *types.Header
+	)
+	for start := time.Now(); header == nil; header = client.handler.backend.blockchain.GetHeaderByHash(hash) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("client did not sync the sealed block")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	odr := client.handler.backend.odr
+	sample := func(header *types.Header, samples int) (float64, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return light.SampleDataAvailability(ctx, odr, header, samples)
+	}
+	// All chunks are served, samples beyond a request are batched
+	confidence, err := sample(header, 40)
+	if err != nil {
+		t.Fatalf("sampling available block failed: %v", err)
+	}
+	if want := das.Confidence(matrix.Width(), 40); confidence != want || confidence < 0.999 {
+		t.Fatalf("confidence mismatch: have %v, want %v", confidence, want)
+	}
+	// Withhold the smallest set of chunks making the block unrecoverable
+	served, err := server.handler.DataMatrix(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := served.Width()
+	for r := 0; r <= width; r++ {
+		for c := 0; c <= width; c++ {
+			served.Withhold(r, c)
+		}
+	}
+	if _, err := sample(header, 40); err != light.ErrDataUnavailable {
+		t.Fatalf("withheld chunks not detected: have %v, want %v", err, light.ErrDataUnavailable)
+	}
+	// The server stays connected, withholding isn't answering invalidly
+	if client.handler.backend.peers.len() == 0 {
+		t.Fatal("server dropped")
+	}
+	// Blocks not committing to a data root can't be sampled
+	if _, err := sample(parent.Header(), 1); err == nil {
+		t.Fatal("sampled block without data root")
+	}
+}
+
+// Tests that data samples are only requested from servers speaking LES5, the
+// version introducing the sampling messages.
+func TestDataSamplesRequestVersion(t *testing.T) {
+	req := new(DataSamplesRequest)
+	for _, version := range []int{lpv2, lpv3, lpv4} {
+		if req.CanSend(&serverPeer{peerCommons: peerCommons{version: version}}) {
+			t.Errorf("data samples requested from LES%d server", version)
+		}
+	}
+	if ProtocolLengths[lpv4] > GetDataSamplesMsg {
+		t.Errorf("sampling messages introduced in LES4: length %d", ProtocolLengths[lpv4])
+	}
+}
@@ -23,8 +23,10 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/common/mclock"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/forkid"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/txpool"
@@ -51,6 +53,9 @@ const (
 	MaxHelperTrieProofsFetch = 64  // Amount of helper tries to be fetched per retrieval request
 	MaxTxSend                = 64  // Amount of transactions to be send per request
 	MaxTxStatus              = 256 // Amount of transactions to queried per request
+	MaxDataSampleFetch       = 64  // Amount of data chunks to be sampled per request
+
+	dataMatrixCacheSize = 16 // Number of extended data squares of recent blocks to keep
 )
 
 var (
@@ -70,6 +75,8 @@ type serverHandler struct {
 	wg      sync.WaitGroup // WaitGroup used to track all background routines of handler.
 	synced  func() bool    // Callback function used to determine whether local node is synced.
 
+	matrices *lru.Cache[common.Hash, *das.Matrix] // Extended data squares of recently sampled blocks
+
 	// Testing fields
 	addTxsSync bool
 }
@@ -83,6 +90,7 @@ func newServerHandler(server *LesServer, blockchain *core.BlockChain, chainDb et
 		txpool:     txpool,
 		closeCh:    make(chan struct{}),
 		synced:     synced,
+		matrices:   lru.NewCache[common.Hash, *das.Matrix](dataMatrixCacheSize),
 	}
 	return handler
 }
@@ -358,6 +366,23 @@ func (h *serverHandler) AddTxsSync() bool {
 	return h.addTxsSync
 }
 
+// DataMatrix implements serverBackend
+func (h *serverHandler) DataMatrix(hash common.Hash) (*das.Matrix, error) {
+	if matrix, ok := h.matrices.Get(hash); ok {
+		return matrix, nil
+	}
+	block := h.blockchain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, errors.New("unknown block")
+	}
+	matrix, err := das.NewTxMatrix(block.Transactions())
+	if err != nil {
+		return nil, err
+	}
+	h.matrices.Add(hash, matrix)
+	return matrix, nil
+}
+
 // getAccount retrieves an account from the state based on root.
 func getAccount(triedb *trie.Database, root, hash common.Hash) (types.StateAccount, error) {
 	trie, err := trie.New(trie.StateTrieID(root), triedb)
@@ -22,6 +22,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/txpool"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -39,6 +40,7 @@ type serverBackend interface {
 	BlockChain() *core.BlockChain
 	TxPool() *txpool.TxPool
 	GetHelperTrie(typ uint, index uint64) *trie.Trie
+	DataMatrix(hash common.Hash) (*das.Matrix, error)
 }
 
 // Decoder is implemented by the messages passed to the handler functions
@@ -146,6 +148,16 @@ var Les3 = map[uint64]RequestType{
 		ServingTimeMeter: miscServingTimeTxStatusTimer,
 		Handle:           handleGetTxStatus,
 	},
+	GetDataSamplesMsg: {
+		Name:             "data sample request",
+		MaxCount:         MaxDataSampleFetch,
+		InPacketsMeter:   miscInDataSamplePacketsMeter,
+		InTrafficMeter:   miscInDataSampleTrafficMeter,
+		OutPacketsMeter:  miscOutDataSamplePacketsMeter,
+		OutTrafficMeter:  miscOutDataSampleTrafficMeter,
+		ServingTimeMeter: miscServingTimeDataSampleTimer,
+		Handle:           handleGetDataSamples,
+	},
 }
 
 // handleGetBlockHeaders handles a block header request
@@ -568,3 +580,34 @@ func txStatus(b serverBackend, hash common.Hash) light.TxStatus {
 	}
 	return stat
 }
+
+// handleGetDataSamples handles a data availability sampling request
+func handleGetDataSamples(msg Decoder) (serveRequestFn, uint64, uint64, error) {
+	var r GetDataSamplesPacket
+	if err := msg.Decode(&r); err != nil {
+		return nil, 0, 0, err
+	}
+	return func(backend serverBackend, p *clientPeer, waitOrStop func() bool) *reply {
+		var samples []*das.Sample
+		for i, request := range r.Reqs {
+			if i != 0 && !waitOrStop() {
+				return nil
+			}
+			// Blocks unknown to the server may be requested on a race with
+			// a reorg, their samples are left out without penalty.
+			matrix, err := backend.DataMatrix(request.BHash)
+			if err != nil {
+				p.Log().Debug("Failed to extend block data", "hash", request.BHash, "err", err)
+				continue
+			}
+			// Withheld chunks are left out of the reply, the client detects
+			// them by the positions missing.
+			sample, err := matrix.Sample(request.Row, request.Col)
+			if err != nil {
+				continue
+			}
+			samples = append(samples, sample)
+		}
+		return p.replyDataSamples(r.ReqID, samples)
+	}, r.ReqID, uint64(len(r.Reqs)), nil
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/txpool"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -196,3 +197,22 @@ type TxStatusRequest struct {
 
 // StoreResult stores the retrieved data in local database
 func (req *TxStatusRequest) StoreResult(db ethdb.Database) {}
+
+// DataSamplePosition is the position of a chunk in the extended data square of
+// a block, reduced modulo the width of the square by the server.
+type DataSamplePosition struct {
+	Row, Col uint16
+}
+
+// DataSamplesRequest is the ODR request type for sampling chunks of the
+// erasure-coded transaction list of a block
+type DataSamplesRequest struct {
+	Hash      common.Hash
+	Number    uint64
+	Header    *types.Header
+	Positions []DataSamplePosition
+	Samples   []*das.Sample // proven chunks by position, nil if withheld
+}
+
+// StoreResult stores the retrieved data in local database
+func (req *DataSamplesRequest) StoreResult(db ethdb.Database) {}
@@ -19,10 +19,13 @@ package light
 import (
 	"bytes"
 	"context"
+	crand "crypto/rand"
+	"encoding/binary"
 	"errors"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/txpool"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -32,7 +35,15 @@ import (
 // errNonCanonicalHash is returned if the requested chain data doesn't belong
 // to the canonical chain. ODR can only retrieve the canonical chain data covered
 // by the CHT or Bloom trie for verification.
-var errNonCanonicalHash = errors.New("hash is not currently canonical")
+var (
+	errNonCanonicalHash = errors.New("hash is not currently canonical")
+
+	// ErrDataUnavailable is returned if a sampled chunk of a block is withheld.
+	ErrDataUnavailable = errors.New("block data unavailable")
+)
+
+// dataSamplesPerRequest is the number of chunks sampled per request.
+const dataSamplesPerRequest = 32
 
 // GetHeaderByNumber retrieves the canonical block header corresponding to the
 // given number. The returned header is proven by local CHT.
@@ -291,3 +302,45 @@ func GetTransaction(ctx context.Context, odr OdrBackend, txHash common.Hash) (*t
 	}
 	return body.Transactions[pos.Index], pos.BlockHash, pos.BlockIndex, pos.Index, nil
 }
+
+// SampleDataAvailability samples random chunks of the erasure-coded transaction
+// list of a block, committed to in the extra-data vanity of its header, and
+// returns the confidence that the block data is available. It returns
+// ErrDataUnavailable if any sampled chunk is withheld.
+func SampleDataAvailability(ctx context.Context, odr OdrBackend, header *types.Header, samples int) (float64, error) {
+	if _, err := das.HeaderRoot(header); err != nil {
+		return 0, err
+	}
+	var (
+		hash   = header.Hash()
+		number = header.Number.Uint64()
+		width  int
+	)
+	for sampled := 0; sampled < samples; {
+		// Draw the positions unpredictably for the server
+		positions := make([]DataSamplePosition, samples-sampled)
+		if len(positions) > dataSamplesPerRequest {
+			positions = positions[:dataSamplesPerRequest]
+		}
+		buf := make([]byte, 4*len(positions))
+		if _, err := crand.Read(buf); err != nil {
+			return 0, err
+		}
+		for i := range positions {
+			positions[i].Row = binary.BigEndian.Uint16(buf[4*i:])
+			positions[i].Col = binary.BigEndian.Uint16(buf[4*i+2:])
+		}
+		r := &DataSamplesRequest{Hash: hash, Number: number, Header: header, Positions: positions}
+		if err := odr.Retrieve(ctx, r); err != nil {
+			return 0, err
+		}
+		for _, sample := range r.Samples {
+			if sample == nil {
+				return 0, ErrDataUnavailable
+			}
+			width = int(sample.Width)
+		}
+		sampled += len(positions)
+	}
+	return das.Confidence(width, samples), nil
+}
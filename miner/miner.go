@@ -18,6 +18,7 @@
 package miner
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"sync"
@@ -55,6 +56,8 @@ type Config struct {
 	Recommit   time.Duration  // The time interval for miner to re-create mining work.
 	Noverify   bool           // Disable remote mining solution verification(only useful in ethash).
 
+	DataAvailability bool // Commit to the erasure-coded transaction list in the extra-data vanity of sealed blocks, and require it of imported blocks
+
 	NewPayloadTimeout time.Duration // The maximum time allowance for creating a new payload
 }
 
@@ -190,6 +193,9 @@ func (miner *Miner) SetExtra(extra []byte) error {
 	if uint64(len(extra)) > params.MaximumExtraDataSize {
 		return fmt.Errorf("extra exceeds max length. %d > %v", len(extra), params.MaximumExtraDataSize)
 	}
+	if miner.worker.config.DataAvailability && len(extra) != 0 {
+		return errors.New("extra vanity reserved for the data root")
+	}
 	miner.worker.setExtra(extra)
 	return nil
 }
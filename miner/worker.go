@@ -29,11 +29,13 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/misc"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 )
 
@@ -99,6 +101,7 @@ type environment struct {
 	txs      []*types.Transaction
 	receipts []*types.Receipt
 	uncles   map[common.Hash]*types.Header
+	dataSize uint64 // upper bound of the encoded size of the txs, without list header
 }
 
 // copy creates a deep copy of environment.
@@ -112,6 +115,7 @@ func (env *environment) copy() *environment {
 		coinbase:  env.coinbase,
 		header:    types.CopyHeader(env.header),
 		receipts:  copyReceipts(env.receipts),
+		dataSize:  env.dataSize,
 	}
 	if env.gasPool != nil {
 		gasPool := *env.gasPool
@@ -873,10 +877,17 @@ func (w *worker) commitTransaction(env *environment, tx *types.Transaction) ([]*
 	}
 	env.txs = append(env.txs, tx)
 	env.receipts = append(env.receipts, receipt)
+	env.dataSize += txDataSize(tx)
 
 	return receipt.Logs, nil
 }
 
+// txDataSize returns an upper bound of the size of the transaction encoded in
+// the transaction list of a block.
+func txDataSize(tx *types.Transaction) uint64 {
+	return rlp.ListSize(tx.Size())
+}
+
 func (w *worker) commitTransactions(env *environment, txs *types.TransactionsByPriceAndNonce, interrupt *int32) error {
 	gasLimit := env.header.GasLimit
 	if env.gasPool == nil {
@@ -901,6 +912,12 @@ func (w *worker) commitTransactions(env *environment, txs *types.TransactionsByP
 		if tx == nil {
 			break
 		}
+		// If the transaction list is committed to and doesn't fit in the data
+		// square with the transaction, we're done.
+		if w.config.DataAvailability && rlp.ListSize(env.dataSize+txDataSize(tx)) > das.MaxDataSize {
+			log.Trace("Not enough space in the data square for further transactions", "have", env.dataSize, "want", txDataSize(tx))
+			break
+		}
 		// Error may be ignored here. The error has already been checked
 		// during transaction acceptance is the transaction pool.
 		from, _ := types.Sender(env.signer, tx)
@@ -1014,8 +1031,11 @@ func (w *worker) prepareWork(genParams *generateParams) (*environment, error) {
 		Time:       timestamp,
 		Coinbase:   genParams.coinbase,
 	}
-	// Set the extra field.
-	if len(w.extra) != 0 {
+	// Set the extra field, reserving the vanity for the data root if the
+	// transaction list is committed to.
+	if w.config.DataAvailability {
+		header.Extra = make([]byte, common.HashLength)
+	} else if len(w.extra) != 0 {
 		header.Extra = w.extra
 	}
 	// Set the randomness field from the beacon chain if it's available.
@@ -1113,6 +1133,9 @@ func (w *worker) generateWork(params *generateParams) (*types.Block, *big.Int, e
 			log.Warn("Block building is interrupted", "allowance", common.PrettyDuration(w.newpayloadTimeout))
 		}
 	}
+	if err := w.commitDataRoot(work); err != nil {
+		return nil, nil, err
+	}
 	block, err := w.engine.FinalizeAndAssemble(w.chain, work.header, work.state, work.txs, work.unclelist(), work.receipts, params.withdrawals)
 	if err != nil {
 		return nil, nil, err
@@ -1186,6 +1209,20 @@ func (w *worker) commitWork(interrupt *int32, noempty bool, timestamp int64) {
 	w.current = work
 }
 
+// commitDataRoot commits to the erasure-coded transaction list of the block in
+// the extra-data vanity of its header, if data availability sampling is enabled.
+func (w *worker) commitDataRoot(env *environment) error {
+	if !w.config.DataAvailability {
+		return nil
+	}
+	matrix, err := das.NewTxMatrix(env.txs)
+	if err != nil {
+		return err
+	}
+	das.SetHeaderRoot(env.header, matrix.Root())
+	return nil
+}
+
 // commit runs any post-transaction state modifications, assembles the final block
 // and commits new work if consensus engine is running.
 // Note the assumption is held that the mutation is allowed to the passed env, do
@@ -1198,6 +1235,9 @@ func (w *worker) commit(env *environment, interval func(), update bool, start ti
 		// Create a local environment copy, avoid the data race with snapshot state.
 		// https://github.com/ethereum/go-ethereum/issues/24299
 		env := env.copy()
+		if err := w.commitDataRoot(env); err != nil {
+			return err
+		}
 		// Withdrawals are set to nil here, because this is only called in PoW.
 		block, err := w.engine.FinalizeAndAssemble(w.chain, env.header, env.state, env.txs, env.unclelist(), env.receipts, nil)
 		if err != nil {
@@ -30,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/txpool"
@@ -664,3 +665,51 @@ func testGetSealingWork(t *testing.T, chainConfig *params.ChainConfig, engine co
 		}
 	}
 }
+
+func TestDataAvailabilityRoot(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	engine := clique.New(cliqueChainConfig.Clique, db)
+	defer engine.Close()
+
+	config := *testConfig
+	config.DataAvailability = true
+	backend := newTestWorkerBackend(t, cliqueChainConfig, engine, db, 0)
+	backend.txPool.AddLocals(pendingTxs)
+	w := newWorker(&config, cliqueChainConfig, engine, backend, new(event.TypeMux), nil, false)
+	defer w.close()
+
+	block, _, err := w.getSealingBlock(backend.chain.Genesis().Hash(), uint64(time.Now().Unix()), common.Address{}, common.Hash{}, nil, false)
+	if err != nil {
+		t.Fatalf("failed to generate block: %v", err)
+	}
+	if len(block.Transactions()) == 0 {
+		t.Fatal("no transactions included")
+	}
+	matrix, err := das.NewTxMatrix(block.Transactions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := das.HeaderRoot(block.Header())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != matrix.Root() {
+		t.Errorf("data root mismatch: have %x, want %x", root, matrix.Root())
+	}
+	// The signer list and seal of clique follow the vanity
+	if len(block.Extra()) != 32+crypto.SignatureLength {
+		t.Errorf("extra data length mismatch: have %d, want %d", len(block.Extra()), 32+crypto.SignatureLength)
+	}
+	// Importers requiring the data root accept the block, but not with another
+	// data root
+	backend.chain.SetDataAvailability(true)
+	if err := backend.chain.Validator().ValidateBody(block); err != nil {
+		t.Errorf("block with data root rejected: %v", err)
+	}
+	header := block.Header()
+	header.Extra = common.CopyBytes(header.Extra)
+	header.Extra[0] ^= 0xff
+	if err := backend.chain.Validator().ValidateBody(block.WithSeal(header)); err == nil {
+		t.Error("block with wrong data root accepted")
+	}
+}
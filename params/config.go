@@ -432,6 +432,8 @@ type ChainConfig struct {
 	GrayGlacierBlock    *big.Int `json:"grayGlacierBlock,omitempty"`    // Eip-5133 (bomb delay) switch block (nil = no fork, 0 = already activated)
 	MergeNetsplitBlock  *big.Int `json:"mergeNetsplitBlock,omitempty"`  // Virtual fork after The Merge to use as a network splitter
 
+	ArchivedAccessBlock *big.Int `json:"archivedAccessBlock,omitempty"` // EC-Chain switch block charging accesses to accounts whose state rent has run out (nil = no fork, 0 = already activated)
+
 	// Fork scheduling was switched from blocks to timestamps here
 
 	ShanghaiTime *uint64 `json:"shanghaiTime,omitempty"` // Shanghai switch time (nil = no fork, 0 = already on shanghai)
@@ -447,9 +449,14 @@ type ChainConfig struct {
 	// even without having seen the TTD locally (safer long term).
 	TerminalTotalDifficultyPassed bool `json:"terminalTotalDifficultyPassed,omitempty"`
 
+	// StateRent, if set, charges the accounts touched by transactions rent for
+	// their residency in the state.
+	StateRent *StateRentConfig `json:"stateRent,omitempty"`
+
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
+	QBFT   *QBFTConfig   `json:"qbft,omitempty"`
 }
 
 // EthashConfig is the consensus engine configs for proof-of-work based sealing.
@@ -464,6 +471,10 @@ func (c *EthashConfig) String() string {
 type CliqueConfig struct {
 	Period uint64 `json:"period"` // Number of seconds between blocks to enforce
 	Epoch  uint64 `json:"epoch"`  // Epoch length to reset votes and checkpoint
+
+	// SignerContract, if set, is the system contract holding the authorized
+	// signers, read at every checkpoint instead of voting through the headers.
+	SignerContract *common.Address `json:"signerContract,omitempty"`
 }
 
 // String implements the stringer interface, returning the consensus engine details.
@@ -471,6 +482,31 @@ func (c *CliqueConfig) String() string {
 	return "clique"
 }
 
+// QBFTConfig is the consensus engine configs for byzantine fault tolerant
+// proof-of-authority sealing.
+type QBFTConfig struct {
+	Period         uint64 `json:"period"`         // Minimum number of seconds between blocks
+	RequestTimeout uint64 `json:"requestTimeout"` // Milliseconds before the first round of a height times out
+}
+
+// String implements the stringer interface, returning the consensus engine details.
+func (c *QBFTConfig) String() string {
+	return "qbft"
+}
+
+// StateRentConfig is the configuration of the state rent. Accounts are charged
+// per block of residency, proportionally to the size of the account, its code
+// and its storage.
+type StateRentConfig struct {
+	Block       *big.Int `json:"block"`       // Block from which rent is charged
+	RentPerByte *big.Int `json:"rentPerByte"` // Wei charged per byte and block
+}
+
+// String implements the stringer interface, returning the state rent details.
+func (c *StateRentConfig) String() string {
+	return fmt.Sprintf("state rent from #%v at %v wei per byte and block", c.Block, c.RentPerByte)
+}
+
 // Description returns a human-readable description of ChainConfig.
 func (c *ChainConfig) Description() string {
 	var banner string
@@ -498,6 +534,8 @@ func (c *ChainConfig) Description() string {
 		} else {
 			banner += "Consensus: Beacon (proof-of-stake), merged from Clique (proof-of-authority)\n"
 		}
+	case c.QBFT != nil:
+		banner += "Consensus: QBFT (byzantine fault tolerant proof-of-authority)\n"
 	default:
 		banner += "Consensus: unknown\n"
 	}
@@ -557,6 +595,14 @@ func (c *ChainConfig) Description() string {
 	if c.PragueTime != nil {
 		banner += fmt.Sprintf(" - Prague:                      @%-10v\n", *c.PragueTime)
 	}
+	if c.ArchivedAccessBlock != nil {
+		banner += "\n"
+		banner += fmt.Sprintf("Archived access: #%-8v\n", c.ArchivedAccessBlock)
+	}
+	if c.StateRent != nil {
+		banner += "\n"
+		banner += fmt.Sprintf("State rent: #%-8v (%v wei per byte and block)\n", c.StateRent.Block, c.StateRent.RentPerByte)
+	}
 	return banner
 }
 
@@ -632,6 +678,18 @@ func (c *ChainConfig) IsGrayGlacier(num *big.Int) bool {
 	return isBlockForked(c.GrayGlacierBlock, num)
 }
 
+// IsArchivedAccess returns whether num is either equal to the archived access
+// fork block or greater.
+func (c *ChainConfig) IsArchivedAccess(num *big.Int) bool {
+	return isBlockForked(c.ArchivedAccessBlock, num)
+}
+
+// IsStateRent returns whether num is either equal to the state rent block or
+// greater.
+func (c *ChainConfig) IsStateRent(num *big.Int) bool {
+	return c.StateRent != nil && isBlockForked(c.StateRent.Block, num)
+}
+
 // IsTerminalPoWBlock returns whether the given block is the last block of PoW stage.
 func (c *ChainConfig) IsTerminalPoWBlock(parentTotalDiff *big.Int, totalDiff *big.Int) bool {
 	if c.TerminalTotalDifficulty == nil {
@@ -744,6 +802,10 @@ func (c *ChainConfig) CheckConfigForkOrder() error {
 			lastFork = cur
 		}
 	}
+	// Accounts are archived once their rent runs out, there's none without rent
+	if c.ArchivedAccessBlock != nil && c.StateRent == nil {
+		return fmt.Errorf("archived access enabled at block %v without state rent", c.ArchivedAccessBlock)
+	}
 	return nil
 }
 
@@ -803,6 +865,15 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, headNumber *big.Int,
 	if isForkBlockIncompatible(c.MergeNetsplitBlock, newcfg.MergeNetsplitBlock, headNumber) {
 		return newBlockCompatError("Merge netsplit fork block", c.MergeNetsplitBlock, newcfg.MergeNetsplitBlock)
 	}
+	if isForkBlockIncompatible(c.ArchivedAccessBlock, newcfg.ArchivedAccessBlock, headNumber) {
+		return newBlockCompatError("Archived access fork block", c.ArchivedAccessBlock, newcfg.ArchivedAccessBlock)
+	}
+	if isForkBlockIncompatible(c.stateRentBlock(), newcfg.stateRentBlock(), headNumber) {
+		return newBlockCompatError("State rent block", c.stateRentBlock(), newcfg.stateRentBlock())
+	}
+	if c.IsStateRent(headNumber) && !configBlockEqual(c.StateRent.RentPerByte, newcfg.StateRent.RentPerByte) {
+		return newBlockCompatError("State rent price", c.stateRentBlock(), newcfg.stateRentBlock())
+	}
 	if isForkTimestampIncompatible(c.ShanghaiTime, newcfg.ShanghaiTime, headTimestamp) {
 		return newTimestampCompatError("Shanghai fork timestamp", c.ShanghaiTime, newcfg.ShanghaiTime)
 	}
@@ -815,6 +886,15 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, headNumber *big.Int,
 	return nil
 }
 
+// stateRentBlock returns the block from which state rent is charged, or nil
+// if there is no state rent.
+func (c *ChainConfig) stateRentBlock() *big.Int {
+	if c.StateRent == nil {
+		return nil
+	}
+	return c.StateRent.Block
+}
+
 // BaseFeeChangeDenominator bounds the amount the base fee can change between blocks.
 func (c *ChainConfig) BaseFeeChangeDenominator() uint64 {
 	return DefaultBaseFeeChangeDenominator
@@ -957,6 +1037,7 @@ type Rules struct {
 	IsByzantium, IsConstantinople, IsPetersburg, IsIstanbul bool
 	IsBerlin, IsLondon                                      bool
 	IsMerge, IsShanghai, IsCancun, IsPrague                 bool
+	IsArchivedAccess                                        bool
 }
 
 // Rules ensures c's ChainID is not nil.
@@ -981,5 +1062,6 @@ func (c *ChainConfig) Rules(num *big.Int, isMerge bool, timestamp uint64) Rules
 		IsShanghai:       c.IsShanghai(timestamp),
 		IsCancun:         c.IsCancun(timestamp),
 		IsPrague:         c.IsPrague(timestamp),
+		IsArchivedAccess: c.IsArchivedAccess(num),
 	}
 }
@@ -63,6 +63,9 @@ const (
 	ColdSloadCostEIP2929         = uint64(2100) // COLD_SLOAD_COST
 	WarmStorageReadCostEIP2929   = uint64(100)  // WARM_STORAGE_READ_COST
 
+	ArchivedAccountAccessCost = uint64(10400) // Cold access to an account living in an erasure-coded cold shard
+	ArchivedSloadCost         = uint64(8400)  // Cold access to a storage slot living in an erasure-coded cold shard
+
 	// In EIP-2200: SstoreResetGas was 5000.
 	// In EIP-2929: SstoreResetGas was changed to '5000 - COLD_SLOAD_COST'.
 	// In EIP-3529: SSTORE_CLEARS_SCHEDULE is defined as SSTORE_RESET_GAS + ACCESS_LIST_STORAGE_KEY_COST
@@ -159,6 +162,9 @@ const (
 	// up to half the consumed gas could be refunded. Redefined as 1/5th in EIP-3529
 	RefundQuotient        uint64 = 2
 	RefundQuotientEIP3529 uint64 = 5
+
+	AccountRentSize     uint64 = 128 // Bytes an account is charged state rent for besides its code and storage (key, nonce, balance, roots).
+	StorageSlotRentSize uint64 = 64  // Bytes a storage slot is charged state rent for (key and value).
 )
 
 // Gas discount table for BLS12-381 G1 and G2 multi exponentiation operations
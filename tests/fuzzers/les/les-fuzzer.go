@@ -19,12 +19,14 @@ package les
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/das"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/txpool"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -251,6 +253,14 @@ func (f *fuzzer) GetHelperTrie(typ uint, index uint64) *trie.Trie {
 	return nil
 }
 
+func (f *fuzzer) DataMatrix(hash common.Hash) (*das.Matrix, error) {
+	block := f.chain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, errors.New("unknown block")
+	}
+	return das.NewTxMatrix(block.Transactions())
+}
+
 type dummyMsg struct {
 	data []byte
 }
@@ -264,7 +274,7 @@ func (f *fuzzer) doFuzz(msgCode uint64, packet interface{}) {
 	if err != nil {
 		panic(err)
 	}
-	version := f.randomInt(3) + 2 // [LES2, LES3, LES4]
+	version := f.randomInt(4) + 2 // [LES2, LES3, LES4, LES5]
 	peer, closeFn := l.NewFuzzerPeer(version)
 	defer closeFn()
 	fn, _, _, err := l.Les3[msgCode].Handle(dummyMsg{enc})
@@ -284,7 +294,7 @@ func Fuzz(input []byte) int {
 		return -1
 	}
 	for !f.exhausted {
-		switch f.randomInt(8) {
+		switch f.randomInt(9) {
 		case 0:
 			req := &l.GetBlockHeadersPacket{
 				Query: l.GetBlockHeadersData{
@@ -400,6 +410,17 @@ func Fuzz(input []byte) int {
 				req.Hashes[i] = f.randomTxHash()
 			}
 			f.doFuzz(l.GetTxStatusMsg, req)
+
+		case 8:
+			req := &l.GetDataSamplesPacket{Reqs: make([]l.DataSampleReq, f.randomInt(l.MaxDataSampleFetch+1))}
+			for i := range req.Reqs {
+				req.Reqs[i] = l.DataSampleReq{
+					BHash: f.randomBlockHash(),
+					Row:   uint16(f.randomX(1 << 16)),
+					Col:   uint16(f.randomX(1 << 16)),
+				}
+			}
+			f.doFuzz(l.GetDataSamplesMsg, req)
 		}
 	}
 	return 0
@@ -22,6 +22,7 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/trie"
 )
 
@@ -142,6 +143,7 @@ func runRandTest(rt randTest) error {
 	triedb := trie.NewDatabase(rawdb.NewMemoryDatabase())
 
 	tr := trie.NewEmpty(triedb)
+	origin := types.EmptyRootHash
 	values := make(map[string]string) // tracks content of the trie
 
 	for i, step := range rt {
@@ -163,7 +165,7 @@ func runRandTest(rt randTest) error {
 		case opCommit:
 			hash, nodes := tr.Commit(false)
 			if nodes != nil {
-				if err := triedb.Update(trie.NewWithNodeSet(nodes)); err != nil {
+				if err := triedb.Update(hash, origin, trie.NewWithNodeSet(nodes)); err != nil {
 					return err
 				}
 			}
@@ -171,7 +173,7 @@ func runRandTest(rt randTest) error {
 			if err != nil {
 				return err
 			}
-			tr = newtr
+			tr, origin = newtr, hash
 		case opItercheckhash:
 			checktr := trie.NewEmpty(triedb)
 			it := trie.NewIterator(tr.NodeIterator(nil))
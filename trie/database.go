@@ -87,6 +87,8 @@ type Database struct {
 	childrenSize common.StorageSize // Storage size of the external children tracking
 	preimages    *preimageStore     // The store for caching preimages
 
+	path *pathDB // Path-based node store, nil for the hash-based scheme
+
 	lock sync.RWMutex
 }
 
@@ -268,6 +270,8 @@ type Config struct {
 	Cache     int    // Memory allowance (MB) to use for caching trie nodes in memory
 	Journal   string // Journal of clean cache to survive node restarts
 	Preimages bool   // Flag whether the preimage of trie key is recorded
+
+	PathDB *PathConfig // Settings of the path-based node store, nil for the hash-based scheme
 }
 
 // NewDatabase creates a new trie database to store ephemeral trie content before
@@ -301,6 +305,9 @@ func NewDatabaseWithConfig(diskdb ethdb.Database, config *Config) *Database {
 		}},
 		preimages: preimage,
 	}
+	if config != nil && config.PathDB != nil {
+		db.path = newPathDB(diskdb, config.PathDB, cleans)
+	}
 	return db
 }
 
@@ -380,6 +387,10 @@ func (db *Database) node(hash common.Hash) node {
 // Node retrieves an encoded cached trie node from memory. If it cannot be found
 // cached, the method queries the persistent database for the content.
 func (db *Database) Node(hash common.Hash) ([]byte, error) {
+	// Nodes aren't keyed by hash in the path-based scheme
+	if db.path != nil {
+		return nil, errNotSupported
+	}
 	// It doesn't make sense to retrieve the metaroot
 	if hash == (common.Hash{}) {
 		return nil, errors.New("not found")
@@ -421,6 +432,9 @@ func (db *Database) Node(hash common.Hash) ([]byte, error) {
 // This method is extremely expensive and should only be used to validate internal
 // states in test code.
 func (db *Database) Nodes() []common.Hash {
+	if db.path != nil {
+		return nil
+	}
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
@@ -437,7 +451,12 @@ func (db *Database) Nodes() []common.Hash {
 // This function is used to add reference between internal trie node
 // and external node(e.g. storage trie root), all internal trie nodes
 // are referenced together by database itself.
+//
+// It's a noop in the path-based scheme, where nodes are overwritten in place.
 func (db *Database) Reference(child common.Hash, parent common.Hash) {
+	if db.path != nil {
+		return
+	}
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
@@ -466,7 +485,12 @@ func (db *Database) reference(child common.Hash, parent common.Hash) {
 }
 
 // Dereference removes an existing reference from a root node.
+//
+// It's a noop in the path-based scheme, where nodes are overwritten in place.
 func (db *Database) Dereference(root common.Hash) {
+	if db.path != nil {
+		return
+	}
 	// Sanity check to ensure that the meta-root is not removed
 	if root == (common.Hash{}) {
 		log.Error("Attempted to dereference the trie cache meta root")
@@ -545,7 +569,13 @@ func (db *Database) dereference(child common.Hash, parent common.Hash) {
 //
 // Note, this method is a non-synchronized mutator. It is unsafe to call this
 // concurrently with other mutators.
+//
+// It's a noop in the path-based scheme, which bounds the in-memory states by
+// itself.
 func (db *Database) Cap(limit common.StorageSize) error {
+	if db.path != nil {
+		return nil
+	}
 	// Create a database batch to flush persistent data out. It is important that
 	// outside code doesn't see an inconsistent state (referenced data removed from
 	// memory cache during commit but not yet in persistent storage). This is ensured
@@ -646,6 +676,9 @@ func (db *Database) Commit(node common.Hash, report bool) error {
 			return err
 		}
 	}
+	if db.path != nil {
+		return db.path.commit(node, report)
+	}
 	// Move the trie itself into the batch, flushing if enough data is accumulated
 	nodes, storage := len(db.dirties), db.dirtiesSize
 
@@ -769,8 +802,13 @@ func (c *cleaner) Delete(key []byte) error {
 }
 
 // Update inserts the dirty nodes in provided nodeset into database and
-// link the account trie with multiple storage tries if necessary.
-func (db *Database) Update(nodes *MergedNodeSet) error {
+// link the account trie with multiple storage tries if necessary. The root
+// and parent are the state roots after and before the changes, which the
+// path-based scheme keys the states by.
+func (db *Database) Update(root common.Hash, parent common.Hash, nodes *MergedNodeSet) error {
+	if db.path != nil {
+		return db.path.update(root, parent, nodes)
+	}
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
@@ -818,6 +856,13 @@ func (db *Database) Update(nodes *MergedNodeSet) error {
 // Size returns the current storage size of the memory cache in front of the
 // persistent database layer.
 func (db *Database) Size() (common.StorageSize, common.StorageSize) {
+	var preimageSize common.StorageSize
+	if db.preimages != nil {
+		preimageSize = db.preimages.size()
+	}
+	if db.path != nil {
+		return db.path.size(), preimageSize
+	}
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
@@ -826,15 +871,15 @@ func (db *Database) Size() (common.StorageSize, common.StorageSize) {
 	// counted.
 	var metadataSize = common.StorageSize((len(db.dirties) - 1) * cachedNodeSize)
 	var metarootRefs = common.StorageSize(len(db.dirties[common.Hash{}].children) * (common.HashLength + 2))
-	var preimageSize common.StorageSize
-	if db.preimages != nil {
-		preimageSize = db.preimages.size()
-	}
 	return db.dirtiesSize + db.childrenSize + metadataSize - metarootRefs, preimageSize
 }
 
 // GetReader retrieves a node reader belonging to the given state root.
+// Nil is returned if the state is not available in the path-based scheme.
 func (db *Database) GetReader(root common.Hash) Reader {
+	if db.path != nil {
+		return db.path.reader(root)
+	}
 	return newHashReader(db)
 }
 
@@ -916,5 +961,49 @@ func (db *Database) CommitPreimages() error {
 
 // Scheme returns the node scheme used in the database.
 func (db *Database) Scheme() string {
+	if db.path != nil {
+		return rawdb.PathScheme
+	}
 	return rawdb.HashScheme
 }
+
+// Initialized reports whether the state of the genesis block with the given
+// root is stored.
+func (db *Database) Initialized(genesisRoot common.Hash) bool {
+	if db.path != nil {
+		return rawdb.ReadStateScheme(db.diskdb) == rawdb.PathScheme
+	}
+	return rawdb.HasLegacyTrieNode(db.diskdb, genesisRoot)
+}
+
+// Recoverable reports whether the persistent state can be rolled back to the
+// state with the given root. It's always false in the hash-based scheme.
+func (db *Database) Recoverable(root common.Hash) bool {
+	if db.path == nil {
+		return false
+	}
+	db.path.lock.RLock()
+	defer db.path.lock.RUnlock()
+
+	return db.path.recoverable(root)
+}
+
+// Recover rolls the persistent state back to the state with the given root,
+// dropping all the newer states. It's only supported by the path-based scheme.
+func (db *Database) Recover(root common.Hash) error {
+	if db.path == nil {
+		return errNotSupported
+	}
+	return db.path.recover(root)
+}
+
+// Reset makes the state with the given root, fully stored on disk, the only
+// state known to the database, or wipes all the states if the root is empty.
+// It's meant to be called once the state is synced, or before the state is
+// regenerated from genesis. It's only supported by the path-based scheme.
+func (db *Database) Reset(root common.Hash) error {
+	if db.path == nil {
+		return errNotSupported
+	}
+	return db.path.reset(root)
+}
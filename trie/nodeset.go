@@ -142,6 +142,16 @@ func (set *NodeSet) markDeleted(path []byte) {
 	set.deletes += 1
 }
 
+// AddDeleted marks the node at the given path as deleted, unless the set
+// already holds a node there. It's used to wipe the stale nodes of a trie that
+// is discarded as a whole, e.g. the storage of a destructed contract.
+func (set *NodeSet) AddDeleted(path []byte) {
+	if _, ok := set.nodes[string(path)]; ok {
+		return
+	}
+	set.markDeleted(path)
+}
+
 // addLeaf collects the provided leaf node into set.
 func (set *NodeSet) addLeaf(node *leaf) {
 	set.leaves = append(set.leaves, node)
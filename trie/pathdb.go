@@ -0,0 +1,414 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// maxDiffLayers is the number of diff layers kept in memory on top of the disk
+// layer, beyond which the bottom-most ones are merged into it.
+const maxDiffLayers = 128
+
+var (
+	// errLayerStale is returned when reading from a disk layer which has been
+	// superseded by a newer one.
+	errLayerStale = errors.New("layer stale")
+
+	// errUnexpectedNode is returned when the node stored at a path isn't the
+	// one requested.
+	errUnexpectedNode = errors.New("unexpected node")
+
+	// errStateUnrecoverable is returned when the persistent state can't be
+	// rolled back to the requested state, lacking the reverse diffs.
+	errStateUnrecoverable = errors.New("state is unrecoverable")
+
+	// errNotSupported is returned for the operations the node scheme of the
+	// database doesn't support.
+	errNotSupported = errors.New("not supported by the node scheme")
+)
+
+// PathConfig contains the settings of the path-based node store.
+type PathConfig struct {
+	StateHistory uint64 // Number of recent states to keep the reverse diffs of, 0 for all
+	DirtyCache   int    // Memory allowance (MB) to aggregate the node writes to disk
+}
+
+// pathDB is the path-based node store. It keeps a single version of every trie
+// node on disk, keyed by the trie owner and the node path, so stale nodes are
+// overwritten instead of piling up.
+//
+// The recent states are kept in memory as a stack of diff layers on top of the
+// disk layer, the persistent state. Once the stack exceeds maxDiffLayers, the
+// bottom-most diff layer is merged into the disk layer, recording the nodes it
+// overwrites as a reverse diff. The reverse diffs allow rolling the persistent
+// state back by as many states as they're kept for.
+type pathDB struct {
+	diskdb ethdb.Database
+	config PathConfig
+	cleans *fastcache.Cache // Clean node cache keyed by owner and path, shared with the hash-based scheme
+
+	layers map[common.Hash]layer // All the layers, keyed by state root
+	lock   sync.RWMutex          // Protects the layers
+}
+
+// newPathDB opens the path-based node store on top of the persistent state.
+func newPathDB(diskdb ethdb.Database, config *PathConfig, cleans *fastcache.Cache) *pathDB {
+	db := &pathDB{
+		diskdb: diskdb,
+		config: *config,
+		cleans: cleans,
+	}
+	dl := db.loadDiskLayer()
+	db.layers = map[common.Hash]layer{dl.root: dl}
+	return db
+}
+
+// loadDiskLayer creates the disk layer of the persistent state. The reverse
+// diffs of the states lost at an unclean shutdown are dropped.
+func (db *pathDB) loadDiskLayer() *diskLayer {
+	root := types.EmptyRootHash
+	if blob, _ := rawdb.ReadAccountTrieNode(db.diskdb, nil); len(blob) > 0 {
+		root = crypto.Keccak256Hash(blob)
+	}
+	id := rawdb.ReadPersistentStateID(db.diskdb)
+
+	batch := db.diskdb.NewBatch()
+	for next := id + 1; rawdb.HasReverseDiff(db.diskdb, next); next++ {
+		if diff, err := db.readReverseDiff(next); err == nil {
+			if stored := rawdb.ReadStateID(db.diskdb, diff.Root); stored != nil && *stored == next {
+				rawdb.DeleteStateID(batch, diff.Root)
+			}
+		}
+		rawdb.DeleteReverseDiff(batch, next)
+	}
+	if stored := rawdb.ReadStateID(db.diskdb, root); stored == nil || *stored != id {
+		rawdb.WriteStateID(batch, root, id)
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to truncate reverse diffs", "err", err)
+	}
+	return newDiskLayer(root, id, db, newNodeBuffer(db.config.DirtyCache))
+}
+
+// disk returns the disk layer.
+func (db *pathDB) disk() *diskLayer {
+	for _, l := range db.layers {
+		if dl, ok := l.(*diskLayer); ok {
+			return dl
+		}
+	}
+	panic("missing disk layer")
+}
+
+// reader returns a reader of the state with the given root, or nil if it's not
+// available.
+func (db *pathDB) reader(root common.Hash) Reader {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if root == (common.Hash{}) {
+		root = types.EmptyRootHash
+	}
+	l := db.layers[root]
+	if l == nil {
+		// Empty tries never resolve nodes, any state serves them
+		if root != types.EmptyRootHash {
+			return nil
+		}
+		l = db.disk()
+	}
+	return &pathReader{layer: l}
+}
+
+// update adds the state transition from parent to root, made of the dirty
+// nodes, as a diff layer on top of the parent state. The bottom-most diff
+// layers are merged into the disk layer to keep maxDiffLayers of them.
+func (db *pathDB) update(root common.Hash, parent common.Hash, nodes *MergedNodeSet) error {
+	if parent == (common.Hash{}) {
+		parent = types.EmptyRootHash
+	}
+	if root == parent {
+		return nil
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.layers[root]; ok {
+		return nil
+	}
+	base := db.layers[parent]
+	if base == nil {
+		return fmt.Errorf("parent state %x missing", parent)
+	}
+	sets := make(map[common.Hash]map[string]*pathNode)
+	for owner, set := range nodes.sets {
+		subset := make(map[string]*pathNode, len(set.nodes))
+		for path, n := range set.nodes {
+			if n.isDeleted() {
+				subset[path] = &pathNode{}
+			} else {
+				subset[path] = &pathNode{hash: n.hash, blob: n.rlp()}
+			}
+		}
+		sets[owner] = subset
+	}
+	dl := newDiffLayer(base, root, base.stateID()+1, sets)
+	db.layers[root] = dl
+
+	return db.cap(dl, maxDiffLayers)
+}
+
+// cap merges the bottom-most diff layers below the given one into the disk
+// layer, until at most the given number of them remain.
+func (db *pathDB) cap(top *diffLayer, layers int) error {
+	for {
+		var (
+			bottom *diffLayer
+			depth  int
+		)
+		for current := layer(top); ; {
+			diff, ok := current.(*diffLayer)
+			if !ok {
+				break
+			}
+			bottom, depth = diff, depth+1
+			current = diff.parentLayer()
+		}
+		if depth <= layers {
+			return nil
+		}
+		if err := db.persist(bottom, false); err != nil {
+			return err
+		}
+	}
+}
+
+// persist merges the bottom-most diff layer into the disk layer. The diff
+// layers forked below it are dropped, as the states they're built on can't be
+// served any more.
+func (db *pathDB) persist(bottom *diffLayer, force bool) error {
+	base, err := bottom.parentLayer().(*diskLayer).commit(bottom, force)
+	if err != nil {
+		return err
+	}
+	for _, l := range db.layers {
+		if diff, ok := l.(*diffLayer); ok && diff.parentLayer() == layer(bottom) {
+			diff.setParent(base)
+		}
+	}
+	layers := map[common.Hash]layer{base.root: base}
+	for root, l := range db.layers {
+		if diff, ok := l.(*diffLayer); ok && diff != bottom && diff.diskLayer() == base {
+			layers[root] = diff
+		}
+	}
+	db.layers = layers
+	return nil
+}
+
+// commit merges all the diff layers below the state with the given root into
+// the disk layer, and writes all the buffered nodes to disk.
+func (db *pathDB) commit(root common.Hash, report bool) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.layers[root] == nil {
+		return fmt.Errorf("state %x missing", root)
+	}
+	var (
+		start  = time.Now()
+		layers int
+	)
+	for {
+		var bottom *diffLayer
+		for current := db.layers[root]; ; {
+			diff, ok := current.(*diffLayer)
+			if !ok {
+				break
+			}
+			bottom, current = diff, diff.parentLayer()
+		}
+		if bottom == nil {
+			break
+		}
+		if err := db.persist(bottom, false); err != nil {
+			return err
+		}
+		layers++
+	}
+	dl := db.disk()
+	nodes, size := dl.buffer.count(), dl.buffer.size
+	if err := dl.flush(); err != nil {
+		return err
+	}
+	logger := log.Debug
+	if report {
+		logger = log.Info
+	}
+	logger("Persisted trie from memory database", "layers", layers, "nodes", nodes, "size", common.StorageSize(size), "id", dl.id, "time", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// size returns the memory used by the diff layers and the node buffer.
+func (db *pathDB) size() common.StorageSize {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var size uint64
+	for _, l := range db.layers {
+		switch l := l.(type) {
+		case *diffLayer:
+			size += l.memory
+		case *diskLayer:
+			size += l.buffer.size
+		}
+	}
+	return common.StorageSize(size)
+}
+
+// recoverable reports whether the persistent state can be rolled back to the
+// state with the given root. The lock must be held.
+func (db *pathDB) recoverable(root common.Hash) bool {
+	id := rawdb.ReadStateID(db.diskdb, root)
+	if id == nil {
+		return false
+	}
+	dl := db.disk()
+	if *id >= dl.id {
+		return false
+	}
+	for next := *id + 1; next <= dl.id; next++ {
+		if !rawdb.HasReverseDiff(db.diskdb, next) {
+			return false
+		}
+	}
+	return true
+}
+
+// recover rolls the persistent state back to the one with the given root by
+// applying the reverse diffs, dropping all the diff layers.
+func (db *pathDB) recover(root common.Hash) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if !db.recoverable(root) {
+		return errStateUnrecoverable
+	}
+	// Write out the buffered nodes first, the reverse diffs apply to disk
+	dl := db.disk()
+	if err := dl.flush(); err != nil {
+		return err
+	}
+	dl.markStale()
+
+	var (
+		start   = time.Now()
+		batch   = db.diskdb.NewBatch()
+		current = dl.root
+		id      = dl.id
+	)
+	for current != root {
+		diff, err := db.readReverseDiff(id)
+		if err != nil {
+			return err
+		}
+		if diff.Root != current {
+			return fmt.Errorf("reverse diff %d root mismatch: have %x, want %x", id, diff.Root, current)
+		}
+		diff.apply(batch)
+		rawdb.DeleteReverseDiff(batch, id)
+		if stored := rawdb.ReadStateID(db.diskdb, current); stored != nil && *stored == id {
+			rawdb.DeleteStateID(batch, current)
+		}
+		current, id = diff.Parent, id-1
+	}
+	rawdb.WritePersistentStateID(batch, id)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if db.cleans != nil {
+		db.cleans.Reset()
+	}
+	db.layers = map[common.Hash]layer{root: newDiskLayer(root, id, db, newNodeBuffer(db.config.DirtyCache))}
+	log.Info("Rolled back persistent state", "from", dl.root, "to", root, "states", dl.id-id, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// reset makes the state with the given root the persistent state, dropping
+// all the diff layers and reverse diffs. The state must be the one stored on
+// disk, like after a sync, or empty to wipe the stored state.
+func (db *pathDB) reset(root common.Hash) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if root == types.EmptyRootHash {
+		rawdb.DeletePathTrieNodes(db.diskdb)
+	} else {
+		blob, _ := rawdb.ReadAccountTrieNode(db.diskdb, nil)
+		if len(blob) == 0 || crypto.Keccak256Hash(blob) != root {
+			return fmt.Errorf("state %x not stored", root)
+		}
+	}
+	rawdb.DeleteStateHistory(db.diskdb)
+
+	batch := db.diskdb.NewBatch()
+	rawdb.WritePersistentStateID(batch, 0)
+	rawdb.WriteStateID(batch, root, 0)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	db.disk().markStale()
+	if db.cleans != nil {
+		db.cleans.Reset()
+	}
+	db.layers = map[common.Hash]layer{root: newDiskLayer(root, 0, db, newNodeBuffer(db.config.DirtyCache))}
+	log.Info("Reset persistent state", "root", root)
+	return nil
+}
+
+// pathReader is a reader of a state of the path-based node store.
+type pathReader struct {
+	layer layer
+}
+
+// Node retrieves the trie node with the given owner, path and hash.
+// No error will be returned if the node is not found.
+func (r *pathReader) Node(owner common.Hash, path []byte, hash common.Hash) (node, error) {
+	blob, err := r.layer.node(owner, path, hash)
+	if err != nil || len(blob) == 0 {
+		return nil, err
+	}
+	return decodeNodeUnsafe(hash[:], blob)
+}
+
+// NodeBlob retrieves the RLP-encoded trie node with the given owner, path and
+// hash. No error will be returned if the node is not found.
+func (r *pathReader) NodeBlob(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	return r.layer.node(owner, path, hash)
+}
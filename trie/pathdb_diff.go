@@ -0,0 +1,74 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// reverseDiffNode is the previous value of a node overwritten by a state
+// transition.
+type reverseDiffNode struct {
+	Path []byte // Path of the node in its trie
+	Blob []byte // RLP-encoded previous node, empty if it didn't exist
+}
+
+// reverseDiffTrie groups the previous values of the nodes of a trie.
+type reverseDiffTrie struct {
+	Owner common.Hash // Owner of the trie, zero for the account trie
+	Nodes []reverseDiffNode
+}
+
+// reverseDiff holds the nodes overwritten by a state transition of the
+// persistent state, allowing to roll it back to the parent state.
+type reverseDiff struct {
+	Parent common.Hash // Root of the state before the transition
+	Root   common.Hash // Root of the state after the transition
+	Tries  []reverseDiffTrie
+}
+
+// encode returns the RLP encoding of the reverse diff.
+func (diff *reverseDiff) encode() ([]byte, error) {
+	return rlp.EncodeToBytes(diff)
+}
+
+// apply writes the previous values of the nodes into the given batch.
+func (diff *reverseDiff) apply(batch ethdb.KeyValueWriter) {
+	for _, trie := range diff.Tries {
+		for _, n := range trie.Nodes {
+			writePathNode(batch, trie.Owner, n.Path, n.Blob)
+		}
+	}
+}
+
+// readReverseDiff reads and decodes the reverse diff with the given id.
+func (db *pathDB) readReverseDiff(id uint64) (*reverseDiff, error) {
+	blob := rawdb.ReadReverseDiff(db.diskdb, id)
+	if len(blob) == 0 {
+		return nil, fmt.Errorf("reverse diff %d missing", id)
+	}
+	var diff reverseDiff
+	if err := rlp.DecodeBytes(blob, &diff); err != nil {
+		return nil, fmt.Errorf("reverse diff %d invalid: %v", id, err)
+	}
+	return &diff, nil
+}
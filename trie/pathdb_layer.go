@@ -0,0 +1,426 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// pathNode is a trie node of the path-based node store.
+type pathNode struct {
+	hash common.Hash // Node hash, empty for deleted nodes
+	blob []byte      // RLP-encoded node, nil for deleted nodes
+}
+
+// pathNodeSize is the approximate memory used by a pathNode without its blob.
+const pathNodeSize = common.HashLength + 24
+
+// layer is a state of the path-based node store, either in memory on top of
+// other states, or the persistent one.
+type layer interface {
+	// rootHash returns the root of the state.
+	rootHash() common.Hash
+
+	// stateID returns the sequence number of the state, incremented with
+	// every state transition.
+	stateID() uint64
+
+	// node retrieves the RLP-encoded trie node with the given owner, path and
+	// hash. No error will be returned if the node is not found.
+	node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error)
+}
+
+// checkNode verifies the node found at a path is the requested one.
+func checkNode(owner common.Hash, path []byte, hash common.Hash, n *pathNode) ([]byte, error) {
+	if n.hash != hash {
+		return nil, fmt.Errorf("%w %x at %x%x: have %x", errUnexpectedNode, hash, owner, path, n.hash)
+	}
+	return n.blob, nil
+}
+
+// diffLayer is an in-memory state, made of the nodes changed by a state
+// transition on top of its parent state.
+type diffLayer struct {
+	root   common.Hash                          // Root of the state
+	id     uint64                               // Sequence number of the state
+	nodes  map[common.Hash]map[string]*pathNode // Changed nodes, keyed by owner and path
+	memory uint64                               // Approximate memory used by the nodes
+	parent layer                                // Parent state, either a diff or the disk layer
+	lock   sync.RWMutex                         // Protects the parent
+}
+
+// newDiffLayer creates a diff layer on top of the given parent.
+func newDiffLayer(parent layer, root common.Hash, id uint64, nodes map[common.Hash]map[string]*pathNode) *diffLayer {
+	dl := &diffLayer{
+		root:   root,
+		id:     id,
+		nodes:  nodes,
+		parent: parent,
+	}
+	for _, subset := range nodes {
+		for path, n := range subset {
+			dl.memory += uint64(pathNodeSize + len(path) + len(n.blob))
+		}
+	}
+	return dl
+}
+
+// rootHash implements layer.
+func (dl *diffLayer) rootHash() common.Hash {
+	return dl.root
+}
+
+// stateID implements layer.
+func (dl *diffLayer) stateID() uint64 {
+	return dl.id
+}
+
+// parentLayer returns the parent of the layer.
+func (dl *diffLayer) parentLayer() layer {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.parent
+}
+
+// setParent replaces the parent of the layer, once it's merged into the disk
+// layer.
+func (dl *diffLayer) setParent(parent layer) {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.parent = parent
+}
+
+// diskLayer returns the disk layer the layer is built on.
+func (dl *diffLayer) diskLayer() *diskLayer {
+	for current := dl.parentLayer(); ; {
+		switch l := current.(type) {
+		case *diskLayer:
+			return l
+		case *diffLayer:
+			current = l.parentLayer()
+		}
+	}
+}
+
+// node implements layer, looking up the node in the layer and its parents.
+func (dl *diffLayer) node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	if subset, ok := dl.nodes[owner]; ok {
+		if n, ok := subset[string(path)]; ok {
+			return checkNode(owner, path, hash, n)
+		}
+	}
+	return dl.parentLayer().node(owner, path, hash)
+}
+
+// diskLayer is the persistent state, with the nodes of the merged diff layers
+// buffered in memory until they're flushed to disk.
+type diskLayer struct {
+	root   common.Hash  // Root of the state
+	id     uint64       // Sequence number of the state
+	db     *pathDB      // Node store the layer belongs to
+	buffer *nodeBuffer  // Nodes not yet flushed to disk, shared with the following disk layers
+	stale  bool         // Whether the layer was superseded by a newer one
+	lock   sync.RWMutex // Protects the staleness and the buffer
+}
+
+// newDiskLayer creates a disk layer with the given buffered nodes.
+func newDiskLayer(root common.Hash, id uint64, db *pathDB, buffer *nodeBuffer) *diskLayer {
+	return &diskLayer{
+		root:   root,
+		id:     id,
+		db:     db,
+		buffer: buffer,
+	}
+}
+
+// rootHash implements layer.
+func (dl *diskLayer) rootHash() common.Hash {
+	return dl.root
+}
+
+// stateID implements layer.
+func (dl *diskLayer) stateID() uint64 {
+	return dl.id
+}
+
+// markStale marks the layer superseded, failing all the reads through it.
+func (dl *diskLayer) markStale() {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.stale = true
+}
+
+// node implements layer, looking up the node in the buffer, the clean cache
+// and finally on disk.
+func (dl *diskLayer) node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, errLayerStale
+	}
+	if n, ok := dl.buffer.node(owner, path); ok {
+		return checkNode(owner, path, hash, n)
+	}
+	key := cacheKey(owner, path)
+	if dl.db.cleans != nil {
+		if blob := dl.db.cleans.Get(nil, key); len(blob) > 0 {
+			memcacheCleanHitMeter.Mark(1)
+			memcacheCleanReadMeter.Mark(int64(len(blob)))
+			return checkNode(owner, path, hash, &pathNode{hash: crypto.Keccak256Hash(blob), blob: blob})
+		}
+		memcacheCleanMissMeter.Mark(1)
+	}
+	blob, nhash := readPathNode(dl.db.diskdb, owner, path)
+	if len(blob) == 0 {
+		return nil, nil
+	}
+	if dl.db.cleans != nil {
+		dl.db.cleans.Set(key, blob)
+		memcacheCleanWriteMeter.Mark(int64(len(blob)))
+	}
+	return checkNode(owner, path, hash, &pathNode{hash: nhash, blob: blob})
+}
+
+// prevNode returns the persisted node at the given path, before merging any
+// newer layer. The lock must be held.
+func (dl *diskLayer) prevNode(owner common.Hash, path []byte) []byte {
+	if n, ok := dl.buffer.node(owner, path); ok {
+		return n.blob
+	}
+	blob, _ := readPathNode(dl.db.diskdb, owner, path)
+	return blob
+}
+
+// commit merges the given diff layer, built on top of this one, into the
+// persistent state and returns the new disk layer. The nodes the diff layer
+// overwrites are stored as a reverse diff, and the buffered nodes are flushed
+// to disk if they exceed the allowance, or if forced.
+func (dl *diskLayer) commit(bottom *diffLayer, force bool) (*diskLayer, error) {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.stale {
+		return nil, errLayerStale
+	}
+	diff := &reverseDiff{Parent: dl.root, Root: bottom.root}
+	owners := make([]common.Hash, 0, len(bottom.nodes))
+	for owner := range bottom.nodes {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool { return bytes.Compare(owners[i][:], owners[j][:]) < 0 })
+	for _, owner := range owners {
+		subset := bottom.nodes[owner]
+		paths := make([]string, 0, len(subset))
+		for path := range subset {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		trie := reverseDiffTrie{Owner: owner}
+		for _, path := range paths {
+			trie.Nodes = append(trie.Nodes, reverseDiffNode{
+				Path: []byte(path),
+				Blob: dl.prevNode(owner, []byte(path)),
+			})
+		}
+		diff.Tries = append(diff.Tries, trie)
+	}
+	blob, err := diff.encode()
+	if err != nil {
+		return nil, err
+	}
+	batch := dl.db.diskdb.NewBatch()
+	rawdb.WriteReverseDiff(batch, bottom.id, blob)
+	rawdb.WriteStateID(batch, bottom.root, bottom.id)
+	if limit := dl.db.config.StateHistory; limit > 0 && bottom.id > limit {
+		dl.db.pruneHistory(batch, bottom.id-limit)
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	dl.buffer.commit(bottom.nodes)
+	if err := dl.buffer.flush(dl.db.diskdb, dl.db.cleans, bottom.id, force); err != nil {
+		return nil, err
+	}
+	dl.stale = true
+	return newDiskLayer(bottom.root, bottom.id, dl.db, dl.buffer), nil
+}
+
+// flush writes all the buffered nodes to disk.
+func (dl *diskLayer) flush() error {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.stale {
+		return errLayerStale
+	}
+	return dl.buffer.flush(dl.db.diskdb, dl.db.cleans, dl.id, true)
+}
+
+// pruneHistory deletes the reverse diffs up to the given id, and the state ids
+// of the states they'd roll back to.
+func (db *pathDB) pruneHistory(batch ethdb.KeyValueWriter, id uint64) {
+	for ; id > 0 && rawdb.HasReverseDiff(db.diskdb, id); id-- {
+		if diff, err := db.readReverseDiff(id); err == nil {
+			if stored := rawdb.ReadStateID(db.diskdb, diff.Parent); stored != nil && *stored == id-1 {
+				rawdb.DeleteStateID(batch, diff.Parent)
+			}
+		}
+		rawdb.DeleteReverseDiff(batch, id)
+	}
+}
+
+// nodeBuffer aggregates the nodes of the merged diff layers in memory, to
+// write them to disk in large batches.
+type nodeBuffer struct {
+	nodes map[common.Hash]map[string]*pathNode // Buffered nodes, keyed by owner and path
+	size  uint64                               // Approximate memory used by the nodes
+	limit uint64                               // Memory allowance beyond which the nodes are flushed
+}
+
+// newNodeBuffer creates a node buffer with the given memory allowance in MB.
+func newNodeBuffer(limit int) *nodeBuffer {
+	return &nodeBuffer{
+		nodes: make(map[common.Hash]map[string]*pathNode),
+		limit: uint64(limit) * 1024 * 1024,
+	}
+}
+
+// node looks up the node at the given path.
+func (b *nodeBuffer) node(owner common.Hash, path []byte) (*pathNode, bool) {
+	subset, ok := b.nodes[owner]
+	if !ok {
+		return nil, false
+	}
+	n, ok := subset[string(path)]
+	return n, ok
+}
+
+// commit merges the given nodes into the buffer.
+func (b *nodeBuffer) commit(nodes map[common.Hash]map[string]*pathNode) {
+	for owner, subset := range nodes {
+		current, ok := b.nodes[owner]
+		if !ok {
+			current = make(map[string]*pathNode, len(subset))
+			b.nodes[owner] = current
+		}
+		for path, n := range subset {
+			if prev, ok := current[path]; ok {
+				b.size -= uint64(len(prev.blob))
+			} else {
+				b.size += uint64(pathNodeSize + len(path))
+			}
+			b.size += uint64(len(n.blob))
+			current[path] = n
+		}
+	}
+}
+
+// count returns the number of buffered nodes.
+func (b *nodeBuffer) count() int {
+	var count int
+	for _, subset := range b.nodes {
+		count += len(subset)
+	}
+	return count
+}
+
+// flush writes the buffered nodes to disk, along with the id of the state
+// they make up, if they exceed the memory allowance or if forced.
+func (b *nodeBuffer) flush(db ethdb.KeyValueStore, cleans *fastcache.Cache, id uint64, force bool) error {
+	if b.size == 0 || (!force && b.size <= b.limit) {
+		return nil
+	}
+	var (
+		batch = db.NewBatch()
+		nodes int
+	)
+	for owner, subset := range b.nodes {
+		for path, n := range subset {
+			writePathNode(batch, owner, []byte(path), n.blob)
+			nodes++
+		}
+	}
+	rawdb.WritePersistentStateID(batch, id)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if cleans != nil {
+		for owner, subset := range b.nodes {
+			for path, n := range subset {
+				key := cacheKey(owner, []byte(path))
+				if n.blob == nil {
+					cleans.Del(key)
+				} else {
+					cleans.Set(key, n.blob)
+				}
+			}
+		}
+	}
+	memcacheFlushNodesMeter.Mark(int64(nodes))
+	memcacheFlushSizeMeter.Mark(int64(b.size))
+	log.Debug("Flushed buffered trie nodes", "nodes", nodes, "size", common.StorageSize(b.size), "id", id)
+
+	b.nodes = make(map[common.Hash]map[string]*pathNode)
+	b.size = 0
+	return nil
+}
+
+// cacheKey returns the clean cache key of the node at the given path.
+func cacheKey(owner common.Hash, path []byte) []byte {
+	return append(owner.Bytes(), path...)
+}
+
+// readPathNode reads the node at the given path from disk, along with its hash.
+func readPathNode(db ethdb.KeyValueReader, owner common.Hash, path []byte) ([]byte, common.Hash) {
+	if owner == (common.Hash{}) {
+		return rawdb.ReadAccountTrieNode(db, path)
+	}
+	return rawdb.ReadStorageTrieNode(db, owner, path)
+}
+
+// writePathNode writes the node at the given path to disk, deleting it if the
+// blob is empty.
+func writePathNode(db ethdb.KeyValueWriter, owner common.Hash, path []byte, blob []byte) {
+	if owner == (common.Hash{}) {
+		if len(blob) == 0 {
+			rawdb.DeleteAccountTrieNode(db, path)
+		} else {
+			rawdb.WriteAccountTrieNode(db, path, blob)
+		}
+		return
+	}
+	if len(blob) == 0 {
+		rawdb.DeleteStorageTrieNode(db, owner, path)
+	} else {
+		rawdb.WriteStorageTrieNode(db, owner, path, blob)
+	}
+}
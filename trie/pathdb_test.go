@@ -0,0 +1,289 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// pathTestOwner is the owner of the storage trie maintained by the tests, its
+// root being stored in the account trie.
+var pathTestOwner = common.HexToHash("0x01")
+
+// pathTestState is the content of a state built by the tests.
+type pathTestState struct {
+	root     common.Hash
+	accounts map[string]string
+	storage  map[string]string
+}
+
+// pathTester builds states on top of each other in a path-based database.
+type pathTester struct {
+	disk   ethdb.Database
+	db     *Database
+	rand   *rand.Rand
+	states []*pathTestState
+}
+
+func newPathTester(disk ethdb.Database, history uint64, dirty int) *pathTester {
+	return &pathTester{
+		disk: disk,
+		db:   NewDatabaseWithConfig(disk, &Config{PathDB: &PathConfig{StateHistory: history, DirtyCache: dirty}}),
+		rand: rand.New(rand.NewSource(1)),
+		states: []*pathTestState{{
+			root:     types.EmptyRootHash,
+			accounts: make(map[string]string),
+			storage:  make(map[string]string),
+		}},
+	}
+}
+
+func (pt *pathTester) head() *pathTestState {
+	return pt.states[len(pt.states)-1]
+}
+
+// mutate applies random changes to the given content.
+func (pt *pathTester) mutate(content map[string]string, tr *Trie) map[string]string {
+	next := make(map[string]string, len(content))
+	for k, v := range content {
+		next[k] = v
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%03d", pt.rand.Intn(100))
+		if _, ok := next[key]; ok && pt.rand.Intn(3) == 0 {
+			tr.Delete([]byte(key))
+			delete(next, key)
+			continue
+		}
+		val := fmt.Sprintf("val-%d", pt.rand.Int63())
+		tr.Update([]byte(key), []byte(val))
+		next[key] = val
+	}
+	return next
+}
+
+// commit builds the given number of states on top of the head.
+func (pt *pathTester) commit(t *testing.T, states int) {
+	t.Helper()
+
+	for i := 0; i < states; i++ {
+		parent := pt.head()
+		nodes := NewMergedNodeSet()
+
+		// Update the storage trie, then the account trie holding its root
+		var storageRoot common.Hash
+		if root, ok := parent.accounts[string(pathTestOwner[:])]; ok {
+			storageRoot = common.BytesToHash([]byte(root))
+		}
+		str, err := New(StorageTrieID(parent.root, pathTestOwner, storageRoot), pt.db)
+		if err != nil {
+			t.Fatalf("failed to open storage trie: %v", err)
+		}
+		storage := pt.mutate(parent.storage, str)
+		storageRoot, set := str.Commit(false)
+		if set != nil {
+			nodes.Merge(set)
+		}
+		atr, err := New(TrieID(parent.root), pt.db)
+		if err != nil {
+			t.Fatalf("failed to open account trie: %v", err)
+		}
+		accounts := pt.mutate(parent.accounts, atr)
+		atr.Update(pathTestOwner[:], storageRoot[:])
+		accounts[string(pathTestOwner[:])] = string(storageRoot[:])
+
+		root, set := atr.Commit(false)
+		if set != nil {
+			nodes.Merge(set)
+		}
+		if err := pt.db.Update(root, parent.root, nodes); err != nil {
+			t.Fatalf("failed to update database: %v", err)
+		}
+		pt.states = append(pt.states, &pathTestState{root: root, accounts: accounts, storage: storage})
+	}
+}
+
+// verify checks the content of the given state in the database.
+func (pt *pathTester) verify(t *testing.T, db *Database, state *pathTestState) {
+	t.Helper()
+
+	check := func(tr *Trie, content map[string]string) {
+		for k, v := range content {
+			have, err := tr.TryGet([]byte(k))
+			if err != nil {
+				t.Fatalf("failed to read %q from state %x: %v", k, state.root, err)
+			}
+			if !bytes.Equal(have, []byte(v)) {
+				t.Fatalf("value %q mismatch in state %x: have %x, want %x", k, state.root, have, v)
+			}
+		}
+		count := 0
+		for it := NewIterator(tr.NodeIterator(nil)); it.Next(); {
+			count++
+		}
+		if count != len(content) {
+			t.Fatalf("entry count mismatch in state %x: have %d, want %d", state.root, count, len(content))
+		}
+	}
+	atr, err := New(TrieID(state.root), db)
+	if err != nil {
+		t.Fatalf("failed to open state %x: %v", state.root, err)
+	}
+	check(atr, state.accounts)
+
+	storageRoot := common.BytesToHash([]byte(state.accounts[string(pathTestOwner[:])]))
+	str, err := New(StorageTrieID(state.root, pathTestOwner, storageRoot), db)
+	if err != nil {
+		t.Fatalf("failed to open storage of state %x: %v", state.root, err)
+	}
+	check(str, state.storage)
+}
+
+// Tests that the states are readable through the diff layers, and once merged
+// into the disk layer, from the disk after a restart.
+func TestPathDBUpdate(t *testing.T) {
+	pt := newPathTester(rawdb.NewMemoryDatabase(), 0, 1)
+	pt.commit(t, maxDiffLayers+32)
+
+	// The states over the disk layer are available in memory
+	for _, state := range pt.states[len(pt.states)-maxDiffLayers-1:] {
+		pt.verify(t, pt.db, state)
+	}
+	// The older states were merged into the disk layer, the empty one aside
+	for _, state := range pt.states[1 : len(pt.states)-maxDiffLayers-1] {
+		if pt.db.GetReader(state.root) != nil {
+			t.Fatalf("state %x still available", state.root)
+		}
+	}
+	if err := pt.db.Commit(pt.head().root, false); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if size, _ := pt.db.Size(); size != 0 {
+		t.Fatalf("memory not released after commit: %v", size)
+	}
+	// Reopen the database, only the persisted head must be available
+	db := NewDatabaseWithConfig(pt.disk, &Config{PathDB: &PathConfig{}})
+	pt.verify(t, db, pt.head())
+	if db.GetReader(pt.states[len(pt.states)-2].root) != nil {
+		t.Fatalf("parent state available after restart")
+	}
+}
+
+// Tests that the states are readable after rolling the persistent state back,
+// as long as their history is kept.
+func TestPathDBRecover(t *testing.T) {
+	var (
+		history = uint64(32)
+		pt      = newPathTester(rawdb.NewMemoryDatabase(), history, 0)
+	)
+	pt.commit(t, 64)
+	if err := pt.db.Commit(pt.head().root, false); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	// States beyond the history can't be recovered
+	for _, state := range pt.states[:len(pt.states)-1-int(history)] {
+		if pt.db.Recoverable(state.root) {
+			t.Fatalf("state %x recoverable beyond history", state.root)
+		}
+	}
+	// Roll back state by state through the whole history
+	for i := len(pt.states) - 2; i >= len(pt.states)-1-int(history); i-- {
+		state := pt.states[i]
+		if !pt.db.Recoverable(state.root) {
+			t.Fatalf("state %d not recoverable", i)
+		}
+		if err := pt.db.Recover(state.root); err != nil {
+			t.Fatalf("failed to recover state %d: %v", i, err)
+		}
+		pt.verify(t, pt.db, state)
+	}
+	// Build a different chain on top of the recovered state
+	pt.states = pt.states[:len(pt.states)-int(history)]
+	pt.commit(t, 8)
+	if err := pt.db.Commit(pt.head().root, false); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := pt.db.Recover(pt.states[len(pt.states)-5].root); err != nil {
+		t.Fatalf("failed to recover: %v", err)
+	}
+	pt.verify(t, pt.db, pt.states[len(pt.states)-5])
+}
+
+// Tests that the history and the buffered nodes of the states not yet
+// persisted are dropped after an unclean shutdown.
+func TestPathDBUncleanShutdown(t *testing.T) {
+	pt := newPathTester(rawdb.NewMemoryDatabase(), 0, 0)
+	pt.commit(t, 16)
+	if err := pt.db.Commit(pt.head().root, false); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	persisted := len(pt.states) - 1
+
+	// Buffer the nodes of more states than kept in memory, and drop them
+	pt.db = NewDatabaseWithConfig(pt.disk, &Config{PathDB: &PathConfig{DirtyCache: 256}})
+	pt.commit(t, maxDiffLayers+16)
+
+	db := NewDatabaseWithConfig(pt.disk, &Config{PathDB: &PathConfig{}})
+	pt.verify(t, db, pt.states[persisted])
+	if id := rawdb.ReadPersistentStateID(pt.disk); id != uint64(persisted) {
+		t.Fatalf("persistent state id mismatch: have %d, want %d", id, persisted)
+	}
+	if rawdb.HasReverseDiff(pt.disk, uint64(persisted+1)) {
+		t.Fatalf("history of lost states not truncated")
+	}
+	if err := db.Recover(pt.states[persisted-4].root); err != nil {
+		t.Fatalf("failed to recover: %v", err)
+	}
+	pt.verify(t, db, pt.states[persisted-4])
+}
+
+// Tests that resetting the database keeps the persisted state only, or wipes
+// all the states.
+func TestPathDBReset(t *testing.T) {
+	pt := newPathTester(rawdb.NewMemoryDatabase(), 0, 0)
+	pt.commit(t, 8)
+	if err := pt.db.Commit(pt.head().root, false); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := pt.db.Reset(pt.states[4].root); err == nil {
+		t.Fatalf("reset to a state not on disk succeeded")
+	}
+	if err := pt.db.Reset(pt.head().root); err != nil {
+		t.Fatalf("failed to reset: %v", err)
+	}
+	pt.verify(t, pt.db, pt.head())
+	if pt.db.Recoverable(pt.states[4].root) {
+		t.Fatalf("history kept after reset")
+	}
+	if err := pt.db.Reset(types.EmptyRootHash); err != nil {
+		t.Fatalf("failed to wipe: %v", err)
+	}
+	if pt.db.Initialized(pt.states[1].root) {
+		t.Fatalf("state kept after wipe")
+	}
+	if pt.db.GetReader(pt.head().root) != nil {
+		t.Fatalf("state available after wipe")
+	}
+}